@@ -29,6 +29,10 @@ func TestParseAdvancedOpcodes(t *testing.T) {
 		// Reverb (should also be recognized)
 		{"reverb_send", "reverb_send", true},
 		{"reverb_room_size", "reverb_room_size", true},
+		{"reverb_size", "reverb_size", true},
+		{"reverb_damp", "reverb_damp", true},
+		{"reverb_input", "reverb_input", true},
+		{"reverb_tone", "reverb_tone", true},
 
 		// Should still reject unknown opcodes
 		{"unknown", "unknown_opcode", false},
@@ -0,0 +1,256 @@
+package gosfzplayer
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/GeoffreyPlitt/debuggo"
+)
+
+var audioWriterDebug = debuggo.Debug("sfzplayer:audiowriter")
+
+// SampleFormat is the sample encoding of an audio file
+type SampleFormat int
+
+// Sample formats
+const (
+	FormatPCM16   SampleFormat = iota // 16-bit integer PCM (default)
+	FormatPCM24                       // 24-bit integer PCM
+	FormatFloat32                     // 32-bit IEEE float (WAV only)
+)
+
+// String returns the sample format name
+func (f SampleFormat) String() string {
+	switch f {
+	case FormatPCM16:
+		return "pcm16"
+	case FormatPCM24:
+		return "pcm24"
+	case FormatFloat32:
+		return "float32"
+	}
+	return fmt.Sprintf("SampleFormat(%d)", int(f))
+}
+
+// bits returns the number of bits per sample
+func (f SampleFormat) bits() int {
+	switch f {
+	case FormatPCM24:
+		return 24
+	case FormatFloat32:
+		return 32
+	}
+	return 16
+}
+
+// Dither selects the dither applied when converting to an integer format
+type Dither int
+
+// Dither modes
+const (
+	DitherNone Dither = iota // Round to the nearest value (default)
+	DitherTPDF               // Triangular dither of ±1 LSB, decorrelating quantization error from the signal
+)
+
+// FileType is the container of an audio file
+type FileType int
+
+// File types
+const (
+	FileTypeAuto FileType = iota // Chosen from the file extension: .flac for FLAC, anything else for WAV
+	FileTypeWAV
+	FileTypeFLAC
+)
+
+// AudioMetadata holds descriptive tags written to an audio file. Empty fields are omitted.
+// WAV files store them in a LIST/INFO chunk, FLAC files as Vorbis comments.
+type AudioMetadata struct {
+	Title      string // INAM / TITLE
+	Artist     string // IART / ARTIST
+	Instrument string // ISBJ / INSTRUMENT, typically the SFZ instrument name
+	Comment    string // ICMT / COMMENT
+	Software   string // ISFT / ENCODER
+}
+
+// tags returns the metadata as RIFF INFO ids and Vorbis comment names with their values
+func (m AudioMetadata) tags() [][3]string {
+	var tags [][3]string
+	for _, tag := range [][3]string{
+		{"INAM", "TITLE", m.Title},
+		{"IART", "ARTIST", m.Artist},
+		{"ISBJ", "INSTRUMENT", m.Instrument},
+		{"ICMT", "COMMENT", m.Comment},
+		{"ISFT", "ENCODER", m.Software},
+	} {
+		if tag[2] != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// AudioWriterOptions configures an AudioWriter; zero values use the defaults
+type AudioWriterOptions struct {
+	SampleRate uint32        // Sample rate in Hz (default 44100)
+	Channels   int           // 1 (mono, default) or 2 (stereo)
+	Format     SampleFormat  // Sample encoding (default FormatPCM16)
+	FileType   FileType      // Container (default from the file extension)
+	Dither     Dither        // Dither for integer formats (default none)
+	Metadata   AudioMetadata // Descriptive tags
+}
+
+// AudioWriter streams audio to a WAV or FLAC file. Samples are float32 in -1.0..1.0,
+// interleaved for stereo; values outside that range are clipped in integer formats.
+type AudioWriter struct {
+	path    string
+	options AudioWriterOptions
+	encoder audioEncoder
+	frames  int64
+	closed  bool
+}
+
+// audioEncoder writes samples to one container format
+type audioEncoder interface {
+	write(samples []float32) error
+	close() error
+}
+
+// NewAudioWriter creates an audio file and writes its header
+func NewAudioWriter(path string, opts AudioWriterOptions) (*AudioWriter, error) {
+	if opts.SampleRate == 0 {
+		opts.SampleRate = 44100
+	}
+	if opts.Channels == 0 {
+		opts.Channels = 1
+	}
+	if opts.Channels != 1 && opts.Channels != 2 {
+		return nil, fmt.Errorf("unsupported channel count %d (must be 1 or 2)", opts.Channels)
+	}
+	if opts.Format < FormatPCM16 || opts.Format > FormatFloat32 {
+		return nil, fmt.Errorf("unsupported sample format %v", opts.Format)
+	}
+	if opts.FileType == FileTypeAuto {
+		opts.FileType = FileTypeWAV
+		if strings.EqualFold(filepath.Ext(path), ".flac") {
+			opts.FileType = FileTypeFLAC
+		}
+	}
+
+	w := &AudioWriter{path: path, options: opts}
+	var err error
+	switch opts.FileType {
+	case FileTypeWAV:
+		w.encoder, err = newWAVEncoder(path, opts)
+	case FileTypeFLAC:
+		if opts.Format == FormatFloat32 {
+			return nil, fmt.Errorf("FLAC does not support %v samples", opts.Format)
+		}
+		w.encoder, err = newFLACEncoder(path, opts)
+	default:
+		return nil, fmt.Errorf("unsupported file type %d", opts.FileType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	audioWriterDebug("Writing %s: %d Hz, %d channels, %v", path, opts.SampleRate, opts.Channels, opts.Format)
+	return w, nil
+}
+
+// Write appends samples, interleaved for stereo
+func (w *AudioWriter) Write(samples []float32) error {
+	if w.closed {
+		return fmt.Errorf("audio writer is closed")
+	}
+	if len(samples)%w.options.Channels != 0 {
+		return fmt.Errorf("sample count %d is not a multiple of %d channels", len(samples), w.options.Channels)
+	}
+	if err := w.encoder.write(samples); err != nil {
+		return err
+	}
+	w.frames += int64(len(samples) / w.options.Channels)
+	return nil
+}
+
+// WriteStereo interleaves and appends left and right channel samples to a stereo file
+func (w *AudioWriter) WriteStereo(left, right []float32) error {
+	if w.options.Channels != 2 {
+		return fmt.Errorf("WriteStereo requires a stereo writer")
+	}
+	if len(left) != len(right) {
+		return fmt.Errorf("channel lengths differ: %d and %d", len(left), len(right))
+	}
+	interleaved := make([]float32, 2*len(left))
+	for i := range left {
+		interleaved[2*i] = left[i]
+		interleaved[2*i+1] = right[i]
+	}
+	return w.Write(interleaved)
+}
+
+// Frames returns the number of frames written so far
+func (w *AudioWriter) Frames() int64 {
+	return w.frames
+}
+
+// Close finishes the file, filling in the header sizes
+func (w *AudioWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if err := w.encoder.close(); err != nil {
+		return err
+	}
+	audioWriterDebug("Finished %s: %d frames", w.path, w.frames)
+	return nil
+}
+
+// WriteAudioFile writes a whole buffer of samples to a WAV or FLAC file
+func WriteAudioFile(path string, samples []float32, opts AudioWriterOptions) error {
+	w, err := NewAudioWriter(path, opts)
+	if err != nil {
+		return err
+	}
+	if err := w.Write(samples); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// quantizer converts float samples to integers, with optional dither
+type quantizer struct {
+	scale  float64 // Largest positive integer value
+	dither Dither
+	rng    uint64 // xorshift state, fixed so renders are reproducible
+}
+
+// newQuantizer creates a quantizer for the given bits per sample
+func newQuantizer(bits int, dither Dither) *quantizer {
+	return &quantizer{
+		scale:  float64(int64(1)<<(bits-1) - 1),
+		dither: dither,
+		rng:    0x9E3779B97F4A7C15,
+	}
+}
+
+// quantize converts a sample to an integer, clipping to the format's range
+func (q *quantizer) quantize(sample float32) int32 {
+	value := float64(sample) * q.scale
+	if q.dither == DitherTPDF {
+		value += q.uniform() - q.uniform()
+	}
+	value = math.Round(value)
+	return int32(clampFloat64(value, -q.scale-1, q.scale))
+}
+
+// uniform returns a uniformly distributed value in 0..1
+func (q *quantizer) uniform() float64 {
+	q.rng ^= q.rng << 13
+	q.rng ^= q.rng >> 7
+	q.rng ^= q.rng << 17
+	return float64(q.rng>>11) / (1 << 53)
+}
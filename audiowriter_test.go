@@ -0,0 +1,252 @@
+package gosfzplayer
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/meta"
+)
+
+// readWAVChunks returns the chunks of a WAV file by id
+func readWAVChunks(t *testing.T, path string) map[string][]byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read WAV file: %v", err)
+	}
+	if string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		t.Fatal("Not a WAV file")
+	}
+	if size := binary.LittleEndian.Uint32(data[4:8]); int(size) != len(data)-8 {
+		t.Errorf("RIFF size %d doesn't match file size %d", size, len(data)-8)
+	}
+
+	chunks := make(map[string][]byte)
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		if pos+8+size > len(data) {
+			t.Fatalf("Chunk %q overruns the file", id)
+		}
+		chunks[id] = data[pos+8 : pos+8+size]
+		pos += 8 + size + size%2
+	}
+	return chunks
+}
+
+func TestAudioWriterWAVFormats(t *testing.T) {
+	samples := []float32{0, 0.5, -0.5, 1.5, -1.5, 0.25}
+
+	tests := []struct {
+		format SampleFormat
+		tag    uint16
+		decode func(data []byte, i int) float64
+	}{
+		{FormatPCM16, 1, func(data []byte, i int) float64 {
+			return float64(int16(binary.LittleEndian.Uint16(data[i*2:]))) / 32767
+		}},
+		{FormatPCM24, 1, func(data []byte, i int) float64 {
+			value := int32(data[i*3]) | int32(data[i*3+1])<<8 | int32(int8(data[i*3+2]))<<16
+			return float64(value) / 8388607
+		}},
+		{FormatFloat32, 3, func(data []byte, i int) float64 {
+			return float64(math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:])))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format.String(), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "out.wav")
+			if err := WriteAudioFile(path, samples, AudioWriterOptions{SampleRate: 48000, Channels: 2, Format: tt.format}); err != nil {
+				t.Fatalf("WriteAudioFile failed: %v", err)
+			}
+
+			chunks := readWAVChunks(t, path)
+			format := chunks["fmt "]
+			if tag := binary.LittleEndian.Uint16(format[0:2]); tag != tt.tag {
+				t.Errorf("Expected format tag %d, got %d", tt.tag, tag)
+			}
+			if channels := binary.LittleEndian.Uint16(format[2:4]); channels != 2 {
+				t.Errorf("Expected 2 channels, got %d", channels)
+			}
+			if rate := binary.LittleEndian.Uint32(format[4:8]); rate != 48000 {
+				t.Errorf("Expected 48000 Hz, got %d", rate)
+			}
+			if bits := binary.LittleEndian.Uint16(format[14:16]); int(bits) != tt.format.bits() {
+				t.Errorf("Expected %d bits, got %d", tt.format.bits(), bits)
+			}
+			if tt.format == FormatFloat32 {
+				if frames := binary.LittleEndian.Uint32(chunks["fact"]); frames != 3 {
+					t.Errorf("Expected fact chunk with 3 frames, got %d", frames)
+				}
+			}
+
+			data := chunks["data"]
+			if len(data) != len(samples)*tt.format.bits()/8 {
+				t.Fatalf("Expected %d data bytes, got %d", len(samples)*tt.format.bits()/8, len(data))
+			}
+			for i, sample := range samples {
+				expected := float64(sample)
+				if tt.format != FormatFloat32 {
+					expected = math.Max(-1, math.Min(1, expected))
+				}
+				if got := tt.decode(data, i); math.Abs(got-expected) > 1e-4 {
+					t.Errorf("Sample %d: expected %.4f, got %.4f", i, expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestAudioWriterMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.wav")
+	metadata := AudioMetadata{Title: "Demo", Instrument: "Upright Piano"}
+	if err := WriteAudioFile(path, make([]float32, 100), AudioWriterOptions{Metadata: metadata}); err != nil {
+		t.Fatalf("WriteAudioFile failed: %v", err)
+	}
+
+	list := readWAVChunks(t, path)["LIST"]
+	if string(list[0:4]) != "INFO" {
+		t.Fatalf("Expected a LIST/INFO chunk, got %q", list[0:4])
+	}
+	info := string(list)
+	if !strings.Contains(info, "INAM") || !strings.Contains(info, "Demo\x00") {
+		t.Error("Expected the title in an INAM chunk")
+	}
+	if !strings.Contains(info, "ISBJ") || !strings.Contains(info, "Upright Piano\x00") {
+		t.Error("Expected the instrument name in an ISBJ chunk")
+	}
+}
+
+func TestAudioWriterOddDataIsPadded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.wav")
+	if err := WriteAudioFile(path, []float32{0.1}, AudioWriterOptions{Format: FormatPCM24}); err != nil {
+		t.Fatalf("WriteAudioFile failed: %v", err)
+	}
+	if data := readWAVChunks(t, path)["data"]; len(data) != 3 {
+		t.Errorf("Expected a 3-byte data chunk, got %d", len(data))
+	}
+}
+
+func TestAudioWriterDither(t *testing.T) {
+	q := newQuantizer(16, DitherTPDF)
+	plain := newQuantizer(16, DitherNone)
+
+	// A signal below 1 LSB vanishes without dither but survives on average with it
+	const level = 0.3 / 32767
+	var sum float64
+	for i := 0; i < 10000; i++ {
+		if plain.quantize(level) != 0 {
+			t.Fatal("Expected a sub-LSB signal to round to zero without dither")
+		}
+		value := q.quantize(level)
+		if value < -1 || value > 2 {
+			t.Fatalf("Dither error exceeded ±1 LSB: %d", value)
+		}
+		sum += float64(value)
+	}
+	if mean := sum / 10000; math.Abs(mean-0.3) > 0.05 {
+		t.Errorf("Expected dithered mean near 0.3 LSB, got %.3f", mean)
+	}
+
+	// Dither must not wrap at full scale
+	if value := q.quantize(1); value != 32767 && value != 32766 {
+		t.Errorf("Expected full scale to stay near 32767, got %d", value)
+	}
+}
+
+func TestAudioWriterFLAC(t *testing.T) {
+	for _, format := range []SampleFormat{FormatPCM16, FormatPCM24} {
+		t.Run(format.String(), func(t *testing.T) {
+			// A sine followed by silence, spanning several FLAC blocks
+			frames := flacBlockSize*2 + 1000
+			samples := make([]float32, frames*2)
+			for i := 0; i < flacBlockSize*2; i++ {
+				samples[2*i] = float32(0.5 * math.Sin(2*math.Pi*440*float64(i)/44100))
+				samples[2*i+1] = -samples[2*i]
+			}
+
+			path := filepath.Join(t.TempDir(), "out.flac")
+			opts := AudioWriterOptions{Channels: 2, Format: format, Metadata: AudioMetadata{Instrument: "Test"}}
+			if err := WriteAudioFile(path, samples, opts); err != nil {
+				t.Fatalf("WriteAudioFile failed: %v", err)
+			}
+
+			stream, err := flac.ParseFile(path)
+			if err != nil {
+				t.Fatalf("Failed to decode FLAC: %v", err)
+			}
+			defer stream.Close()
+
+			if stream.Info.NChannels != 2 || int(stream.Info.BitsPerSample) != format.bits() || stream.Info.NSamples != uint64(frames) {
+				t.Fatalf("Unexpected stream info: %+v", stream.Info)
+			}
+
+			var instrument string
+			for _, block := range stream.Blocks {
+				if comment, ok := block.Body.(*meta.VorbisComment); ok {
+					for _, tag := range comment.Tags {
+						if tag[0] == "INSTRUMENT" {
+							instrument = tag[1]
+						}
+					}
+				}
+			}
+			if instrument != "Test" {
+				t.Errorf("Expected an INSTRUMENT comment of Test, got %q", instrument)
+			}
+
+			if info, err := os.Stat(path); err == nil && info.Size() >= int64(len(samples)*format.bits()/8) {
+				t.Errorf("Expected FLAC to compress a sine, got %d bytes", info.Size())
+			}
+
+			q := newQuantizer(format.bits(), DitherNone)
+			decoded := 0
+			for {
+				f, err := stream.ParseNext()
+				if err != nil {
+					break
+				}
+				for i := 0; i < int(f.BlockSize); i++ {
+					for ch := 0; ch < 2; ch++ {
+						expected := q.quantize(samples[2*(decoded+i)+ch])
+						if got := f.Subframes[ch].Samples[i]; got != expected {
+							t.Fatalf("Frame %d channel %d: expected %d, got %d", decoded+i, ch, expected, got)
+						}
+					}
+				}
+				decoded += int(f.BlockSize)
+			}
+			if decoded != frames {
+				t.Errorf("Expected %d decoded frames, got %d", frames, decoded)
+			}
+		})
+	}
+}
+
+func TestAudioWriterErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewAudioWriter(filepath.Join(dir, "out.flac"), AudioWriterOptions{Format: FormatFloat32}); err == nil {
+		t.Error("Expected FLAC with float samples to fail")
+	}
+	if _, err := NewAudioWriter(filepath.Join(dir, "out.wav"), AudioWriterOptions{Channels: 6}); err == nil {
+		t.Error("Expected 6 channels to fail")
+	}
+
+	w, err := NewAudioWriter(filepath.Join(dir, "mono.wav"), AudioWriterOptions{})
+	if err != nil {
+		t.Fatalf("NewAudioWriter failed: %v", err)
+	}
+	if err := w.WriteStereo([]float32{0}, []float32{0}); err == nil {
+		t.Error("Expected WriteStereo on a mono writer to fail")
+	}
+	w.Close()
+	if err := w.Write([]float32{0}); err == nil {
+		t.Error("Expected Write after Close to fail")
+	}
+}
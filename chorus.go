@@ -0,0 +1,258 @@
+package gosfzplayer
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// maxChorusDelay is the longest modulated delay in seconds (base delay plus depth)
+const maxChorusDelay = 0.05
+
+// Chorus is a stereo chorus, the chorus effect type: a delay line per channel whose length a sine
+// LFO sweeps, the right channel's LFO running ahead of the left's. With a short delay and feedback
+// it is a flanger (the flanger effect type).
+type Chorus struct {
+	// Parameters (float64 bits) are set from any goroutine and picked up by the audio thread on
+	// its next block
+	rate     atomic.Uint64 // LFO rate in Hz
+	depth    atomic.Uint64 // Sweep depth in seconds
+	delay    atomic.Uint64 // Base delay in seconds
+	feedback atomic.Uint64 // -0.95 to 0.95
+	spread   atomic.Uint64 // Phase offset of the right LFO (0.0 to 1.0 of a cycle)
+	wet      atomic.Uint64 // 0.0 to 1.0
+	dry      atomic.Uint64 // 0.0 to 1.0
+	version  atomic.Uint64 // Incremented on every parameter change
+
+	processor *chorusProcessor // Runs Process; engines run processors of their own
+	controls  effectControls
+}
+
+// chorusProcessor is the delay lines, LFO and parameters in use of one audio thread running a Chorus
+type chorusProcessor struct {
+	chorus   *Chorus
+	bufferL  []float32
+	bufferR  []float32
+	writeIdx int
+	phase    float64 // LFO phase (0.0 to 1.0)
+
+	appliedVersion uint64
+	phaseStep      float64
+	depthFrames    float64
+	delayFrames    float64
+	feedbackGain   float64
+	spreadPhase    float64
+	wetGain        float64
+	dryGain        float64
+	sampleRate     float64
+}
+
+// NewChorus creates a chorus sweeping 3 ms around 15 ms at 0.5 Hz, returning only the effect
+func NewChorus(sampleRate uint32) *Chorus {
+	c := &Chorus{}
+	c.rate.Store(math.Float64bits(0.5))
+	c.depth.Store(math.Float64bits(0.003))
+	c.delay.Store(math.Float64bits(0.015))
+	c.spread.Store(math.Float64bits(0.25))
+	c.wet.Store(math.Float64bits(1.0))
+	c.processor = newChorusProcessor(c, sampleRate)
+	return c
+}
+
+// newChorusProcessor allocates delay lines for a chorus's longest delay at a sample rate
+func newChorusProcessor(c *Chorus, sampleRate uint32) *chorusProcessor {
+	frames := int(maxChorusDelay*float64(sampleRate)) + 2
+	return &chorusProcessor{
+		chorus:         c,
+		bufferL:        make([]float32, frames),
+		bufferR:        make([]float32, frames),
+		appliedVersion: math.MaxUint64, // Picks up the parameters on the first block
+		sampleRate:     float64(sampleRate),
+	}
+}
+
+// NewProcessor creates a processor with delay lines and an LFO of its own that follows the
+// chorus's settings
+func (c *Chorus) NewProcessor(sampleRate uint32) Effect {
+	return newChorusProcessor(c, sampleRate)
+}
+
+// NewFlanger creates a chorus set up as a flanger: a 1 ms sweep around 2 ms at 0.25 Hz with 50%
+// feedback
+func NewFlanger(sampleRate uint32) *Chorus {
+	c := NewChorus(sampleRate)
+	c.SetRate(0.25)
+	c.SetDepth(0.001)
+	c.SetDelay(0.002)
+	c.SetFeedback(0.5)
+	return c
+}
+
+// newChorusEffect creates a chorus from an <effect> section's chorus opcodes
+func newChorusEffect(section *SfzSection, sampleRate uint32) (Effect, error) {
+	return bindChorus(NewChorus(sampleRate), section), nil
+}
+
+// newFlangerEffect creates a flanger from an <effect> section's chorus opcodes
+func newFlangerEffect(section *SfzSection, sampleRate uint32) (Effect, error) {
+	return bindChorus(NewFlanger(sampleRate), section), nil
+}
+
+// bindChorus applies the chorus_freq (Hz), chorus_depth and chorus_delay (ms), chorus_feedback
+// (-100 to 100), chorus_phase (degrees), chorus_wet and chorus_dry (0-100) opcodes; each can
+// follow CCs with <opcode>_onccN
+func bindChorus(c *Chorus, section *SfzSection) *Chorus {
+	wet, dry := effectMixDefaults(section)
+	c.controls.bind(section, "chorus_freq", c.GetRate(), c.SetRate)
+	c.controls.bind(section, "chorus_depth", c.GetDepth()*1000.0, func(value float64) { c.SetDepth(value / 1000.0) })
+	c.controls.bind(section, "chorus_delay", c.GetDelay()*1000.0, func(value float64) { c.SetDelay(value / 1000.0) })
+	c.controls.bind(section, "chorus_feedback", c.GetFeedback()*100.0, func(value float64) { c.SetFeedback(value / 100.0) })
+	c.controls.bind(section, "chorus_phase", c.GetSpread()*360.0, func(value float64) { c.SetSpread(value / 360.0) })
+	c.controls.bind(section, "chorus_wet", wet, func(value float64) { c.SetWet(value / 100.0) })
+	c.controls.bind(section, "chorus_dry", dry, func(value float64) { c.SetDry(value / 100.0) })
+	return c
+}
+
+// setParameter clamps a parameter and stores it for the audio thread
+func (c *Chorus) setParameter(param *atomic.Uint64, value, low, high float64) {
+	param.Store(math.Float64bits(clampFloat64(value, low, high)))
+	c.version.Add(1)
+}
+
+// SetRate sets the LFO rate in Hz (0.01 to 20)
+func (c *Chorus) SetRate(hz float64) {
+	c.setParameter(&c.rate, hz, 0.01, 20.0)
+}
+
+// SetDepth sets how far the LFO sweeps the delay either side of the base delay, in seconds
+// (0 to 0.02)
+func (c *Chorus) SetDepth(seconds float64) {
+	c.setParameter(&c.depth, seconds, 0.0, 0.02)
+}
+
+// SetDelay sets the base delay in seconds (0.0001 to 0.03)
+func (c *Chorus) SetDelay(seconds float64) {
+	c.setParameter(&c.delay, seconds, 0.0001, 0.03)
+}
+
+// SetFeedback sets how much of the delayed signal is fed back (-0.95 to 0.95)
+func (c *Chorus) SetFeedback(feedback float64) {
+	c.setParameter(&c.feedback, feedback, -0.95, 0.95)
+}
+
+// SetSpread sets how far the right channel's LFO runs ahead of the left's, in cycles (0.0 to 1.0)
+func (c *Chorus) SetSpread(spread float64) {
+	c.setParameter(&c.spread, spread, 0.0, 1.0)
+}
+
+// SetWet sets the level of the effect (0.0 to 1.0)
+func (c *Chorus) SetWet(wet float64) {
+	c.setParameter(&c.wet, wet, 0.0, 1.0)
+}
+
+// SetDry sets the level of the input (0.0 to 1.0)
+func (c *Chorus) SetDry(dry float64) {
+	c.setParameter(&c.dry, dry, 0.0, 1.0)
+}
+
+// GetRate returns the LFO rate in Hz
+func (c *Chorus) GetRate() float64 {
+	return math.Float64frombits(c.rate.Load())
+}
+
+// GetDepth returns the sweep depth in seconds
+func (c *Chorus) GetDepth() float64 {
+	return math.Float64frombits(c.depth.Load())
+}
+
+// GetDelay returns the base delay in seconds
+func (c *Chorus) GetDelay() float64 {
+	return math.Float64frombits(c.delay.Load())
+}
+
+// GetFeedback returns the feedback amount
+func (c *Chorus) GetFeedback() float64 {
+	return math.Float64frombits(c.feedback.Load())
+}
+
+// GetSpread returns the right LFO's phase offset in cycles
+func (c *Chorus) GetSpread() float64 {
+	return math.Float64frombits(c.spread.Load())
+}
+
+// GetWet returns the level of the effect
+func (c *Chorus) GetWet() float64 {
+	return math.Float64frombits(c.wet.Load())
+}
+
+// GetDry returns the level of the input
+func (c *Chorus) GetDry() float64 {
+	return math.Float64frombits(c.dry.Load())
+}
+
+// applyParameters picks up the chorus's current parameters; only the audio thread calls it
+func (cp *chorusProcessor) applyParameters() {
+	c := cp.chorus
+	cp.appliedVersion = c.version.Load()
+	cp.phaseStep = c.GetRate() / cp.sampleRate
+	cp.delayFrames = c.GetDelay() * cp.sampleRate
+	cp.depthFrames = math.Min(c.GetDepth()*cp.sampleRate, cp.delayFrames-1.0) // The sweep stays behind the input
+	cp.feedbackGain = c.GetFeedback()
+	cp.spreadPhase = c.GetSpread()
+	cp.wetGain = c.GetWet()
+	cp.dryGain = c.GetDry()
+}
+
+// readDelayed reads a delay line a fractional number of frames behind the write position
+func (cp *chorusProcessor) readDelayed(buffer []float32, frames float64) float64 {
+	position := float64(cp.writeIdx) - frames
+	if position < 0 {
+		position += float64(len(buffer))
+	}
+	index := int(position)
+	frac := position - float64(index)
+	next := index + 1
+	if next >= len(buffer) {
+		next = 0
+	}
+	return float64(buffer[index])*(1.0-frac) + float64(buffer[next])*frac
+}
+
+// Process runs a stereo bus through the chorus in place
+func (c *Chorus) Process(left, right []float32) {
+	c.processor.Process(left, right)
+}
+
+// Process runs a stereo bus through the swept delay lines in place
+func (cp *chorusProcessor) Process(left, right []float32) {
+	if cp.chorus.version.Load() != cp.appliedVersion {
+		cp.applyParameters()
+	}
+
+	for i := range left {
+		sweepL := math.Sin(2.0 * math.Pi * cp.phase)
+		sweepR := math.Sin(2.0 * math.Pi * (cp.phase + cp.spreadPhase))
+		delayedL := cp.readDelayed(cp.bufferL, cp.delayFrames+sweepL*cp.depthFrames)
+		delayedR := cp.readDelayed(cp.bufferR, cp.delayFrames+sweepR*cp.depthFrames)
+
+		inputL, inputR := float64(left[i]), float64(right[i])
+		cp.bufferL[cp.writeIdx] = float32(inputL + delayedL*cp.feedbackGain)
+		cp.bufferR[cp.writeIdx] = float32(inputR + delayedR*cp.feedbackGain)
+
+		left[i] = float32(inputL*cp.dryGain + delayedL*cp.wetGain)
+		right[i] = float32(inputR*cp.dryGain + delayedR*cp.wetGain)
+
+		cp.writeIdx++
+		if cp.writeIdx >= len(cp.bufferL) {
+			cp.writeIdx = 0
+		}
+		cp.phase += cp.phaseStep
+		if cp.phase >= 1.0 {
+			cp.phase -= 1.0
+		}
+	}
+}
+
+// ControlChange applies the chorus's CC routes
+func (c *Chorus) ControlChange(cc int, value float64) {
+	c.controls.ControlChange(cc, value)
+}
@@ -0,0 +1,86 @@
+package gosfzplayer
+
+import (
+	"math"
+	"testing"
+)
+
+func TestChorusSweep(t *testing.T) {
+	c := NewChorus(44100)
+	c.SetRate(5)
+	left, right := impulseResponse(c, 4410)
+
+	// The impulse comes out around the base delay, within the sweep depth
+	first := -1
+	for i, sample := range left {
+		if sample != 0 {
+			first = i
+			break
+		}
+	}
+	base, depth := c.GetDelay()*44100, c.GetDepth()*44100
+	if first < int(base-depth)-1 || first > int(base+depth)+1 {
+		t.Errorf("Expected the delayed impulse within %f-%f frames, got frame %d", base-depth, base+depth, first)
+	}
+	if peak(left) > 1.0 || peak(right) != 0 {
+		t.Errorf("Unexpected chorus output: left peak %f, right peak %f", peak(left), peak(right))
+	}
+
+	// A steady tone comes out with a moving delay, so it differs from the input
+	c = NewChorus(44100)
+	c.SetDry(1.0)
+	tone := make([]float32, 44100)
+	for i := range tone {
+		tone[i] = float32(0.5 * math.Sin(2.0*math.Pi*440*float64(i)/44100))
+	}
+	left = append([]float32(nil), tone...)
+	right = append([]float32(nil), tone...)
+	c.Process(left, right)
+	difference := 0.0
+	for i := range tone {
+		difference = math.Max(difference, math.Abs(float64(left[i]-right[i])))
+	}
+	if difference == 0 {
+		t.Error("Expected the spread LFOs to make the channels differ")
+	}
+	if level := peak(left); level > 1.0 {
+		t.Errorf("Expected the chorus to stay bounded, got peak %f", level)
+	}
+}
+
+func TestChorusEffectSections(t *testing.T) {
+	e := createTestEngine(t, `<region>
+sample=sample1.wav
+key=60
+
+<effect>
+type=chorus
+chorus_freq=2
+chorus_depth=5
+chorus_phase=90
+chorus_feedback_oncc30=-50
+
+<effect>
+type=flanger
+chorus_delay=3
+`)
+	effects := e.player.Effects("main")
+	if len(effects) != 2 {
+		t.Fatalf("Expected a chorus and a flanger on the main bus, got %d effects", len(effects))
+	}
+	chorus, flanger := effects[0].(*Chorus), effects[1].(*Chorus)
+	if chorus.GetRate() != 2 || math.Abs(chorus.GetDepth()-0.005) > 1e-12 || chorus.GetSpread() != 0.25 {
+		t.Errorf("Unexpected chorus settings: rate %f depth %f spread %f", chorus.GetRate(), chorus.GetDepth(), chorus.GetSpread())
+	}
+	if chorus.GetWet() != 0.5 || chorus.GetDry() != 1.0 {
+		t.Errorf("Expected a main bus chorus to mix 50%% wet with the dry signal, got wet %f dry %f", chorus.GetWet(), chorus.GetDry())
+	}
+	if flanger.GetFeedback() != 0.5 || math.Abs(flanger.GetDelay()-0.003) > 1e-12 || flanger.GetRate() != 0.25 {
+		t.Errorf("Unexpected flanger settings: feedback %f delay %f rate %f", flanger.GetFeedback(), flanger.GetDelay(), flanger.GetRate())
+	}
+
+	e.processControlChange(30, 127)
+	if got := chorus.GetFeedback(); math.Abs(got+0.5) > 1e-9 {
+		t.Errorf("Expected CC30 to set the feedback to -0.5, got %f", got)
+	}
+}
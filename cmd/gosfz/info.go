@@ -0,0 +1,212 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gosfzplayer"
+)
+
+// instrumentInfo is the info command's JSON output
+type instrumentInfo struct {
+	File    string       `json:"file"`
+	Groups  int          `json:"groups"`
+	Regions []regionInfo `json:"regions"`
+	Samples []sampleInfo `json:"samples"`
+	KeyMap  []keyInfo    `json:"key_map"`
+}
+
+// regionInfo describes one compiled region
+type regionInfo struct {
+	Index          int     `json:"index"`
+	Line           int     `json:"line"`
+	Sample         string  `json:"sample"`
+	LoKey          int     `json:"lokey"`
+	HiKey          int     `json:"hikey"`
+	LoVel          int     `json:"lovel"`
+	HiVel          int     `json:"hivel"`
+	PitchKeycenter int     `json:"pitch_keycenter"`
+	Trigger        string  `json:"trigger,omitempty"`
+	Volume         float64 `json:"volume"`
+}
+
+// sampleInfo describes one loaded sample
+type sampleInfo struct {
+	Path       string  `json:"path"`
+	SampleRate int     `json:"sample_rate"`
+	Channels   int     `json:"channels"`
+	Frames     int     `json:"frames"`
+	Seconds    float64 `json:"seconds"`
+	Regions    int     `json:"regions"`
+}
+
+// keyInfo lists the regions (1-based) that a key can trigger
+type keyInfo struct {
+	Key     int   `json:"key"`
+	Regions []int `json:"regions"`
+}
+
+// runInfo loads an instrument and prints its regions, key map and sample stats
+func runInfo(args []string) error {
+	flags := flag.NewFlagSet("info", flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "Print JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: gosfz info [-json] instrument.sfz")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		if err == nil {
+			flags.Usage()
+		}
+		return errUsage
+	}
+
+	path := flags.Arg(0)
+	player, err := gosfzplayer.NewSfzPlayer(path, "")
+	if err != nil {
+		return err
+	}
+	info := describeInstrument(path, player)
+
+	if *jsonOutput {
+		return writeJSON(info)
+	}
+	printInfo(info)
+	return nil
+}
+
+// describeInstrument collects an instrument's regions, samples and key map
+func describeInstrument(path string, player *gosfzplayer.SfzPlayer) instrumentInfo {
+	info := instrumentInfo{
+		File:    path,
+		Groups:  len(player.GetSfzData().Groups),
+		Regions: []regionInfo{},
+		Samples: []sampleInfo{},
+		KeyMap:  []keyInfo{},
+	}
+
+	samples := make(map[string]*sampleInfo)
+	var keys [128][]int
+	for i, region := range player.Regions() {
+		info.Regions = append(info.Regions, regionInfo{
+			Index:          i + 1,
+			Line:           region.Section.Line,
+			Sample:         region.Sample,
+			LoKey:          region.LoKey,
+			HiKey:          region.HiKey,
+			LoVel:          region.LoVel,
+			HiVel:          region.HiVel,
+			PitchKeycenter: region.PitchKeycenter,
+			Trigger:        region.Trigger,
+			Volume:         region.Volume,
+		})
+
+		for key := max(region.LoKey, 0); key <= min(region.HiKey, 127); key++ {
+			keys[key] = append(keys[key], i+1)
+		}
+
+		if region.Sample == "" {
+			continue
+		}
+		if s, ok := samples[region.Sample]; ok {
+			s.Regions++
+			continue
+		}
+		s := &sampleInfo{Path: region.Sample, Regions: 1}
+		if sample, err := player.GetSample(region.Sample); err == nil {
+			s.SampleRate = sample.SampleRate
+			s.Channels = sample.Channels
+			s.Frames = sample.Length
+			if sample.SampleRate > 0 {
+				s.Seconds = float64(sample.Length) / float64(sample.SampleRate)
+			}
+		}
+		samples[region.Sample] = s
+	}
+
+	for _, s := range samples {
+		info.Samples = append(info.Samples, *s)
+	}
+	sort.Slice(info.Samples, func(i, j int) bool { return info.Samples[i].Path < info.Samples[j].Path })
+
+	for key, regions := range keys {
+		if len(regions) > 0 {
+			info.KeyMap = append(info.KeyMap, keyInfo{Key: key, Regions: regions})
+		}
+	}
+	return info
+}
+
+// printInfo prints an instrument summary for people
+func printInfo(info instrumentInfo) {
+	fmt.Printf("File:     %s\n", info.File)
+	fmt.Printf("Regions:  %d in %d groups\n", len(info.Regions), info.Groups)
+
+	var frames int
+	var seconds float64
+	for _, s := range info.Samples {
+		frames += s.Frames
+		seconds += s.Seconds
+	}
+	fmt.Printf("Samples:  %d unique, %d frames, %.1fs total\n", len(info.Samples), frames, seconds)
+
+	if len(info.KeyMap) == 0 {
+		fmt.Println("Keys:     none mapped")
+	} else {
+		keys := noteName(info.KeyMap[0].Key)
+		if last := info.KeyMap[len(info.KeyMap)-1].Key; last != info.KeyMap[0].Key {
+			keys += "-" + noteName(last)
+		}
+		fmt.Printf("Keys:     %s (%d mapped)\n", keys, len(info.KeyMap))
+	}
+
+	fmt.Println("\nKey map:")
+	for i := 0; i < len(info.KeyMap); {
+		// Collapse runs of adjacent keys that trigger the same regions
+		j := i + 1
+		for j < len(info.KeyMap) && info.KeyMap[j].Key == info.KeyMap[j-1].Key+1 && sameInts(info.KeyMap[j].Regions, info.KeyMap[i].Regions) {
+			j++
+		}
+		keys := noteName(info.KeyMap[i].Key)
+		if j-1 > i {
+			keys += "-" + noteName(info.KeyMap[j-1].Key)
+		}
+		fmt.Printf("  %-9s regions %s\n", keys, joinInts(info.KeyMap[i].Regions))
+		i = j
+	}
+
+	fmt.Println("\nRegions:")
+	for _, r := range info.Regions {
+		fmt.Printf("  %3d  keys %3d-%-3d  vel %3d-%-3d  %s\n", r.Index, r.LoKey, r.HiKey, r.LoVel, r.HiVel, r.Sample)
+	}
+
+	fmt.Println("\nSamples:")
+	for _, s := range info.Samples {
+		fmt.Printf("  %-40s %6d Hz  %d ch  %8.2fs  %d regions\n", s.Path, s.SampleRate, s.Channels, s.Seconds, s.Regions)
+	}
+}
+
+// sameInts reports whether two int slices are equal
+func sameInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// joinInts formats ints as a comma-separated list
+func joinInts(values []int) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprint(v)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gosfzplayer"
+)
+
+// lintResult is the lint command's JSON output for one file
+type lintResult struct {
+	File        string           `json:"file"`
+	Errors      int              `json:"errors"`
+	Warnings    int              `json:"warnings"`
+	Diagnostics []lintDiagnostic `json:"diagnostics"`
+}
+
+// lintDiagnostic is one problem found in a file
+type lintDiagnostic struct {
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// runLint checks SFZ files without loading samples and fails if any has errors
+func runLint(args []string) error {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "Print JSON")
+	strict := flags.Bool("strict", false, "Fail on warnings as well as errors")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: gosfz lint [-json] [-strict] instrument.sfz...")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() == 0 {
+		if err == nil {
+			flags.Usage()
+		}
+		return errUsage
+	}
+
+	results := []lintResult{}
+	failed := false
+	for _, path := range flags.Args() {
+		diagnostics, err := gosfzplayer.LintSfzFile(path)
+		if err != nil {
+			return err
+		}
+
+		result := lintResult{File: path, Diagnostics: []lintDiagnostic{}}
+		for _, d := range diagnostics {
+			if d.Severity == "error" {
+				result.Errors++
+			} else {
+				result.Warnings++
+			}
+			result.Diagnostics = append(result.Diagnostics, lintDiagnostic{File: d.File, Line: d.Line, Severity: d.Severity, Message: d.Message})
+			if !*jsonOutput {
+				file := path
+				if d.File != "" {
+					file = filepath.Join(filepath.Dir(path), d.File)
+				}
+				if d.Line > 0 {
+					fmt.Printf("%s:%d: %s: %s\n", file, d.Line, d.Severity, d.Message)
+				} else {
+					fmt.Printf("%s: %s: %s\n", file, d.Severity, d.Message)
+				}
+			}
+		}
+		if result.Errors > 0 || (*strict && result.Warnings > 0) {
+			failed = true
+		}
+		results = append(results, result)
+	}
+
+	if *jsonOutput {
+		if err := writeJSON(results); err != nil {
+			return err
+		}
+	}
+	if failed {
+		return errFailed
+	}
+	return nil
+}
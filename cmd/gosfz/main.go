@@ -0,0 +1,93 @@
+// Command gosfz inspects, checks, renders and plays SFZ instruments.
+//
+// Usage:
+//
+//	gosfz info [-json] instrument.sfz
+//	gosfz lint [-json] instrument.sfz...
+//	gosfz render [-json] [-o out.wav] [-midi song.mid | -notes "C4 E4 G4"] instrument.sfz
+//	gosfz play [-name client] [-watch 1s] instrument.sfz...
+//
+// play needs a binary built with -tags jack.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/GeoffreyPlitt/debuggo"
+)
+
+var debug = debuggo.Debug("sfzplayer:cmd")
+
+// command is a gosfz subcommand
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"info", "Show regions, key map and sample stats", runInfo},
+	{"lint", "Report parser diagnostics and unplayable regions", runLint},
+	{"render", "Render a MIDI file or note list to WAV or FLAC", runRender},
+	{"play", "Play an instrument through JACK", runPlay},
+}
+
+// errUsage reports bad arguments; the subcommand has already printed its usage
+var errUsage = fmt.Errorf("usage")
+
+// errFailed reports a failure that has already been printed, such as lint errors
+var errFailed = fmt.Errorf("failed")
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		debug("Running %s with %v", name, os.Args[2:])
+		switch err := cmd.run(os.Args[2:]); err {
+		case nil:
+			return
+		case errUsage:
+			os.Exit(2)
+		case errFailed:
+			os.Exit(1)
+		default:
+			fmt.Fprintf(os.Stderr, "gosfz %s: %v\n", name, err)
+			os.Exit(1)
+		}
+	}
+
+	if name != "help" && name != "-h" && name != "--help" {
+		fmt.Fprintf(os.Stderr, "gosfz: unknown command %q\n", name)
+	}
+	usage()
+	os.Exit(2)
+}
+
+// usage prints the list of subcommands
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: gosfz <command> [flags] instrument.sfz")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run 'gosfz <command> -h' for a command's flags.")
+}
+
+// writeJSON prints a value as indented JSON to stdout
+func writeJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(v)
+}
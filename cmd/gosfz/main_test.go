@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"gosfzplayer"
+)
+
+func TestParseNote(t *testing.T) {
+	tests := map[string]int{"60": 60, "C4": 60, "c4": 60, "C#4": 61, "Db4": 61, "A0": 21, "C-1": 0, "G9": 127}
+	for input, expected := range tests {
+		if got, err := parseNote(input); err != nil || got != expected {
+			t.Errorf("parseNote(%q) = %d, %v; expected %d", input, got, err, expected)
+		}
+	}
+	for _, input := range []string{"", "H4", "C", "128", "-1", "G#9"} {
+		if _, err := parseNote(input); err == nil {
+			t.Errorf("Expected parseNote(%q) to fail", input)
+		}
+	}
+
+	if name := noteName(61); name != "C#4" {
+		t.Errorf("Expected noteName(61) to be C#4, got %s", name)
+	}
+}
+
+func TestParseNoteList(t *testing.T) {
+	events, err := parseNoteList("C4, E4:90 G4::0:2", 100, 0.5)
+	if err != nil {
+		t.Fatalf("parseNoteList failed: %v", err)
+	}
+
+	expected := []gosfzplayer.TimedMidiEvent{
+		{Time: 0, Data: []byte{0x90, 60, 100}},
+		{Time: 0, Data: []byte{0x90, 67, 100}},
+		{Time: 0.5, Data: []byte{0x80, 60, 0}},
+		{Time: 0.5, Data: []byte{0x90, 64, 90}},
+		{Time: 1.0, Data: []byte{0x80, 64, 0}},
+		{Time: 2.0, Data: []byte{0x80, 67, 0}},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d events, got %d", len(expected), len(events))
+	}
+	for i := range expected {
+		if events[i].Time != expected[i].Time || !bytes.Equal(events[i].Data, expected[i].Data) {
+			t.Errorf("Event %d: expected %v, got %v", i, expected[i], events[i])
+		}
+	}
+
+	for _, list := range []string{"", "C4:0", "C4:100:-1", "C4:100:0:0", "C4:1:2:3:4"} {
+		if _, err := parseNoteList(list, 100, 0.5); err == nil {
+			t.Errorf("Expected parseNoteList(%q) to fail", list)
+		}
+	}
+}
+
+func TestDescribeInstrument(t *testing.T) {
+	path := "../../testdata/edm_drum_loop.sfz"
+	player, err := gosfzplayer.NewSfzPlayer(path, "")
+	if err != nil {
+		t.Fatalf("Failed to load instrument: %v", err)
+	}
+
+	info := describeInstrument(path, player)
+	if len(info.Regions) != 1 || len(info.Samples) != 1 {
+		t.Fatalf("Expected 1 region and 1 sample, got %d and %d", len(info.Regions), len(info.Samples))
+	}
+	if s := info.Samples[0]; s.SampleRate != 44100 || s.Frames == 0 || s.Regions != 1 {
+		t.Errorf("Unexpected sample stats: %+v", s)
+	}
+	if len(info.KeyMap) != 1 || info.KeyMap[0].Key != 36 || info.KeyMap[0].Regions[0] != 1 {
+		t.Errorf("Expected key 36 to map to region 1, got %+v", info.KeyMap)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"gosfzplayer"
+)
+
+// runPlay hosts an instrument in a JACK client until interrupted. With several SFZ files, program
+// changes 0, 1, 2... switch between them.
+func runPlay(args []string) error {
+	flags := flag.NewFlagSet("play", flag.ContinueOnError)
+	name := flags.String("name", "gosfz", "JACK client name")
+	channel := flags.Int("channel", 0, "MIDI channel to respond to (1-16, 0 for all)")
+	watch := flags.Duration("watch", 0, "Reload the instrument when its SFZ files change, checking at this interval (e.g. 1s)")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: gosfz play [-name client] [-channel n] [-watch interval] instrument.sfz...")
+		fmt.Fprintln(os.Stderr, "Program changes 0, 1, 2... select among several instruments.")
+		fmt.Fprintln(os.Stderr, "Requires a binary built with -tags jack.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() < 1 {
+		if err == nil {
+			flags.Usage()
+		}
+		return errUsage
+	}
+
+	player, err := gosfzplayer.NewSfzPlayer(flags.Arg(0), "")
+	if err != nil {
+		return err
+	}
+	if err := player.SetMidiChannel(*channel); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		presets := make([]gosfzplayer.Preset, flags.NArg())
+		for i, path := range flags.Args() {
+			presets[i] = gosfzplayer.Preset{Path: path, Program: i}
+		}
+		if err := player.SetPresets(presets); err != nil {
+			return err
+		}
+		for _, preset := range player.Presets() {
+			fmt.Printf("Program %3d: %s\n", preset.Program, preset.Name)
+		}
+	}
+
+	client, err := gosfzplayer.NewJackClient(player, *name)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if err := client.Start(); err != nil {
+		return err
+	}
+
+	if *watch > 0 {
+		stop := player.Watch(*watch, func(err error) {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "gosfz play: %v\n", err)
+				return
+			}
+			fmt.Printf("Reloaded %s\n", player.InstrumentPath())
+		})
+		defer stop()
+	}
+
+	fmt.Printf("Playing %s as JACK client %q; press Ctrl+C to stop\n", flags.Arg(0), *name)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	return client.Stop()
+}
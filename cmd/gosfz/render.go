@@ -0,0 +1,215 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gosfzplayer"
+)
+
+// renderResult is the render command's JSON output
+type renderResult struct {
+	Output     string  `json:"output"`
+	Frames     int64   `json:"frames"`
+	Seconds    float64 `json:"seconds"`
+	SampleRate uint32  `json:"sample_rate"`
+	Channels   int     `json:"channels"`
+	Events     int     `json:"events"`
+	PeakDB     float64 `json:"peak_db"`           // Highest output level in dBFS
+	Reduction  float64 `json:"gain_reduction_db"` // Most the master limiter turned the output down
+}
+
+// runRender renders a MIDI file or a note list through an instrument to an audio file
+func runRender(args []string) error {
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+	output := flags.String("o", "out.wav", "Output file (.wav or .flac)")
+	midiPath := flags.String("midi", "", "Standard MIDI File to render")
+	notes := flags.String("notes", "", "Notes to render: NOTE[:VELOCITY[:START[:DURATION]]], separated by spaces or commas")
+	velocity := flags.Int("velocity", 100, "Default note velocity")
+	duration := flags.Float64("duration", 0.5, "Default note duration in seconds")
+	sampleRate := flags.Uint("rate", 44100, "Sample rate in Hz")
+	format := flags.String("format", "pcm16", "Sample format: pcm16, pcm24 or float32")
+	dither := flags.Bool("dither", false, "Apply TPDF dither to integer formats")
+	stereo := flags.Bool("stereo", false, "Render stereo, with panning and the reverb's stereo return")
+	tail := flags.Float64("tail", 10, "Longest release and reverb tail in seconds")
+	gain := flags.Float64("gain", 0, "Master gain in dB, ahead of the limiter")
+	softClip := flags.Bool("softclip", false, "Soft clip peaks ahead of the limiter")
+	jsonOutput := flags.Bool("json", false, "Print JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: gosfz render [flags] (-midi song.mid | -notes \"C4 E4:90 G4:90:1:2\") instrument.sfz")
+		fmt.Fprintln(os.Stderr, "Notes without a start time follow the previous note.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 || (*midiPath == "") == (*notes == "") {
+		if err == nil {
+			flags.Usage()
+		}
+		return errUsage
+	}
+
+	options := &gosfzplayer.RenderOptions{
+		SampleRate: uint32(*sampleRate),
+		Channels:   1,
+		MaxTail:    *tail,
+		Metadata: gosfzplayer.AudioMetadata{
+			Instrument: strings.TrimSuffix(filepath.Base(flags.Arg(0)), filepath.Ext(flags.Arg(0))),
+			Software:   "gosfz",
+		},
+	}
+	switch *format {
+	case "pcm16":
+		options.Format = gosfzplayer.FormatPCM16
+	case "pcm24":
+		options.Format = gosfzplayer.FormatPCM24
+	case "float32":
+		options.Format = gosfzplayer.FormatFloat32
+	default:
+		return fmt.Errorf("unknown sample format %q", *format)
+	}
+	if *dither {
+		options.Dither = gosfzplayer.DitherTPDF
+	}
+	if *stereo {
+		options.Channels = 2
+	}
+
+	var events []gosfzplayer.TimedMidiEvent
+	if *midiPath != "" {
+		midiFile, err := gosfzplayer.ParseMidiFile(*midiPath)
+		if err != nil {
+			return err
+		}
+		events = midiFile.PlaybackEvents()
+	} else {
+		var err error
+		events, err = parseNoteList(*notes, *velocity, *duration)
+		if err != nil {
+			return err
+		}
+	}
+
+	player, err := gosfzplayer.NewSfzPlayer(flags.Arg(0), "")
+	if err != nil {
+		return err
+	}
+	player.Master().SetGain(*gain)
+	player.Master().SetSoftClip(*softClip)
+	frames, err := gosfzplayer.RenderMidiEvents(player, events, *output, options)
+	if err != nil {
+		return err
+	}
+	meters := player.Master().Meters()
+
+	result := renderResult{
+		Output:     *output,
+		Frames:     frames,
+		Seconds:    float64(frames) / float64(options.SampleRate),
+		SampleRate: options.SampleRate,
+		Channels:   options.Channels,
+		Events:     len(events),
+		PeakDB:     max(meters.PeakLeft, meters.PeakRight),
+		Reduction:  meters.GainReduction,
+	}
+	if *jsonOutput {
+		return writeJSON(result)
+	}
+	fmt.Printf("Rendered %d events to %s (%.2fs at %d Hz, peak %.1f dBFS", result.Events, result.Output, result.Seconds, result.SampleRate, result.PeakDB)
+	if result.Reduction > 0 {
+		fmt.Printf(", limited by %.1f dB", result.Reduction)
+	}
+	fmt.Println(")")
+	return nil
+}
+
+// parseNoteList turns a note list into note on and note off events sorted by time. Each entry is
+// NOTE[:VELOCITY[:START[:DURATION]]]; an empty or missing START follows the previous note.
+func parseNoteList(list string, velocity int, duration float64) ([]gosfzplayer.TimedMidiEvent, error) {
+	var events []gosfzplayer.TimedMidiEvent
+	next := 0.0
+	for _, entry := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' }) {
+		fields := strings.Split(entry, ":")
+		if len(fields) > 4 {
+			return nil, fmt.Errorf("note %q has too many fields", entry)
+		}
+
+		key, err := parseNote(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		vel, start, length := velocity, next, duration
+		if len(fields) > 1 && fields[1] != "" {
+			if vel, err = strconv.Atoi(fields[1]); err != nil || vel < 1 || vel > 127 {
+				return nil, fmt.Errorf("note %q: velocity must be 1-127", entry)
+			}
+		}
+		if len(fields) > 2 && fields[2] != "" {
+			if start, err = strconv.ParseFloat(fields[2], 64); err != nil || start < 0 {
+				return nil, fmt.Errorf("note %q: invalid start time", entry)
+			}
+		}
+		if len(fields) > 3 && fields[3] != "" {
+			if length, err = strconv.ParseFloat(fields[3], 64); err != nil || length <= 0 {
+				return nil, fmt.Errorf("note %q: invalid duration", entry)
+			}
+		}
+
+		events = append(events,
+			gosfzplayer.TimedMidiEvent{Time: start, Data: []byte{0x90, byte(key), byte(vel)}},
+			gosfzplayer.TimedMidiEvent{Time: start + length, Data: []byte{0x80, byte(key), 0}},
+		)
+		next = start + length
+	}
+	if len(events) == 0 {
+		return nil, fmt.Errorf("no notes given")
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time < events[j].Time })
+	return events, nil
+}
+
+// noteNames are the pitch class names used for parsing and printing notes
+var noteNames = []string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+
+// parseNote parses a MIDI note number or a note name such as C4, F#3 or Bb2 (C4 = 60)
+func parseNote(s string) (int, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 0 || n > 127 {
+			return 0, fmt.Errorf("note %d out of range 0-127", n)
+		}
+		return n, nil
+	}
+
+	upper := strings.ToUpper(s)
+	if upper == "" || upper[0] < 'A' || upper[0] > 'G' {
+		return 0, fmt.Errorf("invalid note %q", s)
+	}
+	pitch := map[byte]int{'C': 0, 'D': 2, 'E': 4, 'F': 5, 'G': 7, 'A': 9, 'B': 11}[upper[0]]
+	rest := s[1:]
+	switch {
+	case strings.HasPrefix(rest, "#"):
+		pitch++
+		rest = rest[1:]
+	case strings.HasPrefix(rest, "b"):
+		pitch--
+		rest = rest[1:]
+	}
+	octave, err := strconv.Atoi(rest)
+	if err != nil {
+		return 0, fmt.Errorf("invalid note %q", s)
+	}
+	n := (octave+1)*12 + pitch
+	if n < 0 || n > 127 {
+		return 0, fmt.Errorf("note %q out of range", s)
+	}
+	return n, nil
+}
+
+// noteName returns the name of a MIDI note number (60 = C4)
+func noteName(n int) string {
+	return fmt.Sprintf("%s%d", noteNames[n%12], n/12-1)
+}
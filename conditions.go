@@ -0,0 +1,120 @@
+package gosfzplayer
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// ccConditionOpcodePattern matches CC range conditions (loccN, hiccN)
+var ccConditionOpcodePattern = regexp.MustCompile(`^(lo|hi)cc(\d+)$`)
+
+// ccCondition is a loccN/hiccN range
+type ccCondition struct {
+	cc     int
+	lo, hi int
+}
+
+// regionConditions holds a region's controller-based trigger conditions
+type regionConditions struct {
+	ccRanges             []ccCondition
+	lobend, hibend       int
+	lochanaft, hichanaft int
+	lopolyaft, hipolyaft int
+	lobpm, hibpm         float64
+	lotimer, hitimer     float64 // Seconds since the region's group last played
+}
+
+// isConditionOpcode checks if an opcode is a CC range condition
+func isConditionOpcode(opcode string) bool {
+	m := ccConditionOpcodePattern.FindStringSubmatch(opcode)
+	if m == nil {
+		return false
+	}
+	cc, _ := strconv.Atoi(m[2])
+	return cc <= 127
+}
+
+// compileRegionConditions collects a region's condition opcodes
+func compileRegionConditions(region *SfzSection) *regionConditions {
+	conditions := &regionConditions{
+		lobend:    region.GetInheritedIntOpcode("lobend", -8192),
+		hibend:    region.GetInheritedIntOpcode("hibend", 8192),
+		lochanaft: region.GetInheritedIntOpcode("lochanaft", 0),
+		hichanaft: region.GetInheritedIntOpcode("hichanaft", 127),
+		lopolyaft: region.GetInheritedIntOpcode("lopolyaft", 0),
+		hipolyaft: region.GetInheritedIntOpcode("hipolyaft", 127),
+		lobpm:     region.GetInheritedFloatOpcode("lobpm", 0),
+		hibpm:     region.GetInheritedFloatOpcode("hibpm", 500),
+		lotimer:   region.GetInheritedFloatOpcode("lotimer", 0),
+		hitimer:   region.GetInheritedFloatOpcode("hitimer", math.Inf(1)),
+	}
+
+	byCC := make(map[int]*ccCondition)
+	for opcode, value := range region.inheritedOpcodes() {
+		m := ccConditionOpcodePattern.FindStringSubmatch(opcode)
+		if m == nil {
+			continue
+		}
+		cc, _ := strconv.Atoi(m[2])
+		condition, exists := byCC[cc]
+		if !exists {
+			condition = &ccCondition{cc: cc, lo: 0, hi: 127}
+			byCC[cc] = condition
+		}
+		if m[1] == "lo" {
+			condition.lo = convertToInt(value, opcode, 0)
+		} else {
+			condition.hi = convertToInt(value, opcode, 127)
+		}
+	}
+	for _, condition := range byCC {
+		conditions.ccRanges = append(conditions.ccRanges, *condition)
+	}
+	sort.Slice(conditions.ccRanges, func(i, j int) bool { return conditions.ccRanges[i].cc < conditions.ccRanges[j].cc })
+
+	return conditions
+}
+
+// conditionsMatch checks a region's CC, bend, aftertouch, tempo and timer conditions
+// against the state of the channel a note arrived on
+func (e *engine) conditionsMatch(region *Region, channel, note uint8) bool {
+	c := region.conditions
+	state := &e.channelState[channel&0x0F]
+
+	for _, cc := range c.ccRanges {
+		value := int(state.cc[cc.cc])
+		if value < cc.lo || value > cc.hi {
+			return false
+		}
+	}
+
+	if bend := int(state.pitchBend); bend < c.lobend || bend > c.hibend {
+		return false
+	}
+
+	if aft := int(state.channelAftertouch); aft < c.lochanaft || aft > c.hichanaft {
+		return false
+	}
+
+	if aft := int(state.polyAftertouch[note&0x7F]); aft < c.lopolyaft || aft > c.hipolyaft {
+		return false
+	}
+
+	if bpm := e.player.GetTempo(); bpm < c.lobpm || bpm >= c.hibpm {
+		return false
+	}
+
+	if c.lotimer > 0 || !math.IsInf(c.hitimer, 1) {
+		elapsed := math.Inf(1)
+		if last := e.groupTriggers[region.parentGroup()]; last != neverTriggered {
+			elapsed = float64(e.frameClock-last) / float64(e.sampleRate)
+		}
+		if elapsed < c.lotimer || elapsed > c.hitimer {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,146 @@
+package gosfzplayer
+
+import (
+	"testing"
+)
+
+// triggeredSamples sends a note-on message and returns the sample files of the voices it started
+func triggeredSamples(e *engine, message []byte) []string {
+	e.clearVoices()
+	e.processMidiMessage(message)
+	var samples []string
+	for _, voice := range e.activeVoices {
+		samples = append(samples, voice.region.Sample)
+	}
+	return samples
+}
+
+func TestCCRangeConditions(t *testing.T) {
+	e := createTestEngine(t, `<group>
+key=60
+
+<region>
+sample=sample1.wav
+locc1=0
+hicc1=63
+
+<region>
+sample=sample2.wav
+locc1=64
+hicc1=127
+`)
+
+	if got := triggeredSamples(e, []byte{0x90, 60, 100}); len(got) != 1 || got[0] != "sample1.wav" {
+		t.Errorf("Expected sample1 with CC1 at 0, got %v", got)
+	}
+
+	e.processMidiMessage([]byte{0xB0, 1, 100})
+	if got := triggeredSamples(e, []byte{0x90, 60, 100}); len(got) != 1 || got[0] != "sample2.wav" {
+		t.Errorf("Expected sample2 with CC1 at 100, got %v", got)
+	}
+
+	// CC state is tracked per channel
+	if got := triggeredSamples(e, []byte{0x91, 60, 100}); len(got) != 1 || got[0] != "sample1.wav" {
+		t.Errorf("Expected channel 2 to keep its own CC1 value, got %v", got)
+	}
+}
+
+func TestBendAndAftertouchConditions(t *testing.T) {
+	e := createTestEngine(t, `<group>
+key=60
+
+<region>
+sample=sample1.wav
+lobend=4096
+
+<region>
+sample=sample2.wav
+lochanaft=100
+
+<region>
+sample=sample3.wav
+lopolyaft=64
+`)
+
+	if got := triggeredSamples(e, []byte{0x90, 60, 100}); len(got) != 0 {
+		t.Errorf("Expected no regions without bend or aftertouch, got %v", got)
+	}
+
+	e.processMidiMessage([]byte{0xE0, 0x7F, 0x7F}) // Full bend up
+	if got := triggeredSamples(e, []byte{0x90, 60, 100}); len(got) != 1 || got[0] != "sample1.wav" {
+		t.Errorf("Expected the lobend region with bend up, got %v", got)
+	}
+	e.processMidiMessage([]byte{0xE0, 0x00, 0x40}) // Center
+
+	e.processMidiMessage([]byte{0xD0, 110})
+	if got := triggeredSamples(e, []byte{0x90, 60, 100}); len(got) != 1 || got[0] != "sample2.wav" {
+		t.Errorf("Expected the lochanaft region with channel aftertouch, got %v", got)
+	}
+	e.processMidiMessage([]byte{0xD0, 0})
+
+	// Poly aftertouch is per note
+	e.processMidiMessage([]byte{0xA0, 61, 127})
+	if got := triggeredSamples(e, []byte{0x90, 60, 100}); len(got) != 0 {
+		t.Errorf("Expected aftertouch on another note to be ignored, got %v", got)
+	}
+	e.processMidiMessage([]byte{0xA0, 60, 127})
+	if got := triggeredSamples(e, []byte{0x90, 60, 100}); len(got) != 1 || got[0] != "sample3.wav" {
+		t.Errorf("Expected the lopolyaft region with poly aftertouch, got %v", got)
+	}
+}
+
+func TestBpmConditions(t *testing.T) {
+	e := createTestEngine(t, `<group>
+key=60
+
+<region>
+sample=sample1.wav
+hibpm=100
+
+<region>
+sample=sample2.wav
+lobpm=100
+`)
+
+	if got := triggeredSamples(e, []byte{0x90, 60, 100}); len(got) != 1 || got[0] != "sample2.wav" {
+		t.Errorf("Expected the lobpm region at the default 120 BPM, got %v", got)
+	}
+
+	e.player.SetTempo(90)
+	if got := triggeredSamples(e, []byte{0x90, 60, 100}); len(got) != 1 || got[0] != "sample1.wav" {
+		t.Errorf("Expected the hibpm region at 90 BPM, got %v", got)
+	}
+}
+
+func TestTimerConditions(t *testing.T) {
+	e := createTestEngine(t, `<group>
+key=60
+hitimer=0.1
+
+<region>
+sample=sample1.wav
+
+<group>
+key=60
+lotimer=0.1
+
+<region>
+sample=sample2.wav
+`)
+
+	// Neither group has played: only the lotimer group matches
+	if got := triggeredSamples(e, []byte{0x90, 60, 100}); len(got) != 1 || got[0] != "sample2.wav" {
+		t.Fatalf("Expected only the lotimer group on the first hit, got %v", got)
+	}
+
+	// The hitimer group has never played, so it still doesn't match
+	renderTestFrames(e, 441)
+	if got := triggeredSamples(e, []byte{0x90, 60, 100}); len(got) != 0 {
+		t.Errorf("Expected no regions 10ms after the lotimer group played, got %v", got)
+	}
+
+	renderTestFrames(e, 4410)
+	if got := triggeredSamples(e, []byte{0x90, 60, 100}); len(got) != 1 || got[0] != "sample2.wav" {
+		t.Errorf("Expected the lotimer group after 100ms, got %v", got)
+	}
+}
@@ -0,0 +1,52 @@
+package gosfzplayer
+
+import (
+	"sync/atomic"
+)
+
+// controlQueueSize is the number of control messages that can wait for the audio thread (a power of two)
+const controlQueueSize = 1024
+
+// controlMessage is a MIDI channel message queued for the audio thread
+type controlMessage struct {
+	data   [3]byte
+	length uint8
+}
+
+// bytes returns the message's MIDI bytes
+func (m *controlMessage) bytes() []byte {
+	return m.data[:m.length]
+}
+
+// controlQueue is a lock-free single-producer, single-consumer ring buffer. The audio thread is the
+// only consumer; producers on other goroutines must be serialized by the caller.
+type controlQueue struct {
+	head     atomic.Uint64 // Next slot to read, advanced by the consumer
+	tail     atomic.Uint64 // Next slot to write, advanced by the producer
+	messages [controlQueueSize]controlMessage
+}
+
+// push queues a message, returning false if the queue is full
+func (q *controlQueue) push(data []byte) bool {
+	tail := q.tail.Load()
+	if tail-q.head.Load() >= controlQueueSize {
+		return false
+	}
+
+	m := &q.messages[tail&(controlQueueSize-1)]
+	m.length = uint8(copy(m.data[:], data))
+	q.tail.Store(tail + 1)
+	return true
+}
+
+// pop removes the oldest message into m, returning false if the queue is empty
+func (q *controlQueue) pop(m *controlMessage) bool {
+	head := q.head.Load()
+	if head == q.tail.Load() {
+		return false
+	}
+
+	*m = q.messages[head&(controlQueueSize-1)]
+	q.head.Store(head + 1)
+	return true
+}
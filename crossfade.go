@@ -0,0 +1,205 @@
+package gosfzplayer
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// xfCCOpcodePattern matches CC crossfade opcodes (xfin_loccN, xfin_hiccN, xfout_loccN, xfout_hiccN)
+var xfCCOpcodePattern = regexp.MustCompile(`^xf(in|out)_(lo|hi)cc(\d+)$`)
+
+// xfCurve selects the gain law of a crossfade
+type xfCurve int
+
+const (
+	xfCurvePower xfCurve = iota // Equal-power (default)
+	xfCurveGain                 // Linear gain
+)
+
+// isCrossfadeOpcode checks if an opcode is a CC crossfade opcode
+func isCrossfadeOpcode(opcode string) bool {
+	m := xfCCOpcodePattern.FindStringSubmatch(opcode)
+	if m == nil {
+		return false
+	}
+	cc, _ := strconv.Atoi(m[3])
+	return cc <= 127
+}
+
+// parseXfCurve converts an xf_keycurve/xf_velcurve/xf_cccurve value to a gain law
+func parseXfCurve(value string) xfCurve {
+	if value == "gain" {
+		return xfCurveGain
+	}
+	return xfCurvePower
+}
+
+// xfGain converts a crossfade position (0.0 to 1.0) into a gain using the given law
+func xfGain(position float64, curve xfCurve) float64 {
+	position = clampFloat64(position, 0.0, 1.0)
+	if curve == xfCurveGain {
+		return position
+	}
+	// Equal-power: fade-in and fade-out gains sum to constant power
+	return math.Sqrt(position)
+}
+
+// xfadeIn returns the fade-in position (0.0 to 1.0) of value within lo..hi
+func xfadeIn(value, lo, hi float64) float64 {
+	if value >= hi {
+		return 1.0
+	}
+	if value <= lo {
+		return 0.0
+	}
+	return (value - lo) / (hi - lo)
+}
+
+// xfadeOut returns the fade-out position (1.0 to 0.0) of value within lo..hi
+func xfadeOut(value, lo, hi float64) float64 {
+	if value <= lo {
+		return 1.0
+	}
+	if value >= hi {
+		return 0.0
+	}
+	return (hi - value) / (hi - lo)
+}
+
+// keyVelCrossfade holds a region's key and velocity crossfade ranges
+type keyVelCrossfade struct {
+	keyInLo, keyInHi   float64
+	keyOutLo, keyOutHi float64
+	keyCurve           xfCurve
+	velInLo, velInHi   float64
+	velOutLo, velOutHi float64
+	velCurve           xfCurve
+}
+
+// compileKeyVelCrossfade collects a region's xfin_lokey..xfout_hivel opcodes
+func compileKeyVelCrossfade(region *SfzSection) keyVelCrossfade {
+	return keyVelCrossfade{
+		keyInLo:  region.GetInheritedFloatOpcode("xfin_lokey", 0),
+		keyInHi:  region.GetInheritedFloatOpcode("xfin_hikey", 0),
+		keyOutLo: region.GetInheritedFloatOpcode("xfout_lokey", 127),
+		keyOutHi: region.GetInheritedFloatOpcode("xfout_hikey", 127),
+		keyCurve: parseXfCurve(region.GetInheritedStringOpcode("xf_keycurve")),
+		velInLo:  region.GetInheritedFloatOpcode("xfin_lovel", 0),
+		velInHi:  region.GetInheritedFloatOpcode("xfin_hivel", 0),
+		velOutLo: region.GetInheritedFloatOpcode("xfout_lovel", 127),
+		velOutHi: region.GetInheritedFloatOpcode("xfout_hivel", 127),
+		velCurve: parseXfCurve(region.GetInheritedStringOpcode("xf_velcurve")),
+	}
+}
+
+// gain computes the key and velocity crossfade gain at note-on
+func (xf *keyVelCrossfade) gain(note, velocity uint8) float64 {
+	key := float64(note)
+	gain := xfGain(xfadeIn(key, xf.keyInLo, xf.keyInHi), xf.keyCurve)
+	gain *= xfGain(xfadeOut(key, xf.keyOutLo, xf.keyOutHi), xf.keyCurve)
+
+	vel := float64(velocity)
+	gain *= xfGain(xfadeIn(vel, xf.velInLo, xf.velInHi), xf.velCurve)
+	gain *= xfGain(xfadeOut(vel, xf.velOutLo, xf.velOutHi), xf.velCurve)
+
+	return gain
+}
+
+// ccCrossfade holds the fade-in and fade-out ranges of one controller
+type ccCrossfade struct {
+	cc           int
+	inLo, inHi   float64
+	outLo, outHi float64
+}
+
+// crossfadeSpec holds a region's CC crossfades
+type crossfadeSpec struct {
+	fades []ccCrossfade
+	curve xfCurve
+}
+
+// compileCrossfadeSpec collects a region's xfin_loccN/xfout_hiccN opcodes, returning nil if there are none
+func compileCrossfadeSpec(region *SfzSection) *crossfadeSpec {
+	byCC := make(map[int]*ccCrossfade)
+	for opcode, value := range region.inheritedOpcodes() {
+		m := xfCCOpcodePattern.FindStringSubmatch(opcode)
+		if m == nil {
+			continue
+		}
+		cc, _ := strconv.Atoi(m[3])
+		fade, exists := byCC[cc]
+		if !exists {
+			fade = &ccCrossfade{cc: cc, outLo: 127, outHi: 127}
+			byCC[cc] = fade
+		}
+
+		amount := convertToFloat(value, opcode, 0)
+		switch m[1] + "_" + m[2] {
+		case "in_lo":
+			fade.inLo = amount
+		case "in_hi":
+			fade.inHi = amount
+		case "out_lo":
+			fade.outLo = amount
+		case "out_hi":
+			fade.outHi = amount
+		}
+	}
+
+	if len(byCC) == 0 {
+		return nil
+	}
+
+	spec := &crossfadeSpec{curve: parseXfCurve(region.GetInheritedStringOpcode("xf_cccurve"))}
+	for _, fade := range byCC {
+		spec.fades = append(spec.fades, *fade)
+	}
+	sort.Slice(spec.fades, func(i, j int) bool { return spec.fades[i].cc < spec.fades[j].cc })
+	return spec
+}
+
+// gain returns the combined CC crossfade gain for the current CC values
+func (s *crossfadeSpec) gain(ccValues *[128]float64) float64 {
+	gain := 1.0
+	for _, fade := range s.fades {
+		value := ccValues[fade.cc] * 127.0
+		gain *= xfGain(xfadeIn(value, fade.inLo, fade.inHi), s.curve)
+		gain *= xfGain(xfadeOut(value, fade.outLo, fade.outHi), s.curve)
+	}
+	return gain
+}
+
+// ccCrossfadeState smooths a voice's CC crossfade gain
+type ccCrossfadeState struct {
+	spec        *crossfadeSpec
+	ccValues    *[128]float64
+	coefficient float64
+	current     float64
+}
+
+// InitializeCrossfade sets up CC crossfading for a voice
+func (v *Voice) InitializeCrossfade(spec *crossfadeSpec, sampleRate uint32, ccValues *[128]float64) {
+	if spec == nil {
+		v.crossfade = ccCrossfadeState{}
+		return
+	}
+	v.crossfade = ccCrossfadeState{
+		spec:        spec,
+		ccValues:    ccValues,
+		coefficient: math.Exp(-1.0 / (minModSmoothTime * float64(sampleRate))),
+		current:     spec.gain(ccValues),
+	}
+}
+
+// ProcessCrossfade advances the CC crossfade smoother by one sample and returns the gain
+func (v *Voice) ProcessCrossfade() float64 {
+	xf := &v.crossfade
+	if xf.spec == nil {
+		return 1.0
+	}
+	target := xf.spec.gain(xf.ccValues)
+	xf.current = target + (xf.current-target)*xf.coefficient
+	return xf.current
+}
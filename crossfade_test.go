@@ -0,0 +1,127 @@
+package gosfzplayer
+
+import (
+	"math"
+	"testing"
+)
+
+func TestXfadeRanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		fadeIn   bool
+		value    float64
+		lo, hi   float64
+		expected float64
+	}{
+		{"in below", true, 10, 20, 40, 0.0},
+		{"in middle", true, 30, 20, 40, 0.5},
+		{"in above", true, 50, 20, 40, 1.0},
+		{"in default range", true, 0, 0, 0, 1.0},
+		{"out below", false, 10, 20, 40, 1.0},
+		{"out middle", false, 25, 20, 40, 0.75},
+		{"out above", false, 50, 20, 40, 0.0},
+		{"out default range", false, 127, 127, 127, 1.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got float64
+			if tt.fadeIn {
+				got = xfadeIn(tt.value, tt.lo, tt.hi)
+			} else {
+				got = xfadeOut(tt.value, tt.lo, tt.hi)
+			}
+			if math.Abs(got-tt.expected) > 1e-9 {
+				t.Errorf("got %f, want %f", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestXfCurves(t *testing.T) {
+	// Equal-power: two layers crossing at the midpoint keep constant power
+	in := xfGain(0.5, xfCurvePower)
+	out := xfGain(0.5, xfCurvePower)
+	if math.Abs(in*in+out*out-1.0) > 1e-9 {
+		t.Errorf("Expected equal-power crossfade to keep unit power, got %f", in*in+out*out)
+	}
+
+	// Gain: two layers crossing at the midpoint keep constant amplitude
+	if got := xfGain(0.5, parseXfCurve("gain")); got != 0.5 {
+		t.Errorf("Expected linear gain 0.5, got %f", got)
+	}
+
+	if parseXfCurve("") != xfCurvePower {
+		t.Error("Expected equal-power to be the default crossfade curve")
+	}
+}
+
+func TestVelocityCrossfadeGain(t *testing.T) {
+	region := &SfzSection{
+		Type: "region",
+		Opcodes: map[string]string{
+			"xfin_lovel":  "40",
+			"xfin_hivel":  "80",
+			"xf_velcurve": "gain",
+		},
+	}
+
+	xf := compileKeyVelCrossfade(region)
+	if got := xf.gain(60, 20); got != 0 {
+		t.Errorf("Expected silence below xfin_lovel, got %f", got)
+	}
+	if got := xf.gain(60, 60); math.Abs(got-0.5) > 1e-9 {
+		t.Errorf("Expected half gain in the middle of the fade, got %f", got)
+	}
+	if got := xf.gain(60, 100); got != 1 {
+		t.Errorf("Expected full gain above xfin_hivel, got %f", got)
+	}
+}
+
+func TestAmpKeytrack(t *testing.T) {
+	e := createTestEngine(t, `<region>
+sample=sample1.wav
+amp_keytrack=-1.5
+amp_keycenter=60
+`)
+
+	region := e.player.current().regions[0]
+	if got := e.calculateKeyGain(region, 60, 100); math.Abs(got-1.0) > 1e-9 {
+		t.Errorf("Expected unity gain at amp_keycenter, got %f", got)
+	}
+	if got := e.calculateKeyGain(region, 64, 100); math.Abs(got-dbToLinear(-6)) > 1e-9 {
+		t.Errorf("Expected -6dB four keys above amp_keycenter, got %f", got)
+	}
+}
+
+func TestCCCrossfadeRendering(t *testing.T) {
+	e := createTestEngine(t, `<group>
+xfin_locc1=0
+xfin_hicc1=127
+xf_cccurve=gain
+
+<region>
+sample=sample1.wav
+key=60
+pitch_keycenter=60
+ampeg_attack=0
+`)
+
+	spec := e.player.current().regions[0].crossfade
+	if spec == nil || len(spec.fades) != 1 || spec.fades[0].cc != 1 || spec.fades[0].inHi != 127 {
+		t.Fatalf("Unexpected crossfade spec: %+v", spec)
+	}
+
+	// CC1 at 0: the layer is faded out
+	e.noteOn(60, 127)
+	if rms := calculateRMS(renderTestFrames(e, 2205)); rms > 1e-6 {
+		t.Errorf("Expected silence with CC1 at 0, got RMS %f", rms)
+	}
+
+	// CC1 at 127: the layer fades in while the note sounds
+	e.processControlChange(1, 127)
+	renderTestFrames(e, 2205)
+	if rms := calculateRMS(renderTestFrames(e, 2205)); rms == 0 {
+		t.Error("Expected the layer to fade in with CC1 at 127")
+	}
+}
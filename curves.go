@@ -0,0 +1,176 @@
+package gosfzplayer
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// Curve opcode patterns
+var (
+	// vNNN inside <curve> sections
+	curvePointOpcodePattern = regexp.MustCompile(`^v(\d{1,3})$`)
+	// amp_velcurve_N in regions
+	velCurveOpcodePattern = regexp.MustCompile(`^amp_velcurve_(\d{1,3})$`)
+)
+
+// numBuiltinCurves is the number of predefined SFZ v2 curves (indexes 0-6)
+const numBuiltinCurves = 7
+
+// Curve maps a normalized input (0.0 to 1.0) to an output value through 128 points
+type Curve struct {
+	points [128]float64
+}
+
+// isCurveOpcode checks if an opcode defines curve points (vNNN or amp_velcurve_N)
+func isCurveOpcode(opcode string) bool {
+	if m := curvePointOpcodePattern.FindStringSubmatch(opcode); m != nil {
+		index, _ := strconv.Atoi(m[1])
+		return index <= 127
+	}
+	if m := velCurveOpcodePattern.FindStringSubmatch(opcode); m != nil {
+		index, _ := strconv.Atoi(m[1])
+		return index <= 127
+	}
+	return false
+}
+
+// newCurveFromFunc samples a function at the 128 curve points
+func newCurveFromFunc(fn func(x float64) float64) *Curve {
+	curve := &Curve{}
+	for i := range curve.points {
+		curve.points[i] = fn(float64(i) / 127.0)
+	}
+	return curve
+}
+
+// newCurveFromPoints builds a curve from sparse points, interpolating linearly between them.
+// Unspecified endpoints default to 0 at index 0 and 1 at index 127.
+func newCurveFromPoints(points map[int]float64) *Curve {
+	known := make(map[int]float64, len(points)+2)
+	known[0] = 0.0
+	known[127] = 1.0
+	for index, value := range points {
+		if index >= 0 && index <= 127 {
+			known[index] = value
+		}
+	}
+
+	curve := &Curve{}
+	prev := 0
+	for i := 1; i <= 127; i++ {
+		value, exists := known[i]
+		if !exists {
+			continue
+		}
+		// Interpolate between the previous known point and this one
+		start := known[prev]
+		for j := prev; j <= i; j++ {
+			t := float64(j-prev) / float64(i-prev)
+			curve.points[j] = start + (value-start)*t
+		}
+		prev = i
+	}
+	return curve
+}
+
+// Evaluate returns the curve value for a normalized input (0.0 to 1.0)
+func (c *Curve) Evaluate(x float64) float64 {
+	position := clampFloat64(x, 0.0, 1.0) * 127.0
+	index := int(position)
+	if index >= 127 {
+		return c.points[127]
+	}
+	frac := position - float64(index)
+	return c.points[index] + frac*(c.points[index+1]-c.points[index])
+}
+
+// At returns the curve value at an integer MIDI value (0-127)
+func (c *Curve) At(value uint8) float64 {
+	if value > 127 {
+		value = 127
+	}
+	return c.points[value]
+}
+
+// CurveSet holds the predefined curves plus any <curve> sections from an SFZ file
+type CurveSet struct {
+	curves map[int]*Curve
+}
+
+// NewCurveSet builds a curve set from parsed <curve> sections (nil for built-in curves only)
+func NewCurveSet(sections []*SfzSection) *CurveSet {
+	cs := &CurveSet{curves: make(map[int]*Curve)}
+
+	for i := 0; i < numBuiltinCurves; i++ {
+		index := i
+		cs.curves[index] = newCurveFromFunc(func(x float64) float64 {
+			return builtinCurve(index, x)
+		})
+	}
+
+	for _, section := range sections {
+		index := section.GetIntOpcode("curve_index", -1)
+		if index < 0 || index > 255 {
+			parserDebug("Warning: <curve> section without valid curve_index, skipping")
+			continue
+		}
+
+		points := make(map[int]float64)
+		for opcode, value := range section.Opcodes {
+			if m := curvePointOpcodePattern.FindStringSubmatch(opcode); m != nil {
+				point, _ := strconv.Atoi(m[1])
+				points[point] = convertToFloat(value, opcode, 0)
+			}
+		}
+		cs.curves[index] = newCurveFromPoints(points)
+		parserDebug("Loaded curve %d with %d points", index, len(points))
+	}
+
+	return cs
+}
+
+// Get returns the curve with the given index, falling back to linear for unknown indexes
+func (cs *CurveSet) Get(index int) *Curve {
+	if cs != nil {
+		if curve, exists := cs.curves[index]; exists {
+			return curve
+		}
+	}
+	return linearCurve
+}
+
+// linearCurve is the identity curve used when no curve is specified
+var linearCurve = newCurveFromFunc(func(x float64) float64 { return x })
+
+// defaultVelocityCurve is the standard SFZ amplitude velocity response (velocity squared)
+var defaultVelocityCurve = newCurveFromFunc(func(x float64) float64 { return x * x })
+
+// compileVelocityCurve builds a region's amp_velcurve_N curve, or returns the default curve
+func compileVelocityCurve(region *SfzSection) *Curve {
+	points := make(map[int]float64)
+	for opcode, value := range region.inheritedOpcodes() {
+		if m := velCurveOpcodePattern.FindStringSubmatch(opcode); m != nil {
+			index, _ := strconv.Atoi(m[1])
+			points[index] = convertToFloat(value, opcode, 0)
+		}
+	}
+
+	if len(points) == 0 {
+		return defaultVelocityCurve
+	}
+	return newCurveFromPoints(points)
+}
+
+// velocityGain applies amp_veltrack (-100 to 100%) to a velocity curve value
+func velocityGain(curve *Curve, velocity uint8, veltrack float64) float64 {
+	track := clampFloat64(veltrack, -100.0, 100.0) / 100.0
+	value := curve.At(velocity)
+
+	if track >= 0 {
+		// Positive tracking: louder with velocity, 0% tracking is constant full gain
+		return math.Max(1.0-track*(1.0-value), 0.0)
+	}
+	// Negative tracking: softer with velocity
+	return math.Max(1.0+track*value, 0.0)
+}
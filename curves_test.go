@@ -0,0 +1,108 @@
+package gosfzplayer
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseCurveSections(t *testing.T) {
+	e := createTestEngine(t, `<curve>
+curve_index=7
+v000=0
+v063=1
+v127=1
+
+<region>
+sample=sample1.wav
+key=60
+volume_oncc1=-20
+volume_curvecc1=7
+`)
+
+	if len(e.player.current().sfzData.Curves) != 1 {
+		t.Fatalf("Expected 1 curve section, got %d", len(e.player.current().sfzData.Curves))
+	}
+
+	curve := e.player.current().curves.Get(7)
+	if got := curve.At(63); got != 1.0 {
+		t.Errorf("Expected curve 7 at v063 to be 1.0, got %f", got)
+	}
+	if got := curve.At(127); got != 1.0 {
+		t.Errorf("Expected curve 7 at v127 to be 1.0, got %f", got)
+	}
+
+	// curvecc should resolve the user-defined curve
+	spec := e.player.current().regions[0].modulation
+	route := spec.ccRoutes[ModTargetVolume][0]
+	if got := route.curve.Evaluate(0.5); math.Abs(got-1.0) > 0.02 {
+		t.Errorf("Expected volume route to use curve 7, got %f at 0.5", got)
+	}
+
+	// Unknown curves fall back to linear
+	if got := e.player.current().curves.Get(42).Evaluate(0.5); math.Abs(got-0.5) > 1e-9 {
+		t.Errorf("Expected unknown curve to be linear, got %f", got)
+	}
+}
+
+func TestNewCurveFromPoints(t *testing.T) {
+	curve := newCurveFromPoints(map[int]float64{64: 0.8})
+
+	tests := []struct {
+		index    uint8
+		expected float64
+	}{
+		{0, 0.0},
+		{32, 0.4},
+		{64, 0.8},
+		{127, 1.0},
+	}
+
+	for _, tt := range tests {
+		if got := curve.At(tt.index); math.Abs(got-tt.expected) > 1e-9 {
+			t.Errorf("At(%d) = %f, want %f", tt.index, got, tt.expected)
+		}
+	}
+}
+
+func TestVelocityGain(t *testing.T) {
+	tests := []struct {
+		name     string
+		velocity uint8
+		veltrack float64
+		expected float64
+	}{
+		{"full velocity", 127, 100, 1.0},
+		{"half velocity squared", 64, 100, math.Pow(64.0/127.0, 2)},
+		{"zero tracking", 1, 0, 1.0},
+		{"half tracking", 0, 50, 0.5},
+		{"negative tracking soft", 0, -100, 1.0},
+		{"negative tracking loud", 127, -100, 0.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := velocityGain(defaultVelocityCurve, tt.velocity, tt.veltrack)
+			if math.Abs(got-tt.expected) > 1e-9 {
+				t.Errorf("velocityGain(%d, %.0f) = %f, want %f", tt.velocity, tt.veltrack, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAmpVelcurveRegion(t *testing.T) {
+	e := createTestEngine(t, `<group>
+amp_velcurve_64=1
+
+<region>
+sample=sample1.wav
+key=60
+`)
+
+	region := e.player.current().regions[0]
+	if got := e.calculateVolume(region, 64); math.Abs(got-1.0) > 1e-9 {
+		t.Errorf("Expected amp_velcurve_64=1 to give full gain at velocity 64, got %f", got)
+	}
+	if got := e.calculateVolume(region, 32); math.Abs(got-0.5) > 0.01 {
+		t.Errorf("Expected interpolated gain 0.5 at velocity 32, got %f", got)
+	}
+}
@@ -22,13 +22,7 @@ func TestIndividualNoteRendering(t *testing.T) {
 	defer player.StopAndClose()
 
 	// Create a mock JACK client for offline rendering
-	mockClient := &MockJackClient{
-		player:       player,
-		sampleRate:   44100,
-		bufferSize:   512,
-		activeVoices: make([]*Voice, 0),
-		maxVoices:    32,
-	}
+	mockClient := createTestMockClient(player, 44100, 512)
 
 	// Test notes: all should work due to pitch-shifting capabilities
 	testNotes := []struct {
@@ -50,7 +44,7 @@ func TestIndividualNoteRendering(t *testing.T) {
 	for _, test := range testNotes {
 		t.Run(test.name, func(t *testing.T) {
 			// Clear any existing voices
-			mockClient.activeVoices = make([]*Voice, 0)
+			mockClient.clearVoices()
 
 			// Try to trigger the note
 			mockClient.noteOn(test.midiNote, 100)
@@ -107,13 +101,7 @@ func TestArpeggioNotesByNote(t *testing.T) {
 	defer player.StopAndClose()
 
 	// Create a mock JACK client for offline rendering
-	mockClient := &MockJackClient{
-		player:       player,
-		sampleRate:   44100,
-		bufferSize:   512,
-		activeVoices: make([]*Voice, 0),
-		maxVoices:    32,
-	}
+	mockClient := createTestMockClient(player, 44100, 512)
 
 	// Original arpeggio notes: C-E-G-C-E-G-C
 	arpeggioNotes := []uint8{60, 64, 67, 72, 76, 79, 84}
@@ -126,7 +114,7 @@ func TestArpeggioNotesByNote(t *testing.T) {
 
 	for i, note := range arpeggioNotes {
 		// Clear voices
-		mockClient.activeVoices = make([]*Voice, 0)
+		mockClient.clearVoices()
 
 		// Try to create voice for this note
 		mockClient.noteOn(note, 100)
@@ -0,0 +1,228 @@
+package gosfzplayer
+
+import (
+	"math"
+	"strings"
+	"sync/atomic"
+)
+
+// maxDelayTime is the longest delay time in seconds; the delay lines are allocated for it up front
+const maxDelayTime = 4.0
+
+// maxDelayFeedback keeps the echoes from building up forever
+const maxDelayFeedback = 0.95
+
+// Delay is a stereo delay, the delay effect type. Its time is set in seconds or, synced to the
+// player's tempo, in beats. In ping-pong mode the echoes alternate between the channels.
+type Delay struct {
+	// Parameters (float64 bits) are set from any goroutine and picked up by the audio thread on
+	// its next block
+	time     atomic.Uint64 // Seconds
+	beats    atomic.Uint64 // Beats, 0 to use the time in seconds
+	tempo    atomic.Uint64 // BPM for beats
+	feedback atomic.Uint64 // 0.0 to 0.95
+	wet      atomic.Uint64 // 0.0 to 1.0
+	dry      atomic.Uint64 // 0.0 to 1.0
+	pingPong atomic.Bool
+	version  atomic.Uint64 // Incremented on every parameter change
+
+	processor *delayProcessor // Runs Process; engines run processors of their own
+	controls  effectControls
+}
+
+// delayProcessor is the delay lines and parameters in use of one audio thread running a Delay
+type delayProcessor struct {
+	delay    *Delay
+	bufferL  []float32
+	bufferR  []float32
+	writeIdx int
+
+	appliedVersion uint64
+	delayFrames    int
+	feedbackGain   float64
+	wetGain        float64
+	dryGain        float64
+	pingPongMode   bool
+	sampleRate     float64
+}
+
+// NewDelay creates a delay of 0.25 seconds with 30% feedback, returning only the echoes
+func NewDelay(sampleRate uint32) *Delay {
+	d := &Delay{}
+	d.time.Store(math.Float64bits(0.25))
+	d.tempo.Store(math.Float64bits(defaultTempo))
+	d.feedback.Store(math.Float64bits(0.3))
+	d.wet.Store(math.Float64bits(1.0))
+	d.processor = newDelayProcessor(d, sampleRate)
+	return d
+}
+
+// newDelayProcessor allocates delay lines for a delay's longest time at a sample rate
+func newDelayProcessor(d *Delay, sampleRate uint32) *delayProcessor {
+	frames := int(maxDelayTime*float64(sampleRate)) + 1
+	return &delayProcessor{
+		delay:          d,
+		bufferL:        make([]float32, frames),
+		bufferR:        make([]float32, frames),
+		appliedVersion: math.MaxUint64, // Picks up the parameters on the first block
+		sampleRate:     float64(sampleRate),
+	}
+}
+
+// NewProcessor creates a processor with delay lines of its own that follows the delay's settings
+func (d *Delay) NewProcessor(sampleRate uint32) Effect {
+	return newDelayProcessor(d, sampleRate)
+}
+
+// newDelayEffect creates a delay from the delay_time, delay_beats, delay_feedback, delay_wet,
+// delay_dry (0-100) and delay_mode (stereo or pingpong) opcodes of an <effect> section; each
+// number can follow CCs with <opcode>_onccN
+func newDelayEffect(section *SfzSection, sampleRate uint32) (Effect, error) {
+	d := NewDelay(sampleRate)
+	wet, dry := effectMixDefaults(section)
+	d.controls.bind(section, "delay_time", 0.25, d.SetTime)
+	d.controls.bind(section, "delay_beats", 0.0, d.SetBeats)
+	d.controls.bind(section, "delay_feedback", 30.0, func(value float64) { d.SetFeedback(value / 100.0) })
+	d.controls.bind(section, "delay_wet", wet, func(value float64) { d.SetWet(value / 100.0) })
+	d.controls.bind(section, "delay_dry", dry, func(value float64) { d.SetDry(value / 100.0) })
+	d.SetPingPong(strings.EqualFold(section.GetStringOpcode("delay_mode"), "pingpong"))
+	return d, nil
+}
+
+// setParameter clamps a parameter and stores it for the audio thread
+func (d *Delay) setParameter(param *atomic.Uint64, value, low, high float64) {
+	param.Store(math.Float64bits(clampFloat64(value, low, high)))
+	d.version.Add(1)
+}
+
+// SetTime sets the delay time in seconds (0 to 4), used when no beats are set
+func (d *Delay) SetTime(seconds float64) {
+	d.setParameter(&d.time, seconds, 0.0, maxDelayTime)
+}
+
+// SetBeats syncs the delay time to the tempo, in beats (0.25 is a sixteenth note); 0 turns it off
+func (d *Delay) SetBeats(beats float64) {
+	d.setParameter(&d.beats, beats, 0.0, 64.0)
+}
+
+// SetTempo sets the tempo in BPM that beats are measured in; the engine keeps it at the player's
+func (d *Delay) SetTempo(bpm float64) {
+	if bpm <= 0 {
+		bpm = defaultTempo
+	}
+	d.setParameter(&d.tempo, bpm, 1.0, 999.0)
+}
+
+// SetFeedback sets how much of the output is fed back (0.0 to 0.95)
+func (d *Delay) SetFeedback(feedback float64) {
+	d.setParameter(&d.feedback, feedback, 0.0, maxDelayFeedback)
+}
+
+// SetWet sets the level of the echoes (0.0 to 1.0)
+func (d *Delay) SetWet(wet float64) {
+	d.setParameter(&d.wet, wet, 0.0, 1.0)
+}
+
+// SetDry sets the level of the input (0.0 to 1.0)
+func (d *Delay) SetDry(dry float64) {
+	d.setParameter(&d.dry, dry, 0.0, 1.0)
+}
+
+// SetPingPong switches between stereo echoes and echoes alternating left and right
+func (d *Delay) SetPingPong(pingPong bool) {
+	d.pingPong.Store(pingPong)
+	d.version.Add(1)
+}
+
+// GetTime returns the delay time in seconds
+func (d *Delay) GetTime() float64 {
+	return math.Float64frombits(d.time.Load())
+}
+
+// GetBeats returns the tempo-synced delay time in beats, 0 if it isn't synced
+func (d *Delay) GetBeats() float64 {
+	return math.Float64frombits(d.beats.Load())
+}
+
+// GetFeedback returns the feedback amount
+func (d *Delay) GetFeedback() float64 {
+	return math.Float64frombits(d.feedback.Load())
+}
+
+// GetWet returns the level of the echoes
+func (d *Delay) GetWet() float64 {
+	return math.Float64frombits(d.wet.Load())
+}
+
+// GetDry returns the level of the input
+func (d *Delay) GetDry() float64 {
+	return math.Float64frombits(d.dry.Load())
+}
+
+// GetPingPong reports whether the echoes alternate between the channels
+func (d *Delay) GetPingPong() bool {
+	return d.pingPong.Load()
+}
+
+// EffectiveTime returns the delay time in seconds, from the beats and tempo if it is synced
+func (d *Delay) EffectiveTime() float64 {
+	if beats := d.GetBeats(); beats > 0 {
+		return math.Min(beats*60.0/math.Float64frombits(d.tempo.Load()), maxDelayTime)
+	}
+	return d.GetTime()
+}
+
+// applyParameters picks up the delay's current parameters; only the audio thread calls it
+func (dp *delayProcessor) applyParameters() {
+	d := dp.delay
+	dp.appliedVersion = d.version.Load()
+	dp.delayFrames = min(max(int(math.Round(d.EffectiveTime()*dp.sampleRate)), 1), len(dp.bufferL)-1)
+	dp.feedbackGain = d.GetFeedback()
+	dp.wetGain = d.GetWet()
+	dp.dryGain = d.GetDry()
+	dp.pingPongMode = d.GetPingPong()
+}
+
+// Process runs a stereo bus through the delay in place
+func (d *Delay) Process(left, right []float32) {
+	d.processor.Process(left, right)
+}
+
+// Process runs a stereo bus through the delay lines in place
+func (dp *delayProcessor) Process(left, right []float32) {
+	if dp.delay.version.Load() != dp.appliedVersion {
+		dp.applyParameters()
+	}
+
+	size := len(dp.bufferL)
+	for i := range left {
+		readIdx := dp.writeIdx - dp.delayFrames
+		if readIdx < 0 {
+			readIdx += size
+		}
+		inputL, inputR := float64(left[i]), float64(right[i])
+		echoL, echoR := float64(dp.bufferL[readIdx]), float64(dp.bufferR[readIdx])
+
+		if dp.pingPongMode {
+			// The input enters on the left and each echo crosses to the other side
+			dp.bufferL[dp.writeIdx] = float32((inputL+inputR)*0.5 + echoR*dp.feedbackGain)
+			dp.bufferR[dp.writeIdx] = float32(echoL * dp.feedbackGain)
+		} else {
+			dp.bufferL[dp.writeIdx] = float32(inputL + echoL*dp.feedbackGain)
+			dp.bufferR[dp.writeIdx] = float32(inputR + echoR*dp.feedbackGain)
+		}
+
+		left[i] = float32(inputL*dp.dryGain + echoL*dp.wetGain)
+		right[i] = float32(inputR*dp.dryGain + echoR*dp.wetGain)
+
+		dp.writeIdx++
+		if dp.writeIdx >= size {
+			dp.writeIdx = 0
+		}
+	}
+}
+
+// ControlChange applies the delay's CC routes
+func (d *Delay) ControlChange(cc int, value float64) {
+	d.controls.ControlChange(cc, value)
+}
@@ -0,0 +1,82 @@
+package gosfzplayer
+
+import (
+	"math"
+	"testing"
+)
+
+// impulseResponse runs a one-frame impulse on the left channel through an effect
+func impulseResponse(effect Effect, frames int) ([]float32, []float32) {
+	left := make([]float32, frames)
+	right := make([]float32, frames)
+	left[0] = 1.0
+	effect.Process(left, right)
+	return left, right
+}
+
+func TestDelayEchoes(t *testing.T) {
+	d := NewDelay(1000)
+	d.SetTime(0.1)
+	d.SetFeedback(0.5)
+
+	left, right := impulseResponse(d, 400)
+	for i, want := range map[int]float32{0: 0, 100: 1.0, 200: 0.5, 300: 0.25} {
+		if math.Abs(float64(left[i]-want)) > 1e-6 {
+			t.Errorf("Expected %f at frame %d, got %f", want, i, left[i])
+		}
+	}
+	if peak(right) != 0 {
+		t.Errorf("Expected the stereo delay to keep the echoes on the left, got peak %f on the right", peak(right))
+	}
+
+	// Ping-pong echoes cross to the other channel each time
+	d = NewDelay(1000)
+	d.SetTime(0.1)
+	d.SetFeedback(0.5)
+	d.SetPingPong(true)
+	left, right = impulseResponse(d, 400)
+	if left[100] == 0 || right[100] != 0 || left[200] != 0 || right[200] == 0 || left[300] == 0 {
+		t.Errorf("Expected ping-pong echoes to alternate: L %v/%v/%v R %v/%v", left[100], left[200], left[300], right[100], right[200])
+	}
+}
+
+func TestDelayTempoSync(t *testing.T) {
+	e := createTestEngine(t, `<region>
+sample=sample1.wav
+key=60
+
+<effect>
+bus=fx2
+type=delay
+delay_beats=0.5
+delay_feedback=40
+delay_mode=pingpong
+delay_wet_oncc12=-100
+`)
+	effects := e.player.Effects("fx2")
+	if len(effects) != 1 {
+		t.Fatalf("Expected a delay on fx2, got %d effects", len(effects))
+	}
+	d := effects[0].(*Delay)
+	if d.GetBeats() != 0.5 || math.Abs(d.GetFeedback()-0.4) > 1e-9 || !d.GetPingPong() || d.GetWet() != 1.0 || d.GetDry() != 0 {
+		t.Fatalf("Unexpected delay settings: beats %f feedback %f pingpong %v wet %f dry %f",
+			d.GetBeats(), d.GetFeedback(), d.GetPingPong(), d.GetWet(), d.GetDry())
+	}
+
+	// The engine keeps the delay at the player's tempo
+	renderTestFrames(e, 64)
+	if got := d.EffectiveTime(); math.Abs(got-0.25) > 1e-9 {
+		t.Errorf("Expected half a beat at 120 BPM to be 0.25 s, got %f", got)
+	}
+	e.player.SetTempo(60)
+	renderTestFrames(e, 64)
+	if got := d.EffectiveTime(); math.Abs(got-0.5) > 1e-9 {
+		t.Errorf("Expected half a beat at 60 BPM to be 0.5 s, got %f", got)
+	}
+
+	// delay_wet_oncc12=-100 mutes the echoes as CC12 goes up
+	e.processControlChange(12, 127)
+	if got := d.GetWet(); got != 0 {
+		t.Errorf("Expected CC12 to bring the wet level to 0, got %f", got)
+	}
+}
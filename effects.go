@@ -0,0 +1,499 @@
+package gosfzplayer
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Effect processes a stereo bus in place. Process runs on the audio thread, so it must not block
+// or allocate; parameters set from other goroutines should be picked up on the next call.
+type Effect interface {
+	Process(left, right []float32)
+}
+
+// EffectFactory creates an effect from the opcodes of an <effect> section at a sample rate
+type EffectFactory func(section *SfzSection, sampleRate uint32) (Effect, error)
+
+// ControllerEffect is an effect with parameters that follow MIDI controllers. ControlChange is
+// called on the audio thread with the controller number (0-127) and its value (0.0 to 1.0).
+type ControllerEffect interface {
+	Effect
+	ControlChange(cc int, value float64)
+}
+
+// TempoEffect is an effect that follows the player's tempo. SetTempo is called on the audio
+// thread when the effect starts and whenever the tempo changes.
+type TempoEffect interface {
+	Effect
+	SetTempo(bpm float64)
+}
+
+// SharedEffect is an effect whose settings can be shared by several engines playing the same
+// instrument, each running it with state of its own. NewProcessor returns an effect that processes
+// with fresh state at a sample rate and follows this effect's settings; it is called outside the
+// audio thread. Effects that don't implement it are run as they are by every engine playing them.
+type SharedEffect interface {
+	Effect
+	NewProcessor(sampleRate uint32) Effect
+}
+
+// Effect buses. Voices play into main and send to fx1-fx4 at their region's effect1-effect4
+// levels; each fx bus's output is added to main at its fxNtomain level.
+const (
+	busMain = iota
+	busFx1
+	busFx2
+	busFx3
+	busFx4
+	numBuses
+)
+
+// numFxBuses is the number of send buses, fx1-fx4
+const numFxBuses = numBuses - busFx1
+
+// busNames are the values of an <effect> section's bus opcode
+var busNames = [numBuses]string{"main", "fx1", "fx2", "fx3", "fx4"}
+
+// reverbSilence is the level below which an effect's output counts as silent (-120 dB)
+const reverbSilence = 1e-6
+
+// busHoldTime is how long in seconds an fx bus keeps running once its sends stop and its output is
+// silent, so delays aren't cut off between echoes
+const busHoldTime = 3.0
+
+// effectTypes maps the type opcode to the effects available to <effect> sections
+var (
+	effectTypesMu sync.RWMutex
+	effectTypes   = map[string]EffectFactory{
+		"gain":    newGainEffect,
+		"delay":   newDelayEffect,
+		"chorus":  newChorusEffect,
+		"flanger": newFlangerEffect,
+		"eq":      newEqualizerEffect,
+	}
+)
+
+// RegisterEffect adds an effect type for <effect> sections' type opcode, replacing any effect
+// registered under the same name. Instruments loaded afterwards can use it. The fverb and reverb
+// types always refer to the player's reverb.
+func RegisterEffect(effectType string, factory EffectFactory) {
+	effectTypesMu.Lock()
+	defer effectTypesMu.Unlock()
+	effectTypes[strings.ToLower(effectType)] = factory
+}
+
+// lookupEffect returns the factory registered for an effect type
+func lookupEffect(effectType string) (EffectFactory, bool) {
+	effectTypesMu.RLock()
+	defer effectTypesMu.RUnlock()
+	factory, exists := effectTypes[effectType]
+	return factory, exists
+}
+
+// isReverbType checks if an effect type refers to the player's reverb
+func isReverbType(effectType string) bool {
+	return effectType == "fverb" || effectType == "reverb"
+}
+
+// effectType returns an <effect> section's type, lowercased; empty if it only sets levels
+func effectType(section *SfzSection) string {
+	return strings.ToLower(section.GetStringOpcode("type"))
+}
+
+// effectBus returns the bus an <effect> section's bus opcode names. Without one, reverbs go on fx1,
+// where effect1 sends reach them, and other effects on main.
+func effectBus(section *SfzSection) (int, error) {
+	name := strings.ToLower(section.GetStringOpcode("bus"))
+	if name == "" {
+		if isReverbType(effectType(section)) {
+			return busFx1, nil
+		}
+		return busMain, nil
+	}
+	for bus, busName := range busNames {
+		if name == busName {
+			return bus, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown bus %q", name)
+}
+
+// checkEffect reports the problem, if any, that keeps an <effect> section's effect from loading
+func checkEffect(section *SfzSection) error {
+	if _, err := effectBus(section); err != nil {
+		return err
+	}
+	if t := effectType(section); t != "" && !isReverbType(t) {
+		if _, exists := lookupEffect(t); !exists {
+			return fmt.Errorf("unknown effect type %q", t)
+		}
+	}
+	return nil
+}
+
+// effectMixDefaults returns the default wet and dry levels (0-100) of an <effect> section's effect:
+// on the main bus it is mixed in with the dry signal, on an fx bus it returns only the effect
+func effectMixDefaults(section *SfzSection) (wet, dry float64) {
+	if bus, _ := effectBus(section); bus != busMain {
+		return 100.0, 0.0
+	}
+	return 50.0, 100.0
+}
+
+// effectControl drives one effect parameter from MIDI CCs: the parameter is its opcode value plus,
+// for each <param>_onccN opcode, the depth scaled by CC N's value
+type effectControl struct {
+	base   float64
+	routes []ccRoute
+	values []float64 // Last value of each route's CC (0.0 to 1.0)
+	set    func(float64)
+}
+
+// effectControls holds the CC-controlled parameters of a built-in effect
+type effectControls []*effectControl
+
+// bind sets a parameter from its opcode (or a default) and adds its <param>_onccN routes
+func (ec *effectControls) bind(section *SfzSection, param string, defaultValue float64, set func(float64)) {
+	control := &effectControl{base: section.GetFloatOpcode(param, defaultValue), set: set}
+	prefix := param + "_oncc"
+	for opcode, value := range section.Opcodes {
+		if !strings.HasPrefix(opcode, prefix) {
+			continue
+		}
+		cc, err := strconv.Atoi(opcode[len(prefix):])
+		depth, depthErr := strconv.ParseFloat(value, 64)
+		if err != nil || depthErr != nil || cc < 0 || cc > 127 {
+			continue
+		}
+		control.routes = append(control.routes, ccRoute{cc: cc, depth: depth})
+	}
+	control.values = make([]float64, len(control.routes))
+	set(control.base)
+	if len(control.routes) > 0 {
+		*ec = append(*ec, control)
+	}
+}
+
+// ControlChange updates the parameters routed from a MIDI CC
+func (ec effectControls) ControlChange(cc int, value float64) {
+	for _, control := range ec {
+		changed := false
+		for i, route := range control.routes {
+			if route.cc == cc {
+				control.values[i] = value
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		total := control.base
+		for i, route := range control.routes {
+			total += route.depth * control.values[i]
+		}
+		control.set(total)
+	}
+}
+
+// effectChain is the effects processing one bus, in file order, and the bus's level in the main mix
+type effectChain struct {
+	level   float64 // directtomain for main, fxNtomain for the fx buses (0.0 to 1.0)
+	effects []Effect
+}
+
+// effectBuses holds an instrument's effect chains, indexed by bus
+type effectBuses [numBuses]effectChain
+
+// defaultEffectBuses returns buses without effects at full level
+func defaultEffectBuses() effectBuses {
+	var buses effectBuses
+	for bus := range buses {
+		buses[bus].level = 1.0
+	}
+	return buses
+}
+
+// reverbSection returns the <effect> section that configures the player's reverb: the first one
+// of type fverb or reverb, or nil
+func reverbSection(effects []*SfzSection) *SfzSection {
+	for _, section := range effects {
+		if isReverbType(effectType(section)) {
+			return section
+		}
+	}
+	return nil
+}
+
+// buildEffects creates the effects of an instrument's <effect> sections at a sample rate and reads
+// the bus levels (directtomain, fxNtomain; 0-100%, default 100, later sections override earlier
+// ones). The first fverb section uses the player's reverb, so its setters and CC91-95 control it;
+// later ones get a reverb of their own. If no section uses fverb and none puts an effect on fx1,
+// fx1 is the player's reverb. Sections that can't be loaded are skipped.
+func (p *SfzPlayer) buildEffects(sfzData *SfzData, sampleRate uint32) effectBuses {
+	buses := defaultEffectBuses()
+	playerReverb := reverbSection(sfzData.Effects)
+
+	for i, section := range sfzData.Effects {
+		buses[busMain].level = clampFloat64(section.GetFloatOpcode("directtomain", buses[busMain].level*100.0)/100.0, 0.0, 1.0)
+		for bus := busFx1; bus < numBuses; bus++ {
+			opcode := busNames[bus] + "tomain"
+			buses[bus].level = clampFloat64(section.GetFloatOpcode(opcode, buses[bus].level*100.0)/100.0, 0.0, 1.0)
+		}
+
+		t := effectType(section)
+		if t == "" {
+			continue
+		}
+		bus, err := effectBus(section)
+		if err != nil {
+			debug("Warning: Skipping effect %d: %v", i+1, err)
+			continue
+		}
+
+		var effect Effect
+		switch {
+		case section == playerReverb:
+			effect = p.reverb
+		case isReverbType(t):
+			reverb := NewFreeverb(int(sampleRate))
+			configureReverb(reverb, section)
+			effect = reverb
+		default:
+			factory, exists := lookupEffect(t)
+			if !exists {
+				debug("Warning: Skipping effect %d: unknown effect type %q", i+1, t)
+				continue
+			}
+			if effect, err = factory(section, sampleRate); err != nil {
+				debug("Warning: Skipping effect %d: %v", i+1, err)
+				continue
+			}
+		}
+		buses[bus].effects = append(buses[bus].effects, effect)
+		debug("Effect %d: %s on %s", i+1, t, busNames[bus])
+	}
+
+	if playerReverb == nil && len(buses[busFx1].effects) == 0 {
+		buses[busFx1].effects = []Effect{p.reverb}
+	}
+	return buses
+}
+
+// useSampleRate rebuilds the player's reverb and the effects of the current instrument and the
+// presets for an engine's sample rate. Built-in effects run at each engine's own rate; others run
+// at one sample rate, that of the last engine created for the player.
+func (p *SfzPlayer) useSampleRate(sampleRate uint32) {
+	if p.sampleRate.Load() == sampleRate {
+		return
+	}
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+	p.sampleRate.Store(sampleRate)
+	if p.reverb != nil {
+		p.reverb.SetSampleRate(int(sampleRate))
+	}
+
+	// Instruments are never modified once loaded, so swap in copies
+	rebuilt := make(map[*instrument]*instrument)
+	var copies []*instrument
+	for _, inst := range p.loadedInstruments() {
+		if inst == emptyInstrument || rebuilt[inst] != nil {
+			continue
+		}
+		copied := *inst
+		copied.buses = p.buildEffects(inst.sfzData, sampleRate)
+		rebuilt[inst] = &copied
+		copies = append(copies, &copied)
+	}
+	p.prepareEngines(copies...)
+
+	current := p.current()
+	for inst, copied := range rebuilt {
+		if inst == current {
+			p.instrument.Store(copied)
+		}
+		p.replacePresetInstrument(inst, copied)
+	}
+	debug("Effects rebuilt for %d Hz", sampleRate)
+}
+
+// sendBus is an engine's audio for one fx bus
+type sendBus struct {
+	left    []float32
+	right   []float32
+	input   bool // A voice sent to the bus in the current segment
+	running bool // The bus is processing: it has had input within the hold time
+	idle    int  // Frames the bus has been silent since its sends stopped
+}
+
+// prepareSends clears the send buses of the instrument's fx buses that have effects for a segment
+func (e *engine) prepareSends(frames int) {
+	for i := range e.sends {
+		if len(e.inst.buses[busFx1+i].effects) == 0 {
+			continue
+		}
+		send := &e.sends[i]
+		if cap(send.left) < frames {
+			send.left = make([]float32, frames)
+			send.right = make([]float32, frames)
+		}
+		send.left = send.left[:frames]
+		send.right = send.right[:frames]
+		clear(send.left)
+		clear(send.right)
+	}
+}
+
+// mixEffects scales the dry signal by directtomain, runs each fx bus through its effects and adds
+// the result at fxNtomain, then runs the main bus's effects over the mix. right is nil for mono
+// output, which gets the average of each bus's channels. An fx bus keeps running after its sends
+// stop until its output has been silent for busHoldTime.
+func (e *engine) mixEffects(left, right []float32) {
+	buses := &e.inst.buses
+	if level := buses[busMain].level; level != 1.0 {
+		scaleBuffer(left, level)
+		scaleBuffer(right, level)
+	}
+
+	hold := int(busHoldTime * float64(e.sampleRate))
+	for i := range e.sends {
+		send := &e.sends[i]
+		chain := &buses[busFx1+i]
+		if len(chain.effects) == 0 || (!send.input && !send.running) {
+			send.input = false
+			continue
+		}
+
+		wetL, wetR := send.left[:len(left)], send.right[:len(left)]
+		if right == nil {
+			copy(wetR, wetL)
+		}
+		for _, effect := range e.processors[busFx1+i] {
+			effect.Process(wetL, wetR)
+		}
+
+		if send.input || max(peak(wetL), peak(wetR)) > reverbSilence {
+			send.idle = 0
+		} else {
+			send.idle += len(left)
+		}
+		send.running = send.idle < hold
+		send.input = false
+
+		for j := range left {
+			if right == nil {
+				left[j] += float32((float64(wetL[j]) + float64(wetR[j])) * 0.5 * chain.level)
+				continue
+			}
+			left[j] += float32(float64(wetL[j]) * chain.level)
+			right[j] += float32(float64(wetR[j]) * chain.level)
+		}
+	}
+
+	if effects := e.processors[busMain]; len(effects) > 0 {
+		e.processMain(left, right, effects)
+	}
+}
+
+// processMain runs the main bus's effects over the mix; a mono mix runs through them as stereo
+// and is folded back to one channel
+func (e *engine) processMain(left, right []float32, effects []Effect) {
+	if right != nil {
+		for _, effect := range effects {
+			effect.Process(left, right)
+		}
+		return
+	}
+
+	if cap(e.monoRight) < len(left) {
+		e.monoRight = make([]float32, len(left))
+	}
+	right = e.monoRight[:len(left)]
+	copy(right, left)
+	for _, effect := range effects {
+		effect.Process(left, right)
+	}
+	for i := range left {
+		left[i] = (left[i] + right[i]) * 0.5
+	}
+}
+
+// controlEffects passes a MIDI CC to the instrument's effects that follow controllers
+func (e *engine) controlEffects(cc int, value float64) {
+	for bus := range e.inst.buses {
+		for _, effect := range e.inst.buses[bus].effects {
+			if controller, ok := effect.(ControllerEffect); ok {
+				controller.ControlChange(cc, value)
+			}
+		}
+	}
+}
+
+// syncEffectTempo passes the player's tempo to the instrument's tempo-synced effects when it or
+// the instrument has changed
+func (e *engine) syncEffectTempo() {
+	bpm := e.player.GetTempo()
+	if bpm == e.effectTempo && e.inst == e.tempoInst {
+		return
+	}
+	e.effectTempo = bpm
+	e.tempoInst = e.inst
+	for bus := range e.inst.buses {
+		for _, effect := range e.inst.buses[bus].effects {
+			if synced, ok := effect.(TempoEffect); ok {
+				synced.SetTempo(bpm)
+			}
+		}
+	}
+}
+
+// Effects returns the effects on a bus (main or fx1-fx4) of the instrument the player is playing,
+// in processing order, for changing their settings; nil if the bus name isn't known
+func (p *SfzPlayer) Effects(bus string) []Effect {
+	for index, name := range busNames {
+		if strings.EqualFold(bus, name) {
+			return slices.Clone(p.current().buses[index].effects)
+		}
+	}
+	return nil
+}
+
+// scaleBuffer multiplies a buffer by a gain; a nil buffer is left alone
+func scaleBuffer(buffer []float32, gain float64) {
+	for i := range buffer {
+		buffer[i] = float32(float64(buffer[i]) * gain)
+	}
+}
+
+// voicePanGains returns a voice's left and right gains for its pan (-1.0 to 1.0). The louder side
+// stays at full level, so a centered voice sounds the same as in mono output.
+func voicePanGains(pan float64) (left, right float64) {
+	if pan > 0 {
+		return 1.0 - pan, 1.0
+	}
+	return 1.0, 1.0 + pan
+}
+
+// gainEffect changes a bus's level (type=gain, gain in dB)
+type gainEffect struct {
+	gain float64
+}
+
+// newGainEffect creates a gain effect from an <effect> section
+func newGainEffect(section *SfzSection, sampleRate uint32) (Effect, error) {
+	db := section.GetFloatOpcode("gain", 0.0)
+	if math.IsNaN(db) || db > 48.0 {
+		return nil, fmt.Errorf("gain %.1f dB out of range (max 48)", db)
+	}
+	return &gainEffect{gain: dbToLinear(db)}, nil
+}
+
+// Process applies the gain to both channels
+func (g *gainEffect) Process(left, right []float32) {
+	scaleBuffer(left, g.gain)
+	scaleBuffer(right, g.gain)
+}
@@ -0,0 +1,227 @@
+package gosfzplayer
+
+import (
+	"math"
+	"testing"
+)
+
+// probeEffect records what it is given and passes its input through unchanged
+type probeEffect struct {
+	sampleRate uint32
+	peak       float64
+}
+
+func (p *probeEffect) Process(left, right []float32) {
+	p.peak = math.Max(p.peak, math.Max(peak(left), peak(right)))
+}
+
+// registerProbe registers an effect type that hands out probes, returning those created
+func registerProbe(effectType string) *[]*probeEffect {
+	probes := &[]*probeEffect{}
+	RegisterEffect(effectType, func(section *SfzSection, sampleRate uint32) (Effect, error) {
+		probe := &probeEffect{sampleRate: sampleRate}
+		*probes = append(*probes, probe)
+		return probe, nil
+	})
+	return probes
+}
+
+func TestEffectSendBuses(t *testing.T) {
+	probes := registerProbe("test_send_probe")
+	e := createTestEngine(t, `<region>
+sample=sample1.wav
+key=60
+effect1=0
+effect3=50
+
+<region>
+sample=sample1.wav
+key=62
+effect1=0
+
+<effect>
+bus=fx3
+type=test_send_probe
+fx3tomain=0
+`)
+	if len(*probes) != 1 {
+		t.Fatalf("Expected one effect on fx3, got %d", len(*probes))
+	}
+	probe := (*probes)[0]
+	buses := &e.inst.buses
+	if len(buses[busFx3].effects) != 1 || len(buses[busFx1].effects) != 1 || buses[busFx1].effects[0] != e.player.reverb {
+		t.Fatalf("Expected the probe on fx3 and the player's reverb on fx1")
+	}
+
+	// A region without an effect3 send leaves the bus silent
+	e.noteOn(62, 100)
+	renderTestFrames(e, 4096)
+	if probe.peak != 0 {
+		t.Errorf("Expected no signal on fx3 without a send, got peak %f", probe.peak)
+	}
+
+	// effect3=50 sends half the voice to fx3; fx3tomain=0 keeps it out of the output
+	e = createTestEngine(t, "<region>\nsample=sample1.wav\nkey=60\neffect1=0\n")
+	e.noteOn(60, 100)
+	direct := peak(renderTestFrames(e, 4096))
+
+	e = createTestEngine(t, "<region>\nsample=sample1.wav\nkey=60\neffect1=0\neffect3=50\n\n<effect>\nbus=fx3\ntype=test_send_probe\nfx3tomain=0\n")
+	probe = (*probes)[len(*probes)-1]
+	e.noteOn(60, 100)
+	output := peak(renderTestFrames(e, 4096))
+	if math.Abs(probe.peak-direct*0.5) > 1e-4 {
+		t.Errorf("Expected fx3 to get half the voice's level %f, got %f", direct*0.5, probe.peak)
+	}
+	if math.Abs(output-direct) > 1e-6 {
+		t.Errorf("Expected fx3tomain=0 to leave the output dry, got peak %f vs %f", output, direct)
+	}
+}
+
+func TestMainBusEffect(t *testing.T) {
+	dry := createTestEngine(t, "<region>\nsample=sample1.wav\nkey=60\neffect1=0\n")
+	quiet := createTestEngine(t, "<region>\nsample=sample1.wav\nkey=60\neffect1=0\n\n<effect>\ntype=gain\ngain=-6\n")
+
+	dry.noteOn(60, 100)
+	quiet.noteOn(60, 100)
+	dryOutput := renderTestFrames(dry, 4096)
+	quietOutput := renderTestFrames(quiet, 4096)
+	gain := dbToLinear(-6)
+	for i := range dryOutput {
+		if math.Abs(float64(quietOutput[i])-float64(dryOutput[i])*gain) > 1e-6 {
+			t.Fatalf("Expected the main bus gain effect to scale the output, frame %d: %f vs %f", i, quietOutput[i], dryOutput[i])
+		}
+	}
+}
+
+func TestFverbEffectSection(t *testing.T) {
+	e := createTestEngine(t, `<region>
+sample=sample1.wav
+key=60
+effect1=100
+
+<region>
+sample=sample1.wav
+key=62
+effect2=100
+
+<effect>
+bus=fx2
+type=fverb
+reverb_room_size=90
+`)
+	buses := &e.inst.buses
+	if len(buses[busFx1].effects) != 0 || len(buses[busFx2].effects) != 1 || buses[busFx2].effects[0] != e.player.reverb {
+		t.Fatalf("Expected the player's reverb to move to fx2")
+	}
+	if got := e.player.GetReverbRoomSize(); math.Abs(got-0.9) > 1e-9 {
+		t.Errorf("Expected the fverb section to set the room size to 0.9, got %f", got)
+	}
+
+	if tail := renderNoteTail(e, 60); tail != 0 {
+		t.Errorf("Expected no reverb from a send to the empty fx1 bus, got peak %f", tail)
+	}
+	if tail := renderNoteTail(e, 62); tail == 0 {
+		t.Error("Expected a reverb tail from the fx2 send")
+	}
+}
+
+func TestFverbEffectSectionWithoutBus(t *testing.T) {
+	e := createTestEngine(t, "<region>\nsample=sample1.wav\nkey=60\neffect1=100\n\n<effect>\ntype=fverb\nreverb_room_size=90\n")
+	buses := &e.inst.buses
+	if len(buses[busMain].effects) != 0 || len(buses[busFx1].effects) != 1 || buses[busFx1].effects[0] != e.player.reverb {
+		t.Fatalf("Expected an fverb section without a bus to put the player's reverb on fx1")
+	}
+
+	// The direct sound stays as loud as without the reverb, which only adds a tail
+	dry := createTestEngine(t, "<region>\nsample=sample1.wav\nkey=60\neffect1=0\n")
+	dry.noteOn(60, 100)
+	e.noteOn(60, 100)
+	dryRMS := calculateRMS(renderTestFrames(dry, 4096))
+	if rms := calculateRMS(renderTestFrames(e, 4096)); rms < dryRMS*0.9 {
+		t.Errorf("Expected the direct sound to be kept, got RMS %f vs %f dry", rms, dryRMS)
+	}
+	if tail := renderNoteTail(e, 60); tail == 0 {
+		t.Error("Expected a reverb tail from the effect1 send")
+	}
+}
+
+func TestEffectsFollowEngineSampleRate(t *testing.T) {
+	probes := registerProbe("test_rate_probe")
+	_, sfzPath := createReloadDir(t, "<region>\nsample=sample1.wav\nkey=60\n\n<effect>\ntype=test_rate_probe\n")
+	player, err := NewSfzPlayer(sfzPath, "")
+	if err != nil {
+		t.Fatalf("Failed to create player: %v", err)
+	}
+	if len(*probes) != 1 || (*probes)[0].sampleRate != 44100 {
+		t.Fatalf("Expected the effect to be created at 44100 Hz")
+	}
+
+	e := newEngine(player, 48000)
+	if len(*probes) != 2 || (*probes)[1].sampleRate != 48000 {
+		t.Fatalf("Expected the effect to be recreated at the engine's 48000 Hz")
+	}
+	if e.inst.buses[busMain].effects[0] != (*probes)[1] {
+		t.Error("Expected the engine to use the recreated effect")
+	}
+}
+
+func TestEnginesRunOwnEffectState(t *testing.T) {
+	const sfz = "<region>\nsample=sample1.wav\nkey=60\neffect1=100\n\n<effect>\nbus=fx2\ntype=delay\n"
+	reference := createTestEngine(t, sfz)
+	e := createTestEngine(t, sfz)
+	other := newEngine(e.player, 44100)
+
+	// Two engines playing the same player sound the same as one engine on its own
+	for _, engine := range []*engine{reference, e, other} {
+		engine.noteOn(60, 100)
+	}
+	for block := 0; block < 40; block++ {
+		if block == 8 {
+			for _, engine := range []*engine{reference, e, other} {
+				engine.noteOff(60)
+			}
+		}
+		want := renderTestFrames(reference, 512)
+		for _, engine := range []*engine{e, other} {
+			got := renderTestFrames(engine, 512)
+			for i := range want {
+				if got[i] != want[i] {
+					t.Fatalf("Block %d, frame %d: expected %f, got %f", block, i, want[i], got[i])
+				}
+			}
+		}
+	}
+}
+
+func TestRenderStartsWithFreshEffects(t *testing.T) {
+	e := createTestEngine(t, "<region>\nsample=sample1.wav\nkey=60\neffect1=100\n")
+	events := []TimedMidiEvent{
+		{Time: 0, Data: []byte{0x90, 60, 100}},
+		{Time: 0.1, Data: []byte{0x80, 60, 0}},
+	}
+	render := func() []float32 {
+		var output []float32
+		_, err := renderMidiEvents(e.player, events, (&RenderOptions{MaxTail: 0.5}).withDefaults(), func(left, right []float32) error {
+			output = append(output, left...)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+		return output
+	}
+
+	first := render()
+	second := render()
+	if len(first) != len(second) {
+		t.Fatalf("Expected renders of the same length, got %d and %d frames", len(first), len(second))
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("Frame %d: expected the second render to start without the first's reverb tail, got %f vs %f", i, second[i], first[i])
+		}
+	}
+	if len(e.player.engines) != 1 {
+		t.Errorf("Expected finished renders to detach from the player, got %d engines", len(e.player.engines))
+	}
+}
@@ -0,0 +1,760 @@
+package gosfzplayer
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/GeoffreyPlitt/debuggo"
+)
+
+var engineDebug = debuggo.Debug("sfzplayer:engine")
+
+// engineDebugEnabled guards logging on the audio thread, where formatting a message locks and allocates
+var engineDebugEnabled = debuggo.IsEnabled("sfzplayer:engine")
+
+// maxBlockFrames is the longest block engines and racks allocate their mixing buffers for up front;
+// longer blocks grow them on the audio thread
+const maxBlockFrames = 8192
+
+// engine holds the voice and MIDI state shared by the JACK client and offline rendering.
+// It is owned by the thread calling render; other goroutines reach it through sendMidi.
+type engine struct {
+	player     *SfzPlayer
+	inst       *instrument // The player's instrument as of the start of this block
+	sampleRate uint32
+
+	// Control messages from other goroutines, applied by the audio thread at the start of each block
+	controls   controlQueue
+	producerMu sync.Mutex // Serializes producers; the audio thread never takes it
+
+	// Audio rendering state
+	activeVoices []*Voice // In the order they started
+	pool         *voicePool
+
+	// Scratch buffers reused by every note-on so the audio thread doesn't allocate
+	touchedSequences []seqKey
+	triggeredGroups  []*SfzSection
+	releasedNotes    []channelNote
+
+	// Effect processors with this engine's own state for the instrument it plays, and the state
+	// prepared by other goroutines for the instruments it can switch to
+	processors *busProcessors
+	prepared   atomic.Pointer[map[*instrument]*instrumentState]
+	prepareMu  sync.Mutex    // Serializes preparing; the audio thread never takes it
+	players    []*SfzPlayer  // Players that prepare state for the engine, guarded by prepareMu
+	reserved   voiceCapacity // Voice buffers asked of the pool, guarded by prepareMu
+
+	// Effect send buses fx1-fx4, refilled by the voices every segment
+	sends     [numFxBuses]sendBus
+	monoRight []float32 // Right channel for running the main bus's effects on mono output
+
+	// Processing state of the player's master bus stage
+	master masterStage
+
+	// Tempo last passed to the effects and the instrument whose effects got it
+	effectTempo float64
+	tempoInst   *instrument
+
+	// Advanced Features
+	lastKeyswitch    int       // Last keyswitch played (sw_last), -1 if none
+	keysDown         [128]bool // Keys currently held (sw_down/sw_up)
+	previousNote     int       // Note played before the current one (sw_previous), -1 if none
+	previousVelocity uint8     // Velocity of the previous note (sw_vel=previous)
+	activeNoteCount  int       // Count of active notes for trigger modes
+
+	// Round-robin counters per group and key, from the instrument's state
+	seqCounters map[seqKey]int
+
+	// MIDI state per channel, consulted by region conditions (loccN, lobend, lotimer, ...)
+	channelState  [16]midiState
+	frameClock    uint64                 // Frames rendered since the engine started
+	groupTriggers map[*SfzSection]uint64 // Frame at which each group last played, from the instrument's state
+
+	// Called on program changes with the channel (0-15), bank and program; selectPreset by default
+	onProgramChange func(channel uint8, bank uint16, program uint8)
+}
+
+// newEngine creates an engine rendering at the given sample rate
+func newEngine(player *SfzPlayer, sampleRate uint32) *engine {
+	e := &engine{
+		player:        player,
+		sampleRate:    sampleRate,
+		activeVoices:  make([]*Voice, 0, MaxPolyphony+stealHeadroom),
+		pool:          newVoicePool(MaxPolyphony + stealHeadroom),
+		master:        newMasterStage(sampleRate),
+		lastKeyswitch: -1,
+		previousNote:  -1,
+
+		touchedSequences: make([]seqKey, 0, DefaultPolyphony),
+		triggeredGroups:  make([]*SfzSection, 0, DefaultPolyphony),
+		releasedNotes:    make([]channelNote, 0, DefaultPolyphony),
+	}
+
+	for i := range e.channelState {
+		e.channelState[i] = newMidiState()
+	}
+
+	e.onProgramChange = e.selectPreset
+
+	// Create the player's effects for this sample rate
+	player.useSampleRate(sampleRate)
+
+	// Mixing buffers for blocks up to maxBlockFrames
+	for i := range e.sends {
+		e.sends[i].left = make([]float32, 0, maxBlockFrames)
+		e.sends[i].right = make([]float32, 0, maxBlockFrames)
+	}
+	e.monoRight = make([]float32, 0, maxBlockFrames)
+
+	// Start on the sw_default articulation, with effect processors of the engine's own
+	e.attach(player)
+	e.useInstrument(player.current())
+	e.lastKeyswitch = e.inst.keyswitches.defaultKeyswitch
+	return e
+}
+
+// setPlayer switches the instrument new notes play, for program changes. Sounding voices finish
+// on the previous instrument.
+func (e *engine) setPlayer(player *SfzPlayer) {
+	e.player = player
+	e.useInstrument(player.current())
+	e.lastKeyswitch = e.inst.keyswitches.defaultKeyswitch
+}
+
+// useInstrument switches to a newly loaded instrument between blocks. Round-robin and group trigger
+// state refer to the old instrument's groups, so it starts over; the keyswitch carries over if the
+// new instrument still has it.
+func (e *engine) useInstrument(inst *instrument) {
+	state := e.stateFor(inst)
+	state.restart()
+	e.inst = inst
+	e.processors = &state.processors
+	e.seqCounters = state.seqCounters
+	e.groupTriggers = state.groupTriggers
+	e.lastKeyswitch = inst.keyswitches.carryOver(e.lastKeyswitch)
+}
+
+// Helper function to clamp float64 values
+func clampFloat64(value, min, max float64) float64 {
+	if value > max {
+		return max
+	}
+	if value < min {
+		return min
+	}
+	return value
+}
+
+// dbToLinear converts a gain in decibels to a linear factor
+func dbToLinear(db float64) float64 {
+	return math.Pow(10.0, db/20.0)
+}
+
+// centsToRatio converts a pitch offset in cents to a frequency ratio
+func centsToRatio(cents float64) float64 {
+	return math.Pow(2.0, cents/1200.0)
+}
+
+// noteOn handles MIDI note on events on the first MIDI channel
+func (e *engine) noteOn(note, velocity uint8) {
+	e.noteOnChannel(0, note, velocity)
+}
+
+// noteOnChannel handles MIDI note on events on a MIDI channel (0-15)
+func (e *engine) noteOnChannel(channel, note, velocity uint8) {
+	if engineDebugEnabled {
+		engineDebug("Note on: channel=%d, note=%d, velocity=%d", channel+1, note, velocity)
+	}
+
+	// Remember this note for sw_previous/sw_vel once regions have been matched
+	defer func() {
+		e.previousNote = int(note)
+		e.previousVelocity = velocity
+	}()
+	e.keysDown[note&0x7F] = true
+
+	// Keyswitch notes select an articulation and don't sound
+	if e.updateKeyswitchState(note, velocity) {
+		return
+	}
+
+	// Increment active note count for trigger modes
+	e.activeNoteCount++
+
+	// One random value per note-on so layered regions pick consistently
+	randomValue := e.player.random()
+	touchedSequences := e.touchedSequences[:0]
+	triggeredGroups := e.triggeredGroups[:0]
+
+	// Find matching regions among those mapped to this key
+	for _, region := range e.inst.index.attackRegions(note, velocity) {
+		if e.regionMatches(region, channel, note, velocity) {
+			// Round-robin and random selection
+			if region.SeqLength > 1 {
+				touchedSequences = append(touchedSequences, seqKeyFor(region, note))
+			}
+			if !e.seqMatches(region, note) || !randomMatches(region, randomValue) {
+				continue
+			}
+
+			// Regions without a loaded sample stay silent
+			if region.sample == nil {
+				continue
+			}
+
+			// Handle group exclusion - stop voices that should be stopped by this group
+			if region.Group > 0 {
+				e.stopVoicesByOffBy(region.Group)
+			}
+
+			volume := e.calculateVolume(region, velocity) * e.calculateKeyGain(region, note, velocity) * e.softPedalGain(channel)
+			e.startVoice(region, channel, note, velocity, volume, true)
+
+			triggeredGroups = append(triggeredGroups, region.parentGroup())
+
+			if engineDebugEnabled {
+				engineDebug("Started voice for note %d, sample: %s", note, region.Sample)
+			}
+		}
+	}
+
+	// Step round-robins once per note-on, after all layers have been selected
+	e.advanceSequences(touchedSequences)
+
+	// Restart the lotimer/hitimer clock of every group that played
+	for _, group := range triggeredGroups {
+		e.groupTriggers[group] = e.frameClock
+	}
+
+	// Keep the grown scratch buffers for the next note-on
+	e.touchedSequences = touchedSequences
+	e.triggeredGroups = triggeredGroups
+}
+
+// startVoice takes a voice from the pool and initializes it to play a region
+func (e *engine) startVoice(region *Region, channel, note, velocity uint8, volume float64, noteOn bool) *Voice {
+	e.enforcePolyphony(region, channel, note)
+	voice := e.allocVoice()
+	if voice == nil {
+		return nil
+	}
+
+	voice.sample = region.sample
+	voice.region = region
+	voice.channel = channel
+	voice.midiNote = note
+	voice.velocity = velocity
+	voice.volume = volume
+	voice.pan = e.calculatePan(region)
+	voice.sends = [numFxBuses]float64{region.Effect1 / 100.0, region.Effect2 / 100.0, region.Effect3 / 100.0, region.Effect4 / 100.0}
+	if region.Effect1 < 0 {
+		voice.sends[0] = -1
+	}
+	voice.pitchRatio = e.calculatePitchRatio(region, note)
+	voice.isActive = true
+	voice.noteOn = noteOn
+	voice.groupID = region.Group
+	voice.offByGroup = region.OffBy
+	voice.triggerMode = region.Trigger
+
+	// Initialize ADSR envelope, loop parameters, filter, EQ, modulation, pitch bend and pedals
+	voice.InitializeEnvelope(e.sampleRate)
+	voice.InitializeLoop()
+	voice.InitializeFilter(e.sampleRate)
+	state := &e.channelState[channel&0x0F]
+	voice.InitializeEQ(e.sampleRate, &state.ccValues)
+	voice.InitializeModulation(region.modulation, e.sampleRate, &state.ccValues)
+	voice.InitializeCrossfade(region.crossfade, e.sampleRate, &state.ccValues)
+	voice.InitializeBend(e.sampleRate, state)
+	voice.InitializePedals()
+
+	e.activeVoices = append(e.activeVoices, voice)
+	return voice
+}
+
+// noteOff handles MIDI note off events on the first MIDI channel
+func (e *engine) noteOff(note uint8) {
+	e.noteOffChannel(0, note)
+}
+
+// noteOffChannel handles MIDI note off events on a MIDI channel (0-15)
+func (e *engine) noteOffChannel(channel, note uint8) {
+	if engineDebugEnabled {
+		engineDebug("Note off: channel=%d, note=%d", channel+1, note)
+	}
+
+	e.keysDown[note&0x7F] = false
+	if e.inst.keyswitches.isKeyswitch[note&0x7F] {
+		return
+	}
+
+	// Decrement active note count
+	e.activeNoteCount--
+	if e.activeNoteCount < 0 {
+		e.activeNoteCount = 0
+	}
+
+	// Trigger release envelope for voices playing this note, unless a pedal holds them
+	pedalHeld := false
+	for _, voice := range e.activeVoices {
+		if voice.midiNote == note && voice.channel == channel && voice.keyDown {
+			voice.keyDown = false
+			if e.pedalHolds(voice) {
+				pedalHeld = true
+				continue
+			}
+			voice.TriggerRelease()
+		}
+	}
+
+	// Handle release trigger regions (deferred until pedal release for held notes)
+	if !pedalHeld {
+		e.handleReleaseTriggers(channel, note)
+	}
+}
+
+// regionMatches checks if a region should respond to the given channel, note and velocity
+func (e *engine) regionMatches(region *Region, channel, note, velocity uint8) bool {
+	// Check MIDI channel range (lochan/hichan are 1-16)
+	if !region.matchesChannel(channel) {
+		return false
+	}
+
+	// Check key range
+	if int(note) < region.LoKey || int(note) > region.HiKey {
+		return false
+	}
+
+	// Check velocity range (the previous note's velocity with sw_vel=previous)
+	matchVelocity := e.matchVelocity(region, velocity)
+	if int(matchVelocity) < region.LoVel || int(matchVelocity) > region.HiVel {
+		return false
+	}
+
+	// Check CC, bend, aftertouch, tempo and timer conditions
+	if !e.conditionsMatch(region, channel, note) {
+		return false
+	}
+
+	// Check keyswitch conditions
+	if !e.keyswitchMatches(region) {
+		return false
+	}
+
+	// Check trigger mode
+	switch region.Trigger {
+	case "first":
+		if e.activeNoteCount > 1 { // We already incremented, so >1 means other notes are active
+			return false
+		}
+	case "legato":
+		if e.activeNoteCount <= 1 { // No other notes active
+			return false
+		}
+	case "release":
+		return false // Release triggers are handled separately in noteOff
+	}
+
+	return true
+}
+
+// calculateVolume calculates the final volume for a voice
+func (e *engine) calculateVolume(region *Region, velocity uint8) float64 {
+	// Convert dB to linear gain: linear = 10^(dB/20)
+	linear := dbToLinear(region.Volume)
+
+	// Velocity scaling through the region's velocity curve and amp_veltrack
+	velocityScale := velocityGain(region.velocityCurve, velocity, region.AmpVeltrack)
+
+	return linear * velocityScale
+}
+
+// calculateKeyGain calculates the amp_keytrack gain and key/velocity crossfade gain for a voice
+func (e *engine) calculateKeyGain(region *Region, note, velocity uint8) float64 {
+	// Key tracking in dB per key relative to amp_keycenter
+	keytrackDB := clampFloat64(region.AmpKeytrack*float64(int(note)-region.AmpKeycenter), -96.0, 24.0)
+
+	return dbToLinear(keytrackDB) * region.keyXfade.gain(note, velocity)
+}
+
+// calculatePan calculates the pan position for a voice
+func (e *engine) calculatePan(region *Region) float64 {
+	return region.Pan / 100.0 // Normalize to -1.0 to 1.0
+}
+
+// calculatePitchRatio calculates the static pitch adjustment ratio for a voice
+// (pitch bend is applied live per sample by the voice)
+func (e *engine) calculatePitchRatio(region *Region, midiNote uint8) float64 {
+	// pitch_keycenter (root note) defaults to the played note
+	pitchKeycenter := region.PitchKeycenter
+	if pitchKeycenter < 0 {
+		pitchKeycenter = int(midiNote)
+	}
+
+	// Semitone difference from pitch_keycenter, plus transpose (semitones), tune and pitch (cents)
+	semitones := float64(int(midiNote) - pitchKeycenter)
+	semitones += float64(region.Transpose)
+	semitones += region.Tune / 100.0 // 100 cents = 1 semitone
+	semitones += region.Pitch / 100.0
+
+	// Convert semitones to pitch ratio: ratio = 2^(semitones/12)
+	pitchRatio := math.Pow(2.0, semitones/12.0)
+
+	// Clamp pitch ratio to reasonable range (avoid extreme values)
+	pitchRatio = clampFloat64(pitchRatio, 0.1, 10.0)
+
+	if engineDebugEnabled {
+		engineDebug("Pitch adjustment: note=%d, keycenter=%d, transpose=%d, tune=%.1fc, pitch=%.1fc, total_semitones=%.2f, ratio=%f",
+			midiNote, pitchKeycenter, region.Transpose, region.Tune, region.Pitch, semitones, pitchRatio)
+	}
+
+	return pitchRatio
+}
+
+// render mixes all active voices into a mono output buffer and applies reverb
+func (e *engine) render(output []float32) {
+	e.renderEvents(output, nil)
+}
+
+// renderEvents renders a mono block like renderStereoEvents
+func (e *engine) renderEvents(output []float32, events []MidiEvent) {
+	e.renderStereoEvents(output, nil, events)
+}
+
+// renderStereoEvents renders a block, splitting it at each event's frame so notes, controllers and
+// bends take effect on the exact frame. Events must be in frame order; events stamped past the
+// end of the block are applied at its end. right must be as long as left, or nil to render mono,
+// where voices ignore their pan and the reverb return is folded to one channel.
+func (e *engine) renderStereoEvents(left, right []float32, events []MidiEvent) {
+	// Give idle voices the buffers prepared for newly loaded instruments, then pick up a reloaded
+	// instrument; voices already sounding keep their regions
+	e.pool.adoptReserve()
+	if inst := e.player.current(); inst != e.inst {
+		e.useInstrument(inst)
+	}
+
+	// Keep tempo-synced effects at the player's tempo
+	e.syncEffectTempo()
+
+	// Apply MIDI sent from other goroutines since the last block
+	var message controlMessage
+	for e.controls.pop(&message) {
+		e.processMidiMessage(message.bytes())
+	}
+
+	position := 0
+	for _, event := range events {
+		frame := min(int(event.Frame), len(left))
+		if frame > position {
+			e.renderSegment(left[position:frame], channelSpan(right, position, frame))
+			position = frame
+		}
+		e.processMidiMessage(event.Data)
+	}
+	e.renderSegment(left[position:], channelSpan(right, position, len(left)))
+
+	// Gain, limit and meter the whole block
+	e.master.process(e.player.master, left, right)
+}
+
+// channelSpan slices part of a block's right channel, which is nil when rendering mono
+func channelSpan(channel []float32, start, end int) []float32 {
+	if channel == nil {
+		return nil
+	}
+	return channel[start:end]
+}
+
+// renderSegment mixes all active voices into part of a block and applies reverb
+func (e *engine) renderSegment(left, right []float32) {
+	if len(left) == 0 {
+		return
+	}
+
+	e.prepareSends(len(left))
+	sendLevel := e.player.GetReverbSend()
+
+	// Process each active voice
+	for i := len(e.activeVoices) - 1; i >= 0; i-- {
+		voice := e.activeVoices[i]
+
+		if !voice.isActive {
+			// Return finished voices to the pool
+			e.removeVoice(i)
+			continue
+		}
+
+		e.renderVoice(voice, left, right, sendLevel)
+	}
+
+	// Advance the clock used by lotimer/hitimer
+	e.frameClock += uint64(len(left))
+
+	// Run the effect buses
+	e.mixEffects(left, right)
+}
+
+// renderVoice renders a single voice with pitch-shifting to the output and, at its send levels,
+// to the fx buses that have effects. The fx1 send is the player's, sendLevel, if the region doesn't
+// set one. right is nil when rendering mono.
+func (e *engine) renderVoice(voice *Voice, left, right []float32, sendLevel float64) {
+	sample := voice.sample
+
+	sends := voice.sends
+	sending := false
+	for i, level := range sends {
+		if level < 0 {
+			level = sendLevel
+		}
+		if len(e.inst.buses[busFx1+i].effects) == 0 {
+			level = 0
+		}
+		if level > 0 {
+			e.sends[i].input = true
+			sending = true
+		}
+		sends[i] = level
+	}
+
+	// Handle mono vs stereo sample indexing
+	samplesPerFrame := 1
+	if sample.Channels != 1 {
+		samplesPerFrame = 2
+	}
+
+	channelGain := e.channelState[voice.channel&0x0F].gain()
+	channelPan := e.channelState[voice.channel&0x0F].pan
+
+	for i := range left {
+		// Process envelope
+		envelopeLevel := voice.ProcessEnvelope()
+
+		// Check if envelope is finished
+		if envelopeLevel <= 0.0 && voice.envelopeState == EnvelopeOff {
+			voice.isActive = false
+			break
+		}
+
+		// Fade out stolen voices
+		envelopeLevel *= voice.processSteal()
+		if !voice.isActive {
+			break
+		}
+
+		// Advance modulation sources and collect target offsets
+		mod := voice.ProcessModulation()
+
+		// Get the interpolated sample value
+		sampleValue := getInterpolatedSample(sample, voice.position, samplesPerFrame)
+
+		// Apply the voice filter, if the region has one
+		if voice.filter != nil {
+			sampleValue = voice.filter.Process(sampleValue, mod[ModTargetCutoff], mod[ModTargetResonance])
+		}
+
+		// Apply the region's EQ bands, if it has any
+		if voice.eq.count > 0 {
+			sampleValue = voice.ProcessEQ(sampleValue)
+		}
+
+		// Apply volume, envelope, CC crossfade, volume modulation and channel volume/expression
+		sampleValue *= voice.volume * envelopeLevel * voice.ProcessCrossfade() * dbToLinear(mod[ModTargetVolume]) * channelGain
+
+		// Pan modulation (pan_oncc, lfoN_pan, egN_pan) and the channel's CC10 pan move the voice
+		// from its region's pan
+		leftGain, rightGain := voicePanGains(clampFloat64(voice.pan+mod[ModTargetPan]/100.0+channelPan, -1.0, 1.0))
+
+		if right == nil {
+			left[i] += float32(sampleValue)
+		} else {
+			left[i] += float32(sampleValue * leftGain)
+			right[i] += float32(sampleValue * rightGain)
+		}
+		if sending {
+			for bus, level := range sends {
+				if level == 0 {
+					continue
+				}
+				send := &e.sends[bus]
+				if right == nil {
+					send.left[i] += float32(sampleValue * level)
+				} else {
+					send.left[i] += float32(sampleValue * leftGain * level)
+					send.right[i] += float32(sampleValue * rightGain * level)
+				}
+			}
+		}
+
+		// Advance position by pitch ratio, pitch modulation and live pitch bend
+		voice.position += voice.pitchRatio * centsToRatio(mod[ModTargetPitch]+voice.ProcessBend())
+
+		// Process loop behavior
+		if !voice.ProcessLoop() {
+			voice.isActive = false
+			break
+		}
+	}
+}
+
+// getInterpolatedSample performs linear interpolation between sample points
+func getInterpolatedSample(sample *Sample, position float64, samplesPerFrame int) float64 {
+	// Get integer and fractional parts of position
+	intPos := int(position)
+	fracPos := position - float64(intPos)
+
+	// Ensure we don't go out of bounds
+	maxFrames := len(sample.Data) / samplesPerFrame
+	if intPos >= maxFrames {
+		return 0.0
+	}
+
+	// Get current sample (left channel for stereo samples)
+	sample1 := sample.Data[intPos*samplesPerFrame]
+
+	// Get next sample for interpolation
+	var sample2 float64
+	if intPos+1 < maxFrames {
+		sample2 = sample.Data[(intPos+1)*samplesPerFrame]
+	} else {
+		// At end of sample, use same value
+		sample2 = sample1
+	}
+
+	// Linear interpolation: result = sample1 + fracPos * (sample2 - sample1)
+	return sample1 + fracPos*(sample2-sample1)
+}
+
+// processControlChange handles MIDI Control Change messages on the first MIDI channel
+func (e *engine) processControlChange(cc, value uint8) {
+	e.processControlChangeChannel(0, cc, value)
+}
+
+// processControlChangeChannel handles MIDI Control Change messages on a MIDI channel (0-15)
+func (e *engine) processControlChangeChannel(channel, cc, value uint8) {
+	if cc > 127 {
+		return
+	}
+	value &= 0x7F
+
+	// Convert MIDI value (0-127) to float (0.0-1.0)
+	floatValue := float64(value) / 127.0
+
+	// Record the value for the channel's modulation routings (volume_oncc, pitch_oncc, ...) and
+	// update its pedals
+	state := &e.channelState[channel&0x0F]
+	state.cc[cc] = value
+	previous := state.ccValues[cc]
+	state.ccValues[cc] = floatValue
+	e.processPedals(channel&0x0F, cc, previous, floatValue)
+	e.processChannelController(channel, cc, value)
+	e.controlEffects(int(cc), floatValue)
+
+	// Reverb controllers bypass the player's setters, which log
+	switch cc {
+	case 91: // Standard MIDI CC for reverb send/depth
+		e.player.reverbSend.Store(math.Float64bits(floatValue))
+		if engineDebugEnabled {
+			engineDebug("MIDI CC91 (Reverb Send): %.3f", floatValue)
+		}
+
+	case 92: // Reverb room size (custom mapping)
+		e.player.reverb.SetRoomSize(floatValue)
+		if engineDebugEnabled {
+			engineDebug("MIDI CC92 (Reverb Room Size): %.3f", floatValue)
+		}
+
+	case 93: // Reverb damping (custom mapping)
+		e.player.reverb.SetDamping(floatValue)
+		if engineDebugEnabled {
+			engineDebug("MIDI CC93 (Reverb Damping): %.3f", floatValue)
+		}
+
+	case 94: // Reverb wet level (custom mapping)
+		e.player.reverb.SetWet(floatValue)
+		if engineDebugEnabled {
+			engineDebug("MIDI CC94 (Reverb Wet): %.3f", floatValue)
+		}
+
+	case 95: // Reverb dry level (custom mapping)
+		e.player.reverb.SetDry(floatValue)
+		if engineDebugEnabled {
+			engineDebug("MIDI CC95 (Reverb Dry): %.3f", floatValue)
+		}
+
+	default:
+		if engineDebugEnabled {
+			engineDebug("MIDI CC%d: %d", cc, value)
+		}
+	}
+}
+
+// processPitchBend handles MIDI Pitch Bend messages on the first MIDI channel
+func (e *engine) processPitchBend(lsb, msb uint8) {
+	e.processPitchBendChannel(0, lsb, msb)
+}
+
+// processPitchBendChannel handles MIDI Pitch Bend messages on a MIDI channel (0-15)
+func (e *engine) processPitchBendChannel(channel, lsb, msb uint8) {
+	// Convert 14-bit pitch bend value to signed 16-bit (-8192 to +8191)
+	// LSB = low 7 bits, MSB = high 7 bits
+	bendValue := int16((uint16(msb)<<7)|uint16(lsb)) - 8192
+
+	e.channelState[channel&0x0F].pitchBend = bendValue
+	if engineDebugEnabled {
+		engineDebug("Pitch Bend: %d", bendValue)
+	}
+}
+
+// stopVoicesByOffBy stops all active voices that should be stopped by the given group
+func (e *engine) stopVoicesByOffBy(groupID int) {
+	for i := len(e.activeVoices) - 1; i >= 0; i-- {
+		voice := e.activeVoices[i]
+		if voice.offByGroup == groupID {
+			if engineDebugEnabled {
+				engineDebug("Stopping voice (group exclusion): note=%d, stopped_by_group=%d", voice.midiNote, groupID)
+			}
+			// Remove voice immediately
+			e.removeVoice(i)
+		}
+	}
+}
+
+// handleReleaseTriggers handles release trigger regions when a note is released
+func (e *engine) handleReleaseTriggers(channel, note uint8) {
+	// Find regions with trigger=release mapped to this note
+	for _, region := range e.inst.index.releaseRegions(note) {
+		// Check if this region matches the released note (without trigger mode check)
+		if !e.regionMatchesForRelease(region, channel, note) {
+			continue
+		}
+
+		// Regions without a loaded sample stay silent
+		if region.sample == nil {
+			continue
+		}
+
+		// Release voices use a moderate velocity and don't respond to note-off
+		volume := e.calculateVolume(region, 64) * e.calculateKeyGain(region, note, 64)
+		e.startVoice(region, channel, note, 64, volume, false)
+
+		if engineDebugEnabled {
+			engineDebug("Started release voice for note %d", note)
+		}
+	}
+}
+
+// regionMatchesForRelease checks if a region matches for release triggers (without trigger mode check)
+func (e *engine) regionMatchesForRelease(region *Region, channel, note uint8) bool {
+	if !region.matchesChannel(channel) {
+		return false
+	}
+
+	// Check key range
+	if int(note) < region.LoKey || int(note) > region.HiKey {
+		return false
+	}
+
+	// Check keyswitch conditions (same as normal matching)
+	return e.keyswitchMatches(region)
+}
@@ -19,7 +19,7 @@ func TestEnvelopeInitialization(t *testing.T) {
 
 	// Create a test voice
 	voice := &Voice{
-		region: region,
+		region: compileRegion(region, nil),
 	}
 
 	// Initialize envelope with test sample rate
@@ -67,7 +67,7 @@ func TestEnvelopeDefaults(t *testing.T) {
 
 	// Create a test voice
 	voice := &Voice{
-		region: region,
+		region: compileRegion(region, nil),
 	}
 
 	// Initialize envelope with test sample rate
@@ -102,7 +102,7 @@ func TestEnvelopeProcessing(t *testing.T) {
 	}
 
 	voice := &Voice{
-		region: region,
+		region: compileRegion(region, nil),
 	}
 
 	sampleRate := uint32(44100)
@@ -150,7 +150,7 @@ func TestEnvelopeDoesNotCrash(t *testing.T) {
 	}
 
 	voice := &Voice{
-		region: region,
+		region: compileRegion(region, nil),
 	}
 
 	sampleRate := uint32(44100)
@@ -0,0 +1,401 @@
+package gosfzplayer
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// maxEQBands is the number of bands of a region's EQ and of the EQ effect (eq1-eq3)
+const maxEQBands = 3
+
+// defaultEQFreqs are the frequencies of eq1-eq3 when eqN_freq isn't set
+var defaultEQFreqs = [maxEQBands]float64{50.0, 500.0, 5000.0}
+
+// EQ band types
+const (
+	eqPeak = iota
+	eqLowShelf
+	eqHighShelf
+)
+
+// eqTypeNames are the values of eqN_type
+var eqTypeNames = [...]string{"peak", "lshelf", "hshelf"}
+
+// EQ parameters of a band: freq in Hz, gain in dB, bw in octaves
+const (
+	eqFreq = iota
+	eqGain
+	eqBW
+	numEQParams
+)
+
+// eqParamNames are the opcode suffixes of the EQ parameters
+var eqParamNames = [numEQParams]string{"freq", "gain", "bw"}
+
+// Opcode patterns for the region EQ
+var (
+	// eqN_freq, eqN_gain, eqN_bw, eqN_type, eqN_vel2freq, eqN_vel2gain
+	eqOpcodePattern = regexp.MustCompile(`^eq[1-3]_(freq|gain|bw|type|vel2freq|vel2gain)$`)
+	// eqN_freqccX (SFZ v1) or eqN_freq_onccX (SFZ v2), and the same for gain and bw
+	eqCCOpcodePattern = regexp.MustCompile(`^eq([1-3])_(freq|gain|bw)(?:_on)?cc(\d+)$`)
+)
+
+// isEQOpcode checks if an opcode is one of the region EQ opcodes
+func isEQOpcode(opcode string) bool {
+	return eqOpcodePattern.MatchString(opcode) || eqCCOpcodePattern.MatchString(opcode)
+}
+
+// EQBand is one band of an equalizer
+type EQBand struct {
+	Type string  // "peak" (default), "lshelf" or "hshelf"
+	Freq float64 // Center frequency, or corner frequency of a shelf, in Hz
+	Gain float64 // Gain in dB (-96 to 24)
+	BW   float64 // Bandwidth in octaves (0.001 to 4)
+}
+
+// parseEQType returns the band type for an eqN_type value, peak if it isn't recognized
+func parseEQType(value string) int {
+	for eqType, name := range eqTypeNames {
+		if strings.EqualFold(value, name) {
+			return eqType
+		}
+	}
+	return eqPeak
+}
+
+// biquad is a second-order filter section with coefficients from the RBJ audio EQ cookbook
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+// setEQ computes the coefficients of an EQ band at a sample rate, keeping the filter memory
+func (bq *biquad) setEQ(eqType int, freq, gain, bw, sampleRate float64) {
+	freq = clampFloat64(freq, 10.0, sampleRate*0.45)
+	gain = clampFloat64(gain, -96.0, 24.0)
+	bw = clampFloat64(bw, 0.001, 4.0)
+
+	a := math.Pow(10.0, gain/40.0)
+	w0 := 2.0 * math.Pi * freq / sampleRate
+	sinW0, cosW0 := math.Sin(w0), math.Cos(w0)
+	alpha := sinW0 * math.Sinh(math.Ln2/2.0*bw*w0/sinW0)
+	shelf := 2.0 * math.Sqrt(a) * alpha
+
+	var b0, b1, b2, a0, a1, a2 float64
+	switch eqType {
+	case eqLowShelf:
+		b0 = a * ((a + 1) - (a-1)*cosW0 + shelf)
+		b1 = 2.0 * a * ((a - 1) - (a+1)*cosW0)
+		b2 = a * ((a + 1) - (a-1)*cosW0 - shelf)
+		a0 = (a + 1) + (a-1)*cosW0 + shelf
+		a1 = -2.0 * ((a - 1) + (a+1)*cosW0)
+		a2 = (a + 1) + (a-1)*cosW0 - shelf
+	case eqHighShelf:
+		b0 = a * ((a + 1) + (a-1)*cosW0 + shelf)
+		b1 = -2.0 * a * ((a - 1) + (a+1)*cosW0)
+		b2 = a * ((a + 1) + (a-1)*cosW0 - shelf)
+		a0 = (a + 1) - (a-1)*cosW0 + shelf
+		a1 = 2.0 * ((a - 1) - (a+1)*cosW0)
+		a2 = (a + 1) - (a-1)*cosW0 - shelf
+	default: // Peaking
+		b0 = 1.0 + alpha*a
+		b1 = -2.0 * cosW0
+		b2 = 1.0 - alpha*a
+		a0 = 1.0 + alpha/a
+		a1 = -2.0 * cosW0
+		a2 = 1.0 - alpha/a
+	}
+
+	bq.b0 = b0 / a0
+	bq.b1 = b1 / a0
+	bq.b2 = b2 / a0
+	bq.a1 = a1 / a0
+	bq.a2 = a2 / a0
+}
+
+// process filters one sample
+func (bq *biquad) process(input float64) float64 {
+	output := bq.b0*input + bq.b1*bq.x1 + bq.b2*bq.x2 - bq.a1*bq.y1 - bq.a2*bq.y2
+	bq.x2 = bq.x1
+	bq.x1 = input
+	bq.y2 = bq.y1
+	bq.y1 = output
+	return output
+}
+
+// Equalizer is a stereo three-band equalizer, the eq effect type
+type Equalizer struct {
+	// Band parameters are set from any goroutine and picked up by the audio thread on its next block
+	types   [maxEQBands]atomic.Int32
+	params  [maxEQBands][numEQParams]atomic.Uint64 // float64 bits
+	version atomic.Uint64                          // Incremented on every parameter change
+
+	processor *equalizerProcessor // Runs Process; engines run processors of their own
+	controls  effectControls
+}
+
+// equalizerProcessor is the filters of one audio thread running an Equalizer
+type equalizerProcessor struct {
+	eq             *Equalizer
+	appliedVersion uint64
+	active         [maxEQBands]bool // Bands with a gain, which are the only ones processed
+	filters        [maxEQBands][2]biquad
+	sampleRate     float64
+}
+
+// NewEqualizer creates an equalizer with flat peaking bands at 50 Hz, 500 Hz and 5 kHz
+func NewEqualizer(sampleRate uint32) *Equalizer {
+	eq := &Equalizer{}
+	for i := range eq.params {
+		eq.SetBand(i, EQBand{Freq: defaultEQFreqs[i], BW: 1.0})
+	}
+	eq.processor = newEqualizerProcessor(eq, sampleRate)
+	return eq
+}
+
+// newEqualizerProcessor creates filters for an equalizer at a sample rate
+func newEqualizerProcessor(eq *Equalizer, sampleRate uint32) *equalizerProcessor {
+	return &equalizerProcessor{
+		eq:             eq,
+		appliedVersion: math.MaxUint64, // Picks up the parameters on the first block
+		sampleRate:     float64(sampleRate),
+	}
+}
+
+// NewProcessor creates a processor with filters of its own that follows the equalizer's settings
+func (eq *Equalizer) NewProcessor(sampleRate uint32) Effect {
+	return newEqualizerProcessor(eq, sampleRate)
+}
+
+// newEqualizerEffect creates an equalizer from the eqN_type, eqN_freq, eqN_gain and eqN_bw opcodes
+// of an <effect> section; each parameter can follow CCs with eqN_<param>_onccX
+func newEqualizerEffect(section *SfzSection, sampleRate uint32) (Effect, error) {
+	eq := NewEqualizer(sampleRate)
+	for i := 0; i < maxEQBands; i++ {
+		band := i
+		prefix := fmt.Sprintf("eq%d_", band+1)
+		eq.types[band].Store(int32(parseEQType(section.GetStringOpcode(prefix + "type"))))
+		defaults := [numEQParams]float64{defaultEQFreqs[band], 0.0, 1.0}
+		for param, name := range eqParamNames {
+			eq.controls.bind(section, prefix+name, defaults[param], func(value float64) {
+				eq.setParameter(band, param, value)
+			})
+		}
+	}
+	return eq, nil
+}
+
+// setParameter stores a band parameter for the audio thread; bands outside 0-2 are ignored
+func (eq *Equalizer) setParameter(band, param int, value float64) {
+	if band < 0 || band >= maxEQBands {
+		return
+	}
+	eq.params[band][param].Store(math.Float64bits(value))
+	eq.version.Add(1)
+}
+
+// SetBand sets a band (0-2); an empty type is a peaking band
+func (eq *Equalizer) SetBand(band int, settings EQBand) {
+	if band < 0 || band >= maxEQBands {
+		return
+	}
+	eq.types[band].Store(int32(parseEQType(settings.Type)))
+	eq.setParameter(band, eqFreq, settings.Freq)
+	eq.setParameter(band, eqGain, settings.Gain)
+	eq.setParameter(band, eqBW, settings.BW)
+}
+
+// GetBand returns a band's settings (0-2)
+func (eq *Equalizer) GetBand(band int) EQBand {
+	if band < 0 || band >= maxEQBands {
+		return EQBand{}
+	}
+	return EQBand{
+		Type: eqTypeNames[eq.types[band].Load()],
+		Freq: math.Float64frombits(eq.params[band][eqFreq].Load()),
+		Gain: math.Float64frombits(eq.params[band][eqGain].Load()),
+		BW:   math.Float64frombits(eq.params[band][eqBW].Load()),
+	}
+}
+
+// SetBandGain sets a band's gain in dB (band 0-2)
+func (eq *Equalizer) SetBandGain(band int, gain float64) {
+	eq.setParameter(band, eqGain, gain)
+}
+
+// SetBandFreq sets a band's frequency in Hz (band 0-2)
+func (eq *Equalizer) SetBandFreq(band int, freq float64) {
+	eq.setParameter(band, eqFreq, freq)
+}
+
+// SetBandWidth sets a band's bandwidth in octaves (band 0-2)
+func (eq *Equalizer) SetBandWidth(band int, bw float64) {
+	eq.setParameter(band, eqBW, bw)
+}
+
+// applyParameters updates the filters from the equalizer's current parameters; only the audio
+// thread calls it
+func (ep *equalizerProcessor) applyParameters() {
+	ep.appliedVersion = ep.eq.version.Load()
+	for band := range ep.filters {
+		settings := ep.eq.GetBand(band)
+		ep.active[band] = settings.Gain != 0
+		for channel := range ep.filters[band] {
+			ep.filters[band][channel].setEQ(parseEQType(settings.Type), settings.Freq, settings.Gain, settings.BW, ep.sampleRate)
+		}
+	}
+}
+
+// Process runs a stereo bus through the equalizer in place
+func (eq *Equalizer) Process(left, right []float32) {
+	eq.processor.Process(left, right)
+}
+
+// Process runs a stereo bus through the band filters in place
+func (ep *equalizerProcessor) Process(left, right []float32) {
+	if ep.eq.version.Load() != ep.appliedVersion {
+		ep.applyParameters()
+	}
+	for band := range ep.filters {
+		if !ep.active[band] {
+			continue
+		}
+		filterL, filterR := &ep.filters[band][0], &ep.filters[band][1]
+		for i := range left {
+			left[i] = float32(filterL.process(float64(left[i])))
+			right[i] = float32(filterR.process(float64(right[i])))
+		}
+	}
+}
+
+// ControlChange applies the equalizer's CC routes
+func (eq *Equalizer) ControlChange(cc int, value float64) {
+	eq.controls.ControlChange(cc, value)
+}
+
+// regionEQRouting is the velocity and CC routing of one of a region's EQ bands
+type regionEQRouting struct {
+	vel2freq float64                // Hz added at velocity 127 (eqN_vel2freq)
+	vel2gain float64                // dB added at velocity 127 (eqN_vel2gain)
+	cc       [numEQParams][]ccRoute // Routes to freq (Hz), gain (dB) and bw (octaves)
+}
+
+// compileRegionEQ reads a region's eq1-eq3 opcodes, with inheritance. Bands that can never have a
+// gain are left out.
+func compileRegionEQ(section *SfzSection) ([]EQBand, []regionEQRouting) {
+	var routing [maxEQBands]regionEQRouting
+	for opcode, value := range section.inheritedOpcodes() {
+		m := eqCCOpcodePattern.FindStringSubmatch(opcode)
+		if m == nil {
+			continue
+		}
+		band, _ := strconv.Atoi(m[1])
+		cc, _ := strconv.Atoi(m[3])
+		depth, err := strconv.ParseFloat(value, 64)
+		if err != nil || cc > 127 {
+			continue
+		}
+		for param, name := range eqParamNames {
+			if name == m[2] {
+				routing[band-1].cc[param] = append(routing[band-1].cc[param], ccRoute{cc: cc, depth: depth, curve: linearCurve})
+			}
+		}
+	}
+
+	var bands []EQBand
+	var routes []regionEQRouting
+	for i := 0; i < maxEQBands; i++ {
+		prefix := fmt.Sprintf("eq%d_", i+1)
+		band := EQBand{
+			Type: eqTypeNames[parseEQType(section.GetInheritedStringOpcode(prefix+"type"))],
+			Freq: section.GetInheritedFloatOpcode(prefix+"freq", defaultEQFreqs[i]),
+			Gain: section.GetInheritedFloatOpcode(prefix+"gain", 0.0),
+			BW:   section.GetInheritedFloatOpcode(prefix+"bw", 1.0),
+		}
+		routing[i].vel2freq = section.GetInheritedFloatOpcode(prefix+"vel2freq", 0.0)
+		routing[i].vel2gain = section.GetInheritedFloatOpcode(prefix+"vel2gain", 0.0)
+		if band.Gain == 0 && routing[i].vel2gain == 0 && len(routing[i].cc[eqGain]) == 0 {
+			continue
+		}
+		bands = append(bands, band)
+		routes = append(routes, routing[i])
+	}
+	return bands, routes
+}
+
+// voiceEQBand is the filter and CC state of one band of a voice's EQ
+type voiceEQBand struct {
+	filter  biquad
+	eqType  int
+	base    [numEQParams]float64 // Parameters with velocity tracking applied
+	last    [numEQParams]float64 // Effective parameters the coefficients were computed for
+	ccMods  [numEQParams][]ccModulator
+	routing *regionEQRouting
+}
+
+// voiceEQ is a voice's EQ, the region's eq1-eq3 bands
+type voiceEQ struct {
+	bands      [maxEQBands]voiceEQBand
+	count      int // Bands in use
+	ccValues   *[128]float64
+	sampleRate float64
+}
+
+// InitializeEQ sets up the voice's EQ bands from its region, reusing the CC buffers of its
+// previous note
+func (v *Voice) InitializeEQ(sampleRate uint32, ccValues *[128]float64) {
+	eq := &v.eq
+	eq.count = len(v.region.EQ)
+	eq.ccValues = ccValues
+	eq.sampleRate = float64(sampleRate)
+
+	velocity := float64(v.velocity) / 127.0
+	for i, settings := range v.region.EQ {
+		band := &eq.bands[i]
+		band.routing = &v.region.eqRouting[i]
+		band.eqType = parseEQType(settings.Type)
+		band.base = [numEQParams]float64{
+			settings.Freq + band.routing.vel2freq*velocity,
+			settings.Gain + band.routing.vel2gain*velocity,
+			settings.BW,
+		}
+		band.filter = biquad{}
+		for param := range band.ccMods {
+			band.ccMods[param] = band.ccMods[param][:0]
+			for _, route := range band.routing.cc[param] {
+				band.ccMods[param] = append(band.ccMods[param], newCCModulator(route, eq.sampleRate))
+			}
+		}
+		band.last = band.effective(ccValues)
+		band.filter.setEQ(band.eqType, band.last[eqFreq], band.last[eqGain], band.last[eqBW], eq.sampleRate)
+	}
+}
+
+// effective returns the band's parameters with its CC routes applied
+func (band *voiceEQBand) effective(ccValues *[128]float64) [numEQParams]float64 {
+	params := band.base
+	for param, mods := range band.ccMods {
+		params[param] += sumCCModulators(mods, ccValues)
+	}
+	return params
+}
+
+// ProcessEQ runs one sample through the voice's EQ bands
+func (v *Voice) ProcessEQ(input float64) float64 {
+	eq := &v.eq
+	for i := 0; i < eq.count; i++ {
+		band := &eq.bands[i]
+		if len(band.routing.cc[eqFreq])+len(band.routing.cc[eqGain])+len(band.routing.cc[eqBW]) > 0 {
+			if params := band.effective(eq.ccValues); params != band.last {
+				band.last = params
+				band.filter.setEQ(band.eqType, params[eqFreq], params[eqGain], params[eqBW], eq.sampleRate)
+			}
+		}
+		input = band.filter.process(input)
+	}
+	return input
+}
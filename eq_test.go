@@ -0,0 +1,141 @@
+package gosfzplayer
+
+import (
+	"math"
+	"testing"
+)
+
+// sineLevel runs a sine through a filter function and returns its peak once the filter has settled
+func sineLevel(freq, sampleRate float64, filter func(float64) float64) float64 {
+	level := 0.0
+	for i := 0; i < int(sampleRate); i++ {
+		output := filter(math.Sin(2.0 * math.Pi * freq * float64(i) / sampleRate))
+		if i > int(sampleRate)/2 {
+			level = math.Max(level, math.Abs(output))
+		}
+	}
+	return level
+}
+
+func TestBiquadEQBands(t *testing.T) {
+	tests := []struct {
+		name       string
+		eqType     int
+		freq, gain float64
+		probe      float64 // Frequency measured
+		want       float64 // Expected gain in dB at the probe frequency
+	}{
+		{"peak at center", eqPeak, 1000, 12, 1000, 12},
+		{"peak far away", eqPeak, 1000, 12, 50, 0},
+		{"low shelf below", eqLowShelf, 200, -12, 30, -12},
+		{"low shelf above", eqLowShelf, 200, -12, 8000, 0},
+		{"high shelf above", eqHighShelf, 2000, 6, 15000, 6},
+		{"high shelf below", eqHighShelf, 2000, 6, 100, 0},
+	}
+	for _, tt := range tests {
+		var bq biquad
+		bq.setEQ(tt.eqType, tt.freq, tt.gain, 1.0, 44100)
+		got := 20.0 * math.Log10(sineLevel(tt.probe, 44100, bq.process))
+		if math.Abs(got-tt.want) > 0.5 {
+			t.Errorf("%s: expected %.1f dB, got %.2f dB", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestRegionEQ(t *testing.T) {
+	e := createTestEngine(t, `<group>
+eq1_gain=6
+<region>
+sample=sample1.wav
+key=60
+eq2_freq=1000
+eq2_bw=2
+eq2_gain=-12
+eq3_type=hshelf
+eq3_vel2gain=6
+eq3_gaincc20=-6
+`)
+	if diagnostics := e.player.GetSfzData().Diagnostics; len(diagnostics) != 0 {
+		t.Fatalf("Expected the EQ opcodes to be recognized, got %v", diagnostics)
+	}
+
+	region := e.player.Regions()[0]
+	want := []EQBand{
+		{Type: "peak", Freq: 50, Gain: 6, BW: 1},
+		{Type: "peak", Freq: 1000, Gain: -12, BW: 2},
+		{Type: "hshelf", Freq: 5000, Gain: 0, BW: 1},
+	}
+	if len(region.EQ) != len(want) {
+		t.Fatalf("Expected %d EQ bands, got %+v", len(want), region.EQ)
+	}
+	for i := range want {
+		if region.EQ[i] != want[i] {
+			t.Errorf("Band %d: expected %+v, got %+v", i+1, want[i], region.EQ[i])
+		}
+	}
+	if routing := region.eqRouting[2]; routing.vel2gain != 6 || len(routing.cc[eqGain]) != 1 || routing.cc[eqGain][0].cc != 20 {
+		t.Errorf("Unexpected eq3 routing: %+v", routing)
+	}
+
+	// The voice applies velocity tracking and follows the CC
+	e.processControlChange(20, 127)
+	voice := e.startVoice(&region, 0, 60, 127, 1.0, true)
+	band := &voice.eq.bands[2]
+	if voice.eq.count != 3 || band.base[eqGain] != 6 {
+		t.Fatalf("Expected eq3 at +6 dB from velocity, got %v", band.base)
+	}
+	voice.ProcessEQ(0)
+	if math.Abs(band.last[eqGain]) > 1e-9 {
+		t.Errorf("Expected eq3_gaincc20 to bring the band back to 0 dB, got %f", band.last[eqGain])
+	}
+
+	// Regions without gains have no EQ
+	plain := createTestEngine(t, "<region>\nsample=sample1.wav\neq1_freq=100\n")
+	if eq := plain.player.Regions()[0].EQ; eq != nil {
+		t.Errorf("Expected no EQ bands without a gain, got %+v", eq)
+	}
+}
+
+func TestEqualizerEffect(t *testing.T) {
+	e := createTestEngine(t, `<region>
+sample=sample1.wav
+key=60
+
+<effect>
+type=eq
+eq2_freq=1000
+eq2_gain=-12
+eq2_gain_oncc21=24
+`)
+	effects := e.player.Effects("main")
+	if len(effects) != 1 {
+		t.Fatalf("Expected an equalizer on the main bus, got %d effects", len(effects))
+	}
+	eq := effects[0].(*Equalizer)
+	if band := eq.GetBand(1); band.Freq != 1000 || band.Gain != -12 || band.Type != "peak" {
+		t.Errorf("Unexpected band: %+v", band)
+	}
+
+	left := make([]float32, 44100)
+	right := make([]float32, 44100)
+	measure := func() float64 {
+		for i := range left {
+			left[i] = float32(math.Sin(2.0 * math.Pi * 1000 * float64(i) / 44100))
+			right[i] = left[i]
+		}
+		eq.Process(left, right)
+		return 20.0 * math.Log10(peak(left[22050:]))
+	}
+	if got := measure(); math.Abs(got+12) > 0.5 {
+		t.Errorf("Expected -12 dB at 1 kHz, got %.2f dB", got)
+	}
+
+	// CC21 at full scale adds 24 dB
+	e.processControlChange(21, 127)
+	if band := eq.GetBand(1); band.Gain != 12 {
+		t.Errorf("Expected CC21 to raise the gain to 12 dB, got %f", band.Gain)
+	}
+	if got := measure(); math.Abs(got-12) > 0.5 {
+		t.Errorf("Expected +12 dB at 1 kHz, got %.2f dB", got)
+	}
+}
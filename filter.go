@@ -0,0 +1,132 @@
+package gosfzplayer
+
+import (
+	"math"
+)
+
+// VoiceFilter implements the per-voice SFZ filter (fil_type, cutoff, resonance)
+type VoiceFilter struct {
+	filterType string
+	cutoff     float64 // Base cutoff frequency in Hz
+	resonance  float64 // Base resonance in dB
+	sampleRate float64
+
+	// Biquad coefficients (one-pole types leave b2 and a2 at zero)
+	b0, b1, b2, a1, a2 float64
+
+	// Filter memory
+	x1, x2, y1, y2 float64
+
+	// Effective parameters the coefficients were last computed for
+	lastCutoff    float64
+	lastResonance float64
+}
+
+// NewVoiceFilter creates a filter of the given SFZ type (lpf_1p, lpf_2p, hpf_1p, hpf_2p, bpf_2p, brf_2p)
+func NewVoiceFilter(filterType string, cutoff, resonance float64, sampleRate uint32) *VoiceFilter {
+	vf := &VoiceFilter{}
+	vf.init(filterType, cutoff, resonance, sampleRate)
+	return vf
+}
+
+// init sets up a filter in place, clearing its memory
+func (vf *VoiceFilter) init(filterType string, cutoff, resonance float64, sampleRate uint32) {
+	if filterType == "" {
+		filterType = "lpf_2p" // SFZ default filter type
+	}
+	*vf = VoiceFilter{
+		filterType: filterType,
+		cutoff:     cutoff,
+		resonance:  resonance,
+		sampleRate: float64(sampleRate),
+	}
+	vf.updateCoefficients(cutoff, resonance)
+}
+
+// updateCoefficients recomputes the filter coefficients for an effective cutoff and resonance
+func (vf *VoiceFilter) updateCoefficients(cutoff, resonance float64) {
+	vf.lastCutoff = cutoff
+	vf.lastResonance = resonance
+
+	cutoff = clampFloat64(cutoff, 10.0, vf.sampleRate*0.45)
+	resonance = clampFloat64(resonance, 0.0, 40.0)
+
+	switch vf.filterType {
+	case "lpf_1p":
+		p := math.Exp(-2.0 * math.Pi * cutoff / vf.sampleRate)
+		vf.b0, vf.b1, vf.b2, vf.a1, vf.a2 = 1.0-p, 0, 0, -p, 0
+		return
+	case "hpf_1p":
+		p := math.Exp(-2.0 * math.Pi * cutoff / vf.sampleRate)
+		vf.b0, vf.b1, vf.b2, vf.a1, vf.a2 = (1.0+p)/2.0, -(1.0+p)/2.0, 0, -p, 0
+		return
+	}
+
+	// Two-pole types use the RBJ cookbook biquads; 0 dB resonance is a Butterworth response
+	w0 := 2.0 * math.Pi * cutoff / vf.sampleRate
+	q := dbToLinear(resonance) / math.Sqrt2
+	alpha := math.Sin(w0) / (2.0 * q)
+	cosW0 := math.Cos(w0)
+	a0 := 1.0 + alpha
+
+	var b0, b1, b2 float64
+	switch vf.filterType {
+	case "hpf_2p":
+		b0 = (1.0 + cosW0) / 2.0
+		b1 = -(1.0 + cosW0)
+		b2 = (1.0 + cosW0) / 2.0
+	case "bpf_2p":
+		b0 = alpha
+		b1 = 0
+		b2 = -alpha
+	case "brf_2p":
+		b0 = 1.0
+		b1 = -2.0 * cosW0
+		b2 = 1.0
+	default: // lpf_2p
+		b0 = (1.0 - cosW0) / 2.0
+		b1 = 1.0 - cosW0
+		b2 = (1.0 - cosW0) / 2.0
+	}
+
+	vf.b0 = b0 / a0
+	vf.b1 = b1 / a0
+	vf.b2 = b2 / a0
+	vf.a1 = -2.0 * cosW0 / a0
+	vf.a2 = (1.0 - alpha) / a0
+}
+
+// Process filters one sample; cutoffCents and resonanceDB are modulation offsets
+func (vf *VoiceFilter) Process(input, cutoffCents, resonanceDB float64) float64 {
+	cutoff := vf.cutoff
+	if cutoffCents != 0 {
+		cutoff *= centsToRatio(cutoffCents)
+	}
+	resonance := vf.resonance + resonanceDB
+	if cutoff != vf.lastCutoff || resonance != vf.lastResonance {
+		vf.updateCoefficients(cutoff, resonance)
+	}
+
+	output := vf.b0*input + vf.b1*vf.x1 + vf.b2*vf.x2 - vf.a1*vf.y1 - vf.a2*vf.y2
+	vf.x2 = vf.x1
+	vf.x1 = input
+	vf.y2 = vf.y1
+	vf.y1 = output
+	return output
+}
+
+// InitializeFilter sets up the voice filter if the region defines a cutoff
+func (v *Voice) InitializeFilter(sampleRate uint32) {
+	v.filter = nil
+	cutoff := v.region.Cutoff
+	if cutoff <= 0 {
+		return
+	}
+
+	resonance := v.region.Resonance
+	v.filterState.init(v.region.FilType, cutoff, resonance, sampleRate)
+	v.filter = &v.filterState
+	if voiceDebugEnabled {
+		voiceDebug("Initialized filter: type=%s, cutoff=%.1fHz, resonance=%.1fdB", v.filter.filterType, cutoff, resonance)
+	}
+}
@@ -0,0 +1,235 @@
+package gosfzplayer
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/frame"
+	"github.com/mewkiz/flac/meta"
+)
+
+// flacBlockSize is the number of frames per FLAC frame
+const flacBlockSize = 4096
+
+// flacMaxRiceParam is the largest Rice parameter; 31 is the escape code
+const flacMaxRiceParam = 30
+
+// flacEncoder streams integer audio to a FLAC file, choosing a fixed predictor per subframe
+type flacEncoder struct {
+	encoder   *flac.Encoder
+	options   AudioWriterOptions
+	quantizer *quantizer
+	channels  [][]int32 // Pending samples per channel, up to flacBlockSize
+	residuals []int32
+}
+
+// seekableBuffer buffers writes to a file, flushing before a seek so the encoder can rewrite
+// its stream info block on close
+type seekableBuffer struct {
+	*bufio.Writer
+	file *os.File
+}
+
+// Seek flushes pending writes and seeks the file
+func (b *seekableBuffer) Seek(offset int64, whence int) (int64, error) {
+	if err := b.Flush(); err != nil {
+		return 0, err
+	}
+	return b.file.Seek(offset, whence)
+}
+
+// Close flushes pending writes and closes the file
+func (b *seekableBuffer) Close() error {
+	err := b.Flush()
+	if closeErr := b.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// newFLACEncoder creates a FLAC file and writes its metadata blocks
+func newFLACEncoder(path string, opts AudioWriterOptions) (*flacEncoder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create FLAC file: %w", err)
+	}
+	output := &seekableBuffer{Writer: bufio.NewWriter(file), file: file}
+
+	info := &meta.StreamInfo{
+		BlockSizeMin:  flacBlockSize,
+		BlockSizeMax:  flacBlockSize,
+		SampleRate:    opts.SampleRate,
+		NChannels:     uint8(opts.Channels),
+		BitsPerSample: uint8(opts.Format.bits()),
+	}
+	var blocks []*meta.Block
+	if tags := opts.Metadata.tags(); len(tags) > 0 {
+		// The encoder skips the body of a block with zero length, so set it up front
+		comment := &meta.VorbisComment{Vendor: "gosfzplayer"}
+		length := 4 + len(comment.Vendor) + 4
+		for _, tag := range tags {
+			comment.Tags = append(comment.Tags, [2]string{tag[1], tag[2]})
+			length += 4 + len(tag[1]) + 1 + len(tag[2])
+		}
+		blocks = append(blocks, &meta.Block{
+			Header: meta.Header{Type: meta.TypeVorbisComment, Length: int64(length)},
+			Body:   comment,
+		})
+	}
+
+	encoder, err := flac.NewEncoder(output, info, blocks...)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write FLAC header: %w", err)
+	}
+
+	w := &flacEncoder{
+		encoder:   encoder,
+		options:   opts,
+		quantizer: newQuantizer(opts.Format.bits(), opts.Dither),
+		channels:  make([][]int32, opts.Channels),
+		residuals: make([]int32, flacBlockSize),
+	}
+	for i := range w.channels {
+		w.channels[i] = make([]int32, 0, flacBlockSize)
+	}
+	return w, nil
+}
+
+// write appends interleaved samples, encoding a FLAC frame each time a block fills
+func (w *flacEncoder) write(samples []float32) error {
+	channels := len(w.channels)
+	for i := 0; i < len(samples); i += channels {
+		for ch := range w.channels {
+			w.channels[ch] = append(w.channels[ch], w.quantizer.quantize(samples[i+ch]))
+		}
+		if len(w.channels[0]) == flacBlockSize {
+			if err := w.writeFrame(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeFrame encodes the pending samples as one FLAC frame
+func (w *flacEncoder) writeFrame() error {
+	n := len(w.channels[0])
+	if n == 0 {
+		return nil
+	}
+
+	channels := frame.ChannelsMono
+	if len(w.channels) == 2 {
+		channels = frame.ChannelsLR
+	}
+	f := &frame.Frame{
+		Header: frame.Header{
+			HasFixedBlockSize: true,
+			BlockSize:         uint16(n),
+			SampleRate:        w.options.SampleRate,
+			Channels:          channels,
+			BitsPerSample:     uint8(w.options.Format.bits()),
+		},
+	}
+	for ch := range w.channels {
+		f.Subframes = append(f.Subframes, w.subframe(w.channels[ch]))
+	}
+
+	if err := w.encoder.WriteFrame(f); err != nil {
+		return fmt.Errorf("failed to write FLAC frame: %w", err)
+	}
+	for ch := range w.channels {
+		w.channels[ch] = w.channels[ch][:0]
+	}
+	return nil
+}
+
+// subframe picks the cheapest encoding for one channel's samples: constant for silence or DC,
+// otherwise the fixed predictor order (0-4) with the smallest residuals, Rice coded
+func (w *flacEncoder) subframe(samples []int32) *frame.Subframe {
+	subframe := &frame.Subframe{Samples: samples, NSamples: len(samples)}
+
+	constant := true
+	for _, sample := range samples[1:] {
+		if sample != samples[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		subframe.Pred = frame.PredConstant
+		return subframe
+	}
+
+	bestOrder, bestCost := 0, uint64(math.MaxUint64)
+	for order := 0; order <= 4 && order < len(samples); order++ {
+		var cost uint64
+		for _, residual := range w.fixedResiduals(samples, order) {
+			cost += uint64(math.Abs(float64(residual)))
+		}
+		if cost < bestCost {
+			bestOrder, bestCost = order, cost
+		}
+	}
+
+	subframe.Pred = frame.PredFixed
+	subframe.Order = bestOrder
+	subframe.ResidualCodingMethod = frame.ResidualCodingMethodRice2
+	subframe.RiceSubframe = &frame.RiceSubframe{
+		Partitions: []frame.RicePartition{{Param: riceParameter(w.fixedResiduals(samples, bestOrder))}},
+	}
+	return subframe
+}
+
+// fixedResiduals returns the prediction errors of a fixed FLAC predictor, after its warm-up samples
+func (w *flacEncoder) fixedResiduals(samples []int32, order int) []int32 {
+	residuals := w.residuals[:0]
+	for i := order; i < len(samples); i++ {
+		x := int64(samples[i])
+		var prediction int64
+		switch order {
+		case 1:
+			prediction = int64(samples[i-1])
+		case 2:
+			prediction = 2*int64(samples[i-1]) - int64(samples[i-2])
+		case 3:
+			prediction = 3*int64(samples[i-1]) - 3*int64(samples[i-2]) + int64(samples[i-3])
+		case 4:
+			prediction = 4*int64(samples[i-1]) - 6*int64(samples[i-2]) + 4*int64(samples[i-3]) - int64(samples[i-4])
+		}
+		residuals = append(residuals, int32(x-prediction))
+	}
+	return residuals
+}
+
+// riceParameter returns the Rice parameter that codes the residuals in the fewest bits
+func riceParameter(residuals []int32) uint {
+	best, bestBits := uint(0), uint64(math.MaxUint64)
+	for k := uint(0); k <= flacMaxRiceParam; k++ {
+		bits := uint64(len(residuals)) * uint64(k+1)
+		for _, residual := range residuals {
+			folded := uint32(residual<<1) ^ uint32(residual>>31)
+			bits += uint64(folded >> k)
+		}
+		if bits < bestBits {
+			best, bestBits = k, bits
+		}
+	}
+	return best
+}
+
+// close encodes the final partial block and finishes the stream info block
+func (w *flacEncoder) close() error {
+	err := w.writeFrame()
+	if closeErr := w.encoder.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to finish FLAC file: %w", err)
+	}
+	return nil
+}
@@ -4,14 +4,16 @@ go 1.22
 
 toolchain go1.24.0
 
-require github.com/GeoffreyPlitt/debuggo v0.1.0
+require (
+	github.com/GeoffreyPlitt/debuggo v0.1.0
+	github.com/go-audio/wav v1.1.0
+	github.com/mewkiz/flac v1.0.12
+	github.com/xthexder/go-jack v0.0.0-20220805234212-bc8604043aba
+)
 
 require (
 	github.com/go-audio/audio v1.0.0 // indirect
 	github.com/go-audio/riff v1.0.0 // indirect
-	github.com/go-audio/wav v1.1.0 // indirect
 	github.com/icza/bitio v1.1.0 // indirect
-	github.com/mewkiz/flac v1.0.12 // indirect
 	github.com/mewkiz/pkg v0.0.0-20230226050401-4010bf0fec14 // indirect
-	github.com/xthexder/go-jack v0.0.0-20220805234212-bc8604043aba // indirect
 )
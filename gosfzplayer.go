@@ -2,52 +2,65 @@ package gosfzplayer
 
 import (
 	"fmt"
+	"math"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/GeoffreyPlitt/debuggo"
 )
 
 var debug = debuggo.Debug("sfzplayer:gosfzplayer")
 
+// defaultTempo is the tempo assumed until one is set
+const defaultTempo = 120.0
+
 // SfzPlayer represents an SFZ sampler that can parse SFZ files and play samples
 type SfzPlayer struct {
-	sfzData     *SfzData
-	sampleCache *SampleCache
-	sfzDir      string      // Directory containing the SFZ file for relative sample paths
-	jackClient  *JackClient // Internal JACK client (nil if JACK not available)
-	reverb      *Freeverb   // Master reverb processor
-	reverbSend  float64     // Global reverb send level (0.0 to 1.0)
+	instrument  atomic.Pointer[instrument]     // Loaded SFZ file, replaced by Reload and LoadInstrument
+	reloadMu    sync.Mutex                     // Serializes loading
+	presets     atomic.Pointer[[]loadedPreset] // Instruments selected by program change
+	jackClient  *JackClient                    // Internal JACK client (nil if JACK not available)
+	reverb      *Freeverb                      // Master reverb processor
+	master      *Master                        // Master bus gain, limiter and meters
+	reverbSend  atomic.Uint64                  // Global reverb send level (0.0 to 1.0, float64 bits)
+	rngState    atomic.Uint64                  // Random state for lorand/hirand selection
+	midiChannel atomic.Int32                   // MIDI channel filter (1-16, 0 for all channels)
+	polyphony   atomic.Int32                   // Voices sounding at once
+	stealPolicy atomic.Int32                   // StealPolicy used when a polyphony limit is reached
+	tempo       atomic.Uint64                  // Tempo in BPM (float64 bits) for lobpm/hibpm
+	sampleRate  atomic.Uint32                  // Sample rate the instruments' effects are created for
+
+	// Engines playing the player's instruments, which prepare effect processors for each one loaded
+	enginesMu sync.Mutex
+	engines   []*engine
+
+	// Keyswitches
+	activeKeyswitch atomic.Int32 // Last keyswitch played, -1 if none
 }
 
 // NewSfzPlayer creates a new SFZ player from an SFZ file
 func NewSfzPlayer(sfzPath string, jackClientName string) (*SfzPlayer, error) {
 	debug("Creating new SFZ player for file: %s", sfzPath)
 
-	// Parse the SFZ file
-	sfzData, err := ParseSfzFile(sfzPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create SFZ player: %w", err)
-	}
-
-	debug("Successfully parsed SFZ file with %d regions", len(sfzData.Regions))
-
-	// Get the directory of the SFZ file for relative sample paths
-	sfzDir := filepath.Dir(sfzPath)
-
 	player := &SfzPlayer{
-		sfzData:     sfzData,
-		sampleCache: NewSampleCache(),
-		sfzDir:      sfzDir,
-		reverb:      NewFreeverb(44100), // Initialize with default sample rate
-		reverbSend:  0.0,                // Start with no reverb
+		reverb: NewFreeverb(44100), // Initialize with default sample rate
+		master: NewMaster(),
 	}
+	player.sampleRate.Store(44100)
 
-	// Load all samples referenced in the SFZ file
-	err = player.loadAllSamples()
+	inst, err := player.loadInstrument(sfzPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load samples: %w", err)
+		return nil, fmt.Errorf("failed to create SFZ player: %w", err)
 	}
 
+	player.instrument.Store(inst)
+	player.rngState.Store(uint64(time.Now().UnixNano()))
+	player.polyphony.Store(DefaultPolyphony)
+	player.tempo.Store(math.Float64bits(defaultTempo))
+	player.activeKeyswitch.Store(int32(inst.keyswitches.defaultKeyswitch))
+
 	// Load reverb settings from SFZ file
 	player.loadReverbSettings()
 
@@ -74,31 +87,10 @@ func NewSfzPlayer(sfzPath string, jackClientName string) (*SfzPlayer, error) {
 	return player, nil
 }
 
-// loadAllSamples loads all sample files referenced in the SFZ regions
-func (p *SfzPlayer) loadAllSamples() error {
-	debug("Loading all samples referenced in SFZ file")
-
-	for i, region := range p.sfzData.Regions {
-		samplePath := region.GetStringOpcode("sample")
-		if samplePath == "" {
-			debug("Warning: Region %d has no sample opcode", i)
-			continue
-		}
-
-		debug("Loading sample for region %d: %s", i, samplePath)
-		_, err := p.sampleCache.LoadSampleRelative(p.sfzDir, samplePath)
-		if err != nil {
-			return fmt.Errorf("failed to load sample '%s' for region %d: %w", samplePath, i, err)
-		}
-	}
-
-	debug("Successfully loaded %d unique samples", p.sampleCache.Size())
-	return nil
-}
-
 // GetSample returns the loaded sample for a given file path
 func (p *SfzPlayer) GetSample(samplePath string) (*Sample, error) {
-	sample, exists := p.sampleCache.GetSample(filepath.Join(p.sfzDir, samplePath))
+	inst := p.current()
+	sample, exists := inst.sampleCache.GetSample(filepath.Join(inst.dir, samplePath))
 	if !exists {
 		return nil, fmt.Errorf("sample not found: %s", samplePath)
 	}
@@ -107,7 +99,7 @@ func (p *SfzPlayer) GetSample(samplePath string) (*Sample, error) {
 
 // GetSfzData returns the parsed SFZ data
 func (p *SfzPlayer) GetSfzData() *SfzData {
-	return p.sfzData
+	return p.current().sfzData
 }
 
 // StopAndClose stops and closes the internal JACK client if it's running
@@ -132,6 +124,72 @@ func (p *SfzPlayer) StopAndClose() error {
 	return nil
 }
 
+// SendMidi sends a MIDI channel message to the internal JACK client from any goroutine.
+// It is applied at the start of the next JACK period without blocking audio processing.
+func (p *SfzPlayer) SendMidi(data []byte) error {
+	if p.jackClient == nil {
+		return fmt.Errorf("no JACK client running")
+	}
+	return p.jackClient.SendMidi(data)
+}
+
+// SetRandomSeed reseeds the random source used for lorand/hirand region selection
+func (p *SfzPlayer) SetRandomSeed(seed int64) {
+	p.rngState.Store(uint64(seed))
+	debug("Random seed set to %d", seed)
+}
+
+// SetMidiChannel restricts the player to one MIDI channel (1-16), or 0 to respond to all channels
+func (p *SfzPlayer) SetMidiChannel(channel int) error {
+	if channel < 0 || channel > 16 {
+		return fmt.Errorf("invalid MIDI channel %d (must be 1-16, or 0 for all channels)", channel)
+	}
+	p.midiChannel.Store(int32(channel))
+	debug("MIDI channel filter set to %d", channel)
+	return nil
+}
+
+// GetMidiChannel returns the MIDI channel filter (1-16, 0 for all channels)
+func (p *SfzPlayer) GetMidiChannel() int {
+	return int(p.midiChannel.Load())
+}
+
+// acceptsChannel checks a MIDI channel (0-15) against the player's channel filter
+func (p *SfzPlayer) acceptsChannel(channel uint8) bool {
+	filter := p.midiChannel.Load()
+	return filter == 0 || int32(channel)+1 == filter
+}
+
+// SetTempo sets the tempo in BPM used by lobpm/hibpm region conditions
+func (p *SfzPlayer) SetTempo(bpm float64) {
+	if bpm <= 0 {
+		bpm = defaultTempo
+	}
+	p.tempo.Store(math.Float64bits(bpm))
+	debug("Tempo set to %.2f BPM", bpm)
+}
+
+// GetTempo returns the tempo in BPM
+func (p *SfzPlayer) GetTempo() float64 {
+	return math.Float64frombits(p.tempo.Load())
+}
+
+// random returns the next random value in [0.0, 1.0). It is wait-free (SplitMix64) so the audio
+// thread can call it while other goroutines reseed.
+func (p *SfzPlayer) random() float64 {
+	z := p.rngState.Add(0x9E3779B97F4A7C15)
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	z ^= z >> 31
+	return float64(z>>11) / (1 << 53)
+}
+
+// Master returns the player's master bus stage, for setting its gain and limiter and reading its
+// meters
+func (p *SfzPlayer) Master() *Master {
+	return p.master
+}
+
 // Reverb Control Methods
 
 // SetReverbSend sets the global reverb send level (0.0 to 1.0)
@@ -142,13 +200,13 @@ func (p *SfzPlayer) SetReverbSend(send float64) {
 	if send > 1.0 {
 		send = 1.0
 	}
-	p.reverbSend = send
+	p.reverbSend.Store(math.Float64bits(send))
 	debug("Reverb send set to %.2f", send)
 }
 
 // GetReverbSend returns the current reverb send level
 func (p *SfzPlayer) GetReverbSend() float64 {
-	return p.reverbSend
+	return math.Float64frombits(p.reverbSend.Load())
 }
 
 // SetReverbRoomSize sets the reverb room size (0.0 to 1.0)
@@ -206,62 +264,128 @@ func (p *SfzPlayer) GetReverbWidth() float64 {
 	return p.reverb.GetWidth()
 }
 
-// loadReverbSettings reads reverb opcodes from the SFZ file and applies them
+// SetReverbInput sets the gain of the signal entering the reverb (0.0 to 1.0)
+func (p *SfzPlayer) SetReverbInput(input float64) {
+	p.reverb.SetInput(input)
+	debug("Reverb input set to %.2f", p.reverb.GetInput())
+}
+
+// GetReverbInput returns the reverb's input gain
+func (p *SfzPlayer) GetReverbInput() float64 {
+	return p.reverb.GetInput()
+}
+
+// SetReverbPreDelay sets the delay before the reverb's input enters the room, in seconds (0 to 0.5)
+func (p *SfzPlayer) SetReverbPreDelay(seconds float64) {
+	p.reverb.SetPreDelay(seconds)
+	debug("Reverb pre-delay set to %.3fs", p.reverb.GetPreDelay())
+}
+
+// GetReverbPreDelay returns the reverb pre-delay in seconds
+func (p *SfzPlayer) GetReverbPreDelay() float64 {
+	return p.reverb.GetPreDelay()
+}
+
+// SetReverbLowCut sets the cutoff of the high-pass on the reverb's input in Hz (0 for none)
+func (p *SfzPlayer) SetReverbLowCut(hz float64) {
+	p.reverb.SetLowCut(hz)
+	debug("Reverb low cut set to %.0f Hz", p.reverb.GetLowCut())
+}
+
+// GetReverbLowCut returns the reverb's input high-pass cutoff in Hz
+func (p *SfzPlayer) GetReverbLowCut() float64 {
+	return p.reverb.GetLowCut()
+}
+
+// SetReverbHighCut sets the cutoff of the low-pass on the reverb's input in Hz (0 for none)
+func (p *SfzPlayer) SetReverbHighCut(hz float64) {
+	p.reverb.SetHighCut(hz)
+	debug("Reverb high cut set to %.0f Hz", p.reverb.GetHighCut())
+}
+
+// GetReverbHighCut returns the reverb's input low-pass cutoff in Hz
+func (p *SfzPlayer) GetReverbHighCut() float64 {
+	return p.reverb.GetHighCut()
+}
+
+// loadReverbSettings applies the reverb parameters set in the <global> section, in <effect> sections
+// without a type and in the <effect> section of type fverb that uses the player's reverb. Send levels
+// are per region (effect1, reverb_send) and resolved at load time.
 func (p *SfzPlayer) loadReverbSettings() {
-	// Check global section first
-	if p.sfzData.Global != nil {
-		p.applyReverbOpcodes(p.sfzData.Global)
-	}
+	sfzData := p.current().sfzData
 
-	// Apply reverb settings from groups and regions as defaults
-	// Note: In a full implementation, reverb would typically be per-voice
-	// but for simplicity, we're using global reverb here
-	for _, group := range p.sfzData.Groups {
-		p.applyReverbOpcodes(group)
-		break // Use first group's settings as global default
+	if sfzData.Global != nil {
+		configureReverb(p.reverb, sfzData.Global)
+	}
+	for _, effect := range sfzData.Effects {
+		if effectType(effect) == "" {
+			configureReverb(p.reverb, effect)
+		}
+	}
+	if section := reverbSection(sfzData.Effects); section != nil {
+		configureReverb(p.reverb, section)
 	}
 
 	debug("Reverb settings loaded from SFZ file")
 }
 
-// applyReverbOpcodes applies reverb opcodes from an SFZ section
-func (p *SfzPlayer) applyReverbOpcodes(section *SfzSection) {
-	// Standard SFZ reverb opcodes
-	if reverbSend := section.GetStringOpcode("reverb_send"); reverbSend != "" {
-		if value := section.GetFloatOpcode("reverb_send", -1); value >= 0 {
-			// Convert from dB to linear if needed, or use as percentage
-			p.SetReverbSend(value / 100.0) // Assuming percentage
-		}
-	}
+// Range of the reverb input low-pass that reverb_tone sweeps, in Hz
+const (
+	reverbToneMinCut = 200.0
+	reverbToneMaxCut = 20000.0
+)
 
-	// Custom reverb opcodes (non-standard but useful)
-	if roomSize := section.GetStringOpcode("reverb_room_size"); roomSize != "" {
-		if value := section.GetFloatOpcode("reverb_room_size", -1); value >= 0 {
-			p.SetReverbRoomSize(value / 100.0)
-		}
+// configureReverb applies the reverb opcodes (0-100, reverb_predelay in ms and the cuts in Hz) of
+// an SFZ section to a reverb. The standard SFZ fverb names (reverb_size, reverb_damp,
+// reverb_input, reverb_tone) take precedence over the older reverb_room_size, reverb_damping and
+// reverb_highcut.
+func configureReverb(reverb *Freeverb, section *SfzSection) {
+	if value := reverbOpcode(section, "reverb_size", "reverb_room_size"); value >= 0 {
+		reverb.SetRoomSize(value / 100.0)
 	}
-
-	if damping := section.GetStringOpcode("reverb_damping"); damping != "" {
-		if value := section.GetFloatOpcode("reverb_damping", -1); value >= 0 {
-			p.SetReverbDamping(value / 100.0)
-		}
+	if value := reverbOpcode(section, "reverb_damp", "reverb_damping"); value >= 0 {
+		reverb.SetDamping(value / 100.0)
 	}
-
-	if wet := section.GetStringOpcode("reverb_wet"); wet != "" {
-		if value := section.GetFloatOpcode("reverb_wet", -1); value >= 0 {
-			p.SetReverbWet(value / 100.0)
-		}
+	if value := section.GetFloatOpcode("reverb_input", -1); value >= 0 {
+		reverb.SetInput(value / 100.0)
 	}
+	if value := section.GetFloatOpcode("reverb_wet", -1); value >= 0 {
+		reverb.SetWet(value / 100.0)
+	}
+	if value := section.GetFloatOpcode("reverb_dry", -1); value >= 0 {
+		reverb.SetDry(value / 100.0)
+	}
+	if value := section.GetFloatOpcode("reverb_width", -1); value >= 0 {
+		reverb.SetWidth(value / 100.0)
+	}
+	if value := section.GetFloatOpcode("reverb_predelay", -1); value >= 0 {
+		reverb.SetPreDelay(value / 1000.0)
+	}
+	if value := section.GetFloatOpcode("reverb_lowcut", -1); value >= 0 {
+		reverb.SetLowCut(value)
+	}
+	if value := section.GetFloatOpcode("reverb_tone", -1); value >= 0 {
+		reverb.SetHighCut(reverbToneCutoff(value))
+	} else if value := section.GetFloatOpcode("reverb_highcut", -1); value >= 0 {
+		reverb.SetHighCut(value)
+	}
+}
 
-	if dry := section.GetStringOpcode("reverb_dry"); dry != "" {
-		if value := section.GetFloatOpcode("reverb_dry", -1); value >= 0 {
-			p.SetReverbDry(value / 100.0)
+// reverbOpcode returns the value of the first of an opcode's names set in a section, or -1
+func reverbOpcode(section *SfzSection, names ...string) float64 {
+	for _, name := range names {
+		if value := section.GetFloatOpcode(name, -1); value >= 0 {
+			return value
 		}
 	}
+	return -1
+}
 
-	if width := section.GetStringOpcode("reverb_width"); width != "" {
-		if value := section.GetFloatOpcode("reverb_width", -1); value >= 0 {
-			p.SetReverbWidth(value / 100.0)
-		}
+// reverbToneCutoff maps reverb_tone (0-100) to the reverb's input low-pass cutoff in Hz, swept
+// exponentially from reverbToneMinCut to reverbToneMaxCut; 100 turns the filter off
+func reverbToneCutoff(tone float64) float64 {
+	if tone >= 100 {
+		return 0
 	}
+	return reverbToneMinCut * math.Pow(reverbToneMaxCut/reverbToneMinCut, tone/100.0)
 }
@@ -170,7 +170,7 @@ func TestComplexPitchCalculation(t *testing.T) {
 
 	// Create mock client to test pitch calculation
 	player := &SfzPlayer{}
-	mockClient := &MockJackClient{player: player}
+	mockClient := createTestMockClient(player, 44100, 512)
 
 	// Test MIDI note 72 (C5) with pitch_keycenter=60 (C4)
 	// Expected calculation:
@@ -181,7 +181,7 @@ func TestComplexPitchCalculation(t *testing.T) {
 	// - Total: 12 + 12 + 0.2 - 0.1 = 24.1 semitones
 	// - Ratio: 2^(24.1/12) ≈ 4.014 (about 4x = 2 octaves)
 
-	ratio := mockClient.calculatePitchRatio(region, 72)
+	ratio := mockClient.calculatePitchRatio(compileRegion(region, nil), 72)
 	expectedRatio := 4.014 // Approximately 2^(24.1/12)
 
 	if ratio < expectedRatio-0.1 || ratio > expectedRatio+0.1 {
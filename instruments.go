@@ -0,0 +1,195 @@
+package gosfzplayer
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Preset is an SFZ file selected by MIDI bank select (CC0/CC32) and program change
+type Preset struct {
+	Name    string // Display name, defaults to the SFZ file name without its extension
+	Path    string // SFZ file
+	Bank    int    // Bank number, CC0 * 128 + CC32 (0-16383)
+	Program int    // Program number (0-127)
+}
+
+// loadedPreset is a preset with its instrument loaded ahead of time, so a program change only
+// swaps a pointer on the audio thread
+type loadedPreset struct {
+	Preset
+	inst *instrument
+}
+
+// InstrumentPath returns the SFZ file the player is playing
+func (p *SfzPlayer) InstrumentPath() string {
+	return p.current().path
+}
+
+// LoadInstrument replaces the player's instrument with another SFZ file. The file and its samples
+// are loaded on the calling goroutine while audio keeps playing, then swapped in between audio
+// blocks; notes already sounding finish on the previous instrument. Samples shared with the current
+// instrument or a preset aren't read again. On error the current instrument stays loaded.
+// LoadInstrument blocks until the samples are read; callers that must stay responsive, such as a
+// UI or MIDI handler, should use LoadInstrumentAsync.
+func (p *SfzPlayer) LoadInstrument(sfzPath string) error {
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+
+	debug("Loading instrument %s", sfzPath)
+	inst, err := p.loadInstrument(sfzPath)
+	if err != nil {
+		return fmt.Errorf("failed to load instrument: %w", err)
+	}
+
+	p.prepareEngines(inst)
+	p.instrument.Store(inst)
+	p.activeKeyswitch.Store(int32(inst.keyswitches.defaultKeyswitch))
+	p.loadReverbSettings()
+
+	debug("Loaded instrument %s: %d regions", sfzPath, len(inst.regions))
+	return nil
+}
+
+// LoadInstrumentAsync runs LoadInstrument on a goroutine of its own and returns a channel that
+// receives its result, nil once the new instrument is playing
+func (p *SfzPlayer) LoadInstrumentAsync(sfzPath string) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- p.LoadInstrument(sfzPath)
+	}()
+	return done
+}
+
+// SetPresets loads a list of SFZ files for program changes to choose from. Every preset is loaded
+// up front, so switching is instant and safe on the audio thread; notes already sounding finish on
+// the previous instrument. The player's reverb settings are kept across program changes. An empty
+// list removes the presets. On error the previous presets are kept.
+func (p *SfzPlayer) SetPresets(presets []Preset) error {
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+
+	seen := make(map[int]string)
+	for _, preset := range presets {
+		if preset.Bank < 0 || preset.Bank > 16383 {
+			return fmt.Errorf("preset %q: invalid bank %d (must be 0-16383)", preset.Path, preset.Bank)
+		}
+		if preset.Program < 0 || preset.Program > 127 {
+			return fmt.Errorf("preset %q: invalid program %d (must be 0-127)", preset.Path, preset.Program)
+		}
+		key := preset.Bank<<7 | preset.Program
+		if other, exists := seen[key]; exists {
+			return fmt.Errorf("presets %q and %q both use bank %d program %d", other, preset.Path, preset.Bank, preset.Program)
+		}
+		seen[key] = preset.Path
+	}
+
+	table := make([]loadedPreset, 0, len(presets))
+	for _, preset := range presets {
+		if preset.Name == "" {
+			preset.Name = strings.TrimSuffix(filepath.Base(preset.Path), filepath.Ext(preset.Path))
+		}
+
+		// Presets of the current file or of the same file share one instrument
+		var inst *instrument
+		if current := p.current(); current.path == preset.Path {
+			inst = current
+		}
+		for _, loaded := range table {
+			if loaded.Path == preset.Path {
+				inst = loaded.inst
+				break
+			}
+		}
+		if inst == nil {
+			var loaded []*instrument
+			for _, other := range table {
+				loaded = append(loaded, other.inst)
+			}
+			var err error
+			if inst, err = p.loadInstrument(preset.Path, loaded...); err != nil {
+				return fmt.Errorf("failed to load preset %q: %w", preset.Name, err)
+			}
+		}
+		table = append(table, loadedPreset{Preset: preset, inst: inst})
+	}
+
+	instruments := make([]*instrument, len(table))
+	for i, loaded := range table {
+		instruments[i] = loaded.inst
+	}
+	p.prepareEngines(instruments...)
+
+	p.presets.Store(&table)
+	debug("Loaded %d presets", len(table))
+	return nil
+}
+
+// Presets returns the presets program changes choose from
+func (p *SfzPlayer) Presets() []Preset {
+	table := p.presetTable()
+	presets := make([]Preset, len(table))
+	for i, loaded := range table {
+		presets[i] = loaded.Preset
+	}
+	return presets
+}
+
+// CurrentPreset returns the preset the player is playing, if its instrument came from one
+func (p *SfzPlayer) CurrentPreset() (Preset, bool) {
+	inst := p.current()
+	for _, loaded := range p.presetTable() {
+		if loaded.inst == inst {
+			return loaded.Preset, true
+		}
+	}
+	return Preset{}, false
+}
+
+// presetTable returns the loaded presets
+func (p *SfzPlayer) presetTable() []loadedPreset {
+	if table := p.presets.Load(); table != nil {
+		return *table
+	}
+	return nil
+}
+
+// loadedInstruments returns the current instrument and the presets' instruments, whose samples a
+// new instrument can share
+func (p *SfzPlayer) loadedInstruments() []*instrument {
+	loaded := []*instrument{p.current()}
+	for _, preset := range p.presetTable() {
+		loaded = append(loaded, preset.inst)
+	}
+	return loaded
+}
+
+// replacePresetInstrument points the presets using an instrument at its reloaded version
+func (p *SfzPlayer) replacePresetInstrument(previous, inst *instrument) {
+	table := p.presetTable()
+	replaced := make([]loadedPreset, len(table))
+	copy(replaced, table)
+	for i := range replaced {
+		if replaced[i].inst == previous {
+			replaced[i].inst = inst
+		}
+	}
+	p.presets.Store(&replaced)
+}
+
+// selectPreset switches to the preset for a bank and program, if there is one. It runs on the
+// audio thread and only swaps pointers.
+func (e *engine) selectPreset(channel uint8, bank uint16, program uint8) {
+	for _, loaded := range e.player.presetTable() {
+		if loaded.Bank != int(bank) || loaded.Program != int(program) {
+			continue
+		}
+		if loaded.inst != e.inst {
+			e.player.instrument.Store(loaded.inst)
+			e.player.activeKeyswitch.Store(int32(loaded.inst.keyswitches.defaultKeyswitch))
+			e.useInstrument(loaded.inst)
+			e.lastKeyswitch = loaded.inst.keyswitches.defaultKeyswitch
+		}
+		return
+	}
+}
@@ -0,0 +1,189 @@
+package gosfzplayer
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadInstrument(t *testing.T) {
+	dir, pianoPath := createReloadDir(t, "<region>\nsample=sample1.wav key=60 loop_mode=loop_continuous\n")
+	organPath := filepath.Join(dir, "organ.sfz")
+	writeReloadFile(t, organPath, "<region>\nsample=sample1.wav key=62\n<region>\nsample=sample2.wav key=64\n")
+
+	player, err := NewSfzPlayer(pianoPath, "")
+	if err != nil {
+		t.Fatalf("Failed to create player: %v", err)
+	}
+	sample1, _ := player.GetSample("sample1.wav")
+	e := newEngine(player, 44100)
+	e.noteOn(60, 100)
+	renderTestFrames(e, 512)
+
+	if err := player.LoadInstrument(organPath); err != nil {
+		t.Fatalf("LoadInstrument failed: %v", err)
+	}
+	if player.InstrumentPath() != organPath {
+		t.Errorf("Expected the player to play %s, got %s", organPath, player.InstrumentPath())
+	}
+	if shared, _ := player.GetSample("sample1.wav"); shared != sample1 {
+		t.Error("Expected the sample both instruments use to be shared")
+	}
+
+	// The note already sounding finishes; new notes play the new instrument
+	renderTestFrames(e, 512)
+	if len(e.activeVoices) != 1 {
+		t.Fatalf("Expected the sounding voice to survive the switch, got %d voices", len(e.activeVoices))
+	}
+	e.noteOn(60, 100)
+	e.noteOn(64, 100)
+	if len(e.activeVoices) != 2 || e.activeVoices[1].region.Sample != "sample2.wav" {
+		t.Errorf("Expected only key 64 to start a voice on the new instrument, got %d voices", len(e.activeVoices))
+	}
+
+	if err := player.LoadInstrument(filepath.Join(dir, "missing.sfz")); err == nil {
+		t.Error("Expected an error loading a missing file")
+	}
+	if player.InstrumentPath() != organPath {
+		t.Error("Expected the current instrument to stay loaded after a failed load")
+	}
+}
+
+func TestLoadInstrumentAsync(t *testing.T) {
+	dir, pianoPath := createReloadDir(t, "<region>\nsample=sample1.wav key=60\n")
+	organPath := filepath.Join(dir, "organ.sfz")
+	writeReloadFile(t, organPath, "<region>\nsample=sample2.wav key=64\n")
+
+	player, err := NewSfzPlayer(pianoPath, "")
+	if err != nil {
+		t.Fatalf("Failed to create player: %v", err)
+	}
+	e := newEngine(player, 44100)
+
+	// The engine keeps rendering while the instrument loads
+	done := player.LoadInstrumentAsync(organPath)
+	for loading := true; loading; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("LoadInstrumentAsync failed: %v", err)
+			}
+			loading = false
+		default:
+			renderTestFrames(e, 64)
+		}
+	}
+	if player.InstrumentPath() != organPath {
+		t.Errorf("Expected the player to play %s, got %s", organPath, player.InstrumentPath())
+	}
+
+	if err := <-player.LoadInstrumentAsync(filepath.Join(dir, "missing.sfz")); err == nil {
+		t.Error("Expected an error loading a missing file")
+	}
+	if player.InstrumentPath() != organPath {
+		t.Error("Expected the current instrument to stay loaded after a failed load")
+	}
+}
+
+func TestPresetProgramChange(t *testing.T) {
+	dir, pianoPath := createReloadDir(t, "<region>\nsample=sample1.wav key=60\n")
+	organPath := filepath.Join(dir, "organ.sfz")
+	writeReloadFile(t, organPath, "<region>\nsample=sample2.wav key=60\n")
+
+	player, err := NewSfzPlayer(pianoPath, "")
+	if err != nil {
+		t.Fatalf("Failed to create player: %v", err)
+	}
+	e := newEngine(player, 44100)
+
+	presets := []Preset{
+		{Path: pianoPath, Program: 0},
+		{Name: "Church Organ", Path: organPath, Bank: 1, Program: 19},
+	}
+	if err := player.SetPresets(presets); err != nil {
+		t.Fatalf("SetPresets failed: %v", err)
+	}
+	if got := player.Presets(); len(got) != 2 || got[0].Name != "instrument" || got[1].Name != "Church Organ" {
+		t.Fatalf("Unexpected presets: %+v", got)
+	}
+
+	// Bank select (CC32 = 1) then program change 19
+	e.processMidiMessage([]byte{0xB0, 0, 0})
+	e.processMidiMessage([]byte{0xB0, 32, 1})
+	e.processMidiMessage([]byte{0xC0, 19})
+	if player.InstrumentPath() != organPath {
+		t.Fatalf("Expected bank 1 program 19 to select the organ, got %s", player.InstrumentPath())
+	}
+	e.noteOn(60, 100)
+	if len(e.activeVoices) != 1 || e.activeVoices[0].region.Sample != "sample2.wav" {
+		t.Fatalf("Expected the next note to play the organ")
+	}
+	if preset, ok := player.CurrentPreset(); !ok || preset.Name != "Church Organ" {
+		t.Errorf("Expected the current preset to be the organ, got %+v", preset)
+	}
+
+	// Programs without a preset leave the instrument alone
+	e.processMidiMessage([]byte{0xC0, 20})
+	if player.InstrumentPath() != organPath {
+		t.Errorf("Expected an unmapped program to be ignored")
+	}
+
+	// Reloading updates the preset, so switching back to it plays the edited file
+	writeReloadFile(t, organPath, "<region>\nsample=sample2.wav key=60\n<region>\nsample=sample2.wav key=61\n")
+	if err := player.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	e.processMidiMessage([]byte{0xB0, 32, 0})
+	e.processMidiMessage([]byte{0xC0, 0})
+	e.processMidiMessage([]byte{0xB0, 32, 1})
+	e.processMidiMessage([]byte{0xC0, 19})
+	if got := len(player.Regions()); got != 2 {
+		t.Errorf("Expected the reloaded organ with 2 regions, got %d", got)
+	}
+}
+
+func TestSetPresetsValidation(t *testing.T) {
+	_, sfzPath := createReloadDir(t, "<region>\nsample=sample1.wav key=60\n")
+	player, err := NewSfzPlayer(sfzPath, "")
+	if err != nil {
+		t.Fatalf("Failed to create player: %v", err)
+	}
+	if err := player.SetPresets([]Preset{{Path: sfzPath}}); err != nil {
+		t.Fatalf("SetPresets failed: %v", err)
+	}
+
+	invalid := [][]Preset{
+		{{Path: sfzPath, Program: 128}},
+		{{Path: sfzPath, Bank: 16384}},
+		{{Path: sfzPath, Program: 1}, {Path: sfzPath, Program: 1}},
+		{{Path: filepath.Join(filepath.Dir(sfzPath), "missing.sfz")}},
+	}
+	for _, presets := range invalid {
+		if err := player.SetPresets(presets); err == nil {
+			t.Errorf("Expected an error for presets %+v", presets)
+		}
+	}
+	if got := player.Presets(); len(got) != 1 {
+		t.Errorf("Expected the previous presets to be kept, got %+v", got)
+	}
+}
+
+func TestRackProgramChangeFallsBackToPresets(t *testing.T) {
+	dir, pianoPath := createReloadDir(t, "<region>\nsample=sample1.wav key=60\n")
+	organPath := filepath.Join(dir, "organ.sfz")
+	writeReloadFile(t, organPath, "<region>\nsample=sample2.wav key=60\n")
+
+	player, err := NewSfzPlayer(pianoPath, "")
+	if err != nil {
+		t.Fatalf("Failed to create player: %v", err)
+	}
+	if err := player.SetPresets([]Preset{{Path: organPath, Program: 4}}); err != nil {
+		t.Fatalf("SetPresets failed: %v", err)
+	}
+
+	rack := NewMultitimbral(44100)
+	rack.SetPart(1, player)
+	rack.ProcessMidi([]byte{0xC0, 4})
+	if player.InstrumentPath() != organPath {
+		t.Errorf("Expected a program without a rack instrument to select the part's preset")
+	}
+}
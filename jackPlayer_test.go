@@ -45,11 +45,11 @@ func TestRegionMatching(t *testing.T) {
 
 	// Create a mock JACK client for testing
 	jc := &JackClient{
-		player: player,
+		engine: newEngine(player, 44100),
 	}
 
 	// Test region matching
-	regions := player.sfzData.Regions
+	regions := player.current().regions
 	if len(regions) == 0 {
 		t.Fatal("No regions found in test SFZ file")
 	}
@@ -58,17 +58,17 @@ func TestRegionMatching(t *testing.T) {
 	region := regions[0]
 
 	// Should match C3 (MIDI 48) with velocity 50
-	if !jc.regionMatches(region, 48, 50) {
+	if !jc.regionMatches(region, 0, 48, 50) {
 		t.Error("Expected region to match C3 with velocity 50")
 	}
 
 	// Should not match C3 with velocity 100 (too high)
-	if jc.regionMatches(region, 48, 100) {
+	if jc.regionMatches(region, 0, 48, 100) {
 		t.Error("Expected region to NOT match C3 with velocity 100")
 	}
 
 	// Should not match C5 (MIDI 72) - outside key range
-	if jc.regionMatches(region, 72, 50) {
+	if jc.regionMatches(region, 0, 72, 50) {
 		t.Error("Expected region to NOT match C5")
 	}
 }
@@ -82,10 +82,10 @@ func TestVolumeCalculation(t *testing.T) {
 
 	// Create a mock JACK client for testing
 	jc := &JackClient{
-		player: player,
+		engine: newEngine(player, 44100),
 	}
 
-	regions := player.sfzData.Regions
+	regions := player.current().regions
 	if len(regions) == 0 {
 		t.Fatal("No regions found in test SFZ file")
 	}
@@ -116,10 +116,10 @@ func TestPanCalculation(t *testing.T) {
 
 	// Create a mock JACK client for testing
 	jc := &JackClient{
-		player: player,
+		engine: newEngine(player, 44100),
 	}
 
-	regions := player.sfzData.Regions
+	regions := player.current().regions
 	if len(regions) >= 2 {
 		// Test pan calculation on region with pan setting
 		region := regions[1] // This should have pan=-50
@@ -13,6 +13,11 @@ func NewJackClient(player *SfzPlayer, clientName string) (*JackClient, error) {
 	return nil, fmt.Errorf("JACK support not enabled - rebuild with '-tags jack' and ensure JACK development headers are installed")
 }
 
+// NewMultitimbralJackClient creates a stub JACK client that returns an error
+func NewMultitimbralJackClient(rack *Multitimbral, clientName string) (*JackClient, error) {
+	return nil, fmt.Errorf("JACK support not enabled - rebuild with '-tags jack' and ensure JACK development headers are installed")
+}
+
 // Start returns an error for stub client
 func (jc *JackClient) Start() error {
 	return fmt.Errorf("JACK support not enabled")
@@ -27,3 +32,13 @@ func (jc *JackClient) Stop() error {
 func (jc *JackClient) Close() error {
 	return fmt.Errorf("JACK support not enabled")
 }
+
+// SendMidi returns an error for stub client
+func (jc *JackClient) SendMidi(data []byte) error {
+	return fmt.Errorf("JACK support not enabled")
+}
+
+// LoadInstrument returns an error for stub client
+func (jc *JackClient) LoadInstrument(sfzPath string) error {
+	return fmt.Errorf("JACK support not enabled")
+}
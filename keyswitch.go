@@ -0,0 +1,138 @@
+package gosfzplayer
+
+import (
+	"sort"
+)
+
+// Articulation is a keyswitch and its sw_label
+type Articulation struct {
+	Keyswitch int    // MIDI note selecting the articulation (sw_last), -1 if none is active
+	Label     string // sw_label, empty if not set
+}
+
+// keyswitchMap describes an instrument's keyswitches, compiled once per instrument
+type keyswitchMap struct {
+	isKeyswitch      [128]bool      // Notes that switch articulations instead of sounding
+	articulations    []Articulation // sw_last articulations sorted by keyswitch
+	defaultKeyswitch int            // sw_default, -1 if not set
+}
+
+// compileKeyswitchMap collects the keyswitch ranges, sw_last/sw_down/sw_up notes and labels of an instrument
+func compileKeyswitchMap(regions []*Region) *keyswitchMap {
+	km := &keyswitchMap{defaultKeyswitch: -1}
+	labels := make(map[int]string)
+
+	markKey := func(note int) {
+		if note >= 0 && note <= 127 {
+			km.isKeyswitch[note] = true
+		}
+	}
+
+	for _, region := range regions {
+		// sw_lokey/sw_hikey declare the keyswitch range
+		if region.SwLoKey >= 0 && region.SwHiKey >= region.SwLoKey {
+			for note := region.SwLoKey; note <= region.SwHiKey; note++ {
+				markKey(note)
+			}
+		}
+
+		markKey(region.SwDown)
+		markKey(region.SwUp)
+
+		if swLast := region.SwLast; swLast >= 0 && swLast <= 127 {
+			markKey(swLast)
+			if _, exists := labels[swLast]; !exists || labels[swLast] == "" {
+				labels[swLast] = region.SwLabel
+			}
+		}
+
+		if km.defaultKeyswitch < 0 && region.SwDefault >= 0 && region.SwDefault <= 127 {
+			km.defaultKeyswitch = region.SwDefault
+		}
+	}
+
+	for note, label := range labels {
+		km.articulations = append(km.articulations, Articulation{Keyswitch: note, Label: label})
+	}
+	sort.Slice(km.articulations, func(i, j int) bool {
+		return km.articulations[i].Keyswitch < km.articulations[j].Keyswitch
+	})
+
+	return km
+}
+
+// carryOver returns the keyswitch to keep after switching to this map: the same one if it is still
+// a keyswitch here, otherwise sw_default
+func (km *keyswitchMap) carryOver(keyswitch int) int {
+	if keyswitch >= 0 && keyswitch <= 127 && km.isKeyswitch[keyswitch] {
+		return keyswitch
+	}
+	return km.defaultKeyswitch
+}
+
+// articulation returns the articulation for a keyswitch note
+func (km *keyswitchMap) articulation(keyswitch int) Articulation {
+	for _, articulation := range km.articulations {
+		if articulation.Keyswitch == keyswitch {
+			return articulation
+		}
+	}
+	return Articulation{Keyswitch: keyswitch}
+}
+
+// Articulations returns the instrument's sw_last articulations sorted by keyswitch
+func (p *SfzPlayer) Articulations() []Articulation {
+	keyswitches := p.current().keyswitches
+	articulations := make([]Articulation, len(keyswitches.articulations))
+	copy(articulations, keyswitches.articulations)
+	return articulations
+}
+
+// ActiveArticulation returns the articulation selected by the last keyswitch played (or sw_default)
+func (p *SfzPlayer) ActiveArticulation() Articulation {
+	return p.current().keyswitches.articulation(int(p.activeKeyswitch.Load()))
+}
+
+// updateKeyswitchState records a keyswitch note and reports whether the note is a keyswitch
+func (e *engine) updateKeyswitchState(note, velocity uint8) bool {
+	if !e.inst.keyswitches.isKeyswitch[note&0x7F] {
+		return false
+	}
+
+	e.lastKeyswitch = int(note)
+	e.player.activeKeyswitch.Store(int32(note))
+	if engineDebugEnabled {
+		engineDebug("Keyswitch updated: %d (velocity %d)", note, velocity)
+	}
+	return true
+}
+
+// keyswitchMatches checks a region's sw_last, sw_down, sw_up and sw_previous conditions
+func (e *engine) keyswitchMatches(region *Region) bool {
+	if region.SwLast >= 0 && e.lastKeyswitch != region.SwLast {
+		return false
+	}
+
+	if swDown := region.SwDown; swDown >= 0 && swDown <= 127 && !e.keysDown[swDown] {
+		return false
+	}
+
+	if swUp := region.SwUp; swUp >= 0 && swUp <= 127 && e.keysDown[swUp] {
+		return false
+	}
+
+	if region.SwPrevious >= 0 && e.previousNote != region.SwPrevious {
+		return false
+	}
+
+	return true
+}
+
+// matchVelocity returns the velocity a region matches against: the played note's,
+// or the previous note's with sw_vel=previous
+func (e *engine) matchVelocity(region *Region, velocity uint8) uint8 {
+	if region.SwVelPrevious && e.previousNote >= 0 {
+		return e.previousVelocity
+	}
+	return velocity
+}
@@ -0,0 +1,125 @@
+package gosfzplayer
+
+import (
+	"testing"
+)
+
+const keyswitchTestSfz = `<global>
+sw_lokey=24
+sw_hikey=26
+sw_default=24
+
+<group>
+sw_last=24
+sw_label=Sustain
+
+<region>
+sample=sample1.wav
+lokey=60
+hikey=72
+
+<group>
+sw_last=25
+sw_label=Staccato
+
+<region>
+sample=sample2.wav
+lokey=60
+hikey=72
+`
+
+func TestKeyswitchLast(t *testing.T) {
+	e := createTestEngine(t, keyswitchTestSfz)
+
+	// sw_default selects the first articulation before any keyswitch is played
+	if got := triggeredSamples(e, []byte{0x90, 60, 100}); len(got) != 1 || got[0] != "sample1.wav" {
+		t.Errorf("Expected the sw_default articulation, got %v", got)
+	}
+	if got := e.player.ActiveArticulation(); got.Keyswitch != 24 || got.Label != "Sustain" {
+		t.Errorf("Expected active articulation 24/Sustain, got %+v", got)
+	}
+
+	// Keyswitch notes don't sound
+	if got := triggeredSamples(e, []byte{0x90, 25, 100}); len(got) != 0 {
+		t.Errorf("Expected the keyswitch note to be silent, got %v", got)
+	}
+	e.processMidiMessage([]byte{0x80, 25, 0})
+
+	// The articulation stays selected after the keyswitch is released
+	if got := triggeredSamples(e, []byte{0x90, 62, 100}); len(got) != 1 || got[0] != "sample2.wav" {
+		t.Errorf("Expected the staccato articulation, got %v", got)
+	}
+	if got := e.player.ActiveArticulation(); got.Keyswitch != 25 || got.Label != "Staccato" {
+		t.Errorf("Expected active articulation 25/Staccato, got %+v", got)
+	}
+
+	// Keyswitches in the range without regions select nothing
+	triggeredSamples(e, []byte{0x90, 26, 100})
+	if got := triggeredSamples(e, []byte{0x90, 62, 100}); len(got) != 0 {
+		t.Errorf("Expected no regions for keyswitch 26, got %v", got)
+	}
+
+	articulations := e.player.Articulations()
+	if len(articulations) != 2 || articulations[0].Label != "Sustain" || articulations[1].Label != "Staccato" {
+		t.Errorf("Unexpected articulations: %+v", articulations)
+	}
+}
+
+func TestKeyswitchDownUp(t *testing.T) {
+	e := createTestEngine(t, `<region>
+sample=sample1.wav
+key=60
+sw_down=36
+
+<region>
+sample=sample2.wav
+key=60
+sw_up=36
+`)
+
+	if got := triggeredSamples(e, []byte{0x90, 60, 100}); len(got) != 1 || got[0] != "sample2.wav" {
+		t.Errorf("Expected the sw_up region with key 36 up, got %v", got)
+	}
+
+	e.processMidiMessage([]byte{0x90, 36, 100})
+	if got := triggeredSamples(e, []byte{0x90, 60, 100}); len(got) != 1 || got[0] != "sample1.wav" {
+		t.Errorf("Expected the sw_down region with key 36 held, got %v", got)
+	}
+
+	e.processMidiMessage([]byte{0x80, 36, 0})
+	if got := triggeredSamples(e, []byte{0x90, 60, 100}); len(got) != 1 || got[0] != "sample2.wav" {
+		t.Errorf("Expected the sw_up region after key 36 was released, got %v", got)
+	}
+}
+
+func TestKeyswitchPreviousAndVelocity(t *testing.T) {
+	e := createTestEngine(t, `<region>
+sample=sample1.wav
+key=62
+sw_previous=60
+
+<region>
+sample=sample2.wav
+key=64
+sw_vel=previous
+hivel=50
+`)
+
+	if got := triggeredSamples(e, []byte{0x90, 62, 100}); len(got) != 0 {
+		t.Errorf("Expected no sw_previous region without note 60 first, got %v", got)
+	}
+
+	triggeredSamples(e, []byte{0x90, 60, 30})
+	if got := triggeredSamples(e, []byte{0x90, 62, 100}); len(got) != 1 || got[0] != "sample1.wav" {
+		t.Errorf("Expected the sw_previous region after note 60, got %v", got)
+	}
+
+	// sw_vel=previous matches against the velocity of the previous note (100 > hivel)
+	if got := triggeredSamples(e, []byte{0x90, 64, 10}); len(got) != 0 {
+		t.Errorf("Expected sw_vel=previous to use the previous velocity, got %v", got)
+	}
+	triggeredSamples(e, []byte{0x90, 60, 30})
+	if got := triggeredSamples(e, []byte{0x90, 64, 100}); len(got) != 1 || got[0] != "sample2.wav" {
+		t.Errorf("Expected sw_vel=previous to match the previous velocity 30, got %v", got)
+	}
+}
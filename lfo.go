@@ -0,0 +1,196 @@
+package gosfzplayer
+
+import (
+	"math"
+)
+
+// lfoState is the per-voice state of an SFZ v2 LFO
+type lfoState struct {
+	spec       *lfoSpec
+	sampleRate float64
+	phase      float64 // Current phase (0.0 to 1.0)
+	elapsed    float64 // Time since note on, in seconds
+	freqMods   []ccModulator
+	depthMods  [numModTargets][]ccModulator
+}
+
+// newLFOState creates the per-voice state for an LFO
+func newLFOState(spec *lfoSpec, sampleRate float64) lfoState {
+	var state lfoState
+	state.init(spec, sampleRate)
+	return state
+}
+
+// init resets the LFO state for a new note, reusing its modulator buffers
+func (ls *lfoState) init(spec *lfoSpec, sampleRate float64) {
+	freqMods := ls.freqMods[:0]
+	depthMods := ls.depthMods
+	*ls = lfoState{
+		spec:       spec,
+		sampleRate: sampleRate,
+		phase:      spec.phase - math.Floor(spec.phase),
+	}
+	for _, route := range spec.freqCC {
+		freqMods = append(freqMods, newCCModulator(route, sampleRate))
+	}
+	ls.freqMods = freqMods
+	ls.depthMods = initDepthMods(depthMods, spec.depthCC, sampleRate)
+}
+
+// Process advances the LFO by one sample and returns its output (-1.0 to 1.0)
+func (ls *lfoState) Process(ccValues *[128]float64) float64 {
+	freq := ls.spec.freq + sumCCModulators(ls.freqMods, ccValues)
+
+	ls.elapsed += 1.0 / ls.sampleRate
+	if ls.elapsed < ls.spec.delay {
+		return 0.0
+	}
+
+	// Fade in after the delay
+	gain := 1.0
+	if ls.spec.fade > 0 {
+		gain = math.Min((ls.elapsed-ls.spec.delay)/ls.spec.fade, 1.0)
+	}
+
+	value := lfoWaveform(ls.spec.wave, ls.phase) * gain
+
+	ls.phase += freq / ls.sampleRate
+	ls.phase -= math.Floor(ls.phase)
+
+	return value
+}
+
+// Depth returns the current depth towards a target, including CC depth modulation
+func (ls *lfoState) Depth(target ModTarget, ccValues *[128]float64) float64 {
+	return ls.spec.depth[target] + sumCCModulators(ls.depthMods[target], ccValues)
+}
+
+// lfoWaveform evaluates an SFZ v2 LFO waveform at the given phase
+func lfoWaveform(wave int, phase float64) float64 {
+	switch wave {
+	case 0: // Triangle
+		switch {
+		case phase < 0.25:
+			return 4.0 * phase
+		case phase < 0.75:
+			return 2.0 - 4.0*phase
+		default:
+			return 4.0*phase - 4.0
+		}
+	case 2: // Pulse 75%
+		return pulseWave(phase, 0.75)
+	case 3: // Square
+		return pulseWave(phase, 0.5)
+	case 4: // Pulse 25%
+		return pulseWave(phase, 0.25)
+	case 5: // Pulse 12.5%
+		return pulseWave(phase, 0.125)
+	case 6: // Saw up
+		return 2.0*phase - 1.0
+	case 7: // Saw down
+		return 1.0 - 2.0*phase
+	default: // Sine
+		return math.Sin(2.0 * math.Pi * phase)
+	}
+}
+
+// pulseWave returns 1.0 for the first part of the cycle and -1.0 for the rest
+func pulseWave(phase, duty float64) float64 {
+	if phase < duty {
+		return 1.0
+	}
+	return -1.0
+}
+
+// flexEGState is the per-voice state of an SFZ v2 flex envelope
+type flexEGState struct {
+	spec       *flexEGSpec
+	sampleRate float64
+	segment    int     // Index of the point currently being approached
+	segTime    float64 // Time spent in the current segment, in seconds
+	startLevel float64 // Level at the start of the current segment
+	level      float64
+	released   bool
+	depthMods  [numModTargets][]ccModulator
+}
+
+// newFlexEGState creates the per-voice state for a flex envelope
+func newFlexEGState(spec *flexEGSpec, sampleRate float64) flexEGState {
+	var state flexEGState
+	state.init(spec, sampleRate)
+	return state
+}
+
+// init resets the envelope state for a new note, reusing its modulator buffers
+func (es *flexEGState) init(spec *flexEGSpec, sampleRate float64) {
+	depthMods := es.depthMods
+	*es = flexEGState{
+		spec:       spec,
+		sampleRate: sampleRate,
+		segment:    1,
+	}
+	if len(spec.points) > 0 {
+		es.level = spec.points[0].level
+		es.startLevel = es.level
+	}
+	es.depthMods = initDepthMods(depthMods, spec.depthCC, sampleRate)
+}
+
+// Process advances the envelope by one sample and returns its level
+func (es *flexEGState) Process(noteOn bool) float64 {
+	points := es.spec.points
+
+	// On note off, skip the rest of the attack and head for the release segments
+	if !noteOn && !es.released {
+		es.released = true
+		if es.spec.sustain >= 0 && es.segment <= es.spec.sustain {
+			es.segment = es.spec.sustain + 1
+			es.segTime = 0
+			es.startLevel = es.level
+		}
+	}
+
+	if es.segment >= len(points) {
+		return es.level // Envelope finished, hold the last level
+	}
+
+	// Hold at the sustain point while the note is on
+	if !es.released && es.spec.sustain >= 0 && es.segment == es.spec.sustain+1 {
+		return es.level
+	}
+
+	point := points[es.segment]
+	if point.time <= 0 {
+		es.level = point.level
+	} else {
+		es.segTime += 1.0 / es.sampleRate
+		progress := math.Min(es.segTime/point.time, 1.0)
+		es.level = es.startLevel + (point.level-es.startLevel)*shapeCurve(progress, point.shape)
+		if progress < 1.0 {
+			return es.level
+		}
+	}
+
+	// Segment complete, move to the next point
+	es.segment++
+	es.segTime = 0
+	es.startLevel = es.level
+	return es.level
+}
+
+// Depth returns the current depth towards a target, including CC depth modulation
+func (es *flexEGState) Depth(target ModTarget, ccValues *[128]float64) float64 {
+	return es.spec.depth[target] + sumCCModulators(es.depthMods[target], ccValues)
+}
+
+// shapeCurve bends a linear 0-1 progress; positive shapes start slow, negative shapes start fast
+func shapeCurve(progress, shape float64) float64 {
+	switch {
+	case shape > 0:
+		return math.Pow(progress, 1.0+shape)
+	case shape < 0:
+		return 1.0 - math.Pow(1.0-progress, 1.0-shape)
+	default:
+		return progress
+	}
+}
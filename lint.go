@@ -0,0 +1,60 @@
+package gosfzplayer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// LintSfzFile parses an SFZ file without loading samples and returns its diagnostics: the
+// parser's warnings plus regions that can never play (no sample, a missing sample file or an
+// empty key or velocity range) and effects that can't be loaded (an unknown type or bus). It only returns an error if the file can't be read.
+func LintSfzFile(path string) ([]SfzDiagnostic, error) {
+	sfzData, err := ParseSfzFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	diagnostics := append([]SfzDiagnostic(nil), sfzData.Diagnostics...)
+	sfzDir := filepath.Dir(path)
+	for i, region := range compileRegions(sfzData.Regions, NewCurveSet(sfzData.Curves)) {
+		section := region.Section
+		report := func(severity, format string, args ...interface{}) {
+			message := fmt.Sprintf("region %d: ", i+1) + fmt.Sprintf(format, args...)
+			diagnostics = append(diagnostics, SfzDiagnostic{File: section.File, Line: section.Line, Severity: severity, Message: message})
+		}
+
+		if region.Sample == "" {
+			report("error", "no sample")
+		} else if _, err := os.Stat(filepath.Join(sfzDir, region.Sample)); err != nil {
+			report("error", "sample %q not found", region.Sample)
+		}
+		if region.LoKey > region.HiKey {
+			report("warning", "lokey %d is above hikey %d", region.LoKey, region.HiKey)
+		}
+		if region.LoVel > region.HiVel {
+			report("warning", "lovel %d is above hivel %d", region.LoVel, region.HiVel)
+		}
+		if region.LoChan > region.HiChan {
+			report("warning", "lochan %d is above hichan %d", region.LoChan, region.HiChan)
+		}
+	}
+
+	for i, section := range sfzData.Effects {
+		if err := checkEffect(section); err != nil {
+			message := fmt.Sprintf("effect %d: %v", i+1, err)
+			diagnostics = append(diagnostics, SfzDiagnostic{File: section.File, Line: section.Line, Severity: "warning", Message: message})
+		}
+	}
+
+	// The SFZ file's diagnostics first, then each included file's, in line order
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		a, b := diagnostics[i], diagnostics[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return diagnostics, nil
+}
@@ -0,0 +1,76 @@
+package gosfzplayer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLintSfzFile(t *testing.T) {
+	content := `<global>
+volume=-3
+<region>
+sample=sample1.wav
+lokey=60
+hikey=72
+<region>
+sample=missing.wav
+<region>
+sample=sample2.wav
+lovel=100
+hivel=20
+bogus=1
+<control>
+<region>
+pan=10
+<effect>
+type=bogus
+<effect>
+type=gain
+bus=fx9
+`
+	path := filepath.Join("testdata", "lint_test.sfz")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write SFZ file: %v", err)
+	}
+	defer os.Remove(path)
+
+	diagnostics, err := LintSfzFile(path)
+	if err != nil {
+		t.Fatalf("LintSfzFile failed: %v", err)
+	}
+
+	expected := []string{
+		`line 7: error: region 2: sample "missing.wav" not found`,
+		`line 9: warning: region 3: lovel 100 is above hivel 20`,
+		`line 13: warning: unknown opcode "bogus"`,
+		`line 14: warning: unknown section <control>`,
+		`line 15: error: region 4: no sample`,
+		`line 17: warning: effect 1: unknown effect type "bogus"`,
+		`line 19: warning: effect 2: unknown bus "fx9"`,
+	}
+	var got []string
+	for _, d := range diagnostics {
+		got = append(got, d.String())
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected diagnostics:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestLintSfzFileClean(t *testing.T) {
+	diagnostics, err := LintSfzFile(filepath.Join("testdata", "edm_drum_loop.sfz"))
+	if err != nil {
+		t.Fatalf("LintSfzFile failed: %v", err)
+	}
+	for _, d := range diagnostics {
+		if d.Severity == "error" {
+			t.Errorf("Unexpected error in a working instrument: %v", d)
+		}
+	}
+
+	if _, err := LintSfzFile("testdata/missing.sfz"); err == nil {
+		t.Error("Expected an error for a missing SFZ file")
+	}
+}
@@ -82,7 +82,7 @@ func TestLoopInitialization(t *testing.T) {
 			// Create voice and initialize loop
 			voice := &Voice{
 				sample: sample,
-				region: region,
+				region: compileRegion(region, nil),
 			}
 
 			voice.InitializeLoop()
@@ -120,7 +120,7 @@ func TestLoopProcessing(t *testing.T) {
 
 		voice := &Voice{
 			sample:   sample,
-			region:   region,
+			region:   compileRegion(region, nil),
 			position: 98, // Before end
 			noteOn:   true,
 		}
@@ -152,7 +152,7 @@ func TestLoopProcessing(t *testing.T) {
 
 		voice := &Voice{
 			sample:   sample,
-			region:   region,
+			region:   compileRegion(region, nil),
 			position: 75, // Before loop end
 			noteOn:   true,
 		}
@@ -189,7 +189,7 @@ func TestLoopProcessing(t *testing.T) {
 
 		voice := &Voice{
 			sample:   sample,
-			region:   region,
+			region:   compileRegion(region, nil),
 			position: 45,
 			noteOn:   true, // Note held
 		}
@@ -244,7 +244,7 @@ func TestLoopEdgeCases(t *testing.T) {
 
 		voice := &Voice{
 			sample: sample,
-			region: region,
+			region: compileRegion(region, nil),
 		}
 
 		voice.InitializeLoop()
@@ -266,7 +266,7 @@ func TestLoopEdgeCases(t *testing.T) {
 
 		voice := &Voice{
 			sample:   sample,
-			region:   region,
+			region:   compileRegion(region, nil),
 			position: 98,
 		}
 
@@ -0,0 +1,365 @@
+package gosfzplayer
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// maxLookahead is the longest limiter lookahead in seconds; the delay lines are allocated for it
+// up front
+const maxLookahead = 0.01
+
+// meterFloor is the level in dB the meters report for silence
+const meterFloor = -144.0
+
+// rmsWindow is the time constant of the RMS meters in seconds
+const rmsWindow = 0.3
+
+// softClipKnee is the fraction of the ceiling above which the soft clipper starts to bend (-6 dB)
+const softClipKnee = 0.5
+
+// Master is the master bus stage every block of output goes through: a gain, an optional soft
+// clipper and a lookahead brickwall limiter that keeps the output under its ceiling. It also meters
+// the output. Players and multitimbral racks each have one.
+type Master struct {
+	// Parameters (float64 bits) are set from any goroutine and picked up by the audio thread on
+	// its next block
+	gain      atomic.Uint64 // dB
+	ceiling   atomic.Uint64 // dBFS
+	release   atomic.Uint64 // Seconds
+	lookahead atomic.Uint64 // Seconds
+	softClip  atomic.Bool
+	version   atomic.Uint64 // Incremented on every parameter change
+
+	// Meters (float64 bits) written by the audio thread
+	peakL     atomic.Uint64 // Highest level since the meters were last read
+	peakR     atomic.Uint64
+	rmsL      atomic.Uint64 // Highest RMS level since the meters were last read
+	rmsR      atomic.Uint64
+	reduction atomic.Uint64 // Lowest limiter gain since the meters were last read
+}
+
+// MasterMeters are the output levels of a master bus stage, in dB
+type MasterMeters struct {
+	PeakLeft      float64 // Highest sample level since the last read, in dBFS
+	PeakRight     float64
+	RMSLeft       float64 // Highest RMS level, averaged over 300 ms, since the last read, in dBFS
+	RMSRight      float64
+	GainReduction float64 // Most the limiter turned the output down since the last read (0 or more)
+}
+
+// NewMaster creates a master bus stage at unity gain, limiting at -0.3 dBFS with a 100 ms release,
+// without lookahead or soft clipping. Without lookahead the limiter turns the gain down on the
+// frame of a peak, so events still sound on their exact frames.
+func NewMaster() *Master {
+	m := &Master{}
+	m.ceiling.Store(math.Float64bits(-0.3))
+	m.release.Store(math.Float64bits(0.1))
+	m.reduction.Store(math.Float64bits(1.0))
+	return m
+}
+
+// setParameter clamps a parameter and stores it for the audio thread
+func (m *Master) setParameter(param *atomic.Uint64, value, low, high float64) {
+	param.Store(math.Float64bits(clampFloat64(value, low, high)))
+	m.version.Add(1)
+}
+
+// SetGain sets the gain applied before the limiter, in dB (-60 to 24)
+func (m *Master) SetGain(db float64) {
+	m.setParameter(&m.gain, db, -60.0, 24.0)
+	debug("Master gain set to %.1f dB", m.GetGain())
+}
+
+// SetCeiling sets the level the limiter keeps the output under, in dBFS (-24 to 0)
+func (m *Master) SetCeiling(db float64) {
+	m.setParameter(&m.ceiling, db, -24.0, 0.0)
+	debug("Limiter ceiling set to %.1f dBFS", m.GetCeiling())
+}
+
+// SetRelease sets how long the limiter takes to recover after a peak, in seconds (0.001 to 2)
+func (m *Master) SetRelease(seconds float64) {
+	m.setParameter(&m.release, seconds, 0.001, 2.0)
+}
+
+// SetLookahead sets how far ahead the limiter looks for peaks, in seconds (0 to 0.01), so it can
+// ramp the gain down before they arrive instead of on the peak. The output is delayed by the
+// lookahead; changing it while playing restarts the limiter.
+func (m *Master) SetLookahead(seconds float64) {
+	m.setParameter(&m.lookahead, seconds, 0.0, maxLookahead)
+}
+
+// SetSoftClip turns the soft clipper in front of the limiter on or off. It rounds off peaks from
+// 6 dB below the ceiling so they saturate instead of being turned down.
+func (m *Master) SetSoftClip(enabled bool) {
+	m.softClip.Store(enabled)
+	m.version.Add(1)
+}
+
+// GetGain returns the gain in dB
+func (m *Master) GetGain() float64 {
+	return math.Float64frombits(m.gain.Load())
+}
+
+// GetCeiling returns the limiter ceiling in dBFS
+func (m *Master) GetCeiling() float64 {
+	return math.Float64frombits(m.ceiling.Load())
+}
+
+// GetRelease returns the limiter release time in seconds
+func (m *Master) GetRelease() float64 {
+	return math.Float64frombits(m.release.Load())
+}
+
+// GetLookahead returns the limiter lookahead in seconds
+func (m *Master) GetLookahead() float64 {
+	return math.Float64frombits(m.lookahead.Load())
+}
+
+// GetSoftClip reports whether the soft clipper is on
+func (m *Master) GetSoftClip() bool {
+	return m.softClip.Load()
+}
+
+// Meters returns the output levels, resetting the holds. Mono output is reported on both
+// channels; with several engines playing the player, the meters show the loudest.
+func (m *Master) Meters() MasterMeters {
+	return MasterMeters{
+		PeakLeft:      linearToDB(math.Float64frombits(m.peakL.Swap(0))),
+		PeakRight:     linearToDB(math.Float64frombits(m.peakR.Swap(0))),
+		RMSLeft:       linearToDB(math.Float64frombits(m.rmsL.Swap(0))),
+		RMSRight:      linearToDB(math.Float64frombits(m.rmsR.Swap(0))),
+		GainReduction: -linearToDB(math.Float64frombits(m.reduction.Swap(math.Float64bits(1.0)))),
+	}
+}
+
+// holdMax raises a meter to a level if it is higher; several audio threads can hold one meter
+func holdMax(meter *atomic.Uint64, level float64) {
+	for {
+		held := meter.Load()
+		if level <= math.Float64frombits(held) || meter.CompareAndSwap(held, math.Float64bits(level)) {
+			return
+		}
+	}
+}
+
+// holdMin lowers a meter to a level if it is lower; several audio threads can hold one meter
+func holdMin(meter *atomic.Uint64, level float64) {
+	for {
+		held := meter.Load()
+		if level >= math.Float64frombits(held) || meter.CompareAndSwap(held, math.Float64bits(level)) {
+			return
+		}
+	}
+}
+
+// linearToDB converts a level to dB, down to the meter floor
+func linearToDB(level float64) float64 {
+	if level <= 0 {
+		return meterFloor
+	}
+	return math.Max(20.0*math.Log10(level), meterFloor)
+}
+
+// masterStage is the processing state of a master bus stage, owned by the engine or rack whose
+// output it processes
+type masterStage struct {
+	sampleRate float64
+
+	// Lookahead delay lines
+	delayL   []float32
+	delayR   []float32
+	writeIdx int
+
+	// Sliding minimum of the gains the last frames need, as a queue of (frame, gain) with rising gains
+	minFrames []int64
+	minGains  []float64
+	minHead   int
+	minCount  int
+	frame     int64
+
+	// Moving average smoothing the limiter gain over the lookahead
+	box    []float64
+	boxIdx int
+	boxSum float64
+
+	envelope float64 // Held gain with the release applied
+	gain     float64 // Master gain in use, ramped to the parameter each block
+	rmsL     float64 // Mean squares for the RMS meters
+	rmsR     float64
+
+	// Parameters in use
+	appliedVersion uint64
+	window         int // Lookahead in frames plus one
+	ceilingLevel   float64
+	releaseCoef    float64
+	softClipOn     bool
+}
+
+// newMasterStage creates the processing state for a master bus stage at a sample rate
+func newMasterStage(sampleRate uint32) masterStage {
+	frames := int(maxLookahead*float64(sampleRate)) + 1
+	return masterStage{
+		sampleRate:     float64(sampleRate),
+		delayL:         make([]float32, frames),
+		delayR:         make([]float32, frames),
+		minFrames:      make([]int64, frames),
+		minGains:       make([]float64, frames),
+		box:            make([]float64, frames),
+		gain:           1.0,
+		appliedVersion: math.MaxUint64, // Picks up the parameters on the first block
+	}
+}
+
+// applyParameters picks up a master's parameters, restarting the limiter if the lookahead changed
+func (s *masterStage) applyParameters(m *Master) {
+	s.appliedVersion = m.version.Load()
+	s.ceilingLevel = dbToLinear(m.GetCeiling())
+	s.releaseCoef = math.Exp(-1.0 / (m.GetRelease() * s.sampleRate))
+	s.softClipOn = m.GetSoftClip()
+
+	window := s.latency(m) + 1
+	if window == s.window {
+		return
+	}
+	s.window = window
+	clear(s.delayL)
+	clear(s.delayR)
+	s.minCount = 0
+	for i := range s.box {
+		s.box[i] = 1.0
+	}
+	s.boxIdx = 0
+	s.boxSum = float64(window)
+	s.envelope = 1.0
+}
+
+// latency returns the delay in frames a master's lookahead adds to the output
+func (s *masterStage) latency(m *Master) int {
+	return min(int(math.Round(m.GetLookahead()*s.sampleRate)), len(s.box)-1)
+}
+
+// softClip bends a sample smoothly towards the ceiling once it passes the knee
+func (s *masterStage) softClip(x float64) float64 {
+	knee := s.ceilingLevel * softClipKnee
+	magnitude := math.Abs(x)
+	if magnitude <= knee {
+		return x
+	}
+	bent := knee + (s.ceilingLevel-knee)*math.Tanh((magnitude-knee)/(s.ceilingLevel-knee))
+	return math.Copysign(bent, x)
+}
+
+// limiterGain returns the gain for the frame leaving the delay line, given the level of the frame
+// entering it. The gain is the lowest any frame in the lookahead needs, held and released, then
+// averaged over the lookahead so it ramps down before the peak arrives and never lets it past the
+// ceiling.
+func (s *masterStage) limiterGain(level float64) float64 {
+	need := 1.0
+	if level > s.ceilingLevel {
+		need = s.ceilingLevel / level
+	}
+
+	// Sliding minimum over the last window frames
+	size := len(s.minGains)
+	for s.minCount > 0 && s.minGains[(s.minHead+s.minCount-1)%size] >= need {
+		s.minCount--
+	}
+	tail := (s.minHead + s.minCount) % size
+	s.minFrames[tail], s.minGains[tail] = s.frame, need
+	s.minCount++
+	for s.minFrames[s.minHead] <= s.frame-int64(s.window) {
+		s.minHead = (s.minHead + 1) % size
+		s.minCount--
+	}
+	s.frame++
+	held := s.minGains[s.minHead]
+
+	if held < s.envelope {
+		s.envelope = held
+	} else {
+		s.envelope = held + s.releaseCoef*(s.envelope-held)
+	}
+
+	// Moving average, summed afresh on each wrap so rounding errors don't build up
+	s.boxSum += s.envelope - s.box[s.boxIdx]
+	s.box[s.boxIdx] = s.envelope
+	s.boxIdx++
+	if s.boxIdx >= s.window {
+		s.boxIdx = 0
+		s.boxSum = 0
+		for _, gain := range s.box[:s.window] {
+			s.boxSum += gain
+		}
+	}
+	return s.boxSum / float64(s.window)
+}
+
+// process runs a block through the master bus stage in place and updates the master's meters.
+// right is nil for mono output.
+func (s *masterStage) process(m *Master, left, right []float32) {
+	if len(left) == 0 {
+		return
+	}
+	if m.version.Load() != s.appliedVersion {
+		s.applyParameters(m)
+	}
+
+	target := dbToLinear(m.GetGain())
+	gainStep := (target - s.gain) / float64(len(left))
+	delay := s.window - 1
+	size := len(s.delayL)
+
+	lowest := 1.0
+	var peakL, peakR, sumL, sumR float64
+	for i := range left {
+		s.gain += gainStep
+		inputL := float64(left[i]) * s.gain
+		inputR := inputL
+		if right != nil {
+			inputR = float64(right[i]) * s.gain
+		}
+		if s.softClipOn {
+			inputL = s.softClip(inputL)
+			inputR = s.softClip(inputR)
+		}
+
+		// Both channels share one gain so the stereo image holds
+		gain := s.limiterGain(math.Max(math.Abs(inputL), math.Abs(inputR)))
+		lowest = math.Min(lowest, gain)
+
+		s.delayL[s.writeIdx] = float32(inputL)
+		s.delayR[s.writeIdx] = float32(inputR)
+		readIdx := s.writeIdx - delay
+		if readIdx < 0 {
+			readIdx += size
+		}
+		s.writeIdx++
+		if s.writeIdx >= size {
+			s.writeIdx = 0
+		}
+
+		outputL := float64(s.delayL[readIdx]) * gain
+		outputR := float64(s.delayR[readIdx]) * gain
+		left[i] = float32(outputL)
+		if right != nil {
+			right[i] = float32(outputR)
+		}
+
+		peakL = math.Max(peakL, math.Abs(outputL))
+		peakR = math.Max(peakR, math.Abs(outputR))
+		sumL += outputL * outputL
+		sumR += outputR * outputR
+	}
+	s.gain = target
+
+	// Meters
+	smoothing := math.Exp(-float64(len(left)) / (rmsWindow * s.sampleRate))
+	s.rmsL = smoothing*s.rmsL + (1.0-smoothing)*sumL/float64(len(left))
+	s.rmsR = smoothing*s.rmsR + (1.0-smoothing)*sumR/float64(len(left))
+	holdMax(&m.rmsL, math.Sqrt(s.rmsL))
+	holdMax(&m.rmsR, math.Sqrt(s.rmsR))
+	holdMax(&m.peakL, peakL)
+	holdMax(&m.peakR, peakR)
+	holdMin(&m.reduction, lowest)
+}
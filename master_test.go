@@ -0,0 +1,217 @@
+package gosfzplayer
+
+import (
+	"math"
+	"testing"
+)
+
+// processMaster runs a mono signal through a new master bus stage at 44100 Hz, returning the output
+func processMaster(m *Master, input []float32) []float32 {
+	stage := newMasterStage(44100)
+	output := append([]float32(nil), input...)
+	for start := 0; start < len(output); start += 512 {
+		stage.process(m, output[start:min(start+512, len(output))], nil)
+	}
+	return output
+}
+
+// sineSignal returns a second of a 440 Hz sine at a level
+func sineSignal(level float64) []float32 {
+	signal := make([]float32, 44100)
+	for i := range signal {
+		signal[i] = float32(level * math.Sin(2.0*math.Pi*440*float64(i)/44100))
+	}
+	return signal
+}
+
+func TestMasterTransparentBelowCeiling(t *testing.T) {
+	m := NewMaster()
+	input := sineSignal(0.5)
+	output := processMaster(m, input)
+	for i := range input {
+		if output[i] != input[i] {
+			t.Fatalf("Expected the master stage to pass a quiet signal unchanged, frame %d: %f vs %f", i, output[i], input[i])
+		}
+	}
+
+	meters := m.Meters()
+	if math.Abs(meters.PeakLeft-linearToDB(0.5)) > 0.01 || meters.PeakRight != meters.PeakLeft {
+		t.Errorf("Expected a -6 dBFS peak on both channels, got %.2f/%.2f", meters.PeakLeft, meters.PeakRight)
+	}
+	if rms := linearToDB(0.5 / math.Sqrt2); math.Abs(meters.RMSLeft-rms) > 0.5 {
+		t.Errorf("Expected an RMS level near %.2f dBFS, got %.2f", rms, meters.RMSLeft)
+	}
+	if meters.GainReduction != 0 {
+		t.Errorf("Expected no gain reduction, got %.2f dB", meters.GainReduction)
+	}
+
+	// Reading the meters resets the peak hold
+	if peak := m.Meters().PeakLeft; peak != meterFloor {
+		t.Errorf("Expected the peak hold to reset after a read, got %.2f", peak)
+	}
+}
+
+func TestMasterLimiterCeiling(t *testing.T) {
+	for _, lookahead := range []float64{0, 0.002} {
+		m := NewMaster()
+		m.SetGain(12)
+		m.SetCeiling(-1)
+		m.SetLookahead(lookahead)
+
+		output := processMaster(m, sineSignal(0.9))
+		ceiling := dbToLinear(-1)
+		if level := peak(output); level > ceiling+1e-6 {
+			t.Errorf("Lookahead %g: expected the output under the -1 dBFS ceiling, got peak %f", lookahead, level)
+		}
+		meters := m.Meters()
+		if meters.GainReduction < 12 {
+			t.Errorf("Lookahead %g: expected at least 12 dB of gain reduction, got %.2f", lookahead, meters.GainReduction)
+		}
+		if meters.PeakLeft > -1+1e-4 || meters.PeakLeft < -2 {
+			t.Errorf("Lookahead %g: expected a peak just under -1 dBFS, got %.2f", lookahead, meters.PeakLeft)
+		}
+	}
+}
+
+func TestMasterLimiterLookahead(t *testing.T) {
+	m := NewMaster()
+	m.SetLookahead(0.001)
+	stage := newMasterStage(44100)
+	latency := stage.latency(m)
+	if latency != 44 {
+		t.Fatalf("Expected 1 ms of lookahead to be 44 frames, got %d", latency)
+	}
+
+	// A steady level with one loud spike at frame 1000
+	input := make([]float32, 4096)
+	for i := range input {
+		input[i] = 0.1
+	}
+	input[1000] = 4.0
+	output := processMaster(m, input)
+
+	spike := 1000 + latency
+	if output[latency-1] != 0 || output[latency] != 0.1 {
+		t.Errorf("Expected the output delayed by %d frames, got %f, %f", latency, output[latency-1], output[latency])
+	}
+	if level := math.Abs(float64(output[spike])); level > dbToLinear(-0.3)+1e-6 || level < 0.9 {
+		t.Errorf("Expected the spike limited to the ceiling, got %f", level)
+	}
+
+	// The gain ramps down ahead of the spike instead of on it
+	if before := output[spike-latency/2]; before >= 0.1 || before <= 0 {
+		t.Errorf("Expected the gain to be ramping down before the spike, got %f", before)
+	}
+	if before := output[spike-latency-1]; before != 0.1 {
+		t.Errorf("Expected full gain before the lookahead, got %f", before)
+	}
+}
+
+func TestMasterSoftClip(t *testing.T) {
+	m := NewMaster()
+	m.SetCeiling(0)
+	m.SetSoftClip(true)
+	output := processMaster(m, []float32{0.4, -0.4, 0.9, -0.9, 3.0})
+
+	if output[0] != 0.4 || output[1] != -0.4 {
+		t.Errorf("Expected levels under the knee to pass unchanged, got %f, %f", output[0], output[1])
+	}
+	if output[2] >= 0.9 || output[2] <= 0.5 || output[3] != -output[2] {
+		t.Errorf("Expected 0.9 to be rounded off symmetrically, got %f, %f", output[2], output[3])
+	}
+	if output[4] >= 1.0 || output[4] < output[2] {
+		t.Errorf("Expected a 3.0 peak to saturate under the ceiling, got %f", output[4])
+	}
+	if reduction := m.Meters().GainReduction; reduction != 0 {
+		t.Errorf("Expected the soft clipper to keep the limiter idle, got %.2f dB", reduction)
+	}
+}
+
+func TestEngineMasterStage(t *testing.T) {
+	e := createTestEngine(t, "<region>\nsample=sample1.wav\nvolume=6\neffect1=0\n")
+	e.player.Master().SetGain(24)
+
+	// A loud chord stays under the ceiling in stereo
+	for note := uint8(48); note < 72; note += 3 {
+		e.noteOn(note, 127)
+	}
+	left := make([]float32, 4096)
+	right := make([]float32, 4096)
+	e.renderStereoEvents(left, right, nil)
+	ceiling := dbToLinear(-0.3)
+	if level := max(peak(left), peak(right)); level > ceiling+1e-6 {
+		t.Errorf("Expected the master limiter to keep the chord under the ceiling, got peak %f", level)
+	}
+
+	meters := e.player.Master().Meters()
+	if meters.GainReduction <= 0 || meters.RMSLeft <= meterFloor || meters.PeakRight > -0.3+1e-4 {
+		t.Errorf("Unexpected meters: %+v", meters)
+	}
+}
+
+func TestRenderCompensatesLookahead(t *testing.T) {
+	e := createTestEngine(t, "<region>\nsample=sample1.wav\nkey=60\neffect1=0\n")
+	events := []TimedMidiEvent{
+		{Time: 0.1, Data: []byte{0x90, 60, 100}},
+		{Time: 0.2, Data: []byte{0x80, 60, 0}},
+	}
+	render := func() []float32 {
+		var output []float32
+		_, err := renderMidiEvents(e.player, events, (&RenderOptions{}).withDefaults(), func(left, right []float32) error {
+			output = append(output, left...)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+		return output
+	}
+
+	direct := render()
+	e.player.Master().SetLookahead(0.005)
+	delayed := render()
+	if start := firstNonZero(delayed); start != firstNonZero(direct) || start < 4410 {
+		t.Errorf("Expected the note to start on the same frame with lookahead, got %d vs %d", start, firstNonZero(direct))
+	}
+	for i := range direct[:min(len(direct), len(delayed))] {
+		if math.Abs(float64(delayed[i]-direct[i])) > 1e-6 {
+			t.Fatalf("Frame %d: expected %f, got %f", i, direct[i], delayed[i])
+		}
+	}
+}
+
+func TestMultitimbralMasterStage(t *testing.T) {
+	rack := createTestRack(t, 1)
+	rack.ProcessMidi([]byte{0x90, 60, 127})
+	before, _ := renderRack(rack, 2048)
+
+	rack.Master().SetGain(-20)
+	renderRack(rack, 2048) // The gain ramps over the first block
+	after, _ := renderRack(rack, 2048)
+	if ratio := after / before; math.Abs(ratio-0.1) > 0.02 {
+		t.Errorf("Expected -20 dB master gain to scale the rack's output by 0.1, got %f", ratio)
+	}
+	if meters := rack.Master().Meters(); meters.PeakLeft <= meterFloor || meters.PeakLeft != meters.PeakRight {
+		t.Errorf("Expected equal peaks from a centered part, got %+v", meters)
+	}
+}
+
+func TestMasterMetersFromSeveralStages(t *testing.T) {
+	m := NewMaster()
+	loud, quiet := newMasterStage(44100), newMasterStage(44100)
+	loudSignal, quietSignal := sineSignal(0.5), sineSignal(0.1)
+	for start := 0; start < len(loudSignal); start += 512 {
+		end := min(start+512, len(loudSignal))
+		loud.process(m, loudSignal[start:end], nil)
+		quiet.process(m, quietSignal[start:end], nil)
+	}
+
+	// The quieter engine's blocks don't pull the RMS meter down
+	meters := m.Meters()
+	if rms := linearToDB(0.5 / math.Sqrt2); math.Abs(meters.RMSLeft-rms) > 0.5 {
+		t.Errorf("Expected the louder stage's RMS level near %.2f dBFS, got %.2f", rms, meters.RMSLeft)
+	}
+	if meters = m.Meters(); meters.RMSLeft != meterFloor {
+		t.Errorf("Expected the RMS hold to reset after a read, got %.2f", meters.RMSLeft)
+	}
+}
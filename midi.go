@@ -0,0 +1,305 @@
+package gosfzplayer
+
+import (
+	"math"
+
+	"github.com/GeoffreyPlitt/debuggo"
+)
+
+var midiDebug = debuggo.Debug("sfzplayer:midi")
+
+// midiDebugEnabled guards logging on the audio thread
+var midiDebugEnabled = debuggo.IsEnabled("sfzplayer:midi")
+
+// MIDI status bytes (upper nibble of channel messages)
+const (
+	midiNoteOff           = 0x80
+	midiNoteOn            = 0x90
+	midiPolyAftertouch    = 0xA0
+	midiControlChange     = 0xB0
+	midiProgramChange     = 0xC0
+	midiChannelAftertouch = 0xD0
+	midiPitchBend         = 0xE0
+)
+
+// metaSetTempo is the type of a set tempo meta event (FF 51 03 tt tt tt), which rendered event
+// lists carry from MIDI files
+const metaSetTempo = 0x51
+
+// Channel voice and mode controllers
+const (
+	ccBankSelectMSB    = 0
+	ccModulation       = 1
+	ccDataEntryMSB     = 6
+	ccChannelVolume    = 7
+	ccPan              = 10
+	ccExpression       = 11
+	ccBankSelectLSB    = 32
+	ccDataEntryLSB     = 38
+	ccNRPNLSB          = 98
+	ccNRPNMSB          = 99
+	ccRPNLSB           = 100
+	ccRPNMSB           = 101
+	ccAllSoundOff      = 120
+	ccResetControllers = 121
+	ccAllNotesOff      = 123
+	ccOmniOff          = 124 // Omni and mono/poly mode changes also turn all notes off
+	ccOmniOn           = 125
+	ccMonoOn           = 126
+	ccPolyOn           = 127
+)
+
+// Registered parameters
+const (
+	rpnPitchBendRange = 0
+	rpnNull           = 0x3FFF
+
+	noBendRange = -1.0 // No RPN 0 received: regions use bend_up/bend_down
+)
+
+// MidiEvent is a MIDI message stamped with the frame it takes effect on, relative to the start of
+// the block being rendered
+type MidiEvent struct {
+	Frame uint32
+	Data  []byte
+}
+
+// midiState tracks the controller state of one MIDI channel
+type midiState struct {
+	cc                [128]uint8   // Last value of every controller
+	ccValues          [128]float64 // The same normalized to 0.0-1.0, for modulation and pedals
+	channelAftertouch uint8
+	polyAftertouch    [128]uint8 // Per-note aftertouch
+	pitchBend         int16      // -8192 to +8191
+
+	volume     float64 // Channel volume gain from CC7 (full level until the first CC7)
+	expression float64 // Expression gain from CC11 (full level until the first CC11)
+	pan        float64 // Channel pan from CC10, -1.0 left to 1.0 right
+	bendRange  float64 // Pitch bend range in cents from RPN 0, or noBendRange
+	rpn        uint16  // Registered parameter selected with CC101/CC100, rpnNull if none
+	bank       uint16  // Bank selected with CC0/CC32 (MSB << 7 | LSB)
+	program    uint8   // Last program change
+}
+
+// newMidiState returns a channel's state at power-on
+func newMidiState() midiState {
+	return midiState{
+		volume:     1.0,
+		expression: 1.0,
+		bendRange:  noBendRange,
+		rpn:        rpnNull,
+	}
+}
+
+// gain returns the channel's volume and expression gain
+func (s *midiState) gain() float64 {
+	return s.volume * s.expression
+}
+
+// controllerGain converts a CC7/CC11 value to a linear gain (40 log10(value/127) dB, as in General MIDI)
+func controllerGain(value uint8) float64 {
+	level := float64(value) / 127.0
+	return level * level
+}
+
+// setFileTempo sets the player's tempo from a MIDI file's set tempo event, in microseconds per
+// quarter note, and passes it to tempo-synced effects from this frame on
+func (e *engine) setFileTempo(microsecondsPerQuarter uint32) {
+	if microsecondsPerQuarter == 0 {
+		return
+	}
+	e.player.SetTempo(TempoChange{MicrosecondsPerQuarter: microsecondsPerQuarter}.BPM())
+	e.syncEffectTempo()
+}
+
+// sendMidi queues a MIDI channel message from any goroutine for the audio thread, which applies it
+// at the start of its next block. It returns false if the queue is full.
+func (e *engine) sendMidi(data []byte) bool {
+	e.producerMu.Lock()
+	defer e.producerMu.Unlock()
+	return e.controls.push(data)
+}
+
+// processMidiMessage parses a raw MIDI message and dispatches it to the engine
+func (e *engine) processMidiMessage(data []byte) {
+	if len(data) < 1 {
+		return
+	}
+
+	status := data[0]
+	if status == 0xFF && len(data) == 6 && data[1] == metaSetTempo && data[2] == 3 {
+		e.setFileTempo(uint32(data[3])<<16 | uint32(data[4])<<8 | uint32(data[5]))
+		return
+	}
+	if status < 0x80 || status >= 0xF0 {
+		// Data bytes without a status and system messages are ignored
+		return
+	}
+
+	channel := status & 0x0F
+	if !e.player.acceptsChannel(channel) {
+		if midiDebugEnabled {
+			midiDebug("Ignoring message on channel %d", channel+1)
+		}
+		return
+	}
+
+	switch status & 0xF0 {
+	case midiNoteOn:
+		if len(data) >= 3 {
+			note := data[1]
+			velocity := data[2]
+			if velocity > 0 {
+				e.noteOnChannel(channel, note, velocity)
+			} else {
+				e.noteOffChannel(channel, note)
+			}
+		}
+	case midiNoteOff:
+		if len(data) >= 2 {
+			e.noteOffChannel(channel, data[1])
+		}
+	case midiPolyAftertouch:
+		if len(data) >= 3 {
+			e.processPolyAftertouch(channel, data[1], data[2])
+		}
+	case midiControlChange:
+		if len(data) >= 3 {
+			e.processControlChangeChannel(channel, data[1], data[2])
+		}
+	case midiProgramChange:
+		if len(data) >= 2 {
+			e.processProgramChange(channel, data[1])
+		}
+	case midiChannelAftertouch:
+		if len(data) >= 2 {
+			e.processChannelAftertouch(channel, data[1])
+		}
+	case midiPitchBend:
+		if len(data) >= 3 {
+			e.processPitchBendChannel(channel, data[1], data[2])
+		}
+	}
+}
+
+// processPolyAftertouch records polyphonic aftertouch for a note
+func (e *engine) processPolyAftertouch(channel, note, value uint8) {
+	e.channelState[channel&0x0F].polyAftertouch[note&0x7F] = value & 0x7F
+	if midiDebugEnabled {
+		midiDebug("Poly aftertouch: channel=%d, note=%d, value=%d", channel+1, note, value)
+	}
+}
+
+// processChannelAftertouch records channel aftertouch
+func (e *engine) processChannelAftertouch(channel, value uint8) {
+	e.channelState[channel&0x0F].channelAftertouch = value & 0x7F
+	if midiDebugEnabled {
+		midiDebug("Channel aftertouch: channel=%d, value=%d", channel+1, value)
+	}
+}
+
+// processProgramChange records a program change and hands it to the engine's program handler
+func (e *engine) processProgramChange(channel, program uint8) {
+	state := &e.channelState[channel&0x0F]
+	state.program = program & 0x7F
+	if midiDebugEnabled {
+		midiDebug("Program change: channel=%d, bank=%d, program=%d", channel+1, state.bank, state.program)
+	}
+	if e.onProgramChange != nil {
+		e.onProgramChange(channel&0x0F, state.bank, state.program)
+	}
+}
+
+// processChannelController handles the channel voice and mode controllers: bank select, RPNs,
+// channel volume, pan and expression, all sound off, reset controllers and all notes off
+func (e *engine) processChannelController(channel, cc, value uint8) {
+	channel &= 0x0F
+	state := &e.channelState[channel]
+
+	switch cc {
+	case ccBankSelectMSB:
+		state.bank = uint16(value)<<7 | state.bank&0x7F
+	case ccBankSelectLSB:
+		state.bank = state.bank&^0x7F | uint16(value)
+
+	case ccChannelVolume:
+		state.volume = controllerGain(value)
+	case ccExpression:
+		state.expression = controllerGain(value)
+	case ccPan:
+		state.pan = clampFloat64((float64(value)-64.0)/63.0, -1.0, 1.0)
+
+	case ccRPNMSB:
+		state.rpn = uint16(value)<<7 | state.rpn&0x7F
+	case ccRPNLSB:
+		state.rpn = state.rpn&^0x7F | uint16(value)
+	case ccNRPNMSB, ccNRPNLSB:
+		// NRPNs aren't supported; make sure data entry doesn't change the last RPN
+		state.rpn = rpnNull
+	case ccDataEntryMSB:
+		if state.rpn == rpnPitchBendRange {
+			// Semitones; keep the cents set by a previous data entry LSB
+			cents := math.Max(state.bendRange, 0)
+			state.bendRange = float64(value)*100.0 + math.Mod(cents, 100.0)
+		}
+	case ccDataEntryLSB:
+		if state.rpn == rpnPitchBendRange {
+			semitones := math.Floor(math.Max(state.bendRange, 0) / 100.0)
+			state.bendRange = semitones*100.0 + float64(min(value, 99))
+		}
+
+	case ccAllSoundOff:
+		e.allSoundOff(channel)
+	case ccResetControllers:
+		e.resetControllers(channel)
+	case ccAllNotesOff, ccOmniOff, ccOmniOn, ccMonoOn, ccPolyOn:
+		e.allNotesOff(channel)
+	}
+}
+
+// allSoundOff silences every voice on a channel immediately, including release tails
+func (e *engine) allSoundOff(channel uint8) {
+	for i := len(e.activeVoices) - 1; i >= 0; i-- {
+		if e.activeVoices[i].channel == channel {
+			e.removeVoice(i)
+		}
+	}
+	if midiDebugEnabled {
+		midiDebug("All sound off: channel=%d", channel+1)
+	}
+}
+
+// allNotesOff releases every key held on a channel, as if each had received a note-off.
+// Notes held by the sustain or sostenuto pedal keep sounding until the pedal is released.
+func (e *engine) allNotesOff(channel uint8) {
+	for note := 0; note < 128; note++ {
+		for _, voice := range e.activeVoices {
+			if voice.channel == channel && voice.midiNote == uint8(note) && voice.keyDown {
+				e.noteOffChannel(channel, uint8(note))
+				break
+			}
+		}
+	}
+	if midiDebugEnabled {
+		midiDebug("All notes off: channel=%d", channel+1)
+	}
+}
+
+// resetControllers returns a channel's controllers to their defaults (MIDI RP-015): modulation,
+// pedals, expression, pitch bend and aftertouch are reset, while volume, pan and bank are kept
+func (e *engine) resetControllers(channel uint8) {
+	e.processControlChangeChannel(channel, ccModulation, 0)
+	e.processControlChangeChannel(channel, ccExpression, 127)
+	for cc := uint8(sustainPedalCC); cc <= softPedalCC; cc++ {
+		e.processControlChangeChannel(channel, cc, 0)
+	}
+
+	state := &e.channelState[channel]
+	state.pitchBend = 0
+	state.channelAftertouch = 0
+	state.polyAftertouch = [128]uint8{}
+	state.rpn = rpnNull
+	if midiDebugEnabled {
+		midiDebug("Reset all controllers: channel=%d", channel+1)
+	}
+}
@@ -0,0 +1,167 @@
+package gosfzplayer
+
+import (
+	"math"
+	"testing"
+)
+
+const midiTestSfz = `<region>
+sample=sample1.wav
+ampeg_attack=0
+ampeg_release=1
+loop_mode=loop_continuous
+`
+
+func TestAllSoundOffAndAllNotesOff(t *testing.T) {
+	e := createTestEngine(t, midiTestSfz)
+
+	e.processMidiMessage([]byte{0x90, 60, 100})
+	e.processMidiMessage([]byte{0x91, 62, 100})
+	e.processMidiMessage([]byte{0xB0, 120, 0}) // All sound off, channel 1
+	if len(e.activeVoices) != 1 || e.activeVoices[0].channel != 1 {
+		t.Fatalf("Expected all sound off to cut only channel 1, got %d voices", len(e.activeVoices))
+	}
+
+	// All notes off releases held notes, but the sustain pedal still holds them
+	e.processMidiMessage([]byte{0xB1, 64, 127})
+	e.processMidiMessage([]byte{0xB1, 123, 0})
+	if e.activeVoices[0].envelopeState == EnvelopeRelease {
+		t.Error("Expected the sustain pedal to hold notes through all notes off")
+	}
+	e.processMidiMessage([]byte{0xB1, 64, 0})
+	if e.activeVoices[0].envelopeState != EnvelopeRelease {
+		t.Error("Expected the note to release with the pedal")
+	}
+}
+
+func TestResetAllControllers(t *testing.T) {
+	e := createTestEngine(t, midiTestSfz)
+	state := &e.channelState[0]
+
+	e.processMidiMessage([]byte{0xB0, 7, 64})
+	e.processMidiMessage([]byte{0xB0, 11, 32})
+	e.processMidiMessage([]byte{0xB0, 1, 100})
+	e.processMidiMessage([]byte{0xB0, 64, 127})
+	e.processMidiMessage([]byte{0xE0, 0, 127})
+	e.processMidiMessage([]byte{0xD0, 90})
+	e.processMidiMessage([]byte{0xB0, 121, 0})
+
+	if state.pitchBend != 0 || state.channelAftertouch != 0 {
+		t.Errorf("Expected bend and aftertouch to reset, got %d and %d", state.pitchBend, state.channelAftertouch)
+	}
+	if state.cc[1] != 0 || state.cc[64] != 0 || state.expression != 1.0 {
+		t.Errorf("Expected modulation, sustain and expression to reset, got %d %d %f",
+			state.cc[1], state.cc[64], state.expression)
+	}
+	if state.volume != controllerGain(64) {
+		t.Errorf("Expected channel volume to be kept, got %f", state.volume)
+	}
+}
+
+func TestChannelVolumeAndExpression(t *testing.T) {
+	full := createTestEngine(t, midiTestSfz)
+	full.noteOn(60, 100)
+	fullRMS := calculateRMS(renderTestFrames(full, 2048))
+
+	e := createTestEngine(t, midiTestSfz)
+	e.processMidiMessage([]byte{0xB0, 7, 127})
+	e.processMidiMessage([]byte{0xB0, 11, 64})
+	e.noteOn(60, 100)
+	rms := calculateRMS(renderTestFrames(e, 2048))
+
+	expected := fullRMS * controllerGain(64)
+	if math.Abs(rms-expected) > expected*1e-3 {
+		t.Errorf("Expected expression 64 to scale the output to %f, got %f", expected, rms)
+	}
+
+	if got := controllerGain(127); got != 1.0 {
+		t.Errorf("Expected full-level controllers to be unity gain, got %f", got)
+	}
+}
+
+func TestRPNBendRange(t *testing.T) {
+	e := createTestEngine(t, midiTestSfz)
+
+	// RPN 0: 12 semitones, 50 cents
+	for _, message := range [][]byte{
+		{0xB0, 101, 0}, {0xB0, 100, 0}, {0xB0, 6, 12}, {0xB0, 38, 50},
+		{0xB0, 101, 127}, {0xB0, 100, 127}, {0xB0, 6, 2}, // Data entry after RPN null is ignored
+	} {
+		e.processMidiMessage(message)
+	}
+	if got := e.channelState[0].bendRange; got != 1250 {
+		t.Fatalf("Expected a bend range of 1250 cents, got %f", got)
+	}
+
+	e.noteOn(60, 100)
+	e.processMidiMessage([]byte{0xE0, 0x7F, 0x7F}) // Full up
+	if got := e.activeVoices[0].bend.target(); math.Abs(got-1250) > 1e-9 {
+		t.Errorf("Expected the RPN range to override bend_up, got %f cents", got)
+	}
+
+	// Bends on another channel don't affect this voice
+	e.processMidiMessage([]byte{0xE1, 0, 0})
+	if got := e.activeVoices[0].bend.target(); math.Abs(got-1250) > 1e-9 {
+		t.Errorf("Expected channel 2's bend to leave channel 1 alone, got %f cents", got)
+	}
+}
+
+func TestProgramChangeSwitchesInstrument(t *testing.T) {
+	rack := createTestRack(t, 1)
+	other := createTestEngine(t, `<region>
+sample=sample2.wav
+`)
+	if err := rack.SetProgram(128, other.player); err == nil {
+		t.Error("Expected an error for program 128")
+	}
+	rack.SetProgram(5, other.player)
+
+	engine := rack.parts[0].Load().engine
+	rack.ProcessMidi([]byte{0xB0, 0, 1}) // Bank select MSB
+	rack.ProcessMidi([]byte{0xC0, 4})    // Unassigned program: no change
+	rack.ProcessMidi([]byte{0x90, 60, 100})
+	rack.ProcessMidi([]byte{0xC0, 5})
+	rack.ProcessMidi([]byte{0x90, 62, 100})
+
+	if engine.channelState[0].program != 5 || engine.channelState[0].bank != 1<<7 {
+		t.Errorf("Expected bank 128 program 5, got bank %d program %d", engine.channelState[0].bank, engine.channelState[0].program)
+	}
+	if got := engine.activeVoices[0].region.Sample; got != "sample1.wav" {
+		t.Errorf("Expected the first note on the original instrument, got %s", got)
+	}
+	if got := engine.activeVoices[1].region.Sample; got != "sample2.wav" {
+		t.Errorf("Expected the note after the program change on program 5, got %s", got)
+	}
+}
+
+func TestMidiStream(t *testing.T) {
+	var stream MidiStream
+	var messages [][]byte
+	collect := func(message []byte) {
+		messages = append(messages, append([]byte(nil), message...))
+	}
+
+	stream.Feed([]byte{
+		0x90, 60, 100, 62, // Note on, then running status split across Feed calls
+	}, collect)
+	stream.Feed([]byte{
+		90, 0xF8, 64, 80, // Real-time clock byte inside a message
+		0xF0, 0x7E, 0x01, 0xF7, // System exclusive is skipped and cancels running status
+		65, 70, // Dropped: no running status
+		0xC3, 10, 11, // Program change with running status
+		0xE0, 0x00, 0x40,
+	}, collect)
+
+	expected := [][]byte{
+		{0x90, 60, 100}, {0x90, 62, 90}, {0x90, 64, 80},
+		{0xC3, 10}, {0xC3, 11}, {0xE0, 0x00, 0x40},
+	}
+	if len(messages) != len(expected) {
+		t.Fatalf("Expected %d messages, got %v", len(expected), messages)
+	}
+	for i := range expected {
+		if string(messages[i]) != string(expected[i]) {
+			t.Errorf("Message %d: expected %v, got %v", i, expected[i], messages[i])
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package gosfzplayer
+
+// MidiStream splits a raw MIDI byte stream (from a serial port or raw MIDI device) into complete
+// channel messages. It handles running status, skips system exclusive data and ignores system
+// common and real-time messages, including real-time bytes interleaved within a message.
+type MidiStream struct {
+	status   byte    // Running status, 0 if none
+	message  [3]byte // Message being assembled
+	length   int     // Bytes of message received, including the status byte
+	expected int     // Total message length for the running status
+	sysex    bool    // Inside a system exclusive message
+}
+
+// Feed parses bytes from the stream, calling handle with each complete channel message.
+// The message slice is only valid during the call.
+func (s *MidiStream) Feed(data []byte, handle func(message []byte)) {
+	for _, b := range data {
+		switch {
+		case b >= 0xF8:
+			// Real-time messages may appear anywhere and don't affect running status
+			continue
+
+		case b >= 0xF0:
+			// System common messages cancel running status; sysex data runs until EOX (0xF7)
+			s.status = 0
+			s.length = 0
+			s.sysex = b == 0xF0
+
+		case b >= 0x80:
+			s.status = b
+			s.message[0] = b
+			s.length = 1
+			s.expected = channelMessageLength(b)
+			s.sysex = false
+
+		default:
+			if s.sysex || s.status == 0 {
+				continue
+			}
+
+			// Data byte; with running status a new message starts without a status byte
+			if s.length == 0 {
+				s.message[0] = s.status
+				s.length = 1
+			}
+			s.message[s.length] = b
+			s.length++
+			if s.length == s.expected {
+				handle(s.message[:s.length])
+				s.length = 0
+			}
+		}
+	}
+}
+
+// Reset discards running status and any partial message
+func (s *MidiStream) Reset() {
+	*s = MidiStream{}
+}
+
+// channelMessageLength returns the length of a channel message, including its status byte
+func channelMessageLength(status byte) int {
+	switch status & 0xF0 {
+	case midiProgramChange, midiChannelAftertouch:
+		return 2
+	}
+	return 3
+}
@@ -0,0 +1,487 @@
+package gosfzplayer
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/GeoffreyPlitt/debuggo"
+)
+
+var modDebug = debuggo.Debug("sfzplayer:modulation")
+
+// ModTarget identifies a voice parameter that modulation sources can drive
+type ModTarget int
+
+const (
+	ModTargetVolume    ModTarget = iota // Volume offset in dB
+	ModTargetPan                        // Pan offset in percent
+	ModTargetPitch                      // Pitch offset in cents
+	ModTargetCutoff                     // Filter cutoff offset in cents
+	ModTargetResonance                  // Filter resonance offset in dB
+	numModTargets
+)
+
+// modTargetNames maps SFZ opcode stems to modulation targets
+var modTargetNames = map[string]ModTarget{
+	"volume":    ModTargetVolume,
+	"pan":       ModTargetPan,
+	"pitch":     ModTargetPitch,
+	"tune":      ModTargetPitch, // SFZ v2 spelling of pitch_oncc
+	"cutoff":    ModTargetCutoff,
+	"resonance": ModTargetResonance,
+}
+
+// minModSmoothTime is the smoothing applied to every CC route (in seconds),
+// just long enough to hide the 7-bit steps of MIDI controllers
+const minModSmoothTime = 0.005
+
+// Opcode patterns for SFZ v2 modulation routing
+var (
+	// <target>_onccN, <target>_curveccN, <target>_smoothccN, <target>_stepccN
+	ccRouteOpcodePattern = regexp.MustCompile(`^((?:lfo\d+_|eg\d+_)?[a-z]+)_(on|curve|smooth|step)cc(\d+)$`)
+	// lfoN_freq, lfoN_wave, lfoN_pitch, ...
+	lfoOpcodePattern = regexp.MustCompile(`^lfo(\d+)_([a-z]+)$`)
+	// egN_timeK, egN_levelK, egN_shapeK, egN_sustain, egN_pitch, ...
+	egOpcodePattern = regexp.MustCompile(`^eg(\d+)_([a-z]+?)(\d*)$`)
+)
+
+// isModulationOpcode checks if an opcode is one of the numbered modulation opcodes
+func isModulationOpcode(opcode string) bool {
+	if m := ccRouteOpcodePattern.FindStringSubmatch(opcode); m != nil {
+		return isModDestination(m[1])
+	}
+	if m := lfoOpcodePattern.FindStringSubmatch(opcode); m != nil {
+		switch m[2] {
+		case "freq", "wave", "delay", "fade", "phase":
+			return true
+		}
+		_, ok := modTargetNames[m[2]]
+		return ok
+	}
+	if m := egOpcodePattern.FindStringSubmatch(opcode); m != nil {
+		switch m[2] {
+		case "time", "level", "shape":
+			return m[3] != ""
+		case "sustain":
+			return m[3] == ""
+		}
+		_, ok := modTargetNames[m[2]]
+		return ok && m[3] == ""
+	}
+	return false
+}
+
+// isModDestination checks if a stem like "cutoff", "lfo01_pitch" or "lfo01_freq" can receive CC routes
+func isModDestination(stem string) bool {
+	if _, ok := modTargetNames[stem]; ok {
+		return true
+	}
+	if m := lfoOpcodePattern.FindStringSubmatch(stem); m != nil {
+		if m[2] == "freq" {
+			return true
+		}
+		_, ok := modTargetNames[m[2]]
+		return ok
+	}
+	if m := egOpcodePattern.FindStringSubmatch(stem); m != nil && m[3] == "" {
+		_, ok := modTargetNames[m[2]]
+		return ok
+	}
+	return false
+}
+
+// ccRoute describes how a MIDI CC value is shaped before it reaches a destination
+type ccRoute struct {
+	cc     int     // MIDI CC number (0-127)
+	depth  float64 // Amount at CC value 127, in destination units
+	curve  *Curve  // Curve applied to the normalized CC value
+	smooth float64 // Smoothing time in seconds
+	step   float64 // Quantization step in destination units (0 = continuous)
+}
+
+// lfoSpec describes an SFZ v2 LFO and its routing
+type lfoSpec struct {
+	freq    float64 // Frequency in Hz
+	freqCC  []ccRoute
+	wave    int     // Waveform (0=triangle, 1=sine, 2=pulse 75%, 3=square, 4=pulse 25%, 5=pulse 12.5%, 6=saw up, 7=saw down)
+	delay   float64 // Delay before the LFO starts, in seconds
+	fade    float64 // Fade-in time after the delay, in seconds
+	phase   float64 // Initial phase (0.0 to 1.0)
+	depth   [numModTargets]float64
+	depthCC [numModTargets][]ccRoute
+}
+
+// egPoint is one breakpoint of a flex envelope
+type egPoint struct {
+	time  float64 // Time from the previous point, in seconds
+	level float64 // Level at this point (-1.0 to 1.0)
+	shape float64 // Curvature of the segment leading to this point (0 = linear)
+}
+
+// flexEGSpec describes an SFZ v2 flex envelope and its routing
+type flexEGSpec struct {
+	points  []egPoint
+	sustain int // Point held while the note is on
+	depth   [numModTargets]float64
+	depthCC [numModTargets][]ccRoute
+}
+
+// modSpec is the modulation routing compiled from a region's opcodes
+type modSpec struct {
+	ccRoutes [numModTargets][]ccRoute
+	lfos     []*lfoSpec
+	egs      []*flexEGSpec
+}
+
+// isEmpty reports whether the spec contains no routing at all
+func (ms *modSpec) isEmpty() bool {
+	for _, routes := range ms.ccRoutes {
+		if len(routes) > 0 {
+			return false
+		}
+	}
+	return len(ms.lfos) == 0 && len(ms.egs) == 0
+}
+
+// compileModSpec collects the modulation opcodes of a region (with inheritance) into a modSpec,
+// resolving _curveccN indexes against the given curve set
+func compileModSpec(region *SfzSection, curves *CurveSet) *modSpec {
+	spec := &modSpec{}
+	opcodes := region.inheritedOpcodes()
+
+	// Sort opcode names so routes are built in a stable order
+	names := make([]string, 0, len(opcodes))
+	for name := range opcodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lfos := make(map[int]*lfoSpec)
+	egs := make(map[int]*flexEGSpec)
+	routes := make(map[string]map[int]*ccRoute) // stem -> cc -> route
+
+	getLFO := func(index int) *lfoSpec {
+		if lfo, exists := lfos[index]; exists {
+			return lfo
+		}
+		lfo := &lfoSpec{wave: 1}
+		lfos[index] = lfo
+		return lfo
+	}
+	getEG := func(index int) *flexEGSpec {
+		if eg, exists := egs[index]; exists {
+			return eg
+		}
+		eg := &flexEGSpec{sustain: -1}
+		egs[index] = eg
+		return eg
+	}
+	getPoint := func(eg *flexEGSpec, index int) *egPoint {
+		for len(eg.points) <= index {
+			eg.points = append(eg.points, egPoint{})
+		}
+		return &eg.points[index]
+	}
+
+	for _, name := range names {
+		value := opcodes[name]
+
+		if m := ccRouteOpcodePattern.FindStringSubmatch(name); m != nil && isModDestination(m[1]) {
+			cc, err := strconv.Atoi(m[3])
+			if err != nil || cc < 0 || cc > 127 {
+				modDebug("Warning: Invalid CC number in opcode %s", name)
+				continue
+			}
+			if routes[m[1]] == nil {
+				routes[m[1]] = make(map[int]*ccRoute)
+			}
+			route, exists := routes[m[1]][cc]
+			if !exists {
+				route = &ccRoute{cc: cc, curve: curves.Get(0)}
+				routes[m[1]][cc] = route
+			}
+			switch m[2] {
+			case "on":
+				route.depth = convertToFloat(value, name, 0)
+			case "curve":
+				route.curve = curves.Get(convertToInt(value, name, 0))
+			case "smooth":
+				route.smooth = convertToFloat(value, name, 0) / 1000.0 // ms to seconds
+			case "step":
+				route.step = math.Abs(convertToFloat(value, name, 0))
+			}
+			continue
+		}
+
+		if m := lfoOpcodePattern.FindStringSubmatch(name); m != nil {
+			index, _ := strconv.Atoi(m[1])
+			lfo := getLFO(index)
+			switch m[2] {
+			case "freq":
+				lfo.freq = convertToFloat(value, name, 0)
+			case "wave":
+				lfo.wave = convertToInt(value, name, 1)
+			case "delay":
+				lfo.delay = convertToFloat(value, name, 0)
+			case "fade":
+				lfo.fade = convertToFloat(value, name, 0)
+			case "phase":
+				lfo.phase = convertToFloat(value, name, 0)
+			default:
+				if target, ok := modTargetNames[m[2]]; ok {
+					lfo.depth[target] = convertToFloat(value, name, 0)
+				}
+			}
+			continue
+		}
+
+		if m := egOpcodePattern.FindStringSubmatch(name); m != nil {
+			index, _ := strconv.Atoi(m[1])
+			eg := getEG(index)
+			point, _ := strconv.Atoi(m[3])
+			switch m[2] {
+			case "time":
+				getPoint(eg, point).time = convertToFloat(value, name, 0)
+			case "level":
+				getPoint(eg, point).level = convertToFloat(value, name, 0)
+			case "shape":
+				getPoint(eg, point).shape = convertToFloat(value, name, 0)
+			case "sustain":
+				eg.sustain = convertToInt(value, name, -1)
+			default:
+				if target, ok := modTargetNames[m[2]]; ok && m[3] == "" {
+					eg.depth[target] = convertToFloat(value, name, 0)
+				}
+			}
+		}
+	}
+
+	// Attach the CC routes to their destinations
+	for stem, byCC := range routes {
+		ccs := make([]int, 0, len(byCC))
+		for cc := range byCC {
+			ccs = append(ccs, cc)
+		}
+		sort.Ints(ccs)
+
+		for _, cc := range ccs {
+			route := *byCC[cc]
+			if route.depth == 0 {
+				continue // curve/smooth/step without an amount has no effect
+			}
+
+			if target, ok := modTargetNames[stem]; ok {
+				spec.ccRoutes[target] = append(spec.ccRoutes[target], route)
+			} else if m := lfoOpcodePattern.FindStringSubmatch(stem); m != nil {
+				index, _ := strconv.Atoi(m[1])
+				lfo := getLFO(index)
+				if m[2] == "freq" {
+					lfo.freqCC = append(lfo.freqCC, route)
+				} else {
+					target := modTargetNames[m[2]]
+					lfo.depthCC[target] = append(lfo.depthCC[target], route)
+				}
+			} else if m := egOpcodePattern.FindStringSubmatch(stem); m != nil {
+				index, _ := strconv.Atoi(m[1])
+				eg := getEG(index)
+				target := modTargetNames[m[2]]
+				eg.depthCC[target] = append(eg.depthCC[target], route)
+			}
+		}
+	}
+
+	// Keep generators in index order
+	lfoIndexes := make([]int, 0, len(lfos))
+	for index := range lfos {
+		lfoIndexes = append(lfoIndexes, index)
+	}
+	sort.Ints(lfoIndexes)
+	for _, index := range lfoIndexes {
+		spec.lfos = append(spec.lfos, lfos[index])
+	}
+
+	egIndexes := make([]int, 0, len(egs))
+	for index := range egs {
+		egIndexes = append(egIndexes, index)
+	}
+	sort.Ints(egIndexes)
+	for _, index := range egIndexes {
+		spec.egs = append(spec.egs, egs[index])
+	}
+
+	modDebug("Compiled modulation: %d LFOs, %d EGs", len(spec.lfos), len(spec.egs))
+	return spec
+}
+
+// builtinCurve evaluates one of the SFZ v2 default curves at x (0.0 to 1.0)
+func builtinCurve(index int, x float64) float64 {
+	switch index {
+	case 1: // Bipolar, -1 to 1
+		return 2.0*x - 1.0
+	case 2: // Inverted, 1 to 0
+		return 1.0 - x
+	case 3: // Inverted bipolar, 1 to -1
+		return 1.0 - 2.0*x
+	case 4: // Concave
+		return x * x
+	case 5: // Convex
+		return math.Sqrt(x)
+	case 6: // Inverted convex
+		return math.Sqrt(1.0 - x)
+	default: // Linear, 0 to 1
+		return x
+	}
+}
+
+// ccModulator evaluates a CC route with smoothing for a single voice
+type ccModulator struct {
+	route       ccRoute
+	coefficient float64 // One-pole smoothing coefficient
+	current     float64
+	started     bool
+}
+
+// newCCModulator creates the per-voice state for a CC route
+func newCCModulator(route ccRoute, sampleRate float64) ccModulator {
+	if route.curve == nil {
+		route.curve = linearCurve
+	}
+	smooth := math.Max(route.smooth, minModSmoothTime)
+	return ccModulator{
+		route:       route,
+		coefficient: math.Exp(-1.0 / (smooth * sampleRate)),
+	}
+}
+
+// target returns the unsmoothed route output for the current CC value
+func (cm *ccModulator) target(ccValues *[128]float64) float64 {
+	value := cm.route.curve.Evaluate(ccValues[cm.route.cc]) * cm.route.depth
+	if cm.route.step > 0 {
+		value = math.Round(value/cm.route.step) * cm.route.step
+	}
+	return value
+}
+
+// Process advances the smoother by one sample and returns the route output
+func (cm *ccModulator) Process(ccValues *[128]float64) float64 {
+	target := cm.target(ccValues)
+	if !cm.started {
+		// Start at the current value so notes don't glide in from zero
+		cm.current = target
+		cm.started = true
+		return cm.current
+	}
+	cm.current = target + cm.coefficient*(cm.current-target)
+	return cm.current
+}
+
+// voiceModulation holds the modulation state of one voice
+type voiceModulation struct {
+	spec     *modSpec
+	ccValues *[128]float64
+	ccMods   [numModTargets][]ccModulator
+	lfos     []lfoState
+	egs      []flexEGState
+}
+
+// InitializeModulation sets up the modulation sources of a voice from a compiled spec,
+// reusing the voice's buffers from its previous note
+func (v *Voice) InitializeModulation(spec *modSpec, sampleRate uint32, ccValues *[128]float64) {
+	m := &v.modulation
+	m.spec = nil
+	m.ccValues = nil
+	for target := range m.ccMods {
+		m.ccMods[target] = m.ccMods[target][:0]
+	}
+	m.lfos = m.lfos[:0]
+	m.egs = m.egs[:0]
+	if spec == nil || spec.isEmpty() {
+		return
+	}
+
+	rate := float64(sampleRate)
+	m.spec = spec
+	m.ccValues = ccValues
+
+	for target, routes := range spec.ccRoutes {
+		for _, route := range routes {
+			m.ccMods[target] = append(m.ccMods[target], newCCModulator(route, rate))
+		}
+	}
+	for _, lfo := range spec.lfos {
+		m.lfos = extend(m.lfos)
+		m.lfos[len(m.lfos)-1].init(lfo, rate)
+	}
+	for _, eg := range spec.egs {
+		m.egs = extend(m.egs)
+		m.egs[len(m.egs)-1].init(eg, rate)
+	}
+
+	if voiceDebugEnabled {
+		voiceDebug("Initialized modulation for note %d: %d LFOs, %d EGs", v.midiNote, len(m.lfos), len(m.egs))
+	}
+}
+
+// initDepthMods rebuilds per-target CC modulators in place, reusing the slices' capacity
+func initDepthMods(mods [numModTargets][]ccModulator, routes [numModTargets][]ccRoute, sampleRate float64) [numModTargets][]ccModulator {
+	for target := range mods {
+		mods[target] = mods[target][:0]
+		for _, route := range routes[target] {
+			mods[target] = append(mods[target], newCCModulator(route, sampleRate))
+		}
+	}
+	return mods
+}
+
+// extend grows a slice by one element, reusing its capacity (and the old element's buffers) when it can
+func extend[T any](s []T) []T {
+	if len(s) < cap(s) {
+		return s[:len(s)+1]
+	}
+	var zero T
+	return append(s, zero)
+}
+
+// ProcessModulation advances all modulation sources by one sample and returns the summed target offsets
+func (v *Voice) ProcessModulation() [numModTargets]float64 {
+	var offsets [numModTargets]float64
+	m := &v.modulation
+	if m.spec == nil {
+		return offsets
+	}
+
+	for target := range m.ccMods {
+		for i := range m.ccMods[target] {
+			offsets[target] += m.ccMods[target][i].Process(m.ccValues)
+		}
+	}
+
+	for i := range m.lfos {
+		lfo := &m.lfos[i]
+		value := lfo.Process(m.ccValues)
+		for target := range offsets {
+			offsets[target] += value * lfo.Depth(ModTarget(target), m.ccValues)
+		}
+	}
+
+	for i := range m.egs {
+		eg := &m.egs[i]
+		value := eg.Process(v.noteOn)
+		for target := range offsets {
+			offsets[target] += value * eg.Depth(ModTarget(target), m.ccValues)
+		}
+	}
+
+	return offsets
+}
+
+// sumCCModulators processes a list of CC modulators and returns their total
+func sumCCModulators(mods []ccModulator, ccValues *[128]float64) float64 {
+	total := 0.0
+	for i := range mods {
+		total += mods[i].Process(ccValues)
+	}
+	return total
+}
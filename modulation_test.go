@@ -0,0 +1,374 @@
+package gosfzplayer
+
+import (
+	"math"
+	"testing"
+)
+
+// TestParseModulationOpcodes tests that numbered modulation opcodes are recognized
+func TestParseModulationOpcodes(t *testing.T) {
+	tests := []struct {
+		opcode string
+		want   bool
+	}{
+		{"volume_oncc7", true},
+		{"pan_oncc10", true},
+		{"pitch_oncc1", true},
+		{"tune_oncc1", true},
+		{"cutoff_oncc74", true},
+		{"volume_curvecc7", true},
+		{"cutoff_smoothcc74", true},
+		{"pitch_stepcc1", true},
+		{"lfo01_freq", true},
+		{"lfo1_wave", true},
+		{"lfo01_pitch", true},
+		{"lfo01_pitch_oncc1", true},
+		{"lfo02_freq_oncc2", true},
+		{"eg01_time1", true},
+		{"eg01_level2", true},
+		{"eg01_shape1", true},
+		{"eg01_sustain", true},
+		{"eg01_cutoff", true},
+		{"eg01_cutoff_oncc1", true},
+		{"fil_type", true},
+		{"cutoff", true},
+		{"resonance", true},
+
+		{"bogus_oncc1", false},
+		{"lfo01_bogus", false},
+		{"eg01_time", false},
+		{"eg01_sustain2", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.opcode, func(t *testing.T) {
+			if got := isKnownOpcode(tt.opcode); got != tt.want {
+				t.Errorf("isKnownOpcode(%q) = %v, want %v", tt.opcode, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileModSpec(t *testing.T) {
+	group := &SfzSection{
+		Type: "group",
+		Opcodes: map[string]string{
+			"volume_oncc7":     "-12",
+			"volume_curvecc7":  "2",
+			"volume_smoothcc7": "50",
+		},
+	}
+	region := &SfzSection{
+		Type: "region",
+		Opcodes: map[string]string{
+			"pitch_oncc1":       "100",
+			"pitch_stepcc1":     "50",
+			"lfo01_freq":        "5",
+			"lfo01_pitch":       "50",
+			"lfo01_pitch_oncc1": "100",
+			"eg01_time1":        "0.1",
+			"eg01_level1":       "1",
+			"eg01_sustain":      "1",
+			"eg01_cutoff":       "1200",
+			"cutoff_curvecc74":  "4", // No amount, should be dropped
+		},
+		ParentGroup: group,
+	}
+
+	spec := compileModSpec(region, NewCurveSet(nil))
+
+	volumeRoutes := spec.ccRoutes[ModTargetVolume]
+	if len(volumeRoutes) != 1 {
+		t.Fatalf("Expected 1 volume route, got %d", len(volumeRoutes))
+	}
+	if route := volumeRoutes[0]; route.cc != 7 || route.depth != -12 || math.Abs(route.smooth-0.05) > 1e-9 {
+		t.Errorf("Unexpected volume route: %+v", route)
+	}
+
+	if got := volumeRoutes[0].curve.Evaluate(0.25); math.Abs(got-0.75) > 1e-9 {
+		t.Errorf("Expected volume route to use inverted curve 2, got %f at 0.25", got)
+	}
+
+	pitchRoutes := spec.ccRoutes[ModTargetPitch]
+	if len(pitchRoutes) != 1 || pitchRoutes[0].step != 50 {
+		t.Errorf("Expected pitch route with step 50, got %+v", pitchRoutes)
+	}
+
+	if len(spec.ccRoutes[ModTargetCutoff]) != 0 {
+		t.Errorf("Expected curve-only cutoff route to be dropped, got %+v", spec.ccRoutes[ModTargetCutoff])
+	}
+
+	if len(spec.lfos) != 1 {
+		t.Fatalf("Expected 1 LFO, got %d", len(spec.lfos))
+	}
+	lfo := spec.lfos[0]
+	if lfo.freq != 5 || lfo.depth[ModTargetPitch] != 50 || len(lfo.depthCC[ModTargetPitch]) != 1 {
+		t.Errorf("Unexpected LFO spec: %+v", lfo)
+	}
+
+	if len(spec.egs) != 1 {
+		t.Fatalf("Expected 1 EG, got %d", len(spec.egs))
+	}
+	eg := spec.egs[0]
+	if len(eg.points) != 2 || eg.points[1].level != 1 || eg.sustain != 1 || eg.depth[ModTargetCutoff] != 1200 {
+		t.Errorf("Unexpected EG spec: %+v", eg)
+	}
+}
+
+func TestBuiltinCurves(t *testing.T) {
+	tests := []struct {
+		curve    int
+		x        float64
+		expected float64
+	}{
+		{0, 0.5, 0.5},
+		{1, 0.0, -1.0},
+		{1, 1.0, 1.0},
+		{2, 0.25, 0.75},
+		{3, 1.0, -1.0},
+		{4, 0.5, 0.25},
+		{5, 0.25, 0.5},
+		{6, 0.75, 0.5},
+	}
+
+	for _, tt := range tests {
+		if got := builtinCurve(tt.curve, tt.x); math.Abs(got-tt.expected) > 1e-9 {
+			t.Errorf("builtinCurve(%d, %.2f) = %f, want %f", tt.curve, tt.x, got, tt.expected)
+		}
+	}
+}
+
+func TestCCModulatorSmoothing(t *testing.T) {
+	var ccValues [128]float64
+	mod := newCCModulator(ccRoute{cc: 1, depth: 100, smooth: 0.01}, 44100)
+
+	// First sample starts at the current value
+	if got := mod.Process(&ccValues); got != 0 {
+		t.Errorf("Expected initial value 0, got %f", got)
+	}
+
+	// A jump in the CC value should be approached gradually
+	ccValues[1] = 1.0
+	first := mod.Process(&ccValues)
+	if first <= 0 || first >= 10 {
+		t.Errorf("Expected a small first step towards 100, got %f", first)
+	}
+
+	var value float64
+	for i := 0; i < 44100/5; i++ {
+		value = mod.Process(&ccValues)
+	}
+	if math.Abs(value-100) > 0.01 {
+		t.Errorf("Expected smoother to settle at 100, got %f", value)
+	}
+}
+
+func TestCCModulatorStep(t *testing.T) {
+	var ccValues [128]float64
+	mod := newCCModulator(ccRoute{cc: 1, depth: 1200, step: 100}, 44100)
+
+	ccValues[1] = 64.0 / 127.0 // ~604.7 cents unquantized
+	if got := mod.target(&ccValues); got != 600 {
+		t.Errorf("Expected stepped value 600, got %f", got)
+	}
+}
+
+func TestLFOWaveforms(t *testing.T) {
+	tests := []struct {
+		name     string
+		wave     int
+		phase    float64
+		expected float64
+	}{
+		{"triangle peak", 0, 0.25, 1.0},
+		{"triangle trough", 0, 0.75, -1.0},
+		{"sine quarter", 1, 0.25, 1.0},
+		{"square high", 3, 0.25, 1.0},
+		{"square low", 3, 0.75, -1.0},
+		{"pulse 25% low", 4, 0.5, -1.0},
+		{"saw up start", 6, 0.0, -1.0},
+		{"saw down start", 7, 0.0, 1.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lfoWaveform(tt.wave, tt.phase); math.Abs(got-tt.expected) > 1e-9 {
+				t.Errorf("lfoWaveform(%d, %.3f) = %f, want %f", tt.wave, tt.phase, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLFODelayAndFade(t *testing.T) {
+	var ccValues [128]float64
+	spec := &lfoSpec{freq: 10, wave: 3, delay: 0.1, fade: 0.1}
+	lfo := newLFOState(spec, 1000)
+
+	// Silent during the delay
+	for i := 0; i < 99; i++ {
+		if value := lfo.Process(&ccValues); value != 0 {
+			t.Fatalf("Expected LFO to be silent during delay, got %f at sample %d", value, i)
+		}
+	}
+
+	// Partially faded in halfway through the fade
+	var value float64
+	for i := 0; i < 50; i++ {
+		value = lfo.Process(&ccValues)
+	}
+	if math.Abs(value) >= 0.9 || value == 0 {
+		t.Errorf("Expected partial LFO output during fade, got %f", value)
+	}
+
+	// Full scale after the fade
+	for i := 0; i < 100; i++ {
+		value = lfo.Process(&ccValues)
+	}
+	if math.Abs(value) != 1.0 {
+		t.Errorf("Expected full-scale square wave after fade, got %f", value)
+	}
+}
+
+func TestFlexEGSustainAndRelease(t *testing.T) {
+	spec := &flexEGSpec{
+		points: []egPoint{
+			{level: 0},
+			{time: 0.01, level: 1.0},
+			{time: 0.01, level: 0.5},
+			{time: 0.01, level: 0.0},
+		},
+		sustain: 2,
+	}
+	eg := newFlexEGState(spec, 1000)
+
+	// Attack and decay to the sustain point
+	var level float64
+	for i := 0; i < 50; i++ {
+		level = eg.Process(true)
+	}
+	if math.Abs(level-0.5) > 1e-9 {
+		t.Errorf("Expected EG to hold at sustain level 0.5, got %f", level)
+	}
+
+	// Release to the final point
+	for i := 0; i < 50; i++ {
+		level = eg.Process(false)
+	}
+	if math.Abs(level) > 1e-9 {
+		t.Errorf("Expected EG to release to 0, got %f", level)
+	}
+}
+
+func TestVoiceFilterLowpass(t *testing.T) {
+	lowTone := NewVoiceFilter("lpf_2p", 500, 0, 44100)
+	highTone := NewVoiceFilter("lpf_2p", 500, 0, 44100)
+
+	var lowPeak, highPeak float64
+	for i := 0; i < 44100/10; i++ {
+		low := lowTone.Process(math.Sin(2*math.Pi*100*float64(i)/44100), 0, 0)
+		high := highTone.Process(math.Sin(2*math.Pi*8000*float64(i)/44100), 0, 0)
+		if i > 1000 {
+			lowPeak = math.Max(lowPeak, math.Abs(low))
+			highPeak = math.Max(highPeak, math.Abs(high))
+		}
+	}
+
+	if lowPeak < 0.9 {
+		t.Errorf("Expected 100Hz to pass a 500Hz lowpass, peak %f", lowPeak)
+	}
+	if highPeak > 0.05 {
+		t.Errorf("Expected 8kHz to be attenuated by a 500Hz lowpass, peak %f", highPeak)
+	}
+
+	// Raising the cutoff by 6 octaves via modulation should let 8kHz through
+	modulated := NewVoiceFilter("lpf_2p", 500, 0, 44100)
+	var modPeak float64
+	for i := 0; i < 44100/10; i++ {
+		out := modulated.Process(math.Sin(2*math.Pi*8000*float64(i)/44100), 7200, 0)
+		if i > 1000 {
+			modPeak = math.Max(modPeak, math.Abs(out))
+		}
+	}
+	if modPeak < 0.5 {
+		t.Errorf("Expected cutoff modulation to open the filter, peak %f", modPeak)
+	}
+}
+
+func TestVolumeOnCCRendering(t *testing.T) {
+	e := createTestEngine(t, `<region>
+sample=sample1.wav
+key=60
+pitch_keycenter=60
+ampeg_attack=0
+volume_oncc7=-20
+`)
+
+	// CC7 at 0: no attenuation
+	e.noteOn(60, 127)
+	quiet := renderTestFrames(e, 4410)
+	loudRMS := calculateRMS(quiet)
+
+	// CC7 at 127: -20dB, the voice's smoother settles within a few milliseconds
+	e.processControlChange(7, 127)
+	renderTestFrames(e, 2205)
+	attenuated := renderTestFrames(e, 4410)
+	attenuatedRMS := calculateRMS(attenuated)
+
+	if loudRMS == 0 {
+		t.Fatal("Expected audible output before CC change")
+	}
+
+	ratio := attenuatedRMS / loudRMS
+	if math.Abs(ratio-0.1) > 0.02 {
+		t.Errorf("Expected volume_oncc7=-20 at CC127 to scale output by 0.1, got %f", ratio)
+	}
+}
+
+func TestPitchLFORendering(t *testing.T) {
+	e := createTestEngine(t, `<region>
+sample=sample1.wav
+key=60
+pitch_keycenter=60
+lfo01_freq=5
+lfo01_pitch=1200
+`)
+
+	e.noteOn(60, 100)
+	if len(e.activeVoices) != 1 {
+		t.Fatalf("Expected 1 active voice, got %d", len(e.activeVoices))
+	}
+	voice := e.activeVoices[0]
+
+	// With a one-octave vibrato the voice advances faster than 1 sample per frame on the up swing
+	renderTestFrames(e, 2205) // Quarter LFO cycle
+	if voice.position <= 2205*1.2 {
+		t.Errorf("Expected LFO pitch modulation to speed up playback, position %f", voice.position)
+	}
+}
+
+func TestPanLFORendering(t *testing.T) {
+	e := createTestEngine(t, `<region>
+sample=sample1.wav
+key=60
+effect1=0
+lfo01_freq=5
+lfo01_pan=100
+`)
+
+	// Each half of the LFO cycle pulls the voice to one side
+	e.noteOn(60, 100)
+	left := make([]float32, 4410)
+	right := make([]float32, 4410)
+	balance := func() float64 {
+		clear(left)
+		clear(right)
+		e.renderStereoEvents(left, right, nil)
+		return calculateRMS(right) / calculateRMS(left)
+	}
+	if ratio := balance(); ratio < 1.5 {
+		t.Errorf("Expected the rising half of the LFO to pan the voice right, got right/left %f", ratio)
+	}
+	if ratio := balance(); ratio > 1/1.5 {
+		t.Errorf("Expected the falling half of the LFO to pan the voice left, got right/left %f", ratio)
+	}
+}
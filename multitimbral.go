@@ -0,0 +1,250 @@
+package gosfzplayer
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+
+	"github.com/GeoffreyPlitt/debuggo"
+)
+
+var multiDebug = debuggo.Debug("sfzplayer:multi")
+
+// partEventCapacity is the number of events per block a part can receive before its scratch list grows
+const partEventCapacity = 256
+
+// MaxParts is the number of instruments a multitimbral rack can host, one per MIDI channel
+const MaxParts = 16
+
+// part is one instrument in a multitimbral rack. Its mixer settings are atomics so they can be
+// changed from any goroutine while the audio thread renders.
+type part struct {
+	engine *engine
+	volume atomic.Uint64 // Part volume in dB (float64 bits)
+	pan    atomic.Uint64 // Part pan, -1.0 left to 1.0 right (float64 bits)
+	mute   atomic.Bool
+	buffer []float32 // Scratch buffers the part renders into in stereo, owned by the audio thread
+	right  []float32
+	events []MidiEvent // Scratch list of the block's events on the part's channel
+}
+
+// Multitimbral hosts up to 16 SfzPlayer instruments, each on its own MIDI channel,
+// and mixes them to a stereo output with per-part volume, pan and mute
+type Multitimbral struct {
+	sampleRate uint32
+	parts      [MaxParts]atomic.Pointer[part]
+	programs   [128]atomic.Pointer[SfzPlayer] // Instruments selected by program change
+	master     *Master                        // Master bus stage for the mix of the parts
+	stage      masterStage
+}
+
+// NewMultitimbral creates an empty multitimbral rack rendering at the given sample rate
+func NewMultitimbral(sampleRate uint32) *Multitimbral {
+	return &Multitimbral{
+		sampleRate: sampleRate,
+		master:     NewMaster(),
+		stage:      newMasterStage(sampleRate),
+	}
+}
+
+// Master returns the rack's master bus stage, which processes the mix of all parts after each
+// part's own player's master stage
+func (m *Multitimbral) Master() *Master {
+	return m.master
+}
+
+// partIndex converts a MIDI channel (1-16) to a part index
+func partIndex(channel int) (int, error) {
+	if channel < 1 || channel > MaxParts {
+		return 0, fmt.Errorf("invalid MIDI channel %d (must be 1-%d)", channel, MaxParts)
+	}
+	return channel - 1, nil
+}
+
+// SetPart assigns an instrument to a MIDI channel (1-16), replacing any previous part
+func (m *Multitimbral) SetPart(channel int, player *SfzPlayer) error {
+	index, err := partIndex(channel)
+	if err != nil {
+		return err
+	}
+	if player == nil {
+		return fmt.Errorf("player must not be nil")
+	}
+
+	p := &part{
+		engine: newEngine(player, m.sampleRate),
+		events: make([]MidiEvent, 0, partEventCapacity),
+		buffer: make([]float32, maxBlockFrames),
+		right:  make([]float32, maxBlockFrames),
+	}
+
+	// Program changes can switch the part to any of the rack's programs
+	for i := range m.programs {
+		if program := m.programs[i].Load(); program != nil {
+			p.engine.attach(program)
+		}
+	}
+	p.engine.onProgramChange = func(channel uint8, bank uint16, program uint8) {
+		if player := m.programs[program].Load(); player != nil {
+			p.engine.setPlayer(player)
+		} else {
+			p.engine.selectPreset(channel, bank, program)
+		}
+	}
+	if previous := m.parts[index].Swap(p); previous != nil {
+		previous.engine.close()
+	}
+	multiDebug("Part %d assigned", channel)
+	return nil
+}
+
+// RemovePart removes the instrument on a MIDI channel (1-16)
+func (m *Multitimbral) RemovePart(channel int) error {
+	index, err := partIndex(channel)
+	if err != nil {
+		return err
+	}
+
+	if previous := m.parts[index].Swap(nil); previous != nil {
+		previous.engine.close()
+	}
+	multiDebug("Part %d removed", channel)
+	return nil
+}
+
+// SetProgram assigns an instrument to a program number (0-127): a program change on any channel
+// switches that channel's part to it. Sounding notes finish on the previous instrument. A nil
+// player clears the program, so program changes to it fall back to the part's own presets.
+func (m *Multitimbral) SetProgram(program int, player *SfzPlayer) error {
+	if program < 0 || program > 127 {
+		return fmt.Errorf("invalid program %d (must be 0-127)", program)
+	}
+	if player != nil {
+		player.useSampleRate(m.sampleRate)
+		for i := range m.parts {
+			if p := m.parts[i].Load(); p != nil {
+				p.engine.attach(player)
+			}
+		}
+	}
+	m.programs[program].Store(player)
+	multiDebug("Program %d assigned", program)
+	return nil
+}
+
+// withPart runs fn on the part for a MIDI channel
+func (m *Multitimbral) withPart(channel int, fn func(p *part)) error {
+	index, err := partIndex(channel)
+	if err != nil {
+		return err
+	}
+
+	p := m.parts[index].Load()
+	if p == nil {
+		return fmt.Errorf("no part on MIDI channel %d", channel)
+	}
+	fn(p)
+	return nil
+}
+
+// SetPartVolume sets a part's volume in dB
+func (m *Multitimbral) SetPartVolume(channel int, volume float64) error {
+	return m.withPart(channel, func(p *part) {
+		p.volume.Store(math.Float64bits(clampFloat64(volume, -144.0, 24.0)))
+	})
+}
+
+// SetPartPan sets a part's pan position (-1.0 left to 1.0 right)
+func (m *Multitimbral) SetPartPan(channel int, pan float64) error {
+	return m.withPart(channel, func(p *part) {
+		p.pan.Store(math.Float64bits(clampFloat64(pan, -1.0, 1.0)))
+	})
+}
+
+// SetPartMute mutes or unmutes a part
+func (m *Multitimbral) SetPartMute(channel int, mute bool) error {
+	return m.withPart(channel, func(p *part) {
+		p.mute.Store(mute)
+	})
+}
+
+// ProcessMidi routes a raw MIDI message to the part on its channel. It must be called from the
+// thread that calls Render; other goroutines use SendMidi.
+func (m *Multitimbral) ProcessMidi(data []byte) {
+	if len(data) < 1 || data[0] < 0x80 || data[0] >= 0xF0 {
+		return
+	}
+
+	if p := m.parts[data[0]&0x0F].Load(); p != nil {
+		p.engine.processMidiMessage(data)
+	}
+}
+
+// SendMidi queues a raw MIDI message from any goroutine for the part on its channel, to be
+// applied at the start of the next Render
+func (m *Multitimbral) SendMidi(data []byte) error {
+	if len(data) < 1 || data[0] < 0x80 || data[0] >= 0xF0 {
+		return fmt.Errorf("not a MIDI channel message")
+	}
+
+	p := m.parts[data[0]&0x0F].Load()
+	if p == nil {
+		return nil
+	}
+	if !p.engine.sendMidi(data) {
+		return fmt.Errorf("MIDI queue full")
+	}
+	return nil
+}
+
+// Render mixes all parts into the stereo output buffers, which must have the same length
+func (m *Multitimbral) Render(left, right []float32) {
+	m.RenderEvents(left, right, nil)
+}
+
+// RenderEvents mixes all parts into the stereo output buffers like Render, routing each event to
+// the part on its channel at the event's frame. Events must be in frame order.
+func (m *Multitimbral) RenderEvents(left, right []float32, events []MidiEvent) {
+	for i := range m.parts {
+		p := m.parts[i].Load()
+		if p == nil {
+			continue
+		}
+
+		// Pick out the events for this part's channel
+		p.events = p.events[:0]
+		for _, event := range events {
+			if len(event.Data) > 0 && event.Data[0] >= 0x80 && event.Data[0] < 0xF0 && int(event.Data[0]&0x0F) == i {
+				p.events = append(p.events, event)
+			}
+		}
+
+		if cap(p.buffer) < len(left) {
+			p.buffer = make([]float32, len(left))
+			p.right = make([]float32, len(left))
+		}
+		partLeft, partRight := p.buffer[:len(left)], p.right[:len(left)]
+		clear(partLeft)
+		clear(partRight)
+
+		// Muted parts still render so their voices keep time
+		p.engine.renderStereoEvents(partLeft, partRight, p.events)
+		if p.mute.Load() {
+			continue
+		}
+
+		// Balance law like the voices' pan, unity gain at center; the part's voices already follow
+		// the channel's CC10 pan
+		gain := dbToLinear(math.Float64frombits(p.volume.Load()))
+		panLeft, panRight := voicePanGains(math.Float64frombits(p.pan.Load()))
+		leftGain := float32(gain * panLeft)
+		rightGain := float32(gain * panRight)
+
+		for i := range partLeft {
+			left[i] += partLeft[i] * leftGain
+			right[i] += partRight[i] * rightGain
+		}
+	}
+
+	m.stage.process(m.master, left, right)
+}
@@ -0,0 +1,156 @@
+package gosfzplayer
+
+import (
+	"math"
+	"testing"
+)
+
+const channelTestSfz = `<region>
+sample=sample1.wav
+key=60
+pitch_keycenter=60
+lochan=1
+hichan=1
+
+<region>
+sample=sample2.wav
+key=60
+pitch_keycenter=60
+lochan=2
+hichan=16
+`
+
+func TestLochanHichan(t *testing.T) {
+	e := createTestEngine(t, channelTestSfz)
+
+	e.processMidiMessage([]byte{0x90, 60, 100}) // Channel 1
+	if len(e.activeVoices) != 1 || e.activeVoices[0].region.Sample != "sample1.wav" {
+		t.Fatalf("Expected only the channel 1 region to play, got %d voices", len(e.activeVoices))
+	}
+
+	e.processMidiMessage([]byte{0x95, 60, 100}) // Channel 6
+	if len(e.activeVoices) != 2 || e.activeVoices[1].region.Sample != "sample2.wav" {
+		t.Fatalf("Expected the channel 2-16 region to play on channel 6, got %d voices", len(e.activeVoices))
+	}
+
+	// Note-off only releases the voice on its own channel
+	e.processMidiMessage([]byte{0x85, 60, 0})
+	if e.activeVoices[0].envelopeState == EnvelopeRelease {
+		t.Error("Expected note-off on channel 6 to leave the channel 1 voice sounding")
+	}
+	if e.activeVoices[1].envelopeState != EnvelopeRelease {
+		t.Error("Expected note-off on channel 6 to release the channel 6 voice")
+	}
+}
+
+func TestPlayerChannelFilter(t *testing.T) {
+	e := createTestEngine(t, channelTestSfz)
+
+	if err := e.player.SetMidiChannel(17); err == nil {
+		t.Error("Expected an error for MIDI channel 17")
+	}
+	if err := e.player.SetMidiChannel(3); err != nil {
+		t.Fatalf("SetMidiChannel(3) failed: %v", err)
+	}
+	if got := e.player.GetMidiChannel(); got != 3 {
+		t.Errorf("Expected MIDI channel 3, got %d", got)
+	}
+
+	e.processMidiMessage([]byte{0x90, 60, 100}) // Channel 1 is filtered out
+	if len(e.activeVoices) != 0 {
+		t.Errorf("Expected channel 1 to be ignored, got %d voices", len(e.activeVoices))
+	}
+
+	e.processMidiMessage([]byte{0x92, 60, 100}) // Channel 3
+	if len(e.activeVoices) != 1 {
+		t.Errorf("Expected channel 3 to play, got %d voices", len(e.activeVoices))
+	}
+}
+
+// createTestRack creates a multitimbral rack with the test SFZ content on the given channels
+func createTestRack(t *testing.T, channels ...int) *Multitimbral {
+	t.Helper()
+	rack := NewMultitimbral(44100)
+	for _, channel := range channels {
+		e := createTestEngine(t, `<region>
+sample=sample1.wav
+key=60
+pitch_keycenter=60
+ampeg_attack=0
+`)
+		if err := rack.SetPart(channel, e.player); err != nil {
+			t.Fatalf("SetPart(%d) failed: %v", channel, err)
+		}
+	}
+	return rack
+}
+
+// renderRack renders stereo frames from a rack and returns the RMS of each side
+func renderRack(rack *Multitimbral, frames int) (float64, float64) {
+	left := make([]float32, frames)
+	right := make([]float32, frames)
+	rack.Render(left, right)
+	return calculateRMS(left), calculateRMS(right)
+}
+
+func TestMultitimbralRouting(t *testing.T) {
+	rack := createTestRack(t, 1, 10)
+
+	if err := rack.SetPart(17, nil); err == nil {
+		t.Error("Expected an error for MIDI channel 17")
+	}
+	if err := rack.SetPartVolume(5, 0); err == nil {
+		t.Error("Expected an error setting the volume of an empty part")
+	}
+
+	// A note on channel 10 only sounds on part 10
+	rack.ProcessMidi([]byte{0x99, 60, 100})
+	if n := len(rack.parts[9].Load().engine.activeVoices); n != 1 {
+		t.Errorf("Expected 1 voice on part 10, got %d", n)
+	}
+	if n := len(rack.parts[0].Load().engine.activeVoices); n != 0 {
+		t.Errorf("Expected no voices on part 1, got %d", n)
+	}
+
+	// Channels without a part are ignored
+	rack.ProcessMidi([]byte{0x94, 60, 100})
+}
+
+func TestMultitimbralMixer(t *testing.T) {
+	rack := createTestRack(t, 1)
+	rack.ProcessMidi([]byte{0x90, 60, 127})
+
+	// Centered: equal on both sides
+	left, right := renderRack(rack, 2048)
+	if left == 0 || math.Abs(left-right) > 1e-6 {
+		t.Errorf("Expected a centered part to be equal on both sides, got L=%f R=%f", left, right)
+	}
+
+	// Hard left: balance law, so the left side keeps its centered level
+	centered := left
+	rack = createTestRack(t, 1)
+	rack.ProcessMidi([]byte{0x90, 60, 127})
+	rack.SetPartPan(1, -1.0)
+	left, right = renderRack(rack, 2048)
+	if left == 0 || right > 1e-6 {
+		t.Errorf("Expected a hard-left part to only sound on the left, got L=%f R=%f", left, right)
+	}
+	if math.Abs(left-centered) > 1e-6 {
+		t.Errorf("Expected panning to keep the left level %f, got %f", centered, left)
+	}
+
+	// -20dB part volume
+	before, _ := renderRack(rack, 2048)
+	rack.SetPartVolume(1, -20)
+	after, _ := renderRack(rack, 2048)
+	if ratio := after / before; math.Abs(ratio-0.1) > 0.02 {
+		t.Errorf("Expected -20dB part volume to scale output by 0.1, got %f", ratio)
+	}
+
+	// Mute silences the part
+	rack.SetPartMute(1, true)
+	left, right = renderRack(rack, 2048)
+	if left != 0 || right != 0 {
+		t.Errorf("Expected a muted part to be silent, got L=%f R=%f", left, right)
+	}
+}
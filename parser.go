@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -14,39 +15,88 @@ var parserDebug = debuggo.Debug("sfzplayer:parser")
 
 // SfzData represents the parsed SFZ file structure
 type SfzData struct {
-	Global  *SfzSection
-	Groups  []*SfzSection
-	Regions []*SfzSection
+	Global      *SfzSection
+	Groups      []*SfzSection
+	Regions     []*SfzSection
+	Curves      []*SfzSection
+	Effects     []*SfzSection   // <effect> sections, in file order
+	Diagnostics []SfzDiagnostic // Problems found while parsing, in line order
+	Files       []string        // The SFZ file followed by each file it #includes
 }
 
-// SfzSection represents a section in the SFZ file (global, group, or region)
+// SfzDiagnostic is a problem found in an SFZ file
+type SfzDiagnostic struct {
+	File     string // Included file the line is in, relative to the SFZ file; empty for the SFZ file itself
+	Line     int    // 1-based line number, 0 if not tied to a line
+	Severity string // "warning" or "error"
+	Message  string
+}
+
+// String formats the diagnostic as "[file: ]line N: severity: message"
+func (d SfzDiagnostic) String() string {
+	prefix := ""
+	if d.File != "" {
+		prefix = d.File + ": "
+	}
+	if d.Line == 0 {
+		return fmt.Sprintf("%s%s: %s", prefix, d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%sline %d: %s: %s", prefix, d.Line, d.Severity, d.Message)
+}
+
+// SfzSection represents a section in the SFZ file (global, group, region, curve or effect)
 type SfzSection struct {
-	Type        string            // "global", "group", or "region"
+	Type        string            // "global", "group", "region", "curve" or "effect"
 	Opcodes     map[string]string // opcode name -> value
 	ParentGroup *SfzSection       // For regions: the group they belong to (nil if no group)
 	GlobalRef   *SfzSection       // Reference to the global section for inheritance
+	File        string            // Included file the section is in, empty for the SFZ file itself
+	Line        int               // Line of the section header
+}
+
+// maxIncludeDepth limits #include nesting, catching files that include themselves
+const maxIncludeDepth = 16
+
+// sfzParser holds the state carried across an SFZ file and the files it includes
+type sfzParser struct {
+	data    *SfzData
+	baseDir string      // Directory of the SFZ file; #include paths are relative to it
+	file    string      // Included file being parsed, empty for the SFZ file itself
+	section *SfzSection // Section receiving opcodes
+	group   *SfzSection // Current group for region inheritance
 }
 
 // ParseSfzFile parses an SFZ file and returns the structured data
 func ParseSfzFile(filePath string) (*SfzData, error) {
 	parserDebug("Starting to parse SFZ file: %s", filePath)
 
+	p := &sfzParser{
+		data: &SfzData{
+			Groups:  make([]*SfzSection, 0),
+			Regions: make([]*SfzSection, 0),
+		},
+		baseDir: filepath.Dir(filePath),
+	}
+	if err := p.parseFile(filePath, 0); err != nil {
+		return nil, err
+	}
+
+	parserDebug("Parsing complete. Found %d regions, %d groups", len(p.data.Regions), len(p.data.Groups))
+	return p.data, nil
+}
+
+// parseFile parses one file's lines into the parser's SfzData
+func (p *sfzParser) parseFile(filePath string, depth int) error {
 	// Check if file exists
 	file, err := os.Open(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open SFZ file: %w", err)
+		return fmt.Errorf("failed to open SFZ file: %w", err)
 	}
 	defer file.Close()
-
-	sfzData := &SfzData{
-		Groups:  make([]*SfzSection, 0),
-		Regions: make([]*SfzSection, 0),
-	}
+	p.data.Files = append(p.data.Files, filePath)
 
 	scanner := bufio.NewScanner(file)
 	lineNum := 0
-	var currentSection *SfzSection
-	var currentGroup *SfzSection // Track the current group for region inheritance
 
 	for scanner.Scan() {
 		lineNum++
@@ -59,54 +109,104 @@ func ParseSfzFile(filePath string) (*SfzData, error) {
 
 		parserDebug("Parsing line %d: %s", lineNum, line)
 
+		// Included files continue the current section, as if pasted in place
+		if strings.HasPrefix(line, "#include") {
+			if err := p.include(line, lineNum, depth); err != nil {
+				return err
+			}
+			continue
+		}
+
 		// Check for section headers
 		if strings.HasPrefix(line, "<") && strings.HasSuffix(line, ">") {
 			sectionType := strings.ToLower(strings.Trim(line, "<>"))
 			parserDebug("Found section: %s", sectionType)
 
-			currentSection = &SfzSection{
+			p.section = &SfzSection{
 				Type:    sectionType,
 				Opcodes: make(map[string]string),
+				File:    p.file,
+				Line:    lineNum,
 			}
 
 			switch sectionType {
 			case "global":
-				sfzData.Global = currentSection
+				p.data.Global = p.section
 			case "group":
-				currentGroup = currentSection
-				currentSection.GlobalRef = sfzData.Global
-				sfzData.Groups = append(sfzData.Groups, currentSection)
+				p.group = p.section
+				p.section.GlobalRef = p.data.Global
+				p.data.Groups = append(p.data.Groups, p.section)
 			case "region":
-				currentSection.ParentGroup = currentGroup
-				currentSection.GlobalRef = sfzData.Global
-				sfzData.Regions = append(sfzData.Regions, currentSection)
+				p.section.ParentGroup = p.group
+				p.section.GlobalRef = p.data.Global
+				p.data.Regions = append(p.data.Regions, p.section)
+			case "curve":
+				p.data.Curves = append(p.data.Curves, p.section)
+			case "effect":
+				p.data.Effects = append(p.data.Effects, p.section)
 			default:
 				parserDebug("Warning: Unknown section type: %s", sectionType)
+				p.warn(lineNum, "unknown section <%s>", sectionType)
 			}
 			continue
 		}
 
 		// Parse opcodes
-		if currentSection != nil {
-			err := parseOpcodes(line, currentSection, lineNum)
+		if p.section != nil {
+			err := p.parseOpcodes(line, lineNum)
 			if err != nil {
 				parserDebug("Warning: Failed to parse line %d: %v", lineNum, err)
+				p.warn(lineNum, "%v", err)
 			}
 		} else {
 			parserDebug("Warning: Opcode found outside of section at line %d: %s", lineNum, line)
+			p.warn(lineNum, "opcode outside of a section: %s", line)
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading SFZ file: %w", err)
+		return fmt.Errorf("error reading SFZ file: %w", err)
 	}
+	return nil
+}
 
-	parserDebug("Parsing complete. Found %d regions, %d groups", len(sfzData.Regions), len(sfzData.Groups))
-	return sfzData, nil
+// include parses the file named by an #include "path" line
+func (p *sfzParser) include(line string, lineNum, depth int) error {
+	name := strings.TrimSpace(strings.TrimPrefix(line, "#include"))
+	if len(name) < 2 || name[0] != '"' || !strings.HasSuffix(name, "\"") {
+		p.warn(lineNum, "malformed include: %s", line)
+		return nil
+	}
+	name = name[1 : len(name)-1]
+	if depth >= maxIncludeDepth {
+		return fmt.Errorf("#include %q: nested more than %d deep", name, maxIncludeDepth)
+	}
+
+	parserDebug("Including %s", name)
+	parent := p.file
+	p.file = name
+	err := p.parseFile(filepath.Join(p.baseDir, name), depth+1)
+	p.file = parent
+	if err != nil {
+		return fmt.Errorf("#include %q: %w", name, err)
+	}
+	return nil
 }
 
-// parseOpcodes parses a line containing opcodes and adds them to the section
-func parseOpcodes(line string, section *SfzSection, lineNum int) error {
+// warn records a warning diagnostic for a line of the current file
+func (p *sfzParser) warn(line int, format string, args ...interface{}) {
+	p.data.Diagnostics = append(p.data.Diagnostics, SfzDiagnostic{
+		File:     p.file,
+		Line:     line,
+		Severity: "warning",
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// parseOpcodes parses a line containing opcodes and adds them to the current section, recording unknown opcodes
+func (p *sfzParser) parseOpcodes(line string, lineNum int) error {
+	section := p.section
+
 	// Split line by whitespace to get individual opcodes
 	parts := strings.Fields(line)
 
@@ -119,6 +219,7 @@ func parseOpcodes(line string, section *SfzSection, lineNum int) error {
 		// Find the = separator
 		equalIndex := strings.Index(part, "=")
 		if equalIndex == -1 {
+			p.warn(lineNum, "ignoring %q without '='", part)
 			continue // Skip parts without =
 		}
 
@@ -126,11 +227,13 @@ func parseOpcodes(line string, section *SfzSection, lineNum int) error {
 		value := strings.TrimSpace(part[equalIndex+1:])
 
 		// Validate and store the opcode
-		if isKnownOpcode(opcode) {
+		// Effect types define their own opcodes
+		if isKnownOpcode(opcode) || section.Type == "effect" {
 			section.Opcodes[opcode] = value
 			parserDebug("Parsed opcode: %s = %s", opcode, value)
 		} else {
 			parserDebug("Warning: Unknown opcode '%s' at line %d", opcode, lineNum)
+			p.warn(lineNum, "unknown opcode %q", opcode)
 		}
 	}
 
@@ -154,6 +257,25 @@ func isKnownOpcode(opcode string) bool {
 		"volume":          true,
 		"pitch_keycenter": true,
 
+		// Velocity Response
+		"amp_veltrack": true,
+		"curve_index":  true,
+
+		// Key Tracking and Crossfades
+		"amp_keytrack":  true,
+		"amp_keycenter": true,
+		"xfin_lokey":    true,
+		"xfin_hikey":    true,
+		"xfout_lokey":   true,
+		"xfout_hikey":   true,
+		"xfin_lovel":    true,
+		"xfin_hivel":    true,
+		"xfout_lovel":   true,
+		"xfout_hivel":   true,
+		"xf_keycurve":   true,
+		"xf_velcurve":   true,
+		"xf_cccurve":    true,
+
 		// Envelope
 		"ampeg_attack":  true,
 		"ampeg_decay":   true,
@@ -172,30 +294,99 @@ func isKnownOpcode(opcode string) bool {
 		"loop_end":   true,
 
 		// Keyswitching
-		"sw_lokey": true,
-		"sw_hikey": true,
+		"sw_lokey":    true,
+		"sw_hikey":    true,
+		"sw_last":     true,
+		"sw_down":     true,
+		"sw_up":       true,
+		"sw_previous": true,
+		"sw_default":  true,
+		"sw_vel":      true,
+		"sw_label":    true,
 
 		// Groups and Exclusion
 		"group":  true,
 		"off_by": true,
 
+		// Polyphony
+		"polyphony":       true,
+		"note_polyphony":  true,
+		"group_polyphony": true,
+
 		// Trigger Modes
 		"trigger": true,
 
 		// Pitch Bend
-		"bend_up":   true,
-		"bend_down": true,
+		"bend_up":     true,
+		"bend_down":   true,
+		"bend_step":   true,
+		"bend_smooth": true,
+
+		// MIDI Channels
+		"lochan": true,
+		"hichan": true,
+
+		// Region Conditions
+		"lobend":    true,
+		"hibend":    true,
+		"lochanaft": true,
+		"hichanaft": true,
+		"lopolyaft": true,
+		"hipolyaft": true,
+		"lobpm":     true,
+		"hibpm":     true,
+		"lotimer":   true,
+		"hitimer":   true,
+
+		// Round-Robin and Random
+		"seq_length":   true,
+		"seq_position": true,
+		"lorand":       true,
+		"hirand":       true,
+
+		// Pedals
+		"sustain_sw":   true,
+		"sostenuto_sw": true,
+		"sustain_cc":   true,
+
+		// Filter
+		"fil_type":  true,
+		"cutoff":    true,
+		"resonance": true,
+
+		// Effects
+		"effect1":      true,
+		"effect2":      true,
+		"effect3":      true,
+		"effect4":      true,
+		"directtomain": true,
+		"fx1tomain":    true,
+		"fx2tomain":    true,
+		"fx3tomain":    true,
+		"fx4tomain":    true,
 
 		// Reverb
 		"reverb_send":      true,
+		"reverb_size":      true,
 		"reverb_room_size": true,
+		"reverb_damp":      true,
 		"reverb_damping":   true,
+		"reverb_input":     true,
 		"reverb_wet":       true,
 		"reverb_dry":       true,
 		"reverb_width":     true,
+		"reverb_predelay":  true,
+		"reverb_lowcut":    true,
+		"reverb_highcut":   true,
+		"reverb_tone":      true,
+	}
+
+	if knownOpcodes[opcode] {
+		return true
 	}
 
-	return knownOpcodes[opcode]
+	// Numbered curve, modulation, crossfade, condition and EQ opcodes (vNNN, amp_velcurve_N, volume_onccN, xfin_loccN, loccN, eq1_gain, ...)
+	return isCurveOpcode(opcode) || isModulationOpcode(opcode) || isCrossfadeOpcode(opcode) || isConditionOpcode(opcode) || isEQOpcode(opcode)
 }
 
 // Helper functions to extract specific opcode values with type conversion
@@ -228,6 +419,26 @@ func (s *SfzSection) getInheritedValue(opcode string) (string, bool) {
 	return "", false
 }
 
+// inheritedOpcodes returns all opcodes visible to this section, with region values overriding group and global
+func (s *SfzSection) inheritedOpcodes() map[string]string {
+	merged := make(map[string]string)
+	if s == nil {
+		return merged
+	}
+
+	sources := []*SfzSection{s.GlobalRef, s.ParentGroup, s}
+	for _, section := range sources {
+		if section == nil {
+			continue
+		}
+		for opcode, value := range section.Opcodes {
+			merged[opcode] = value
+		}
+	}
+
+	return merged
+}
+
 // convertToInt safely converts a string to int with error handling
 func convertToInt(value, opcode string, defaultValue int) int {
 	intVal, err := strconv.Atoi(value)
@@ -227,6 +227,14 @@ another_unknown=123
 	if value := region.GetStringOpcode("unknown_opcode"); value != "" {
 		t.Errorf("Unknown opcode should not be stored, got '%s'", value)
 	}
+
+	// Each unknown opcode is reported with its line
+	if len(sfzData.Diagnostics) != 3 {
+		t.Fatalf("Expected 3 diagnostics, got %v", sfzData.Diagnostics)
+	}
+	if d := sfzData.Diagnostics[0]; d.Line != 3 || d.Severity != "warning" || d.String() != `line 3: warning: unknown opcode "unknown_opcode"` {
+		t.Errorf("Unexpected diagnostic: %v", d)
+	}
 }
 
 func TestEmptyAndCommentLines(t *testing.T) {
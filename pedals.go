@@ -0,0 +1,97 @@
+package gosfzplayer
+
+// Pedal controllers
+const (
+	sustainPedalCC   = 64
+	sostenutoPedalCC = 66
+	softPedalCC      = 67
+
+	pedalThreshold = 64.0 / 127.0 // Pedal is down at MIDI values of 64 and above
+
+	softPedalAttenuation = -6.0 // Gain in dB for notes started with the soft pedal fully down
+)
+
+// InitializePedals reads the region's sustain_sw, sostenuto_sw and sustain_cc opcodes
+func (v *Voice) InitializePedals() {
+	v.keyDown = v.noteOn
+	v.sustainSw = v.region.SustainSw
+	v.sostenutoSw = v.region.SostenutoSw
+	v.sustainCC = v.region.SustainCC
+	v.sostenutoLatched = false
+}
+
+// pedalHolds reports whether a pedal keeps a voice sounding after its key was released
+func (e *engine) pedalHolds(voice *Voice) bool {
+	if voice.sostenutoLatched {
+		return true
+	}
+	return voice.sustainSw && e.channelState[voice.channel&0x0F].ccValues[voice.sustainCC] >= pedalThreshold
+}
+
+// softPedalGain returns the note-on gain for a channel's soft pedal position
+func (e *engine) softPedalGain(channel uint8) float64 {
+	return dbToLinear(softPedalAttenuation * e.channelState[channel&0x0F].ccValues[softPedalCC])
+}
+
+// processPedals updates the pedal state of a channel's voices after a CC change on the channel
+func (e *engine) processPedals(channel, cc uint8, previous, current float64) {
+	wasDown := previous >= pedalThreshold
+	isDown := current >= pedalThreshold
+	if wasDown == isDown {
+		return
+	}
+
+	if cc == sostenutoPedalCC {
+		for _, voice := range e.activeVoices {
+			if voice.channel != channel {
+				continue
+			}
+			if isDown {
+				// Latch only the notes held when the pedal goes down
+				voice.sostenutoLatched = voice.keyDown && voice.sostenutoSw
+			} else {
+				voice.sostenutoLatched = false
+			}
+		}
+		if engineDebugEnabled {
+			engineDebug("Sostenuto pedal: channel=%d, down=%v", channel+1, isDown)
+		}
+	}
+
+	if !isDown {
+		e.releasePedaledVoices(channel)
+	}
+}
+
+// releasePedaledVoices releases a channel's voices whose keys are up and no longer held by a pedal
+func (e *engine) releasePedaledVoices(channel uint8) {
+	released := e.releasedNotes[:0]
+	for _, voice := range e.activeVoices {
+		if voice.channel == channel && voice.noteOn && !voice.keyDown && !e.pedalHolds(voice) {
+			voice.TriggerRelease()
+			released = appendUniqueNote(released, channelNote{voice.channel, voice.midiNote})
+		}
+	}
+
+	// Release triggers deferred by the pedal fire now
+	for _, n := range released {
+		e.handleReleaseTriggers(n.channel, n.note)
+	}
+	e.releasedNotes = released
+}
+
+// channelNote identifies a note on a MIDI channel
+type channelNote struct {
+	channel uint8
+	note    uint8
+}
+
+// appendUniqueNote appends a note to a list if it isn't already present
+func appendUniqueNote(notes []channelNote, note channelNote) []channelNote {
+	for _, n := range notes {
+		if n == note {
+			return notes
+		}
+	}
+	return append(notes, note)
+}
@@ -0,0 +1,187 @@
+package gosfzplayer
+
+import (
+	"testing"
+)
+
+const pedalTestSfz = `<region>
+sample=sample1.wav
+lokey=60
+hikey=62
+pitch_keycenter=60
+loop_mode=loop_continuous
+`
+
+// voiceForNote returns the first active voice playing a note, or nil
+func voiceForNote(e *engine, note uint8) *Voice {
+	for _, voice := range e.activeVoices {
+		if voice.midiNote == note {
+			return voice
+		}
+	}
+	return nil
+}
+
+func TestSustainPedal(t *testing.T) {
+	e := createTestEngine(t, pedalTestSfz)
+
+	e.processControlChange(64, 127)
+	e.noteOn(60, 100)
+	e.noteOff(60)
+
+	voice := voiceForNote(e, 60)
+	if voice == nil {
+		t.Fatal("Expected a voice for note 60")
+	}
+	if voice.envelopeState == EnvelopeRelease {
+		t.Error("Expected sustain pedal to defer the note-off")
+	}
+
+	e.processControlChange(64, 0)
+	if voice.envelopeState != EnvelopeRelease {
+		t.Errorf("Expected release when the sustain pedal lifts, got state %d", voice.envelopeState)
+	}
+}
+
+func TestSustainPedalReleasesOnlyLiftedKeys(t *testing.T) {
+	e := createTestEngine(t, pedalTestSfz)
+
+	e.processControlChange(64, 127)
+	e.noteOn(60, 100)
+	e.noteOn(62, 100)
+	e.noteOff(60)
+	e.processControlChange(64, 0)
+
+	if voiceForNote(e, 60).envelopeState != EnvelopeRelease {
+		t.Error("Expected the released key to stop with the pedal")
+	}
+	if voiceForNote(e, 62).envelopeState == EnvelopeRelease {
+		t.Error("Expected the still-held key to keep sounding")
+	}
+}
+
+func TestSostenutoPedal(t *testing.T) {
+	e := createTestEngine(t, pedalTestSfz)
+
+	// Note 60 is held when sostenuto goes down, note 62 is played afterwards
+	e.noteOn(60, 100)
+	e.processControlChange(66, 127)
+	e.noteOn(62, 100)
+	e.noteOff(60)
+	e.noteOff(62)
+
+	if voiceForNote(e, 60).envelopeState == EnvelopeRelease {
+		t.Error("Expected sostenuto to hold the latched note")
+	}
+	if voiceForNote(e, 62).envelopeState != EnvelopeRelease {
+		t.Error("Expected notes played after sostenuto went down to release normally")
+	}
+
+	e.processControlChange(66, 0)
+	if voiceForNote(e, 60).envelopeState != EnvelopeRelease {
+		t.Error("Expected the latched note to release when sostenuto lifts")
+	}
+}
+
+func TestSustainOpcodes(t *testing.T) {
+	e := createTestEngine(t, `<group>
+sustain_sw=off
+
+<region>
+sample=sample1.wav
+key=60
+
+<group>
+sustain_cc=4
+
+<region>
+sample=sample2.wav
+key=62
+`)
+
+	// sustain_sw=off ignores the pedal
+	e.processControlChange(64, 127)
+	e.noteOn(60, 100)
+	e.noteOff(60)
+	if voiceForNote(e, 60).envelopeState != EnvelopeRelease {
+		t.Error("Expected sustain_sw=off to ignore the sustain pedal")
+	}
+
+	// sustain_cc=4 uses CC4 instead of CC64
+	e.noteOn(62, 100)
+	e.noteOff(62)
+	if voiceForNote(e, 62).envelopeState != EnvelopeRelease {
+		t.Error("Expected sustain_cc=4 to ignore CC64")
+	}
+
+	e.processControlChange(4, 127)
+	e.noteOn(62, 100)
+	e.noteOff(62)
+	held := e.activeVoices[len(e.activeVoices)-1]
+	if held.envelopeState == EnvelopeRelease {
+		t.Error("Expected sustain_cc=4 to sustain with CC4 down")
+	}
+}
+
+func TestSoftPedalGain(t *testing.T) {
+	e := createTestEngine(t, pedalTestSfz)
+
+	e.noteOn(60, 100)
+	normal := voiceForNote(e, 60).volume
+
+	e.processControlChange(67, 127)
+	e.noteOn(61, 100)
+	soft := voiceForNote(e, 61).volume
+
+	if ratio := soft / normal; ratio >= 0.6 || ratio <= 0.4 {
+		t.Errorf("Expected the soft pedal to attenuate by about 6dB, got ratio %f", ratio)
+	}
+}
+
+func TestPedalsAndControllersPerChannel(t *testing.T) {
+	e := createTestEngine(t, pedalTestSfz)
+
+	// Sustain on channel 2 leaves a channel 1 note free to release
+	e.processControlChangeChannel(1, 64, 127)
+	e.noteOnChannel(0, 60, 100)
+	e.noteOnChannel(1, 62, 100)
+	e.noteOffChannel(0, 60)
+	e.noteOffChannel(1, 62)
+	if voiceForNote(e, 60).envelopeState != EnvelopeRelease {
+		t.Error("Expected the channel 1 note to release with only channel 2's pedal down")
+	}
+	if voiceForNote(e, 62).envelopeState == EnvelopeRelease {
+		t.Error("Expected channel 2's pedal to sustain its note")
+	}
+
+	// Soft pedal on channel 2 leaves channel 1 note-ons at full level
+	e = createTestEngine(t, pedalTestSfz)
+	e.noteOnChannel(0, 60, 100)
+	normal := voiceForNote(e, 60).volume
+	e.processControlChangeChannel(1, 67, 127)
+	e.noteOnChannel(0, 61, 100)
+	if got := voiceForNote(e, 61).volume; got != normal {
+		t.Errorf("Expected channel 2's soft pedal to leave channel 1 alone, got volume %f vs %f", got, normal)
+	}
+
+	// CC modulation follows the voice's own channel
+	const sfz = pedalTestSfz + "volume_oncc20=-20\n"
+	reference := createTestEngine(t, sfz)
+	e = createTestEngine(t, sfz)
+	reference.noteOnChannel(0, 60, 100)
+	e.noteOnChannel(0, 60, 100)
+	e.processControlChangeChannel(1, 20, 127)
+	want := renderTestFrames(reference, 4096)
+	got := renderTestFrames(e, 4096)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected CC20 on channel 2 not to modulate a channel 1 voice, frame %d: %f vs %f", i, got[i], want[i])
+		}
+	}
+	e.processControlChangeChannel(0, 20, 127)
+	renderTestFrames(e, 4096) // Let the CC smoothing settle
+	renderTestFrames(reference, 4096)
+	if ratio := peak(renderTestFrames(e, 4096)) / peak(renderTestFrames(reference, 4096)); ratio > 0.2 {
+		t.Errorf("Expected CC20 on channel 1 to turn its voice down 20dB, got ratio %f", ratio)
+	}
+}
@@ -34,7 +34,7 @@ func TestPianoSfzPlayer(t *testing.T) {
 	defer player.StopAndClose()
 
 	// Verify that piano samples were loaded
-	if player.sampleCache.Size() == 0 {
+	if player.current().sampleCache.Size() == 0 {
 		t.Error("Expected piano samples to be loaded, but cache is empty")
 	}
 
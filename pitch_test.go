@@ -23,12 +23,12 @@ func TestPitchRatioCalculation(t *testing.T) {
 	jc := player.jackClient
 
 	// Create a test region with pitch_keycenter
-	region := &SfzSection{
+	region := compileRegion(&SfzSection{
 		Type: "region",
 		Opcodes: map[string]string{
 			"pitch_keycenter": "60", // Middle C
 		},
-	}
+	}, nil)
 
 	// Test that pitch ratio calculation doesn't crash
 	ratio := jc.calculatePitchRatio(region, 60)
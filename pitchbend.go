@@ -0,0 +1,67 @@
+package gosfzplayer
+
+import (
+	"math"
+)
+
+// bendState tracks a voice's live pitch bend with per-region range, stepping and smoothing
+type bendState struct {
+	bendValue   *int16   // Channel pitch bend value (-8192 to +8191)
+	bendRange   *float64 // Channel bend range in cents set with RPN 0 (nil or negative to use up/down)
+	up          float64  // bend_up in cents
+	down        float64  // bend_down in cents (negative for a downward bend)
+	step        float64  // bend_step in cents
+	coefficient float64  // One-pole smoothing coefficient
+	current     float64  // Smoothed bend in cents
+}
+
+// InitializeBend sets up live pitch bend for a voice from its region's bend opcodes and its channel
+func (v *Voice) InitializeBend(sampleRate uint32, channel *midiState) {
+	region := v.region
+	smooth := region.BendSmooth / 1000.0 // ms to seconds
+
+	v.bend = bendState{
+		bendValue:   &channel.pitchBend,
+		bendRange:   &channel.bendRange,
+		up:          region.BendUp,
+		down:        region.BendDown,
+		step:        region.BendStep,
+		coefficient: math.Exp(-1.0 / (math.Max(smooth, minModSmoothTime) * float64(sampleRate))),
+	}
+
+	// Start at the current bend so notes played while bent don't glide in
+	v.bend.current = v.bend.target()
+}
+
+// target returns the unsmoothed bend in cents for the current pitch bend value
+func (b *bendState) target() float64 {
+	if b.bendValue == nil || *b.bendValue == 0 {
+		return 0.0
+	}
+
+	// A bend range sent with RPN 0 overrides bend_up/bend_down
+	up, down := b.up, b.down
+	if b.bendRange != nil && *b.bendRange >= 0 {
+		up, down = *b.bendRange, -*b.bendRange
+	}
+
+	var cents float64
+	if value := float64(*b.bendValue); value > 0 {
+		cents = value / 8191.0 * up
+	} else {
+		cents = value / 8192.0 * -down
+	}
+
+	if b.step > 1.0 {
+		cents = math.Round(cents/b.step) * b.step
+	}
+	return cents
+}
+
+// ProcessBend advances the bend smoother by one sample and returns the bend in cents
+func (v *Voice) ProcessBend() float64 {
+	b := &v.bend
+	target := b.target()
+	b.current = target + (b.current-target)*b.coefficient
+	return b.current
+}
@@ -0,0 +1,75 @@
+package gosfzplayer
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBendTarget(t *testing.T) {
+	var bendValue int16
+	b := bendState{bendValue: &bendValue, up: 200, down: -1200, step: 1}
+
+	tests := []struct {
+		name     string
+		value    int16
+		expected float64
+	}{
+		{"center", 0, 0},
+		{"full up", 8191, 200},
+		{"full down", -8192, -1200},
+		{"half down", -4096, -600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bendValue = tt.value
+			if got := b.target(); math.Abs(got-tt.expected) > 1e-9 {
+				t.Errorf("target() = %f, want %f", got, tt.expected)
+			}
+		})
+	}
+
+	// bend_step quantizes the bend into semitone steps
+	b.step = 100
+	bendValue = 5000 // ~122 cents unquantized
+	if got := b.target(); got != 100 {
+		t.Errorf("Expected stepped bend of 100 cents, got %f", got)
+	}
+}
+
+func TestLivePitchBend(t *testing.T) {
+	e := createTestEngine(t, `<region>
+sample=sample1.wav
+key=60
+pitch_keycenter=60
+bend_up=1200
+bend_smooth=10
+`)
+
+	e.noteOn(60, 100)
+	if len(e.activeVoices) != 1 {
+		t.Fatalf("Expected 1 active voice, got %d", len(e.activeVoices))
+	}
+	voice := e.activeVoices[0]
+
+	// Unbent, the voice advances one sample per frame
+	renderTestFrames(e, 1000)
+	if math.Abs(voice.position-1000) > 1e-6 {
+		t.Fatalf("Expected position 1000 before bending, got %f", voice.position)
+	}
+
+	// Full bend up on a held note: one octave after smoothing settles
+	e.processPitchBend(0x7F, 0x7F)
+	start := voice.position
+	renderTestFrames(e, 100)
+	if step := voice.position - start; step >= 200 || step <= 100 {
+		t.Errorf("Expected the bend to be smoothed in, advanced %f over 100 frames", step)
+	}
+
+	renderTestFrames(e, 4410)
+	start = voice.position
+	renderTestFrames(e, 1000)
+	if step := voice.position - start; math.Abs(step-2000) > 1 {
+		t.Errorf("Expected a one-octave bend to double playback speed, advanced %f over 1000 frames", step)
+	}
+}
@@ -169,7 +169,7 @@ func TestBasicPlaybackOpcodesNoErrors(t *testing.T) {
 	}
 
 	// Verify samples were loaded
-	if player.sampleCache.Size() == 0 {
+	if player.current().sampleCache.Size() == 0 {
 		t.Error("Expected samples to be loaded")
 	}
 
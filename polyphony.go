@@ -0,0 +1,202 @@
+package gosfzplayer
+
+import (
+	"fmt"
+)
+
+// StealPolicy chooses which voice is cut when a polyphony limit is reached
+type StealPolicy int32
+
+const (
+	StealReleasedFirst StealPolicy = iota // Oldest voice in its release stage, else the quietest voice
+	StealOldest                           // Voice that started first
+	StealQuietest                         // Voice with the lowest current level
+	StealSameNoteFirst                    // Oldest voice playing the same note, else released-first
+)
+
+// String returns the policy's name
+func (s StealPolicy) String() string {
+	switch s {
+	case StealReleasedFirst:
+		return "released-first"
+	case StealOldest:
+		return "oldest"
+	case StealQuietest:
+		return "quietest"
+	case StealSameNoteFirst:
+		return "same-note-first"
+	}
+	return fmt.Sprintf("StealPolicy(%d)", int32(s))
+}
+
+const (
+	// DefaultPolyphony is the number of voices a player can sound at once unless changed with SetPolyphony
+	DefaultPolyphony = 32
+
+	// MaxPolyphony is the highest polyphony a player can be set to
+	MaxPolyphony = 256
+
+	// stealHeadroom is the number of extra pooled voices available to stolen voices while they fade out
+	stealHeadroom = 32
+
+	// stealFadeTime is how long a stolen voice takes to fade out, in seconds
+	stealFadeTime = 0.005
+)
+
+// SetPolyphony sets the maximum number of voices the player sounds at once (1 to MaxPolyphony)
+func (p *SfzPlayer) SetPolyphony(voices int) error {
+	if voices < 1 || voices > MaxPolyphony {
+		return fmt.Errorf("invalid polyphony %d (must be 1-%d)", voices, MaxPolyphony)
+	}
+	p.polyphony.Store(int32(voices))
+	debug("Polyphony set to %d voices", voices)
+	return nil
+}
+
+// GetPolyphony returns the maximum number of voices the player sounds at once
+func (p *SfzPlayer) GetPolyphony() int {
+	if voices := p.polyphony.Load(); voices > 0 {
+		return int(voices)
+	}
+	return DefaultPolyphony
+}
+
+// SetStealPolicy sets how a voice is chosen to make room when a polyphony limit is reached
+func (p *SfzPlayer) SetStealPolicy(policy StealPolicy) error {
+	if policy < StealReleasedFirst || policy > StealSameNoteFirst {
+		return fmt.Errorf("invalid voice stealing policy %d", int32(policy))
+	}
+	p.stealPolicy.Store(int32(policy))
+	debug("Voice stealing policy set to %s", policy)
+	return nil
+}
+
+// GetStealPolicy returns the voice stealing policy
+func (p *SfzPlayer) GetStealPolicy() StealPolicy {
+	return StealPolicy(p.stealPolicy.Load())
+}
+
+// polyphonyGroup returns the section whose voices share the region's polyphony limit:
+// its <group>, or the region itself when it isn't in one
+func (r *Region) polyphonyGroup() *SfzSection {
+	if r == nil {
+		return nil
+	}
+	if group := r.parentGroup(); group != nil {
+		return group
+	}
+	return r.Section
+}
+
+// enforcePolyphony steals voices so a new voice for the region fits within every polyphony limit:
+// note_polyphony, polyphony, group_polyphony and the player's polyphony
+func (e *engine) enforcePolyphony(region *Region, channel, note uint8) {
+	if region.NotePolyphony > 0 {
+		e.limitVoices(region.NotePolyphony, channel, note, func(v *Voice) bool {
+			return v.midiNote == note && v.channel == channel && v.groupID == region.Group
+		})
+	}
+	if region.Polyphony > 0 {
+		group := region.polyphonyGroup()
+		e.limitVoices(region.Polyphony, channel, note, func(v *Voice) bool {
+			return v.region.polyphonyGroup() == group
+		})
+	}
+	if region.GroupPolyphony > 0 {
+		e.limitVoices(region.GroupPolyphony, channel, note, func(v *Voice) bool {
+			return v.groupID == region.Group
+		})
+	}
+	e.limitVoices(e.player.GetPolyphony(), channel, note, nil)
+}
+
+// limitVoices steals matching voices (all voices if match is nil) until one more fits within limit.
+// Voices already fading out after being stolen don't count.
+func (e *engine) limitVoices(limit int, channel, note uint8, match func(v *Voice) bool) {
+	count := 0
+	for _, voice := range e.activeVoices {
+		if !voice.stolen && (match == nil || match(voice)) {
+			count++
+		}
+	}
+
+	for ; count >= limit; count-- {
+		victim := e.pickVictim(channel, note, match)
+		if victim == nil {
+			return
+		}
+		if engineDebugEnabled {
+			engineDebug("Stealing voice: note=%d (limit %d)", victim.midiNote, limit)
+		}
+		victim.steal(e.sampleRate)
+	}
+}
+
+// pickVictim chooses a matching voice to steal according to the player's stealing policy
+func (e *engine) pickVictim(channel, note uint8, match func(v *Voice) bool) *Voice {
+	var oldest, quietest, released, sameNote *Voice
+
+	// Active voices are kept in the order they started
+	for _, voice := range e.activeVoices {
+		if voice.stolen || (match != nil && !match(voice)) {
+			continue
+		}
+		if oldest == nil {
+			oldest = voice
+		}
+		if quietest == nil || voice.level() < quietest.level() {
+			quietest = voice
+		}
+		if released == nil && voice.envelopeState >= EnvelopeRelease {
+			released = voice
+		}
+		if sameNote == nil && voice.midiNote == note && voice.channel == channel {
+			sameNote = voice
+		}
+	}
+
+	switch e.player.GetStealPolicy() {
+	case StealOldest:
+		return oldest
+	case StealQuietest:
+		return quietest
+	case StealSameNoteFirst:
+		if sameNote != nil {
+			return sameNote
+		}
+	}
+	if released != nil {
+		return released
+	}
+	return quietest
+}
+
+// level returns the voice's current output level, used to find the quietest voice
+func (v *Voice) level() float64 {
+	return v.volume * v.envelopeLevel
+}
+
+// steal starts a quick fade-out, after which the voice finishes
+func (v *Voice) steal(sampleRate uint32) {
+	if v.stolen {
+		return
+	}
+	v.stolen = true
+	v.noteOn = false
+	v.keyDown = false
+	v.stealGain = 1.0
+	v.stealStep = 1.0 / (stealFadeTime * float64(sampleRate))
+}
+
+// processSteal advances a stolen voice's fade and returns its gain (1.0 for voices that aren't stolen)
+func (v *Voice) processSteal() float64 {
+	if !v.stolen {
+		return 1.0
+	}
+	v.stealGain -= v.stealStep
+	if v.stealGain <= 0.0 {
+		v.stealGain = 0.0
+		v.isActive = false
+	}
+	return v.stealGain
+}
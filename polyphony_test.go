@@ -0,0 +1,163 @@
+package gosfzplayer
+
+import (
+	"testing"
+)
+
+// soundingNotes returns the notes of voices that haven't been stolen, oldest first
+func soundingNotes(e *engine) []uint8 {
+	var notes []uint8
+	for _, voice := range e.activeVoices {
+		if !voice.stolen {
+			notes = append(notes, voice.midiNote)
+		}
+	}
+	return notes
+}
+
+func TestPlayerPolyphony(t *testing.T) {
+	e := createTestEngine(t, `<region>
+sample=sample1.wav
+loop_mode=loop_continuous
+`)
+	if err := e.player.SetPolyphony(0); err == nil {
+		t.Error("Expected an error for polyphony 0")
+	}
+	if err := e.player.SetPolyphony(4); err != nil {
+		t.Fatalf("SetPolyphony failed: %v", err)
+	}
+	e.player.SetStealPolicy(StealOldest)
+
+	for note := uint8(60); note < 66; note++ {
+		e.noteOn(note, 100)
+	}
+	if got := soundingNotes(e); len(got) != 4 || got[0] != 62 {
+		t.Errorf("Expected the 4 newest notes to sound, got %v", got)
+	}
+
+	// Stolen voices fade out rather than being cut
+	if len(e.activeVoices) != 6 {
+		t.Fatalf("Expected the 2 stolen voices to keep fading, got %d voices", len(e.activeVoices))
+	}
+	renderTestFrames(e, 1024)
+	if len(e.activeVoices) != 4 {
+		t.Errorf("Expected stolen voices to finish after their fade, got %d voices", len(e.activeVoices))
+	}
+}
+
+func TestStealPolicies(t *testing.T) {
+	sfz := `<region>
+sample=sample1.wav
+loop_mode=loop_continuous
+ampeg_release=5
+`
+	tests := []struct {
+		policy StealPolicy
+		want   []uint8 // Sounding notes after playing 60, 62 (soft), 64, releasing 64, then 67
+	}{
+		{StealOldest, []uint8{62, 64, 67}},
+		{StealQuietest, []uint8{60, 64, 67}},
+		{StealReleasedFirst, []uint8{60, 62, 67}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			e := createTestEngine(t, sfz)
+			e.player.SetPolyphony(3)
+			e.player.SetStealPolicy(tt.policy)
+
+			e.noteOn(60, 127)
+			e.noteOn(62, 10)
+			e.noteOn(64, 127)
+			renderTestFrames(e, 512)
+			e.noteOff(64)
+			renderTestFrames(e, 512)
+			e.noteOn(67, 100)
+
+			if got := soundingNotes(e); !equalNotes(got, tt.want) {
+				t.Errorf("Expected %v to sound, got %v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("same-note-first", func(t *testing.T) {
+		e := createTestEngine(t, sfz)
+		e.player.SetPolyphony(3)
+		e.player.SetStealPolicy(StealSameNoteFirst)
+
+		e.noteOn(60, 100)
+		e.noteOn(62, 100)
+		e.noteOn(62, 100)
+		e.noteOn(60, 100)
+		if got := soundingNotes(e); !equalNotes(got, []uint8{62, 62, 60}) {
+			t.Errorf("Expected the earlier note 60 to be stolen, got %v", got)
+		}
+	})
+}
+
+func TestPolyphonyOpcodes(t *testing.T) {
+	e := createTestEngine(t, `<group>
+polyphony=2
+<region>
+sample=sample1.wav
+lokey=0
+hikey=59
+loop_mode=loop_continuous
+
+<group>
+group=1
+group_polyphony=1
+<region>
+sample=sample2.wav
+lokey=60
+hikey=69
+loop_mode=loop_continuous
+
+<group>
+note_polyphony=1
+<region>
+sample=sample3.wav
+lokey=70
+hikey=127
+loop_mode=loop_continuous
+`)
+	e.player.SetStealPolicy(StealOldest)
+
+	// polyphony limits the <group> to 2 voices
+	e.noteOn(40, 100)
+	e.noteOn(41, 100)
+	e.noteOn(42, 100)
+	if got := soundingNotes(e); !equalNotes(got, []uint8{41, 42}) {
+		t.Errorf("polyphony=2: expected notes 41 and 42, got %v", got)
+	}
+
+	// group_polyphony limits group=1 to one voice
+	e.clearVoices()
+	e.noteOn(60, 100)
+	e.noteOn(61, 100)
+	if got := soundingNotes(e); !equalNotes(got, []uint8{61}) {
+		t.Errorf("group_polyphony=1: expected note 61, got %v", got)
+	}
+
+	// note_polyphony limits each note to one voice, without limiting other notes
+	e.clearVoices()
+	e.noteOn(72, 100)
+	e.noteOn(74, 100)
+	e.noteOn(72, 100)
+	if got := soundingNotes(e); !equalNotes(got, []uint8{74, 72}) {
+		t.Errorf("note_polyphony=1: expected notes 74 and 72, got %v", got)
+	}
+}
+
+// equalNotes reports whether two note lists are identical
+func equalNotes(a, b []uint8) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
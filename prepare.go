@@ -0,0 +1,176 @@
+package gosfzplayer
+
+import (
+	"math"
+	"slices"
+)
+
+// neverTriggered marks a group that hasn't played since the engine switched to its instrument
+const neverTriggered = math.MaxUint64
+
+// instrumentState is an engine's state for one instrument, prepared off the audio thread: its
+// effect processors, and its round-robin counters and group trigger frames with every key the
+// instrument's regions can use already in place, so note-ons only update them
+type instrumentState struct {
+	processors    busProcessors
+	seqCounters   map[seqKey]int
+	groupTriggers map[*SfzSection]uint64 // Frame at which each group last played
+}
+
+// newInstrumentState creates an engine's state for an instrument at a sample rate, sharing
+// effect processors with running like newBusProcessors
+func newInstrumentState(inst *instrument, sampleRate uint32, running map[Effect]Effect) *instrumentState {
+	state := &instrumentState{
+		processors:    *newBusProcessors(inst, sampleRate, running),
+		seqCounters:   make(map[seqKey]int),
+		groupTriggers: make(map[*SfzSection]uint64),
+	}
+	for _, region := range inst.regions {
+		state.groupTriggers[region.parentGroup()] = neverTriggered
+		if region.SeqLength <= 1 {
+			continue
+		}
+		for key := max(region.LoKey, 0); key <= min(region.HiKey, 127); key++ {
+			state.seqCounters[seqKeyFor(region, uint8(key))] = 0
+		}
+	}
+	return state
+}
+
+// restart starts the round robins over and forgets when groups last played, keeping the keys
+func (s *instrumentState) restart() {
+	for key := range s.seqCounters {
+		s.seqCounters[key] = 0
+	}
+	for group := range s.groupTriggers {
+		s.groupTriggers[group] = neverTriggered
+	}
+}
+
+// busProcessors are the effects an engine runs on an instrument's buses: a processor with state of
+// its own for each SharedEffect, and the effect itself for the others
+type busProcessors [numBuses][]Effect
+
+// newBusProcessors creates the processors for an instrument's effects at a sample rate. Effects
+// that already have one in running, like the player's reverb shared by its instruments, keep it
+// so their tails carry over; new ones are added to running if it isn't nil.
+func newBusProcessors(inst *instrument, sampleRate uint32, running map[Effect]Effect) *busProcessors {
+	processors := &busProcessors{}
+	for bus := range inst.buses {
+		for _, effect := range inst.buses[bus].effects {
+			processor, exists := running[effect]
+			if !exists {
+				processor = effect
+				if shared, ok := effect.(SharedEffect); ok {
+					processor = shared.NewProcessor(sampleRate)
+				}
+				if running != nil {
+					running[effect] = processor
+				}
+			}
+			processors[bus] = append(processors[bus], processor)
+		}
+	}
+	return processors
+}
+
+// attach prepares the engine's state for a player's instruments and has the player prepare it for
+// each instrument it loads from now on
+func (e *engine) attach(player *SfzPlayer) {
+	e.prepareMu.Lock()
+	if !slices.Contains(e.players, player) {
+		e.players = append(e.players, player)
+	}
+	e.prepareMu.Unlock()
+
+	player.enginesMu.Lock()
+	if !slices.Contains(player.engines, e) {
+		player.engines = append(player.engines, e)
+	}
+	player.enginesMu.Unlock()
+
+	e.prepare()
+}
+
+// close detaches the engine from the players it prepares state for, once it has stopped rendering
+func (e *engine) close() {
+	e.prepareMu.Lock()
+	players := e.players
+	e.players = nil
+	e.prepared.Store(nil)
+	e.prepareMu.Unlock()
+
+	for _, player := range players {
+		player.enginesMu.Lock()
+		player.engines = slices.DeleteFunc(player.engines, func(other *engine) bool { return other == e })
+		player.enginesMu.Unlock()
+	}
+}
+
+// prepare creates the engine's state for the loaded instruments of the players it is attached to
+// and for upcoming ones about to be swapped in, and has its voices' buffers grown for them, so
+// switching instruments and starting notes on the audio thread doesn't allocate. State of
+// instruments no longer loaded is dropped.
+func (e *engine) prepare(upcoming ...*instrument) {
+	e.prepareMu.Lock()
+	defer e.prepareMu.Unlock()
+
+	var previous map[*instrument]*instrumentState
+	if prepared := e.prepared.Load(); prepared != nil {
+		previous = *prepared
+	}
+	running := make(map[Effect]Effect)
+	for inst, state := range previous {
+		for bus := range inst.buses {
+			for i, effect := range inst.buses[bus].effects {
+				running[effect] = state.processors[bus][i]
+			}
+		}
+	}
+
+	instruments := slices.Clone(upcoming)
+	for _, player := range e.players {
+		instruments = append(instruments, player.loadedInstruments()...)
+	}
+	prepared := make(map[*instrument]*instrumentState, len(instruments))
+	needed := e.reserved
+	for _, inst := range instruments {
+		if _, done := prepared[inst]; done {
+			continue
+		}
+		needed = needed.union(capacityFor(inst))
+		if state, exists := previous[inst]; exists {
+			prepared[inst] = state
+			continue
+		}
+		prepared[inst] = newInstrumentState(inst, e.sampleRate, running)
+	}
+	if needed != e.reserved {
+		e.reserved = needed
+		e.pool.reserve(needed)
+	}
+	e.prepared.Store(&prepared)
+}
+
+// stateFor returns the engine's state for an instrument. State that wasn't prepared in time is
+// created on the spot.
+func (e *engine) stateFor(inst *instrument) *instrumentState {
+	if prepared := e.prepared.Load(); prepared != nil {
+		if state, exists := (*prepared)[inst]; exists {
+			return state
+		}
+	}
+	return newInstrumentState(inst, e.sampleRate, nil)
+}
+
+// prepareEngines has the engines playing the player's instruments prepare their state for
+// instruments about to be swapped in
+func (p *SfzPlayer) prepareEngines(upcoming ...*instrument) {
+	p.enginesMu.Lock()
+	engines := slices.Clone(p.engines)
+	p.enginesMu.Unlock()
+
+	for _, e := range engines {
+		e.prepare(upcoming...)
+	}
+}
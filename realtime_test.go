@@ -0,0 +1,275 @@
+package gosfzplayer
+
+import (
+	"sync"
+	"testing"
+)
+
+// realtimeTestSfz exercises the per-block work of a typical instrument: a looping sample with a
+// filter, an LFO, CC modulation and reverb
+const realtimeTestSfz = `<global>
+reverb_send=30
+
+<region>
+sample=sample1.wav
+lokey=0
+hikey=127
+loop_mode=loop_continuous
+cutoff=2000
+fil_type=lpf_2p
+lfo01_freq=5
+lfo01_volume=3
+volume_oncc7=-6
+`
+
+func TestSteadyStateRenderDoesNotAllocate(t *testing.T) {
+	e := createTestEngine(t, realtimeTestSfz)
+	output := make([]float32, 256)
+
+	e.noteOn(60, 100)
+	e.noteOn(64, 100)
+	e.render(output)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		for i := range output {
+			output[i] = 0
+		}
+		e.render(output)
+	})
+	if allocs != 0 {
+		t.Errorf("Expected a steady-state render to allocate nothing, got %.1f allocations", allocs)
+	}
+}
+
+func TestNoteEventsDoNotAllocate(t *testing.T) {
+	e := createTestEngine(t, realtimeTestSfz)
+	output := make([]float32, 256)
+
+	// Warm up the scratch buffers and per-key state
+	e.processMidiMessage([]byte{0x90, 60, 100})
+	e.processMidiMessage([]byte{0x80, 60, 0})
+	e.render(output)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		e.processMidiMessage([]byte{0x90, 60, 100})
+		e.processMidiMessage([]byte{0xB0, 7, 90})
+		e.processMidiMessage([]byte{0xE0, 0, 80})
+		e.render(output)
+		e.processMidiMessage([]byte{0x80, 60, 0})
+		e.render(output)
+	})
+	if allocs != 0 {
+		t.Errorf("Expected note events to allocate nothing once warmed up, got %.1f allocations", allocs)
+	}
+}
+
+func TestVoicePoolReuse(t *testing.T) {
+	e := createTestEngine(t, realtimeTestSfz)
+
+	for i := 0; i < 40; i++ {
+		e.noteOn(uint8(i), 100)
+	}
+	e.clearVoices()
+	if len(e.pool.free) != len(e.pool.voices) {
+		t.Errorf("Expected all %d voices back in the pool, got %d", len(e.pool.voices), len(e.pool.free))
+	}
+}
+
+func TestControlQueue(t *testing.T) {
+	var q controlQueue
+	for i := 0; i < controlQueueSize; i++ {
+		if !q.push([]byte{0x90, byte(i % 128), 100}) {
+			t.Fatalf("Push %d failed before the queue was full", i)
+		}
+	}
+	if q.push([]byte{0x80, 60, 0}) {
+		t.Error("Expected push to fail on a full queue")
+	}
+
+	var m controlMessage
+	for i := 0; i < controlQueueSize; i++ {
+		if !q.pop(&m) || m.length != 3 || m.data[1] != byte(i%128) {
+			t.Fatalf("Pop %d returned %v", i, m.bytes())
+		}
+	}
+	if q.pop(&m) {
+		t.Error("Expected pop to fail on an empty queue")
+	}
+}
+
+// TestConcurrentControl renders on one goroutine while others send MIDI and change parameters;
+// run it with -race
+func TestConcurrentControl(t *testing.T) {
+	e := createTestEngine(t, realtimeTestSfz)
+	rack := NewMultitimbral(44100)
+	if err := rack.SetPart(1, e.player); err != nil {
+		t.Fatalf("SetPart failed: %v", err)
+	}
+
+	done := make(chan struct{})
+	var renderers sync.WaitGroup
+	renderers.Add(1)
+	go func() {
+		defer renderers.Done()
+		mono := make([]float32, 128)
+		left := make([]float32, 128)
+		right := make([]float32, 128)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			e.render(mono)
+			rack.Render(left, right)
+		}
+	}()
+
+	var producers sync.WaitGroup
+	for p := 0; p < 4; p++ {
+		producers.Add(1)
+		go func(p int) {
+			defer producers.Done()
+			for i := 0; i < 200; i++ {
+				note := byte(48 + (p*7+i)%24)
+				e.sendMidi([]byte{0x90, note, 100})
+				e.sendMidi([]byte{0xB0, 91, byte(i % 128)})
+				e.sendMidi([]byte{0x80, note, 0})
+				rack.SendMidi([]byte{0x90, note, 90})
+				rack.SetPartVolume(1, -float64(i%12))
+				rack.SetPartPan(1, float64(p-2)/2)
+				e.player.SetReverbRoomSize(float64(i%10) / 10)
+				e.player.SetTempo(float64(100 + i))
+				e.player.SetRandomSeed(int64(i))
+				_ = e.player.GetReverbSend()
+			}
+		}(p)
+	}
+
+	producers.Wait()
+	close(done)
+	renderers.Wait()
+}
+
+// firstBlockTestSfz has every kind of per-voice and per-engine state a note-on sets up: round
+// robins, group timers, LFOs and envelopes with CC routes, EQ bands, CC crossfades and effect
+// buses
+const firstBlockTestSfz = `<group>
+seq_length=2
+<region>
+sample=sample1.wav
+lokey=0
+hikey=127
+seq_position=1
+lfo01_freq=5
+lfo01_pitch=20
+lfo01_pitch_oncc1=30
+lfo02_freq=2
+lfo02_freq_oncc2=3
+lfo02_cutoff=500
+cutoff=2000
+eg01_time1=0.1
+eg01_level1=1
+eg01_volume=-6
+eg01_volume_oncc3=2
+eq1_gain=6
+eq1_freq_oncc4=1000
+xfin_locc5=0
+xfin_hicc5=64
+effect2=50
+<region>
+sample=sample1.wav
+lokey=0
+hikey=127
+seq_position=2
+pan_oncc10=50
+
+<group>
+lotimer=0.0
+<region>
+sample=sample1.wav
+lokey=0
+hikey=127
+volume_oncc7=-6
+
+<effect>
+bus=fx2
+type=delay
+
+<effect>
+type=chorus
+`
+
+func TestFirstBlockDoesNotAllocate(t *testing.T) {
+	e := createTestEngine(t, firstBlockTestSfz)
+	rack := NewMultitimbral(44100)
+	if err := rack.SetPart(1, e.player); err != nil {
+		t.Fatalf("SetPart failed: %v", err)
+	}
+
+	// AllocsPerRun runs the function once more than asked to warm up, so every run gets engines
+	// that have never rendered
+	const runs = 10
+	engines := make([]*engine, runs+1)
+	racks := make([]*Multitimbral, runs+1)
+	for i := range engines {
+		engines[i] = newEngine(e.player, 44100)
+		racks[i] = NewMultitimbral(44100)
+		if err := racks[i].SetPart(1, e.player); err != nil {
+			t.Fatalf("SetPart failed: %v", err)
+		}
+	}
+	mono := make([]float32, 1024)
+	left := make([]float32, 1024)
+	right := make([]float32, 1024)
+	events := []MidiEvent{
+		{Frame: 0, Data: []byte{0x90, 60, 100}},
+		{Frame: 100, Data: []byte{0x90, 64, 100}},
+		{Frame: 200, Data: []byte{0xB0, 1, 90}},
+		{Frame: 300, Data: []byte{0x90, 60, 100}},
+		{Frame: 400, Data: []byte{0x80, 64, 0}},
+	}
+
+	run := 0
+	allocs := testing.AllocsPerRun(runs, func() {
+		engines[run].renderEvents(mono, events)
+		engines[run].renderStereoEvents(left, right, events)
+		racks[run].RenderEvents(left, right, events)
+		run++
+	})
+	if allocs != 0 {
+		t.Errorf("Expected the first blocks of new engines and racks to allocate nothing, got %.1f allocations", allocs)
+	}
+}
+
+func TestLoadedInstrumentDoesNotAllocate(t *testing.T) {
+	_, sfzPath := createReloadDir(t, firstBlockTestSfz)
+
+	// Engines playing a simpler instrument switch to one needing more voice buffers, round-robin
+	// keys and effect processors
+	const runs = 10
+	engines := make([]*engine, runs+1)
+	output := make([]float32, 1024)
+	for i := range engines {
+		engines[i] = createTestEngine(t, realtimeTestSfz)
+		engines[i].noteOn(60, 100)
+		engines[i].render(output)
+		if err := engines[i].player.LoadInstrument(sfzPath); err != nil {
+			t.Fatalf("LoadInstrument failed: %v", err)
+		}
+	}
+	events := []MidiEvent{
+		{Frame: 0, Data: []byte{0x90, 60, 100}},
+		{Frame: 100, Data: []byte{0x90, 64, 100}},
+		{Frame: 200, Data: []byte{0x90, 60, 100}},
+	}
+
+	run := 0
+	allocs := testing.AllocsPerRun(runs, func() {
+		engines[run].renderEvents(output, events)
+		run++
+	})
+	if allocs != 0 {
+		t.Errorf("Expected the first block on a newly loaded instrument to allocate nothing, got %.1f allocations", allocs)
+	}
+}
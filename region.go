@@ -0,0 +1,263 @@
+package gosfzplayer
+
+import (
+	"math"
+)
+
+// Region is a region with every supported opcode resolved through inheritance (Region → Group → Global),
+// converted and range-checked once at load time. Regions are shared by all voices playing them and are
+// never modified after compilation; SfzSection remains the raw parsed form for tools.
+type Region struct {
+	Section *SfzSection // Parsed region the Region was compiled from
+	Sample  string      // Sample path relative to the SFZ file
+
+	// Key, velocity and channel mapping
+	LoKey, HiKey   int // Ranges outside 0-127 never match
+	LoVel, HiVel   int
+	LoChan, HiChan int    // MIDI channels 1-16
+	Trigger        string // attack, release, first or legato
+
+	// Amplifier
+	Volume       float64 // dB, -60 to 6
+	Pan          float64 // -100 to 100
+	AmpVeltrack  float64 // -100 to 100%
+	AmpKeytrack  float64 // dB per key
+	AmpKeycenter int
+
+	// Pitch
+	PitchKeycenter int     // Root key, -1 to use the played note
+	Transpose      int     // Semitones
+	Tune, Pitch    float64 // Cents
+	BendUp         float64 // Cents at full upward bend
+	BendDown       float64 // Cents at full downward bend (negative for a downward bend)
+	BendStep       float64 // Cents, at least 1
+	BendSmooth     float64 // Milliseconds
+
+	// Amplitude envelope
+	AmpegAttack  float64 // Seconds
+	AmpegDecay   float64 // Seconds
+	AmpegSustain float64 // Level, 0.0 to 1.0
+	AmpegRelease float64 // Seconds
+
+	// Looping
+	LoopMode  string // no_loop, one_shot, loop_continuous or loop_sustain
+	LoopStart int    // Frames
+	LoopEnd   int    // Frames, -1 for the end of the sample
+
+	// Filter
+	FilType   string
+	Cutoff    float64 // Hz, 0 if the region has no filter
+	Resonance float64 // dB
+
+	// Exclusive groups and polyphony (0 = no limit)
+	Group          int // group
+	OffBy          int // off_by
+	Polyphony      int // Voices sounding at once from the region's <group>
+	NotePolyphony  int // Voices sounding at once per note within the region's group
+	GroupPolyphony int // Voices sounding at once from regions with the same group
+
+	// Keyswitches (-1 when not set)
+	SwLoKey, SwHiKey int
+	SwLast           int
+	SwDown, SwUp     int
+	SwPrevious       int
+	SwDefault        int
+	SwLabel          string
+	SwVelPrevious    bool // sw_vel=previous
+
+	// Round-robin and random selection
+	SeqLength, SeqPosition int
+	LoRand, HiRand         float64
+
+	// Pedals
+	SustainSw   bool // Responds to the sustain pedal (sustain_sw)
+	SostenutoSw bool // Responds to the sostenuto pedal (sostenuto_sw)
+	SustainCC   int  // Controller used as the sustain pedal (sustain_cc)
+
+	// Effects
+	Effect1 float64 // Reverb (fx1) send, 0-100% (effect1, or reverb_send); -1 to use the player's send level
+	Effect2 float64 // fx2 send, 0-100% (effect2)
+	Effect3 float64 // fx3 send, 0-100% (effect3)
+	Effect4 float64 // fx4 send, 0-100% (effect4)
+
+	// EQ
+	EQ []EQBand // eq1-eq3 bands that can have a gain, in band order
+
+	// Compiled routing
+	sample        *Sample           // Loaded sample, resolved when the instrument loads (nil if none)
+	velocityCurve *Curve            // amp_velcurve_N points
+	keyXfade      keyVelCrossfade   // xfin_lokey/xfout_hivel crossfades
+	modulation    *modSpec          // CC, LFO and EG modulation routing
+	crossfade     *crossfadeSpec    // CC crossfades (nil if none)
+	eqRouting     []regionEQRouting // Velocity and CC routing of each EQ band
+	conditions    *regionConditions // CC, bend, aftertouch, tempo and timer conditions
+}
+
+// Default amplitude envelope (seconds, sustain level 0.0-1.0)
+const (
+	defaultAmpegAttack  = 0.001
+	defaultAmpegDecay   = 0.1
+	defaultAmpegSustain = 1.0
+	defaultAmpegRelease = 0.1
+)
+
+// compileRegion resolves, converts and range-checks a parsed region's opcodes
+func compileRegion(section *SfzSection, curves *CurveSet) *Region {
+	if curves == nil {
+		curves = NewCurveSet(nil)
+	}
+
+	r := &Region{
+		Section: section,
+		Sample:  section.GetStringOpcode("sample"),
+
+		LoKey:   section.GetInheritedIntOpcode("lokey", 0),
+		HiKey:   section.GetInheritedIntOpcode("hikey", 127),
+		LoVel:   section.GetInheritedIntOpcode("lovel", 1),
+		HiVel:   section.GetInheritedIntOpcode("hivel", 127),
+		LoChan:  section.GetInheritedIntOpcode("lochan", 1),
+		HiChan:  section.GetInheritedIntOpcode("hichan", 16),
+		Trigger: section.GetInheritedStringOpcode("trigger"),
+
+		Volume:       clampFloat64(section.GetInheritedFloatOpcode("volume", 0.0), -60.0, 6.0),
+		Pan:          clampFloat64(section.GetInheritedFloatOpcode("pan", 0.0), -100.0, 100.0),
+		AmpVeltrack:  clampFloat64(section.GetInheritedFloatOpcode("amp_veltrack", 100.0), -100.0, 100.0),
+		AmpKeytrack:  section.GetInheritedFloatOpcode("amp_keytrack", 0.0),
+		AmpKeycenter: section.GetInheritedIntOpcode("amp_keycenter", 60),
+
+		PitchKeycenter: section.GetInheritedIntOpcode("pitch_keycenter", -1),
+		Transpose:      section.GetInheritedIntOpcode("transpose", 0),
+		Tune:           section.GetInheritedFloatOpcode("tune", 0.0),
+		Pitch:          section.GetInheritedFloatOpcode("pitch", 0.0),
+		BendUp:         section.GetInheritedFloatOpcode("bend_up", 200.0),
+		BendDown:       section.GetInheritedFloatOpcode("bend_down", -200.0),
+		BendStep:       math.Max(section.GetInheritedFloatOpcode("bend_step", 1.0), 1.0),
+		BendSmooth:     math.Max(section.GetInheritedFloatOpcode("bend_smooth", 0.0), 0.0),
+
+		AmpegAttack:  section.GetInheritedFloatOpcode("ampeg_attack", defaultAmpegAttack),
+		AmpegDecay:   section.GetInheritedFloatOpcode("ampeg_decay", defaultAmpegDecay),
+		AmpegSustain: section.GetInheritedFloatOpcode("ampeg_sustain", defaultAmpegSustain*100) / 100.0, // Percentage to 0-1
+		AmpegRelease: section.GetInheritedFloatOpcode("ampeg_release", defaultAmpegRelease),
+
+		LoopMode:  section.GetInheritedStringOpcode("loop_mode"),
+		LoopStart: section.GetInheritedIntOpcode("loop_start", 0),
+		LoopEnd:   section.GetInheritedIntOpcode("loop_end", -1),
+
+		FilType:   section.GetInheritedStringOpcode("fil_type"),
+		Resonance: section.GetInheritedFloatOpcode("resonance", 0),
+
+		Group:          section.GetInheritedIntOpcode("group", 0),
+		OffBy:          section.GetInheritedIntOpcode("off_by", 0),
+		Polyphony:      max(section.GetInheritedIntOpcode("polyphony", 0), 0),
+		NotePolyphony:  max(section.GetInheritedIntOpcode("note_polyphony", 0), 0),
+		GroupPolyphony: max(section.GetInheritedIntOpcode("group_polyphony", 0), 0),
+
+		SwLoKey:       section.GetInheritedIntOpcode("sw_lokey", -1),
+		SwHiKey:       section.GetInheritedIntOpcode("sw_hikey", -1),
+		SwLast:        section.GetInheritedIntOpcode("sw_last", -1),
+		SwDown:        section.GetInheritedIntOpcode("sw_down", -1),
+		SwUp:          section.GetInheritedIntOpcode("sw_up", -1),
+		SwPrevious:    section.GetInheritedIntOpcode("sw_previous", -1),
+		SwDefault:     section.GetInheritedIntOpcode("sw_default", -1),
+		SwLabel:       section.GetInheritedStringOpcode("sw_label"),
+		SwVelPrevious: section.GetInheritedStringOpcode("sw_vel") == "previous",
+
+		SeqLength:   section.GetInheritedIntOpcode("seq_length", 1),
+		SeqPosition: section.GetInheritedIntOpcode("seq_position", 1),
+		LoRand:      section.GetInheritedFloatOpcode("lorand", 0.0),
+		HiRand:      section.GetInheritedFloatOpcode("hirand", 1.0),
+
+		SustainSw:   section.GetInheritedStringOpcode("sustain_sw") != "off",
+		SostenutoSw: section.GetInheritedStringOpcode("sostenuto_sw") != "off",
+		SustainCC:   section.GetInheritedIntOpcode("sustain_cc", sustainPedalCC),
+
+		Effect1: -1,
+		Effect2: clampFloat64(section.GetInheritedFloatOpcode("effect2", 0), 0, 100),
+		Effect3: clampFloat64(section.GetInheritedFloatOpcode("effect3", 0), 0, 100),
+		Effect4: clampFloat64(section.GetInheritedFloatOpcode("effect4", 0), 0, 100),
+
+		velocityCurve: compileVelocityCurve(section),
+		keyXfade:      compileKeyVelCrossfade(section),
+		modulation:    compileModSpec(section, curves),
+		crossfade:     compileCrossfadeSpec(section),
+		conditions:    compileRegionConditions(section),
+	}
+
+	// If key is specified, use it as both lokey and hikey
+	if key := section.GetInheritedIntOpcode("key", -1); key >= 0 {
+		r.LoKey = key
+		r.HiKey = key
+	}
+	if r.Trigger == "" {
+		r.Trigger = "attack"
+	}
+	for _, opcode := range []string{"effect1", "reverb_send"} {
+		if _, set := section.getInheritedValue(opcode); set {
+			r.Effect1 = clampFloat64(section.GetInheritedFloatOpcode(opcode, 0), 0, 100)
+			break
+		}
+	}
+	r.EQ, r.eqRouting = compileRegionEQ(section)
+	if r.PitchKeycenter < 0 || r.PitchKeycenter > 127 {
+		r.PitchKeycenter = -1
+	}
+
+	// Envelope values out of range fall back to the defaults
+	if r.AmpegAttack < 0 {
+		r.AmpegAttack = defaultAmpegAttack
+	}
+	if r.AmpegDecay < 0 {
+		r.AmpegDecay = defaultAmpegDecay
+	}
+	if r.AmpegSustain < 0 || r.AmpegSustain > 1 {
+		r.AmpegSustain = defaultAmpegSustain
+	}
+	if r.AmpegRelease < 0 {
+		r.AmpegRelease = defaultAmpegRelease
+	}
+
+	if r.LoopMode == "" {
+		r.LoopMode = "no_loop"
+	}
+
+	// Only a positive cutoff enables the filter
+	if section.GetInheritedStringOpcode("cutoff") != "" {
+		r.Cutoff = math.Max(section.GetInheritedFloatOpcode("cutoff", 0), 0)
+	}
+
+	if r.SustainCC < 0 || r.SustainCC > 127 {
+		r.SustainCC = sustainPedalCC
+	}
+
+	return r
+}
+
+// compileRegions compiles every parsed region of an instrument, in file order
+func compileRegions(sections []*SfzSection, curves *CurveSet) []*Region {
+	regions := make([]*Region, len(sections))
+	for i, section := range sections {
+		regions[i] = compileRegion(section, curves)
+	}
+	return regions
+}
+
+// parentGroup returns the group the region belongs to (nil for ungrouped regions)
+func (r *Region) parentGroup() *SfzSection {
+	return r.Section.ParentGroup
+}
+
+// matchesChannel checks a MIDI channel (0-15) against the region's lochan/hichan
+func (r *Region) matchesChannel(channel uint8) bool {
+	sfzChannel := int(channel) + 1
+	return sfzChannel >= r.LoChan && sfzChannel <= r.HiChan
+}
+
+// Regions returns copies of the instrument's compiled regions in file order
+func (p *SfzPlayer) Regions() []Region {
+	compiled := p.current().regions
+	regions := make([]Region, len(compiled))
+	for i, region := range compiled {
+		regions[i] = *region
+	}
+	return regions
+}
@@ -0,0 +1,92 @@
+package gosfzplayer
+
+import (
+	"testing"
+)
+
+func TestCompileRegionInheritance(t *testing.T) {
+	global := &SfzSection{
+		Type:    "global",
+		Opcodes: map[string]string{"volume": "-6", "ampeg_release": "0.5", "sw_vel": "previous"},
+	}
+	group := &SfzSection{
+		Type:      "group",
+		Opcodes:   map[string]string{"key": "60", "trigger": "release", "cutoff": "1000", "fil_type": "hpf_2p"},
+		GlobalRef: global,
+	}
+	section := &SfzSection{
+		Type:        "region",
+		Opcodes:     map[string]string{"sample": "a.wav", "lokey": "10", "ampeg_release": "1.5", "seq_length": "4"},
+		ParentGroup: group,
+		GlobalRef:   global,
+	}
+
+	region := compileRegion(section, nil)
+
+	if region.Section != section || region.Sample != "a.wav" {
+		t.Errorf("Expected the region to keep its section and sample, got %+v", region)
+	}
+	if region.LoKey != 60 || region.HiKey != 60 {
+		t.Errorf("Expected key=60 to override lokey, got %d-%d", region.LoKey, region.HiKey)
+	}
+	if region.Volume != -6 || region.AmpegRelease != 1.5 || !region.SwVelPrevious {
+		t.Errorf("Expected inherited volume, overridden release and sw_vel, got %v %v %v",
+			region.Volume, region.AmpegRelease, region.SwVelPrevious)
+	}
+	if region.Trigger != "release" || region.Cutoff != 1000 || region.FilType != "hpf_2p" || region.SeqLength != 4 {
+		t.Errorf("Unexpected group opcodes: trigger=%s cutoff=%v fil_type=%s seq_length=%d",
+			region.Trigger, region.Cutoff, region.FilType, region.SeqLength)
+	}
+	if region.parentGroup() != group {
+		t.Error("Expected the region's parent group to be preserved")
+	}
+}
+
+func TestCompileRegionRangeChecks(t *testing.T) {
+	region := compileRegion(&SfzSection{
+		Type: "region",
+		Opcodes: map[string]string{
+			"volume":          "20",
+			"pan":             "-300",
+			"ampeg_attack":    "-1",
+			"ampeg_sustain":   "150",
+			"pitch_keycenter": "200",
+			"sustain_cc":      "300",
+			"bend_step":       "0",
+			"cutoff":          "-50",
+		},
+	}, nil)
+
+	if region.Volume != 6 || region.Pan != -100 {
+		t.Errorf("Expected volume and pan to be clamped, got %v and %v", region.Volume, region.Pan)
+	}
+	if region.AmpegAttack != defaultAmpegAttack || region.AmpegSustain != defaultAmpegSustain {
+		t.Errorf("Expected invalid envelope values to use defaults, got attack=%v sustain=%v",
+			region.AmpegAttack, region.AmpegSustain)
+	}
+	if region.PitchKeycenter != -1 || region.SustainCC != sustainPedalCC || region.BendStep != 1 {
+		t.Errorf("Unexpected range checks: keycenter=%d sustain_cc=%d bend_step=%v",
+			region.PitchKeycenter, region.SustainCC, region.BendStep)
+	}
+	if region.Cutoff != 0 || region.LoopMode != "no_loop" || region.Trigger != "attack" {
+		t.Errorf("Unexpected defaults: cutoff=%v loop_mode=%s trigger=%s", region.Cutoff, region.LoopMode, region.Trigger)
+	}
+}
+
+func TestPlayerRegionsAreCopies(t *testing.T) {
+	e := createTestEngine(t, `<region>
+sample=sample1.wav
+key=60
+volume=-3
+`)
+
+	regions := e.player.Regions()
+	if len(regions) != 1 || regions[0].Volume != -3 || regions[0].LoKey != 60 {
+		t.Fatalf("Unexpected compiled regions: %+v", regions)
+	}
+
+	regions[0].Volume = 0
+	if e.player.current().regions[0].Volume != -3 {
+		t.Error("Expected Regions to return copies that don't affect playback")
+	}
+}
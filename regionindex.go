@@ -0,0 +1,92 @@
+package gosfzplayer
+
+import "sort"
+
+// regionIndex maps each key to the regions that can play it, in file order
+type regionIndex struct {
+	attack  [128][]velocityBand // Regions started by note-on (attack, first, legato), by velocity
+	release [128][]*Region      // Regions started by note-off (trigger=release)
+}
+
+// velocityBand is a range of velocities, up to the next band's lowest, that the same regions of a
+// key can match
+type velocityBand struct {
+	lovel   int
+	regions []*Region
+}
+
+// newRegionIndex builds the per-key lookup index for an instrument
+func newRegionIndex(regions []*Region) *regionIndex {
+	ri := &regionIndex{}
+	var attack [128][]*Region
+	for _, region := range regions {
+		lokey := max(region.LoKey, 0)
+		hikey := min(region.HiKey, 127)
+		for key := lokey; key <= hikey; key++ {
+			if region.Trigger == "release" {
+				ri.release[key] = append(ri.release[key], region)
+			} else {
+				attack[key] = append(attack[key], region)
+			}
+		}
+	}
+	for key := range attack {
+		ri.attack[key] = newVelocityBands(attack[key])
+	}
+	return ri
+}
+
+// newVelocityBands splits a key's regions into bands at the edges of their velocity ranges. Regions
+// with sw_vel=previous match the previous note's velocity, so they are in every band.
+func newVelocityBands(regions []*Region) []velocityBand {
+	if len(regions) == 0 {
+		return nil
+	}
+
+	var edges [129]bool
+	edges[0] = true
+	for _, region := range regions {
+		lovel, hivel := max(region.LoVel, 0), min(region.HiVel, 127)
+		if !region.SwVelPrevious && lovel <= hivel {
+			edges[lovel] = true
+			edges[hivel+1] = true
+		}
+	}
+
+	var bands []velocityBand
+	for velocity := 0; velocity < 128; velocity++ {
+		if !edges[velocity] {
+			continue
+		}
+		band := velocityBand{lovel: velocity}
+		for _, region := range regions {
+			if region.SwVelPrevious || (velocity >= region.LoVel && velocity <= region.HiVel) {
+				band.regions = append(band.regions, region)
+			}
+		}
+		bands = append(bands, band)
+	}
+	return bands
+}
+
+// attackRegions returns the note-on regions whose key range includes a note and whose velocity
+// range can include a velocity
+func (ri *regionIndex) attackRegions(note, velocity uint8) []*Region {
+	if ri == nil {
+		return nil
+	}
+	bands := ri.attack[note&0x7F]
+	i := sort.Search(len(bands), func(i int) bool { return bands[i].lovel > int(velocity) }) - 1
+	if i < 0 {
+		return nil
+	}
+	return bands[i].regions
+}
+
+// releaseRegions returns the release-trigger regions whose key range includes a note
+func (ri *regionIndex) releaseRegions(note uint8) []*Region {
+	if ri == nil {
+		return nil
+	}
+	return ri.release[note&0x7F]
+}
@@ -0,0 +1,169 @@
+package gosfzplayer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Generated instrument size: 128 keys x 8 velocity layers x 20 round-robins = 20480 regions
+const (
+	largeSfzVelocityLayers = 8
+	largeSfzRoundRobins    = 20
+)
+
+// generateLargeSfz builds an instrument with one region per key, velocity layer and round-robin step
+func generateLargeSfz() string {
+	var sb strings.Builder
+	layerWidth := 128 / largeSfzVelocityLayers
+	for key := 0; key < 128; key++ {
+		for layer := 0; layer < largeSfzVelocityLayers; layer++ {
+			fmt.Fprintf(&sb, "<group>\nkey=%d\nlovel=%d\nhivel=%d\nseq_length=%d\n\n",
+				key, max(layer*layerWidth, 1), (layer+1)*layerWidth-1, largeSfzRoundRobins)
+			for step := 1; step <= largeSfzRoundRobins; step++ {
+				fmt.Fprintf(&sb, "<region>\nsample=sample1.wav\nseq_position=%d\n\n", step)
+			}
+		}
+	}
+	return sb.String()
+}
+
+func TestRegionIndexMatchesLinearScan(t *testing.T) {
+	e := createTestEngine(t, `<group>
+lokey=40
+hikey=80
+
+<region>
+sample=sample1.wav
+lovel=1
+hivel=64
+
+<region>
+sample=sample2.wav
+key=60
+
+<region>
+sample=sample3.wav
+trigger=release
+lokey=55
+hikey=65
+
+<region>
+sample=sample1.wav
+lokey=0
+hikey=127
+
+<region>
+sample=sample2.wav
+lokey=85
+hikey=95
+lovel=100
+hivel=110
+
+<region>
+sample=sample3.wav
+lokey=90
+hikey=100
+lovel=90
+hivel=127
+sw_vel=previous
+`)
+
+	inst := e.player.current()
+	for note := 0; note < 128; note++ {
+		var release []*Region
+		for _, region := range inst.regions {
+			if note >= region.LoKey && note <= region.HiKey && region.Trigger == "release" {
+				release = append(release, region)
+			}
+		}
+		if !sameRegions(inst.index.releaseRegions(uint8(note)), release) {
+			t.Errorf("Note %d: release index doesn't match a linear scan", note)
+		}
+
+		// Regions with sw_vel=previous match another note's velocity, so they are always visited
+		for velocity := 0; velocity < 128; velocity++ {
+			var attack []*Region
+			for _, region := range inst.regions {
+				if note < region.LoKey || note > region.HiKey || region.Trigger == "release" {
+					continue
+				}
+				if region.SwVelPrevious || (velocity >= region.LoVel && velocity <= region.HiVel) {
+					attack = append(attack, region)
+				}
+			}
+			if !sameRegions(inst.index.attackRegions(uint8(note), uint8(velocity)), attack) {
+				t.Errorf("Note %d, velocity %d: attack index doesn't match a linear scan", note, velocity)
+			}
+		}
+	}
+
+	// The key opcode overrides the group's lokey/hikey
+	if got := triggeredSamples(e, []byte{0x90, 60, 100}); len(got) != 2 || got[0] != "sample2.wav" || got[1] != "sample1.wav" {
+		t.Errorf("Expected sample2 and sample1 for note 60 at velocity 100, got %v", got)
+	}
+}
+
+// sameRegions reports whether the indexed regions are exactly the expected regions in order
+func sameRegions(indexed, expected []*Region) bool {
+	if len(indexed) != len(expected) {
+		return false
+	}
+	for i := range indexed {
+		if indexed[i] != expected[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLargeInstrumentNoteOn(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping 20k-region instrument in short mode")
+	}
+
+	e := createTestEngine(t, generateLargeSfz())
+	if got := len(e.player.current().sfzData.Regions); got != 128*largeSfzVelocityLayers*largeSfzRoundRobins {
+		t.Fatalf("Expected %d regions, got %d", 128*largeSfzVelocityLayers*largeSfzRoundRobins, got)
+	}
+
+	// Exactly one round-robin step plays for each note
+	for _, note := range []uint8{0, 36, 60, 127} {
+		e.clearVoices()
+		e.noteOn(note, 100)
+		if len(e.activeVoices) != 1 || e.activeVoices[0].midiNote != note {
+			t.Errorf("Expected one voice for note %d, got %d", note, len(e.activeVoices))
+		}
+		e.noteOff(note)
+	}
+}
+
+func BenchmarkRegionLookup20k(b *testing.B) {
+	e := createTestEngine(b, generateLargeSfz())
+	b.ResetTimer()
+
+	matches := 0
+	for i := 0; i < b.N; i++ {
+		note := uint8(i % 128)
+		for _, region := range e.player.current().index.attackRegions(note, 100) {
+			if e.regionMatches(region, 0, note, 100) {
+				matches++
+			}
+		}
+	}
+	if matches == 0 {
+		b.Fatal("Expected region matches")
+	}
+}
+
+func BenchmarkNoteOn20k(b *testing.B) {
+	e := createTestEngine(b, generateLargeSfz())
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		note := uint8(i % 128)
+		e.clearVoices()
+		e.noteOn(note, uint8(1+i%127))
+		e.noteOff(note)
+	}
+}
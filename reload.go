@@ -0,0 +1,206 @@
+package gosfzplayer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// instrument is one loaded version of an SFZ file. It is never modified once loaded: Reload builds
+// a new one and swaps it in, and each engine picks it up at the start of its next block. Voices
+// already sounding keep their own region and sample pointers, so they finish on the old version.
+type instrument struct {
+	path        string // SFZ file
+	dir         string // Directory of the SFZ file, for relative sample paths
+	sfzData     *SfzData
+	curves      *CurveSet // Built-in curves plus <curve> sections
+	sampleCache *SampleCache
+	regions     []*Region    // Compiled regions in file order
+	index       *regionIndex // Per-key region lookup
+	keyswitches *keyswitchMap
+	buses       effectBuses // Effects and bus levels from <effect> sections
+	files       []fileStamp // SFZ and included files as parsed, for Watch
+}
+
+// fileStamp records a file's modification time and size to detect edits
+type fileStamp struct {
+	path    string
+	modTime int64 // Unix nanoseconds
+	size    int64
+}
+
+// emptyInstrument stands in for players that haven't loaded an SFZ file
+var emptyInstrument = &instrument{
+	sfzData:     &SfzData{},
+	curves:      NewCurveSet(nil),
+	sampleCache: NewSampleCache(),
+	index:       newRegionIndex(nil),
+	keyswitches: compileKeyswitchMap(nil),
+	buses:       defaultEffectBuses(),
+}
+
+// loadInstrument loads an SFZ file and creates its effects at the player's sample rate, sharing
+// samples with the player's instruments and any others given
+func (p *SfzPlayer) loadInstrument(sfzPath string, others ...*instrument) (*instrument, error) {
+	inst, err := loadInstrument(sfzPath, append(p.loadedInstruments(), others...)...)
+	if err != nil {
+		return nil, err
+	}
+	inst.buses = p.buildEffects(inst.sfzData, p.sampleRate.Load())
+	return inst, nil
+}
+
+// loadInstrument parses an SFZ file and loads its samples. Samples whose files haven't changed are
+// shared with the previously loaded instruments instead of being read again.
+func loadInstrument(sfzPath string, previous ...*instrument) (*instrument, error) {
+	sfzData, err := ParseSfzFile(sfzPath)
+	if err != nil {
+		return nil, err
+	}
+	debug("Successfully parsed SFZ file with %d regions", len(sfzData.Regions))
+
+	inst := &instrument{
+		path:        sfzPath,
+		dir:         filepath.Dir(sfzPath),
+		sfzData:     sfzData,
+		curves:      NewCurveSet(sfzData.Curves),
+		sampleCache: NewSampleCache(),
+	}
+	inst.regions = compileRegions(sfzData.Regions, inst.curves)
+	inst.index = newRegionIndex(inst.regions)
+	inst.keyswitches = compileKeyswitchMap(inst.regions)
+	for _, path := range sfzData.Files {
+		inst.files = append(inst.files, stampFile(path))
+	}
+
+	if err := inst.loadSamples(previous); err != nil {
+		return nil, fmt.Errorf("failed to load samples: %w", err)
+	}
+	return inst, nil
+}
+
+// loadSamples resolves every region's sample, taking samples whose files haven't changed from previous
+func (inst *instrument) loadSamples(previous []*instrument) error {
+	debug("Loading all samples referenced in SFZ file")
+
+	reused := 0
+	for i, region := range inst.regions {
+		samplePath := region.Sample
+		if samplePath == "" {
+			debug("Warning: Region %d has no sample opcode", i)
+			continue
+		}
+
+		path := filepath.Join(inst.dir, samplePath)
+		if _, cached := inst.sampleCache.GetSample(path); !cached {
+			for _, other := range previous {
+				if sample, ok := other.sampleCache.GetSample(path); ok && sample.unchanged() {
+					inst.sampleCache.samples[path] = sample
+					reused++
+					break
+				}
+			}
+		}
+
+		debug("Loading sample for region %d: %s", i, samplePath)
+		sample, err := inst.sampleCache.LoadSample(path)
+		if err != nil {
+			return fmt.Errorf("failed to load sample '%s' for region %d: %w", samplePath, i, err)
+		}
+		region.sample = sample
+	}
+
+	debug("Successfully loaded %d unique samples (%d unchanged)", inst.sampleCache.Size(), reused)
+	return nil
+}
+
+// stampFile records a file's current modification time and size (zero if it can't be read)
+func stampFile(path string) fileStamp {
+	stamp := fileStamp{path: path}
+	if info, err := os.Stat(path); err == nil {
+		stamp.modTime = info.ModTime().UnixNano()
+		stamp.size = info.Size()
+	}
+	return stamp
+}
+
+// stampFiles records the current state of an instrument's SFZ files
+func (inst *instrument) stampFiles() []fileStamp {
+	stamps := make([]fileStamp, len(inst.files))
+	for i, stamp := range inst.files {
+		stamps[i] = stampFile(stamp.path)
+	}
+	return stamps
+}
+
+// current returns the instrument new notes play
+func (p *SfzPlayer) current() *instrument {
+	if inst := p.instrument.Load(); inst != nil {
+		return inst
+	}
+	return emptyInstrument
+}
+
+// Reload re-reads the SFZ file and its includes, loads new or changed samples and swaps in the
+// new regions between audio blocks. Notes already sounding finish on the previous version. If the
+// file can't be loaded, the player keeps the previous version and the error is returned.
+func (p *SfzPlayer) Reload() error {
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+
+	previous := p.current()
+	debug("Reloading %s", previous.path)
+	inst, err := p.loadInstrument(previous.path)
+	if err != nil {
+		return fmt.Errorf("failed to reload SFZ file: %w", err)
+	}
+
+	p.prepareEngines(inst)
+	p.instrument.Store(inst)
+	p.replacePresetInstrument(previous, inst)
+	p.activeKeyswitch.Store(int32(inst.keyswitches.carryOver(int(p.activeKeyswitch.Load()))))
+	p.loadReverbSettings()
+
+	debug("Reloaded %s: %d regions", inst.path, len(inst.regions))
+	return nil
+}
+
+// Watch checks the SFZ file and the files it includes every interval and reloads the instrument
+// when one of them changes, until stop is called. onReload, if not nil, is called after each
+// reload with its error. Edited sample files are picked up by the next reload but don't trigger one.
+func (p *SfzPlayer) Watch(interval time.Duration, onReload func(error)) (stop func()) {
+	done := make(chan struct{})
+	var once sync.Once
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		seen := p.current().files
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			stamps := p.current().stampFiles()
+			if slices.Equal(stamps, seen) {
+				continue
+			}
+			err := p.Reload()
+			if err != nil {
+				// Keep the previous instrument until the files change again
+				debug("Warning: Reload failed: %v", err)
+				seen = stamps
+			} else {
+				seen = p.current().files
+			}
+			if onReload != nil {
+				onReload(err)
+			}
+		}
+	}()
+	return func() { once.Do(func() { close(done) }) }
+}
@@ -0,0 +1,236 @@
+package gosfzplayer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// createReloadDir copies test samples into a temporary directory and writes an SFZ file there
+func createReloadDir(t *testing.T, content string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range []string{"sample1.wav", "sample2.wav"} {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatalf("Failed to copy %s: %v", name, err)
+		}
+	}
+	sfzPath := filepath.Join(dir, "instrument.sfz")
+	writeReloadFile(t, sfzPath, content)
+	return dir, sfzPath
+}
+
+// writeReloadFile rewrites a file and moves its modification time forward so the change is seen
+// even on filesystems with coarse timestamps
+func writeReloadFile(t *testing.T, path, content string) {
+	t.Helper()
+	modTime := time.Now()
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime().Add(time.Second)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("Failed to set modification time of %s: %v", path, err)
+	}
+}
+
+func TestReloadKeepsSoundingVoices(t *testing.T) {
+	_, sfzPath := createReloadDir(t, "<region>\nsample=sample1.wav key=60 loop_mode=loop_continuous\n")
+	player, err := NewSfzPlayer(sfzPath, "")
+	if err != nil {
+		t.Fatalf("Failed to create player: %v", err)
+	}
+	e := newEngine(player, 44100)
+
+	e.noteOn(60, 100)
+	renderTestFrames(e, 512)
+	oldRegion := e.activeVoices[0].region
+
+	writeReloadFile(t, sfzPath, "<region>\nsample=sample2.wav key=60\n<region>\nsample=sample2.wav key=62\n")
+	if err := player.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	// The sounding voice finishes on the old region
+	renderTestFrames(e, 512)
+	if len(e.activeVoices) != 1 || e.activeVoices[0].region != oldRegion {
+		t.Fatalf("Expected the sounding voice to keep its region across the reload")
+	}
+
+	// New notes play the new regions
+	e.noteOn(62, 100)
+	if len(e.activeVoices) != 2 || e.activeVoices[1].region.Sample != "sample2.wav" {
+		t.Fatalf("Expected key 62 to start a voice on the reloaded region, got %d voices", len(e.activeVoices))
+	}
+	if got := len(player.Regions()); got != 2 {
+		t.Errorf("Expected 2 regions after reload, got %d", got)
+	}
+}
+
+func TestReloadReusesUnchangedSamples(t *testing.T) {
+	dir, sfzPath := createReloadDir(t, "<region>\nsample=sample1.wav key=60\n<region>\nsample=sample2.wav key=62\n")
+	player, err := NewSfzPlayer(sfzPath, "")
+	if err != nil {
+		t.Fatalf("Failed to create player: %v", err)
+	}
+	sample1, _ := player.GetSample("sample1.wav")
+	sample2, _ := player.GetSample("sample2.wav")
+
+	// Replace sample2.wav with sample1's audio
+	data, err := os.ReadFile(filepath.Join(dir, "sample1.wav"))
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+	writeReloadFile(t, filepath.Join(dir, "sample2.wav"), string(data))
+
+	if err := player.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if reloaded, _ := player.GetSample("sample1.wav"); reloaded != sample1 {
+		t.Errorf("Expected the unchanged sample to be reused")
+	}
+	reloaded, _ := player.GetSample("sample2.wav")
+	if reloaded == sample2 || reloaded.Length != sample1.Length {
+		t.Errorf("Expected the changed sample to be loaded again")
+	}
+}
+
+func TestReloadFailureKeepsInstrument(t *testing.T) {
+	_, sfzPath := createReloadDir(t, "<region>\nsample=sample1.wav key=60\n")
+	player, err := NewSfzPlayer(sfzPath, "")
+	if err != nil {
+		t.Fatalf("Failed to create player: %v", err)
+	}
+	inst := player.current()
+
+	writeReloadFile(t, sfzPath, "<region>\nsample=missing.wav key=60\n")
+	if err := player.Reload(); err == nil {
+		t.Fatal("Expected an error reloading a missing sample")
+	}
+	if player.current() != inst {
+		t.Error("Expected the previous instrument to stay loaded after a failed reload")
+	}
+}
+
+func TestReloadIncludes(t *testing.T) {
+	dir, sfzPath := createReloadDir(t, "<group>\nvolume=-6\n#include \"regions.sfz\"\n")
+	includePath := filepath.Join(dir, "regions.sfz")
+	writeReloadFile(t, includePath, "<region>\nsample=sample1.wav key=60\nbogus_opcode=1\n")
+
+	player, err := NewSfzPlayer(sfzPath, "")
+	if err != nil {
+		t.Fatalf("Failed to create player: %v", err)
+	}
+	sfzData := player.GetSfzData()
+	if len(sfzData.Files) != 2 || sfzData.Files[1] != includePath {
+		t.Fatalf("Expected the SFZ file and its include in Files, got %v", sfzData.Files)
+	}
+	regions := player.Regions()
+	if len(regions) != 1 || regions[0].Volume != -6 || regions[0].Section.File != "regions.sfz" {
+		t.Fatalf("Expected one region from the include inheriting the group, got %+v", regions)
+	}
+	if len(sfzData.Diagnostics) != 1 || !strings.HasPrefix(sfzData.Diagnostics[0].String(), "regions.sfz: line 3:") {
+		t.Errorf("Expected a diagnostic for the included file, got %v", sfzData.Diagnostics)
+	}
+
+	// Editing only the include is picked up by a reload
+	writeReloadFile(t, includePath, "<region>\nsample=sample1.wav key=60\n<region>\nsample=sample2.wav key=62\n")
+	if err := player.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if got := len(player.Regions()); got != 2 {
+		t.Errorf("Expected 2 regions after editing the include, got %d", got)
+	}
+
+	// An include cycle is an error rather than a hang
+	writeReloadFile(t, includePath, "#include \"regions.sfz\"\n")
+	if err := player.Reload(); err == nil {
+		t.Error("Expected an error for an include cycle")
+	}
+}
+
+func TestWatchReloadsOnChange(t *testing.T) {
+	_, sfzPath := createReloadDir(t, "<region>\nsample=sample1.wav key=60\n")
+	player, err := NewSfzPlayer(sfzPath, "")
+	if err != nil {
+		t.Fatalf("Failed to create player: %v", err)
+	}
+
+	reloads := make(chan error, 4)
+	stop := player.Watch(10*time.Millisecond, func(err error) { reloads <- err })
+	defer stop()
+
+	writeReloadFile(t, sfzPath, "<region>\nsample=sample1.wav key=60\n<region>\nsample=sample2.wav key=62\n")
+	select {
+	case err := <-reloads:
+		if err != nil {
+			t.Fatalf("Reload failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the watcher to reload")
+	}
+	if got := len(player.Regions()); got != 2 {
+		t.Errorf("Expected 2 regions after the watcher reloaded, got %d", got)
+	}
+
+	// A broken edit is reported once and the instrument keeps playing
+	writeReloadFile(t, sfzPath, "<region>\nsample=missing.wav key=60\n")
+	select {
+	case err := <-reloads:
+		if err == nil {
+			t.Fatal("Expected the watcher to report the failed reload")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the watcher to report the failure")
+	}
+	select {
+	case err := <-reloads:
+		t.Fatalf("Expected no retry until the file changes again, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if got := len(player.Regions()); got != 2 {
+		t.Errorf("Expected the previous regions to stay loaded, got %d", got)
+	}
+}
+
+func TestReloadWhileRendering(t *testing.T) {
+	_, sfzPath := createReloadDir(t, "<region>\nsample=sample1.wav key=60 seq_length=2 seq_position=1\n")
+	player, err := NewSfzPlayer(sfzPath, "")
+	if err != nil {
+		t.Fatalf("Failed to create player: %v", err)
+	}
+	e := newEngine(player, 44100)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			if err := player.Reload(); err != nil {
+				t.Errorf("Reload failed: %v", err)
+				return
+			}
+		}
+	}()
+
+	output := make([]float32, 256)
+	for i := 0; i < 200; i++ {
+		e.sendMidi([]byte{0x90, 60, 100})
+		e.render(output)
+		e.sendMidi([]byte{0x80, 60, 0})
+	}
+	wg.Wait()
+	e.render(output)
+	if e.inst != player.current() {
+		t.Error("Expected the engine to pick up the last reloaded instrument")
+	}
+}
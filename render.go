@@ -0,0 +1,177 @@
+package gosfzplayer
+
+import (
+	"math"
+	"os"
+
+	"github.com/GeoffreyPlitt/debuggo"
+)
+
+var renderDebug = debuggo.Debug("sfzplayer:render")
+
+// RenderOptions controls offline rendering; zero values use the defaults
+type RenderOptions struct {
+	SampleRate       uint32        // Output sample rate in Hz (default 44100)
+	Channels         int           // 1 for mono (default) or 2 for stereo with panning and the reverb's stereo return
+	BlockSize        int           // Frames rendered per block (default 512)
+	MaxTail          float64       // Longest time in seconds to keep rendering release tails and reverb after the last event (default 10)
+	SilenceThreshold float64       // Peak level below which the tail counts as finished (default 0.0001, -80 dB)
+	Format           SampleFormat  // Output sample format (default FormatPCM16)
+	Dither           Dither        // Dither for integer formats (default none)
+	Metadata         AudioMetadata // Tags written to the output file
+}
+
+// Default offline rendering options
+const (
+	defaultRenderSampleRate = 44100
+	defaultRenderBlockSize  = 512
+	defaultRenderMaxTail    = 10.0
+	defaultSilenceThreshold = 0.0001
+)
+
+// withDefaults returns the options with zero values replaced by defaults
+func (o *RenderOptions) withDefaults() RenderOptions {
+	var opts RenderOptions
+	if o != nil {
+		opts = *o
+	}
+	if opts.SampleRate == 0 {
+		opts.SampleRate = defaultRenderSampleRate
+	}
+	if opts.Channels != 2 {
+		opts.Channels = 1
+	}
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = defaultRenderBlockSize
+	}
+	if opts.MaxTail <= 0 {
+		opts.MaxTail = defaultRenderMaxTail
+	}
+	if opts.SilenceThreshold <= 0 {
+		opts.SilenceThreshold = defaultSilenceThreshold
+	}
+	return opts
+}
+
+// RenderMidiFile plays a Standard MIDI File through the player and writes the result to a WAV
+// file, or FLAC if wavOut ends in .flac, in mono unless opts.Channels is 2. Events land on their exact frame, and rendering continues
+// after the last event until release tails and reverb have decayed to silence (or opts.MaxTail is
+// reached). The player's tempo follows the file's tempo map. opts may be nil.
+func RenderMidiFile(player *SfzPlayer, midiPath, wavOut string, opts *RenderOptions) error {
+	midiFile, err := ParseMidiFile(midiPath)
+	if err != nil {
+		return err
+	}
+
+	frames, err := RenderMidiEvents(player, midiFile.PlaybackEvents(), wavOut, opts)
+	if err != nil {
+		return err
+	}
+
+	renderDebug("Rendered %s to %s: %d frames", midiPath, wavOut, frames)
+	return nil
+}
+
+// RenderMidiEvents renders timed MIDI messages, sorted by time, like RenderMidiFile and returns
+// the number of frames written. Set tempo meta events (FF 51 03 tt tt tt) set the player's tempo.
+// If rendering or writing fails, the partial output file is removed
+func RenderMidiEvents(player *SfzPlayer, events []TimedMidiEvent, wavOut string, opts *RenderOptions) (int64, error) {
+	options := opts.withDefaults()
+	out, err := NewAudioWriter(wavOut, AudioWriterOptions{
+		SampleRate: options.SampleRate,
+		Channels:   options.Channels,
+		Format:     options.Format,
+		Dither:     options.Dither,
+		Metadata:   options.Metadata,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	write := func(left, right []float32) error {
+		if right == nil {
+			return out.Write(left)
+		}
+		return out.WriteStereo(left, right)
+	}
+	frames, err := renderMidiEvents(player, events, options, write)
+	if err != nil {
+		// Don't leave a truncated file with an invalid header behind
+		out.Close()
+		os.Remove(wavOut)
+		return frames, err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(wavOut)
+		return frames, err
+	}
+
+	renderDebug("Wrote %s: %d frames (%.2fs)", wavOut, frames, float64(frames)/float64(options.SampleRate))
+	return frames, nil
+}
+
+// renderMidiEvents drives a new engine with timed events block by block, passing each rendered
+// block to write (right is nil for mono), and returns the number of frames written. The master
+// limiter's lookahead delay is left out so events land on their exact frames.
+func renderMidiEvents(player *SfzPlayer, events []TimedMidiEvent, options RenderOptions, write func(left, right []float32) error) (int64, error) {
+	e := newEngine(player, options.SampleRate)
+	defer e.close()
+	sampleRate := float64(options.SampleRate)
+	latency := int64(e.master.latency(player.master))
+	skip := latency
+
+	var lastFrame int64
+	if len(events) > 0 {
+		lastFrame = int64(math.Round(events[len(events)-1].Time * sampleRate))
+	}
+	maxFrames := lastFrame + int64(options.MaxTail*sampleRate)
+
+	block := make([]float32, options.BlockSize)
+	var right []float32
+	if options.Channels == 2 {
+		right = make([]float32, options.BlockSize)
+	}
+	blockEvents := make([]MidiEvent, 0, 64)
+	next := 0
+
+	var position int64
+	for position < maxFrames || next < len(events) {
+		// Stamp this block's events with their offsets
+		blockEnd := position + int64(len(block))
+		blockEvents = blockEvents[:0]
+		for ; next < len(events); next++ {
+			frame := int64(math.Round(events[next].Time * sampleRate))
+			if frame >= blockEnd {
+				break
+			}
+			blockEvents = append(blockEvents, MidiEvent{Frame: uint32(max(frame-position, 0)), Data: events[next].Data})
+		}
+
+		clear(block)
+		clear(right)
+		e.renderStereoEvents(block, right, blockEvents)
+		dropped := min(skip, int64(len(block)))
+		skip -= dropped
+		if int(dropped) < len(block) {
+			if err := write(block[dropped:], channelSpan(right, int(dropped), len(block))); err != nil {
+				return max(position-latency, 0), err
+			}
+		}
+		position = blockEnd
+
+		// Once every event has played, stop when the voices and reverb have died away
+		if next == len(events) && position > lastFrame && len(e.activeVoices) == 0 && max(peak(block), peak(right)) < options.SilenceThreshold {
+			break
+		}
+	}
+	return max(position-latency, 0), nil
+}
+
+// peak returns the highest absolute sample value in a block
+func peak(block []float32) float64 {
+	var p float64
+	for _, value := range block {
+		p = math.Max(p, math.Abs(float64(value)))
+	}
+	return p
+}
@@ -1,6 +1,9 @@
 package gosfzplayer
 
 import (
+	"math"
+	"sync/atomic"
+
 	"github.com/GeoffreyPlitt/debuggo"
 )
 
@@ -33,6 +36,7 @@ const (
 	initialWet   = 1.0 / scaleWet
 	initialDry   = 0.0
 	initialWidth = 1.0
+	initialInput = 1.0
 	stereospread = 23
 )
 
@@ -127,176 +131,399 @@ func (af *AllpassFilter) SetFeedback(val float64) {
 	af.feedback = val
 }
 
-// Freeverb implements the complete Freeverb algorithm
-type Freeverb struct {
-	// Filter banks for left and right channels
+// freeverbTank holds the reverb's filter banks and pre-delay line, sized for one sample rate
+type freeverbTank struct {
+	sampleRate float64
 	combsL     [numCombs]*CombFilter
 	combsR     [numCombs]*CombFilter
 	allpassesL [numAllpasses]*AllpassFilter
 	allpassesR [numAllpasses]*AllpassFilter
-
-	// Parameters
-	gain     float64
-	roomSize float64
-	damp     float64
-	wet      float64
-	dry      float64
-	width    float64
-
-	// Sample rate
-	sampleRate int
+	preDelay   []float64
 }
 
-// NewFreeverb creates a new Freeverb processor
-func NewFreeverb(sampleRate int) *Freeverb {
-	fv := &Freeverb{
-		gain:       fixedGain,
-		roomSize:   initialRoom,
-		damp:       initialDamp,
-		wet:        initialWet,
-		dry:        initialDry,
-		width:      initialWidth,
-		sampleRate: sampleRate,
-	}
-
-	// Calculate delay lengths based on sample rate
+// newFreeverbTank creates the filter banks for a sample rate. The delay lengths and the right
+// channel's stereo spread are tuned for 44.1 kHz and scaled so the reverb sounds the same at
+// other rates.
+func newFreeverbTank(sampleRate int) *freeverbTank {
 	scaleFactor := float64(sampleRate) / 44100.0
+	spread := int(math.Round(stereospread * scaleFactor))
+	tank := &freeverbTank{
+		sampleRate: float64(sampleRate),
+		preDelay:   make([]float64, int(maxPreDelay*float64(sampleRate))+1),
+	}
 
-	// Initialize comb filters
 	combDelayLengths := []int{1116, 1188, 1277, 1356, 1422, 1491, 1557, 1617}
 	for i := 0; i < numCombs; i++ {
 		delayL := int(float64(combDelayLengths[i]) * scaleFactor)
-		delayR := delayL + stereospread
-		fv.combsL[i] = NewCombFilter(delayL)
-		fv.combsR[i] = NewCombFilter(delayR)
+		tank.combsL[i] = NewCombFilter(delayL)
+		tank.combsR[i] = NewCombFilter(delayL + spread)
 	}
 
-	// Initialize allpass filters
 	allpassDelayLengths := []int{556, 441, 341, 225}
 	for i := 0; i < numAllpasses; i++ {
 		delayL := int(float64(allpassDelayLengths[i]) * scaleFactor)
-		delayR := delayL + stereospread
-		fv.allpassesL[i] = NewAllpassFilter(delayL)
-		fv.allpassesR[i] = NewAllpassFilter(delayR)
+		tank.allpassesL[i] = NewAllpassFilter(delayL)
+		tank.allpassesR[i] = NewAllpassFilter(delayL + spread)
 	}
 
-	// Set initial parameters
-	fv.updateParameters()
+	reverbDebug("Freeverb tank created: sampleRate=%d, scaleFactor=%.2f", sampleRate, scaleFactor)
+	return tank
+}
+
+// Freeverb implements the complete Freeverb algorithm
+type Freeverb struct {
+	// Parameters (float64 bits, 0.0 to 1.0 unless noted) are set from any goroutine and
+	// picked up by the audio thread on its next sample
+	roomSize atomic.Uint64
+	damp     atomic.Uint64
+	wet      atomic.Uint64
+	dry      atomic.Uint64
+	width    atomic.Uint64
+	input    atomic.Uint64 // Gain of the signal entering the reverb
+	preDelay atomic.Uint64 // Seconds
+	lowCut   atomic.Uint64 // Hz, 0 for none
+	highCut  atomic.Uint64 // Hz, 0 for none
+	version  atomic.Uint64 // Incremented on every parameter change
+
+	processor *freeverbProcessor // Runs Process; engines run processors of their own
+}
+
+// freeverbProcessor is the tank and parameters in use of one audio thread running a Freeverb.
+// Changes glide to their targets over a few milliseconds so CC sweeps don't step.
+type freeverbProcessor struct {
+	fv    *Freeverb
+	tank  atomic.Pointer[freeverbTank] // Replaced by SetSampleRate
+	input freeverbInput
+
+	appliedVersion uint64
+	active         *freeverbTank
+	current        freeverbMix // Values the filters and mix use
+	target         freeverbMix // Values the current ones glide to
+	smoothing      bool        // Set while the current values are gliding
+	smoothCoef     float64     // Fraction of the distance to the target covered per sample
+	started        bool        // Set once audio has been processed; until then changes apply at once
+}
+
+// freeverbMix is the set of reverb settings that glide when changed
+type freeverbMix struct {
+	gain        float64 // Input gain
+	feedback    float64 // Comb feedback, from the room size
+	damp        float64 // Comb damping coefficient at the tank's sample rate
+	wet1        float64 // Gain of each channel's own reverb
+	wet2        float64 // Gain of the other channel's reverb, for stereo width
+	dry         float64
+	lowCutCoef  float64 // One-pole coefficients of the input filters
+	highCutCoef float64
+	lowCut      float64 // How much of each input filter is applied, 0 when it is off
+	highCut     float64
+}
+
+// freeverbInput is the pre-delay and tone filtering of the reverb's input. Pre-delay changes
+// crossfade from the old delay to the new one.
+type freeverbInput struct {
+	delayFrames int // Pre-delay read, or faded to
+	fromFrames  int // Pre-delay faded from
+	nextFrames  int // Pre-delay to fade to once the current fade is done
+	fadeLeft    int // Frames left of the current fade
+	fadeFrames  int // Length of a fade
+	writeIdx    int
+	lowCutLP    float64 // Low-passed input that the low cut subtracts
+	highCutLP   float64
+}
+
+// Reverb input limits
+const (
+	maxPreDelay    = 0.5     // Seconds
+	maxReverbCut   = 20000.0 // Hz
+	reverbGlide    = 0.02    // Time constant in seconds of parameter changes
+	preDelayFade   = 0.01    // Seconds a pre-delay change crossfades over
+	glideTolerance = 1e-6
+)
 
-	reverbDebug("Freeverb initialized: sampleRate=%d, scaleFactor=%.2f", sampleRate, scaleFactor)
+// NewFreeverb creates a new Freeverb processor
+func NewFreeverb(sampleRate int) *Freeverb {
+	fv := &Freeverb{}
+	fv.roomSize.Store(math.Float64bits(initialRoom))
+	fv.damp.Store(math.Float64bits(initialDamp))
+	fv.wet.Store(math.Float64bits(initialWet / scaleWet)) // Wet gain of initialWet
+	fv.dry.Store(math.Float64bits(initialDry))
+	fv.width.Store(math.Float64bits(initialWidth))
+	fv.input.Store(math.Float64bits(initialInput))
+	fv.processor = newFreeverbProcessor(fv, sampleRate)
 	return fv
 }
 
-// updateParameters updates all filter parameters
-func (fv *Freeverb) updateParameters() {
-	// Calculate room size parameter
-	roomScaled := (fv.roomSize * scaleRoom) + offsetRoom
+// newFreeverbProcessor creates a tank for a reverb at a sample rate
+func newFreeverbProcessor(fv *Freeverb, sampleRate int) *freeverbProcessor {
+	rp := &freeverbProcessor{
+		fv:             fv,
+		appliedVersion: math.MaxUint64, // Picks up the parameters on the first sample
+	}
+	rp.tank.Store(newFreeverbTank(sampleRate))
+	return rp
+}
+
+// NewProcessor creates a processor with a tank of its own, tuned to a sample rate, that follows
+// the reverb's settings
+func (fv *Freeverb) NewProcessor(sampleRate uint32) Effect {
+	return newFreeverbProcessor(fv, int(sampleRate))
+}
+
+// SetSampleRate rebuilds the filter banks Process runs for a sample rate, keeping the reverb's
+// settings. The audio thread switches to them on its next sample, starting from silence. It
+// allocates, so call it from outside the audio thread.
+func (fv *Freeverb) SetSampleRate(sampleRate int) {
+	if fv.SampleRate() == sampleRate {
+		return
+	}
+	fv.processor.tank.Store(newFreeverbTank(sampleRate))
+	fv.version.Add(1)
+}
+
+// SampleRate returns the sample rate Process is tuned for
+func (fv *Freeverb) SampleRate() int {
+	return int(fv.processor.tank.Load().sampleRate)
+}
+
+// applyParameters picks up the reverb's current parameters and sample rate; only the audio
+// thread calls it
+func (rp *freeverbProcessor) applyParameters() {
+	fv := rp.fv
+	rp.appliedVersion = fv.version.Load()
+	restarted := false
+	if tank := rp.tank.Load(); tank != rp.active {
+		rp.active = tank
+		rp.input = freeverbInput{fadeFrames: max(int(preDelayFade*tank.sampleRate), 1)}
+		rp.smoothCoef = 1.0 - math.Exp(-1.0/(reverbGlide*tank.sampleRate))
+		restarted = true
+	}
+	sampleRate := rp.active.sampleRate
+
+	// Room size sets the comb feedback. Damping is a one-pole low-pass in each comb whose
+	// coefficient is tuned at 44.1 kHz; raising it to 44100/rate keeps its cutoff in Hz.
+	rp.target.feedback = (fv.GetRoomSize() * scaleRoom) + offsetRoom
+	rp.target.damp = math.Pow(fv.GetDamping()*scaleDamp, 44100.0/sampleRate)
+
+	wetGain := fv.GetWet() * scaleWet
+	width := fv.GetWidth()
+	rp.target.wet1 = wetGain * (width/2.0 + 0.5)
+	rp.target.wet2 = wetGain * ((1.0 - width) / 2.0)
+	rp.target.dry = fv.GetDry() * scaleDry
+	rp.target.gain = fv.GetInput() * fixedGain
+
+	// Input pre-delay and tone. A filter turning on starts at its new cutoff, one turning off
+	// fades out at its old one.
+	rp.input.nextFrames = min(int(math.Round(fv.GetPreDelay()*sampleRate)), len(rp.active.preDelay)-1)
+	rp.target.lowCut, rp.target.lowCutCoef = cutTarget(fv.GetLowCut(), sampleRate, rp.current.lowCutCoef)
+	rp.target.highCut, rp.target.highCutCoef = cutTarget(fv.GetHighCut(), sampleRate, rp.current.highCutCoef)
+	if rp.current.lowCut == 0 {
+		rp.current.lowCutCoef = rp.target.lowCutCoef
+	}
+	if rp.current.highCut == 0 {
+		rp.current.highCutCoef = rp.target.highCutCoef
+	}
+
+	if !rp.started || restarted {
+		rp.input.delayFrames = rp.input.nextFrames
+	}
+	if !rp.started {
+		rp.current = rp.target
+		rp.updateFilters()
+		return
+	}
+	rp.smoothing = true
+}
+
+// cutTarget returns how much of an input filter to apply for a cutoff, and its coefficient;
+// a filter that is off keeps its current coefficient
+func cutTarget(cutoff, sampleRate, current float64) (amount, coef float64) {
+	coef = onePoleCoef(cutoff, sampleRate)
+	if coef == 0 {
+		return 0, current
+	}
+	return 1, coef
+}
 
-	// Calculate damping
-	dampScaled := fv.damp * scaleDamp
+// onePoleCoef returns the coefficient of a one-pole low-pass at a cutoff, 0 for a cutoff of 0 or
+// one at or above the Nyquist frequency, which turns the filter off
+func onePoleCoef(cutoff, sampleRate float64) float64 {
+	if cutoff <= 0 || cutoff >= sampleRate*0.5 {
+		return 0
+	}
+	return math.Exp(-2.0 * math.Pi * cutoff / sampleRate)
+}
 
-	// Update all comb filters
+// updateFilters sets the comb filters from the current feedback and damping
+func (rp *freeverbProcessor) updateFilters() {
 	for i := 0; i < numCombs; i++ {
-		fv.combsL[i].SetFeedback(roomScaled)
-		fv.combsR[i].SetFeedback(roomScaled)
-		fv.combsL[i].SetDamp(dampScaled)
-		fv.combsR[i].SetDamp(dampScaled)
+		rp.active.combsL[i].SetFeedback(rp.current.feedback)
+		rp.active.combsR[i].SetFeedback(rp.current.feedback)
+		rp.active.combsL[i].SetDamp(rp.current.damp)
+		rp.active.combsR[i].SetDamp(rp.current.damp)
 	}
+}
 
-	// Allpass filters have fixed feedback
-	for i := 0; i < numAllpasses; i++ {
-		fv.allpassesL[i].SetFeedback(0.5)
-		fv.allpassesR[i].SetFeedback(0.5)
+// glide moves the current settings a step towards their targets, snapping once they are close
+func (rp *freeverbProcessor) glide() {
+	current, target := &rp.current, &rp.target
+	current.gain += (target.gain - current.gain) * rp.smoothCoef
+	current.feedback += (target.feedback - current.feedback) * rp.smoothCoef
+	current.damp += (target.damp - current.damp) * rp.smoothCoef
+	current.wet1 += (target.wet1 - current.wet1) * rp.smoothCoef
+	current.wet2 += (target.wet2 - current.wet2) * rp.smoothCoef
+	current.dry += (target.dry - current.dry) * rp.smoothCoef
+	current.lowCutCoef += (target.lowCutCoef - current.lowCutCoef) * rp.smoothCoef
+	current.highCutCoef += (target.highCutCoef - current.highCutCoef) * rp.smoothCoef
+	current.lowCut += (target.lowCut - current.lowCut) * rp.smoothCoef
+	current.highCut += (target.highCut - current.highCut) * rp.smoothCoef
+
+	if math.Abs(target.gain-current.gain) < glideTolerance &&
+		math.Abs(target.feedback-current.feedback) < glideTolerance &&
+		math.Abs(target.damp-current.damp) < glideTolerance &&
+		math.Abs(target.wet1-current.wet1) < glideTolerance &&
+		math.Abs(target.wet2-current.wet2) < glideTolerance &&
+		math.Abs(target.dry-current.dry) < glideTolerance &&
+		math.Abs(target.lowCutCoef-current.lowCutCoef) < glideTolerance &&
+		math.Abs(target.highCutCoef-current.highCutCoef) < glideTolerance &&
+		math.Abs(target.lowCut-current.lowCut) < glideTolerance &&
+		math.Abs(target.highCut-current.highCut) < glideTolerance {
+		*current = *target
+		rp.smoothing = false
 	}
+	rp.updateFilters()
+}
+
+// setParameter clamps a parameter and stores it for the audio thread
+func (fv *Freeverb) setParameter(param *atomic.Uint64, value, low, high float64) {
+	param.Store(math.Float64bits(clampFloat64(value, low, high)))
+	fv.version.Add(1)
 }
 
 // SetRoomSize sets the room size (0.0 to 1.0)
 func (fv *Freeverb) SetRoomSize(size float64) {
-	if size < 0.0 {
-		size = 0.0
-	}
-	if size > 1.0 {
-		size = 1.0
-	}
-	fv.roomSize = size
-	fv.updateParameters()
+	fv.setParameter(&fv.roomSize, size, 0.0, 1.0)
 }
 
 // SetDamping sets the damping amount (0.0 to 1.0)
 func (fv *Freeverb) SetDamping(damp float64) {
-	if damp < 0.0 {
-		damp = 0.0
-	}
-	if damp > 1.0 {
-		damp = 1.0
-	}
-	fv.damp = damp
-	fv.updateParameters()
+	fv.setParameter(&fv.damp, damp, 0.0, 1.0)
 }
 
 // SetWet sets the wet level (0.0 to 1.0)
 func (fv *Freeverb) SetWet(wet float64) {
-	if wet < 0.0 {
-		wet = 0.0
-	}
-	if wet > 1.0 {
-		wet = 1.0
-	}
-	fv.wet = wet * scaleWet
+	fv.setParameter(&fv.wet, wet, 0.0, 1.0)
 }
 
 // SetDry sets the dry level (0.0 to 1.0)
 func (fv *Freeverb) SetDry(dry float64) {
-	if dry < 0.0 {
-		dry = 0.0
-	}
-	if dry > 1.0 {
-		dry = 1.0
-	}
-	fv.dry = dry * scaleDry
+	fv.setParameter(&fv.dry, dry, 0.0, 1.0)
 }
 
 // SetWidth sets the stereo width (0.0 to 1.0)
 func (fv *Freeverb) SetWidth(width float64) {
-	if width < 0.0 {
-		width = 0.0
-	}
-	if width > 1.0 {
-		width = 1.0
-	}
-	fv.width = width
+	fv.setParameter(&fv.width, width, 0.0, 1.0)
+}
+
+// SetInput sets the gain of the signal entering the reverb (0.0 to 1.0)
+func (fv *Freeverb) SetInput(input float64) {
+	fv.setParameter(&fv.input, input, 0.0, 1.0)
+}
+
+// SetPreDelay sets how long the input waits before entering the reverb, in seconds (0 to 0.5)
+func (fv *Freeverb) SetPreDelay(seconds float64) {
+	fv.setParameter(&fv.preDelay, seconds, 0.0, maxPreDelay)
+}
+
+// SetLowCut sets the cutoff in Hz of a one-pole high-pass on the reverb's input (0 for none)
+func (fv *Freeverb) SetLowCut(hz float64) {
+	fv.setParameter(&fv.lowCut, hz, 0.0, maxReverbCut)
+}
+
+// SetHighCut sets the cutoff in Hz of a one-pole low-pass on the reverb's input (0 for none)
+func (fv *Freeverb) SetHighCut(hz float64) {
+	fv.setParameter(&fv.highCut, hz, 0.0, maxReverbCut)
 }
 
 // ProcessStereo processes a stereo sample pair through the reverb
 func (fv *Freeverb) ProcessStereo(inputL, inputR float64) (outputL, outputR float64) {
-	// Scale input
-	input := (inputL + inputR) * fv.gain
+	return fv.processor.processStereo(inputL, inputR)
+}
+
+// processStereo processes a stereo sample pair through the tank
+func (rp *freeverbProcessor) processStereo(inputL, inputR float64) (outputL, outputR float64) {
+	// Pick up parameter changes made since the last sample
+	if rp.fv.version.Load() != rp.appliedVersion {
+		rp.applyParameters()
+	}
+	rp.started = true
+	if rp.smoothing {
+		rp.glide()
+	}
+	tank := rp.active
+
+	// Scale input, filter its tone and delay it
+	in, mix := &rp.input, &rp.current
+	input := (inputL + inputR) * mix.gain
+	in.highCutLP = input + mix.highCutCoef*(in.highCutLP-input)
+	input += (in.highCutLP - input) * mix.highCut
+	in.lowCutLP = input + mix.lowCutCoef*(in.lowCutLP-input)
+	input -= in.lowCutLP * mix.lowCut
+	tank.preDelay[in.writeIdx] = input
+	input = in.tap(tank.preDelay, in.delayFrames)
+	if in.fadeLeft > 0 {
+		input += (in.tap(tank.preDelay, in.fromFrames) - input) * float64(in.fadeLeft) / float64(in.fadeFrames)
+		in.fadeLeft--
+	} else if in.nextFrames != in.delayFrames {
+		in.fromFrames = in.delayFrames
+		in.delayFrames = in.nextFrames
+		in.fadeLeft = in.fadeFrames
+	}
+	in.writeIdx++
+	if in.writeIdx >= len(tank.preDelay) {
+		in.writeIdx = 0
+	}
 
 	// Process through comb filters
 	var outL, outR float64
 	for i := 0; i < numCombs; i++ {
-		outL += fv.combsL[i].Process(input)
-		outR += fv.combsR[i].Process(input)
+		outL += tank.combsL[i].Process(input)
+		outR += tank.combsR[i].Process(input)
 	}
 
 	// Process through allpass filters
 	for i := 0; i < numAllpasses; i++ {
-		outL = fv.allpassesL[i].Process(outL)
-		outR = fv.allpassesR[i].Process(outR)
+		outL = tank.allpassesL[i].Process(outL)
+		outR = tank.allpassesR[i].Process(outR)
 	}
 
-	// Apply wet/dry mix and stereo width
-	wetL := outL * fv.wet
-	wetR := outR * fv.wet
+	// Apply wet/dry mix; width blends in the other channel's reverb (0 is mono, 1 fully stereo)
+	outputL = (inputL * mix.dry) + outL*mix.wet1 + outR*mix.wet2
+	outputR = (inputR * mix.dry) + outR*mix.wet1 + outL*mix.wet2
 
-	// Stereo width processing
-	wet1 := wetL * (fv.width/2.0 + 0.5)
-	wet2 := wetR * ((1.0 - fv.width) / 2.0)
+	return outputL, outputR
+}
 
-	outputL = (inputL * fv.dry) + wet1 + wet2
-	outputR = (inputR * fv.dry) + wet1 + wet2
+// tap reads the pre-delay line a number of frames behind the sample just written
+func (in *freeverbInput) tap(line []float64, frames int) float64 {
+	index := in.writeIdx - frames
+	if index < 0 {
+		index += len(line)
+	}
+	return line[index]
+}
 
-	return outputL, outputR
+// Process runs a stereo bus through the reverb in place, as an Effect
+func (fv *Freeverb) Process(left, right []float32) {
+	fv.processor.Process(left, right)
+}
+
+// Process runs a stereo bus through the tank in place
+func (rp *freeverbProcessor) Process(left, right []float32) {
+	for i := range left {
+		outputL, outputR := rp.processStereo(float64(left[i]), float64(right[i]))
+		left[i] = float32(outputL)
+		right[i] = float32(outputR)
+	}
 }
 
 // ProcessMono processes a mono sample through the reverb
@@ -307,25 +534,45 @@ func (fv *Freeverb) ProcessMono(input float64) float64 {
 
 // GetRoomSize returns the current room size
 func (fv *Freeverb) GetRoomSize() float64 {
-	return fv.roomSize
+	return math.Float64frombits(fv.roomSize.Load())
 }
 
 // GetDamping returns the current damping
 func (fv *Freeverb) GetDamping() float64 {
-	return fv.damp
+	return math.Float64frombits(fv.damp.Load())
 }
 
 // GetWet returns the current wet level
 func (fv *Freeverb) GetWet() float64 {
-	return fv.wet / scaleWet
+	return math.Float64frombits(fv.wet.Load())
 }
 
 // GetDry returns the current dry level
 func (fv *Freeverb) GetDry() float64 {
-	return fv.dry / scaleDry
+	return math.Float64frombits(fv.dry.Load())
 }
 
 // GetWidth returns the current stereo width
 func (fv *Freeverb) GetWidth() float64 {
-	return fv.width
+	return math.Float64frombits(fv.width.Load())
+}
+
+// GetInput returns the current input gain
+func (fv *Freeverb) GetInput() float64 {
+	return math.Float64frombits(fv.input.Load())
+}
+
+// GetPreDelay returns the pre-delay in seconds
+func (fv *Freeverb) GetPreDelay() float64 {
+	return math.Float64frombits(fv.preDelay.Load())
+}
+
+// GetLowCut returns the input high-pass cutoff in Hz, 0 if it is off
+func (fv *Freeverb) GetLowCut() float64 {
+	return math.Float64frombits(fv.lowCut.Load())
+}
+
+// GetHighCut returns the input low-pass cutoff in Hz, 0 if it is off
+func (fv *Freeverb) GetHighCut() float64 {
+	return math.Float64frombits(fv.highCut.Load())
 }
@@ -1,6 +1,7 @@
 package gosfzplayer
 
 import (
+	"math"
 	"testing"
 )
 
@@ -99,3 +100,377 @@ func fileExists(filename string) bool {
 	_, err := NewSfzPlayer(filename, "")
 	return err == nil
 }
+
+func TestFreeverbStereoReturn(t *testing.T) {
+	render := func(width float64) (left, right []float64) {
+		reverb := NewFreeverb(44100)
+		reverb.SetWidth(width)
+		for i := 0; i < 8192; i++ {
+			input := 0.0
+			if i == 0 {
+				input = 1.0
+			}
+			l, r := reverb.ProcessStereo(input, input)
+			left = append(left, l)
+			right = append(right, r)
+		}
+		return left, right
+	}
+
+	// Full width: the channels' reverbs differ
+	left, right := render(1.0)
+	difference := 0.0
+	for i := range left {
+		difference += math.Abs(left[i] - right[i])
+	}
+	if difference < 1e-3 {
+		t.Errorf("Expected a stereo reverb return at full width, got identical channels")
+	}
+
+	// Zero width: both channels get the same mix
+	left, right = render(0.0)
+	for i := range left {
+		if math.Abs(left[i]-right[i]) > 1e-12 {
+			t.Fatalf("Expected a mono reverb return at zero width, frame %d: L=%f R=%f", i, left[i], right[i])
+		}
+	}
+}
+
+// reverbTestSfz has a dry region, a region sending half its level to the reverb and one that
+// follows the player's send level
+const reverbTestSfz = `<region>
+sample=sample1.wav
+key=60
+effect1=0
+
+<region>
+sample=sample1.wav
+key=62
+effect1=50
+
+<region>
+sample=sample1.wav
+key=64
+`
+
+// renderNoteTail plays a short note and renders until its voice has finished, then returns the
+// peak level of the following block, which only the reverb tail can fill
+func renderNoteTail(e *engine, note uint8) float64 {
+	e.noteOn(note, 100)
+	renderTestFrames(e, 4096)
+	e.noteOff(note)
+	for i := 0; i < 200 && len(e.activeVoices) > 0; i++ {
+		renderTestFrames(e, 512)
+	}
+	return peak(renderTestFrames(e, 512))
+}
+
+func TestReverbSendPerVoice(t *testing.T) {
+	e := createTestEngine(t, reverbTestSfz)
+
+	if tail := renderNoteTail(e, 60); tail != 0 {
+		t.Errorf("Expected no reverb from a region with effect1=0, got peak %f", tail)
+	}
+	if tail := renderNoteTail(e, 62); tail == 0 {
+		t.Error("Expected a reverb tail from a region with effect1=50")
+	}
+
+	// Regions without effect1 follow the player's send level
+	e = createTestEngine(t, reverbTestSfz)
+	if tail := renderNoteTail(e, 64); tail != 0 {
+		t.Errorf("Expected no reverb at the default send level of 0, got peak %f", tail)
+	}
+	e.player.SetReverbSend(0.5)
+	if tail := renderNoteTail(e, 64); tail == 0 {
+		t.Error("Expected a reverb tail after raising the player's send level")
+	}
+
+	regions := e.player.Regions()
+	if regions[0].Effect1 != 0 || regions[1].Effect1 != 50 || regions[2].Effect1 != -1 {
+		t.Errorf("Unexpected effect1 values: %v %v %v", regions[0].Effect1, regions[1].Effect1, regions[2].Effect1)
+	}
+}
+
+func TestReverbSendAlias(t *testing.T) {
+	e := createTestEngine(t, "<global>\nreverb_send=30\n\n<group>\neffect1=10\n\n<region>\nsample=sample1.wav\nkey=60\n\n<region>\nsample=sample1.wav\nkey=62\nreverb_send=0\n")
+	regions := e.player.Regions()
+	if regions[0].Effect1 != 10 {
+		t.Errorf("Expected the group's effect1 to win over the global reverb_send, got %v", regions[0].Effect1)
+	}
+	if regions[1].Effect1 != 10 {
+		t.Errorf("Expected effect1 to take precedence over reverb_send, got %v", regions[1].Effect1)
+	}
+	if e.player.GetReverbSend() != 0 {
+		t.Errorf("Expected reverb_send to stay per region, got a player send level of %v", e.player.GetReverbSend())
+	}
+}
+
+func TestFverbStandardOpcodes(t *testing.T) {
+	// The standard names win over the older ones set alongside them
+	e := createTestEngine(t, "<region>\nsample=sample1.wav\n\n<effect>\ntype=fverb\nreverb_size=80\nreverb_room_size=10\nreverb_damp=20\nreverb_damping=90\nreverb_input=50\nreverb_tone=50\nreverb_highcut=100\n")
+	if diagnostics := e.player.GetSfzData().Diagnostics; len(diagnostics) != 0 {
+		t.Errorf("Expected the fverb opcodes to be recognized, got %v", diagnostics)
+	}
+	if got := e.player.GetReverbRoomSize(); math.Abs(got-0.8) > 1e-9 {
+		t.Errorf("Expected reverb_size to set the room size to 0.8, got %f", got)
+	}
+	if got := e.player.GetReverbDamping(); math.Abs(got-0.2) > 1e-9 {
+		t.Errorf("Expected reverb_damp to set the damping to 0.2, got %f", got)
+	}
+	if got := e.player.GetReverbInput(); math.Abs(got-0.5) > 1e-9 {
+		t.Errorf("Expected reverb_input to set the input gain to 0.5, got %f", got)
+	}
+	if got := e.player.GetReverbHighCut(); math.Abs(got-2000) > 1e-6 {
+		t.Errorf("Expected reverb_tone=50 to set a 2 kHz high cut, got %f", got)
+	}
+
+	// A fully open tone turns the high cut off, and the older names still work alone
+	e = createTestEngine(t, "<region>\nsample=sample1.wav\n\n<effect>\ntype=fverb\nreverb_room_size=30\nreverb_damping=60\nreverb_tone=100\n")
+	if e.player.GetReverbRoomSize() != 0.3 || e.player.GetReverbDamping() != 0.6 || e.player.GetReverbHighCut() != 0 {
+		t.Errorf("Unexpected settings: room size %f, damping %f, high cut %f",
+			e.player.GetReverbRoomSize(), e.player.GetReverbDamping(), e.player.GetReverbHighCut())
+	}
+
+	// The input gain scales the whole response
+	plain := reverbImpulse(NewFreeverb(44100), 4096)
+	half := NewFreeverb(44100)
+	half.SetInput(0.5)
+	for i, sample := range reverbImpulse(half, 4096) {
+		if math.Abs(sample-plain[i]*0.5) > 1e-12 {
+			t.Fatalf("Frame %d: expected half the response at an input gain of 0.5, got %f vs %f", i, sample, plain[i])
+		}
+	}
+}
+
+func TestEffectSectionMix(t *testing.T) {
+	dry := createTestEngine(t, "<region>\nsample=sample1.wav\nkey=60\neffect1=100\n\n<effect>\nfx1tomain=0\n")
+	half := createTestEngine(t, "<region>\nsample=sample1.wav\nkey=60\neffect1=100\n\n<effect>\ndirecttomain=50\nfx1tomain=0\n")
+	if diagnostics := dry.player.GetSfzData().Diagnostics; len(diagnostics) != 0 {
+		t.Errorf("Expected <effect> and its opcodes to be recognized, got %v", diagnostics)
+	}
+
+	dry.noteOn(60, 100)
+	half.noteOn(60, 100)
+	dryOutput := renderTestFrames(dry, 4096)
+	halfOutput := renderTestFrames(half, 4096)
+	for i := range dryOutput {
+		if math.Abs(float64(halfOutput[i])-float64(dryOutput[i])*0.5) > 1e-6 {
+			t.Fatalf("Expected directtomain=50 to halve the dry signal, frame %d: %f vs %f", i, halfOutput[i], dryOutput[i])
+		}
+	}
+
+	// With fx1tomain=0 the reverb runs but doesn't return
+	if tail := renderNoteTail(dry, 60); tail != 0 {
+		t.Errorf("Expected fx1tomain=0 to mute the reverb return, got peak %f", tail)
+	}
+}
+
+func TestStereoRenderPansVoices(t *testing.T) {
+	e := createTestEngine(t, "<region>\nsample=sample1.wav\nkey=60\npan=-100\neffect1=0\n")
+	e.noteOn(60, 100)
+
+	left := make([]float32, 2048)
+	right := make([]float32, 2048)
+	e.renderStereoEvents(left, right, nil)
+	if peak(left) == 0 || peak(right) != 0 {
+		t.Errorf("Expected a hard-left voice only on the left, got L=%f R=%f", peak(left), peak(right))
+	}
+}
+
+func TestChannelPanMovesVoices(t *testing.T) {
+	e := createTestEngine(t, "<region>\nsample=sample1.wav\nkey=60\neffect1=0\n")
+	e.processControlChangeChannel(0, 10, 0)
+	e.noteOnChannel(0, 60, 100)
+
+	left := make([]float32, 2048)
+	right := make([]float32, 2048)
+	e.renderStereoEvents(left, right, nil)
+	if peak(left) == 0 || peak(right) != 0 {
+		t.Errorf("Expected CC10=0 to pan the channel's voice hard left, got L=%f R=%f", peak(left), peak(right))
+	}
+
+	// Another channel's voices stay centered
+	e = createTestEngine(t, "<region>\nsample=sample1.wav\nkey=60\neffect1=0\n")
+	e.processControlChangeChannel(0, 10, 0)
+	e.noteOnChannel(1, 60, 100)
+	clear(left)
+	clear(right)
+	e.renderStereoEvents(left, right, nil)
+	if peak(left) == 0 || peak(left) != peak(right) {
+		t.Errorf("Expected a voice on another channel to stay centered, got L=%f R=%f", peak(left), peak(right))
+	}
+}
+
+// reverbImpulse returns the left channel of a reverb's response to an impulse
+func reverbImpulse(reverb *Freeverb, frames int) []float64 {
+	response := make([]float64, frames)
+	for i := range response {
+		input := 0.0
+		if i == 0 {
+			input = 1.0
+		}
+		response[i], _ = reverb.ProcessStereo(input, input)
+	}
+	return response
+}
+
+// decayTime plays a 10 ms tone burst into a reverb and returns the time its output's remaining
+// energy takes to fall 30 dB
+func decayTime(reverb *Freeverb, sampleRate int, freq float64) float64 {
+	burst := int(0.01 * float64(sampleRate))
+	output := make([]float64, 5*sampleRate)
+	for i := range output {
+		input := 0.0
+		if i < burst {
+			window := 0.5 - 0.5*math.Cos(2.0*math.Pi*float64(i)/float64(burst))
+			input = window * math.Sin(2.0*math.Pi*freq*float64(i)/float64(sampleRate))
+		}
+		output[i], _ = reverb.ProcessStereo(input, input)
+	}
+
+	remaining := make([]float64, len(output)+1)
+	for i := len(output) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + output[i]*output[i]
+	}
+	for i := range output {
+		if remaining[i] < remaining[0]*0.001 {
+			return float64(i) / float64(sampleRate)
+		}
+	}
+	return 0
+}
+
+func TestFreeverbSmoothing(t *testing.T) {
+	reverb := NewFreeverb(44100)
+	reverb.SetDry(0.5)
+	if reverb.processor.current.dry != reverb.processor.target.dry {
+		t.Fatal("Expected settings made before any audio to apply at once")
+	}
+
+	reverb.ProcessMono(0)
+	reverb.SetDry(0)
+	reverb.ProcessMono(0)
+	if dry := reverb.processor.current.dry; dry <= 0 || dry >= 0.5*scaleDry {
+		t.Errorf("Expected the dry gain to glide after a change, got %f", dry)
+	}
+	for i := 0; i < 44100/2; i++ {
+		reverb.ProcessMono(0)
+	}
+	if reverb.processor.smoothing || reverb.processor.current.dry != 0 {
+		t.Errorf("Expected the dry gain to settle within 500 ms, got %f", reverb.processor.current.dry)
+	}
+}
+
+func TestFreeverbPreDelayAndCuts(t *testing.T) {
+	plain := reverbImpulse(NewFreeverb(44100), 8192)
+	delayed := NewFreeverb(44100)
+	delayed.SetPreDelay(0.05)
+	response := reverbImpulse(delayed, 8192)
+
+	// The whole response moves later by the pre-delay
+	offset := int(0.05 * 44100)
+	for i := range response {
+		want := 0.0
+		if i >= offset {
+			want = plain[i-offset]
+		}
+		if math.Abs(response[i]-want) > 1e-12 {
+			t.Fatalf("Frame %d: expected the response delayed by %d frames, got %f vs %f", i, offset, response[i], want)
+		}
+	}
+
+	// Cutting the lows of the input leaves little of a low tone in the reverb
+	tone := func(reverb *Freeverb) float64 {
+		level := 0.0
+		for i := 0; i < 44100; i++ {
+			input := 0.5 * math.Sin(2.0*math.Pi*40*float64(i)/44100)
+			output := reverb.ProcessMono(input)
+			if i > 22050 {
+				level = math.Max(level, math.Abs(output))
+			}
+		}
+		return level
+	}
+	cut := NewFreeverb(44100)
+	cut.SetLowCut(1000)
+	if full, filtered := tone(NewFreeverb(44100)), tone(cut); filtered > full*0.1 {
+		t.Errorf("Expected a 1 kHz low cut to take a 40 Hz tone down by 20 dB, got %f vs %f", filtered, full)
+	}
+	cut = NewFreeverb(44100)
+	cut.SetHighCut(100)
+	if full, filtered := tone(NewFreeverb(44100)), tone(cut); filtered < full*0.5 {
+		t.Errorf("Expected a 100 Hz high cut to keep most of a 40 Hz tone, got %f vs %f", filtered, full)
+	}
+}
+
+func TestFreeverbPreDelayAndCutChangesAreSmooth(t *testing.T) {
+	// largestStep feeds a 100 Hz tone through a reverb, calling change halfway, and returns the
+	// largest sample-to-sample step of the output after the change
+	largestStep := func(change func(reverb *Freeverb)) float64 {
+		reverb := NewFreeverb(44100)
+		reverb.SetDry(0)
+		previous, largest := 0.0, 0.0
+		for i := 0; i < 44100; i++ {
+			if i == 22050 {
+				change(reverb)
+			}
+			output := reverb.ProcessMono(0.5 * math.Sin(2.0*math.Pi*100*float64(i)/44100))
+			if i > 22050 {
+				largest = math.Max(largest, math.Abs(output-previous))
+			}
+			previous = output
+		}
+		return largest
+	}
+
+	steady := largestStep(func(reverb *Freeverb) {})
+	for _, change := range []struct {
+		name  string
+		apply func(reverb *Freeverb)
+	}{
+		{"pre-delay", func(reverb *Freeverb) { reverb.SetPreDelay(0.0123) }},
+		{"low cut", func(reverb *Freeverb) { reverb.SetLowCut(2000) }},
+		{"high cut", func(reverb *Freeverb) { reverb.SetHighCut(50) }},
+	} {
+		if step := largestStep(change.apply); step > steady*1.5 {
+			t.Errorf("Expected a %s change not to step the output, got a step of %f vs %f steady", change.name, step, steady)
+		}
+	}
+}
+
+func TestFreeverbSampleRates(t *testing.T) {
+	reference := decayTime(NewFreeverb(44100), 44100, 4000)
+	for _, sampleRate := range []int{48000, 96000} {
+		reverb := NewFreeverb(44100)
+		reverb.SetSampleRate(sampleRate)
+		if got := reverb.SampleRate(); got != sampleRate {
+			t.Fatalf("Expected the reverb at %d Hz, got %d", sampleRate, got)
+		}
+		tank := reverb.processor.tank.Load()
+		spread := tank.combsR[0].bufferSize - tank.combsL[0].bufferSize
+		if want := int(math.Round(stereospread * float64(sampleRate) / 44100)); spread != want {
+			t.Errorf("%d Hz: expected a stereo spread of %d frames, got %d", sampleRate, want, spread)
+		}
+
+		// Damping is rescaled, so a 4 kHz tone dies away as fast as at 44.1 kHz
+		decay := decayTime(reverb, sampleRate, 4000)
+		if math.Abs(decay-reference) > reference*0.05 {
+			t.Errorf("%d Hz: expected the reverb to decay in about %.2fs like at 44.1 kHz, got %.2fs", sampleRate, reference, decay)
+		}
+	}
+
+	// The player's reverb follows the engine's sample rate and the reverb opcodes
+	_, sfzPath := createReloadDir(t, "<region>\nsample=sample1.wav\n\n<effect>\ntype=fverb\nreverb_predelay=20\nreverb_lowcut=150\nreverb_highcut=8000\n")
+	player, err := NewSfzPlayer(sfzPath, "")
+	if err != nil {
+		t.Fatalf("Failed to create player: %v", err)
+	}
+	newEngine(player, 96000)
+	if got := player.reverb.SampleRate(); got != 96000 {
+		t.Errorf("Expected the player's reverb at the engine's 96000 Hz, got %d", got)
+	}
+	if player.GetReverbPreDelay() != 0.02 || player.GetReverbLowCut() != 150 || player.GetReverbHighCut() != 8000 {
+		t.Errorf("Unexpected reverb input settings: pre-delay %f, low cut %f, high cut %f",
+			player.GetReverbPreDelay(), player.GetReverbLowCut(), player.GetReverbHighCut())
+	}
+}
@@ -0,0 +1,46 @@
+package gosfzplayer
+
+// seqKey identifies a round-robin counter: one per group and key
+type seqKey struct {
+	group *SfzSection // Parent group of the region (nil for ungrouped regions)
+	note  uint8
+}
+
+// seqKeyFor returns the round-robin counter key for a region and note
+func seqKeyFor(region *Region, note uint8) seqKey {
+	return seqKey{group: region.parentGroup(), note: note}
+}
+
+// seqMatches checks whether a region's seq_position is the current step of its round-robin
+func (e *engine) seqMatches(region *Region, note uint8) bool {
+	if region.SeqLength <= 1 {
+		return true
+	}
+	counter := e.seqCounters[seqKeyFor(region, note)]
+	return counter%region.SeqLength == region.SeqPosition-1
+}
+
+// advanceSequences steps the round-robin counters touched by a note-on, once per counter
+func (e *engine) advanceSequences(keys []seqKey) {
+	for i, key := range keys {
+		duplicate := false
+		for _, previous := range keys[:i] {
+			if previous == key {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			e.seqCounters[key]++
+		}
+	}
+}
+
+// randomMatches checks whether a note-on's random value falls in the region's lorand/hirand range
+func randomMatches(region *Region, value float64) bool {
+	if region.HiRand >= 1.0 {
+		// hirand=1 includes the top of the range
+		return value >= region.LoRand
+	}
+	return value >= region.LoRand && value < region.HiRand
+}
@@ -0,0 +1,119 @@
+package gosfzplayer
+
+import (
+	"testing"
+)
+
+// playedSamples triggers a note and returns the sample files of the voices it started
+func playedSamples(e *engine, note uint8) []string {
+	e.clearVoices() // Start each hit with no sounding voices
+	e.noteOn(note, 100)
+	var samples []string
+	for _, voice := range e.activeVoices {
+		samples = append(samples, voice.region.Sample)
+	}
+	e.noteOff(note)
+	return samples
+}
+
+func TestRoundRobin(t *testing.T) {
+	e := createTestEngine(t, `<group>
+seq_length=3
+key=60
+
+<region>
+sample=sample1.wav
+seq_position=1
+
+<region>
+sample=sample2.wav
+seq_position=2
+
+<region>
+sample=sample3.wav
+seq_position=3
+`)
+
+	expected := []string{"sample1.wav", "sample2.wav", "sample3.wav", "sample1.wav"}
+	for i, want := range expected {
+		got := playedSamples(e, 60)
+		if len(got) != 1 || got[0] != want {
+			t.Errorf("Hit %d: expected [%s], got %v", i+1, want, got)
+		}
+	}
+}
+
+func TestRoundRobinPerKeyAndLayers(t *testing.T) {
+	e := createTestEngine(t, `<group>
+seq_length=2
+lokey=60
+hikey=61
+
+<region>
+sample=sample1.wav
+seq_position=1
+
+<region>
+sample=sample2.wav
+seq_position=2
+
+<region>
+sample=sample3.wav
+seq_position=2
+`)
+
+	// Each key has its own counter
+	if got := playedSamples(e, 60); len(got) != 1 || got[0] != "sample1.wav" {
+		t.Errorf("Expected first hit on key 60 to play sample1, got %v", got)
+	}
+	if got := playedSamples(e, 61); len(got) != 1 || got[0] != "sample1.wav" {
+		t.Errorf("Expected first hit on key 61 to play sample1, got %v", got)
+	}
+
+	// Layered regions at the same position play together and advance the counter once
+	if got := playedSamples(e, 60); len(got) != 2 {
+		t.Errorf("Expected both layers at position 2, got %v", got)
+	}
+	if got := playedSamples(e, 60); len(got) != 1 || got[0] != "sample1.wav" {
+		t.Errorf("Expected the sequence to wrap to sample1, got %v", got)
+	}
+}
+
+func TestRandomSelection(t *testing.T) {
+	content := `<group>
+key=60
+
+<region>
+sample=sample1.wav
+hirand=0.5
+
+<region>
+sample=sample2.wav
+lorand=0.5
+`
+	e := createTestEngine(t, content)
+	e.player.SetRandomSeed(42)
+
+	counts := map[string]int{}
+	var sequence []string
+	for i := 0; i < 200; i++ {
+		got := playedSamples(e, 60)
+		if len(got) != 1 {
+			t.Fatalf("Expected exactly one region per hit, got %v", got)
+		}
+		counts[got[0]]++
+		sequence = append(sequence, got[0])
+	}
+
+	if counts["sample1.wav"] < 60 || counts["sample2.wav"] < 60 {
+		t.Errorf("Expected both random ranges to be used, got %v", counts)
+	}
+
+	// The same seed reproduces the same sequence
+	e.player.SetRandomSeed(42)
+	for i, want := range sequence {
+		if got := playedSamples(e, 60); got[0] != want {
+			t.Fatalf("Hit %d: expected %s with the same seed, got %s", i, want, got[0])
+		}
+	}
+}
@@ -20,6 +20,9 @@ type Sample struct {
 	SampleRate int       // Sample rate in Hz
 	Channels   int       // Number of audio channels
 	Length     int       // Number of samples per channel
+
+	modTime int64 // File modification time (Unix nanoseconds) when loaded
+	size    int64 // File size when loaded
 }
 
 // SampleCache manages loaded samples to avoid duplicate loading
@@ -45,7 +48,8 @@ func (sc *SampleCache) LoadSample(filePath string) (*Sample, error) {
 	sampleDebug("Loading new sample: %s", filePath)
 
 	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+	info, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
 		return nil, fmt.Errorf("sample file not found: %s", filePath)
 	}
 
@@ -53,7 +57,6 @@ func (sc *SampleCache) LoadSample(filePath string) (*Sample, error) {
 	ext := strings.ToLower(filepath.Ext(filePath))
 
 	var sample *Sample
-	var err error
 
 	switch ext {
 	case ".wav":
@@ -68,6 +71,12 @@ func (sc *SampleCache) LoadSample(filePath string) (*Sample, error) {
 		return nil, err
 	}
 
+	// Remember which version of the file was loaded so a reload can tell whether it changed
+	if info != nil {
+		sample.modTime = info.ModTime().UnixNano()
+		sample.size = info.Size()
+	}
+
 	// Cache the sample
 	sc.samples[filePath] = sample
 
@@ -77,6 +86,12 @@ func (sc *SampleCache) LoadSample(filePath string) (*Sample, error) {
 	return sample, nil
 }
 
+// unchanged reports whether the sample's file is the same version that was loaded
+func (s *Sample) unchanged() bool {
+	info, err := os.Stat(s.FilePath)
+	return err == nil && info.ModTime().UnixNano() == s.modTime && info.Size() == s.size
+}
+
 // loadWAV loads a WAV file
 func (sc *SampleCache) loadWAV(filePath string) (*Sample, error) {
 	file, err := os.Open(filePath)
@@ -86,7 +86,7 @@ func TestSfzPlayerWithSamples(t *testing.T) {
 	}
 
 	// Check that samples were loaded
-	if player.sampleCache.Size() == 0 {
+	if player.current().sampleCache.Size() == 0 {
 		t.Error("Expected samples to be loaded, but cache is empty")
 	}
 
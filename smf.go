@@ -0,0 +1,356 @@
+package gosfzplayer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/GeoffreyPlitt/debuggo"
+)
+
+var smfDebug = debuggo.Debug("sfzplayer:smf")
+
+// defaultMicrosecondsPerQuarter is the tempo of a MIDI file until its first tempo event (120 BPM)
+const defaultMicrosecondsPerQuarter = 500000
+
+// MidiFile is a parsed Standard MIDI File (format 0 or 1)
+type MidiFile struct {
+	Format          int           // 0 (single track) or 1 (simultaneous tracks)
+	TicksPerQuarter int           // Metrical timing resolution, 0 for SMPTE timing
+	TicksPerSecond  float64       // SMPTE timing resolution, 0 for metrical timing
+	Tracks          []MidiTrack   // Tracks in file order
+	TempoMap        []TempoChange // Tempo changes from every track, in tick order
+}
+
+// MidiTrack is one track of a MIDI file
+type MidiTrack struct {
+	Name   string          // Track name meta event, if any
+	Events []MidiFileEvent // Channel messages in tick order
+}
+
+// MidiFileEvent is a channel message at an absolute tick position
+type MidiFileEvent struct {
+	Tick uint64
+	Data []byte
+}
+
+// TempoChange is a set tempo meta event
+type TempoChange struct {
+	Tick                   uint64
+	MicrosecondsPerQuarter uint32
+}
+
+// TimedMidiEvent is a channel message at a time in seconds from the start of the file
+type TimedMidiEvent struct {
+	Time float64
+	Data []byte
+}
+
+// BPM returns the tempo in beats per minute
+func (tc TempoChange) BPM() float64 {
+	return 60000000.0 / float64(tc.MicrosecondsPerQuarter)
+}
+
+// ParseMidiFile reads a Standard MIDI File from disk
+func ParseMidiFile(path string) (*MidiFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open MIDI file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open MIDI file: %w", err)
+	}
+	midiFile, err := readMidiFile(&chunkReader{r: bufio.NewReader(file), remaining: info.Size()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse MIDI file %s: %w", path, err)
+	}
+	return midiFile, nil
+}
+
+// ReadMidiFile reads a Standard MIDI File
+func ReadMidiFile(r io.Reader) (*MidiFile, error) {
+	return readMidiFile(&chunkReader{r: r, remaining: readerSize(r)})
+}
+
+// readMidiFile reads a Standard MIDI File's chunks
+func readMidiFile(r *chunkReader) (*MidiFile, error) {
+	chunkType, header, err := r.readChunk()
+	if err != nil {
+		return nil, err
+	}
+	if chunkType != "MThd" || len(header) < 6 {
+		return nil, fmt.Errorf("not a Standard MIDI File")
+	}
+
+	format := int(binary.BigEndian.Uint16(header[0:2]))
+	trackCount := int(binary.BigEndian.Uint16(header[2:4]))
+	division := binary.BigEndian.Uint16(header[4:6])
+	if format > 1 {
+		return nil, fmt.Errorf("unsupported MIDI file format %d (only 0 and 1)", format)
+	}
+
+	f := &MidiFile{Format: format}
+	if division&0x8000 != 0 {
+		// SMPTE: negative frames per second in the high byte, ticks per frame in the low byte
+		fps := float64(-int8(division >> 8))
+		if fps == 29 {
+			fps = 29.97
+		}
+		f.TicksPerSecond = fps * float64(division&0xFF)
+	} else {
+		f.TicksPerQuarter = int(division)
+	}
+	if f.TicksPerQuarter == 0 && f.TicksPerSecond <= 0 {
+		return nil, fmt.Errorf("invalid MIDI file time division 0x%04X", division)
+	}
+
+	for len(f.Tracks) < trackCount {
+		chunkType, data, err := r.readChunk()
+		if err == io.EOF {
+			smfDebug("Warning: File ends after %d of %d tracks", len(f.Tracks), trackCount)
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if chunkType != "MTrk" {
+			smfDebug("Skipping unknown chunk %q", chunkType)
+			continue
+		}
+
+		track, tempos, err := parseTrack(data)
+		if err != nil {
+			return nil, fmt.Errorf("track %d: %w", len(f.Tracks)+1, err)
+		}
+		f.Tracks = append(f.Tracks, track)
+		f.TempoMap = append(f.TempoMap, tempos...)
+	}
+
+	sort.SliceStable(f.TempoMap, func(i, j int) bool { return f.TempoMap[i].Tick < f.TempoMap[j].Tick })
+
+	smfDebug("Parsed MIDI file: format %d, %d tracks, %d tempo changes", f.Format, len(f.Tracks), len(f.TempoMap))
+	return f, nil
+}
+
+// chunkReader reads a file's chunks, counting the bytes left when the file's size is known
+type chunkReader struct {
+	r         io.Reader
+	remaining int64 // -1 if the size is unknown
+}
+
+// readerSize returns the number of bytes left in readers that know it, like bytes.Reader, or -1
+func readerSize(r io.Reader) int64 {
+	if sized, ok := r.(interface{ Len() int }); ok {
+		return int64(sized.Len())
+	}
+	return -1
+}
+
+// readChunk reads a chunk's type and data. A chunk claiming more bytes than the file has left is
+// an error rather than an allocation of its length.
+func (cr *chunkReader) readChunk() (string, []byte, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(cr.r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return "", nil, fmt.Errorf("truncated chunk header")
+		}
+		return "", nil, err
+	}
+	chunkType := string(header[0:4])
+	length := int64(binary.BigEndian.Uint32(header[4:8]))
+
+	if cr.remaining >= 0 {
+		cr.remaining -= int64(len(header))
+		if length > cr.remaining {
+			return "", nil, fmt.Errorf("%q chunk length %d is larger than the %d bytes left in the file", chunkType, length, cr.remaining)
+		}
+		cr.remaining -= length
+	}
+
+	// Without a known size, the data is read as it arrives so a bogus length can't allocate it all
+	data, err := io.ReadAll(io.LimitReader(cr.r, length))
+	if err != nil {
+		return "", nil, err
+	}
+	if int64(len(data)) < length {
+		return "", nil, fmt.Errorf("truncated %q chunk", chunkType)
+	}
+	return chunkType, data, nil
+}
+
+// parseTrack decodes a track chunk's events, keeping channel messages, the track name and tempo changes
+func parseTrack(data []byte) (MidiTrack, []TempoChange, error) {
+	var track MidiTrack
+	var tempos []TempoChange
+	var tick uint64
+	var runningStatus byte
+
+	for pos := 0; pos < len(data); {
+		delta, n := readVarLen(data[pos:])
+		if n == 0 {
+			return track, tempos, fmt.Errorf("truncated delta time at byte %d", pos)
+		}
+		pos += n
+		tick += uint64(delta)
+
+		if pos >= len(data) {
+			return track, tempos, fmt.Errorf("truncated event at byte %d", pos)
+		}
+		status := data[pos]
+
+		switch {
+		case status == 0xFF:
+			// Meta event: type, length, data
+			if pos+2 > len(data) {
+				return track, tempos, fmt.Errorf("truncated meta event at byte %d", pos)
+			}
+			metaType := data[pos+1]
+			length, n := readVarLen(data[pos+2:])
+			start := pos + 2 + n
+			if n == 0 || start+int(length) > len(data) {
+				return track, tempos, fmt.Errorf("truncated meta event at byte %d", pos)
+			}
+			meta := data[start : start+int(length)]
+			pos = start + int(length)
+
+			switch metaType {
+			case 0x03: // Track name
+				track.Name = string(meta)
+			case 0x51: // Set tempo
+				if len(meta) == 3 {
+					tempo := uint32(meta[0])<<16 | uint32(meta[1])<<8 | uint32(meta[2])
+					if tempo > 0 {
+						tempos = append(tempos, TempoChange{Tick: tick, MicrosecondsPerQuarter: tempo})
+					}
+				}
+			case 0x2F: // End of track
+				return track, tempos, nil
+			}
+			runningStatus = 0
+
+		case status == 0xF0 || status == 0xF7:
+			// System exclusive: length, data (skipped)
+			length, n := readVarLen(data[pos+1:])
+			if n == 0 || pos+1+n+int(length) > len(data) {
+				return track, tempos, fmt.Errorf("truncated system exclusive event at byte %d", pos)
+			}
+			pos += 1 + n + int(length)
+			runningStatus = 0
+
+		default:
+			// Channel message, possibly using running status
+			if status >= 0x80 {
+				runningStatus = status
+				pos++
+			} else if runningStatus == 0 {
+				return track, tempos, fmt.Errorf("data byte without status at byte %d", pos)
+			}
+
+			length := channelMessageLength(runningStatus) - 1
+			if pos+length > len(data) {
+				return track, tempos, fmt.Errorf("truncated channel message at byte %d", pos)
+			}
+			message := make([]byte, 0, length+1)
+			message = append(message, runningStatus)
+			message = append(message, data[pos:pos+length]...)
+			track.Events = append(track.Events, MidiFileEvent{Tick: tick, Data: message})
+			pos += length
+		}
+	}
+
+	smfDebug("Warning: Track has no end-of-track event")
+	return track, tempos, nil
+}
+
+// readVarLen decodes a variable-length quantity, returning the value and the bytes used (0 if truncated)
+func readVarLen(data []byte) (uint32, int) {
+	var value uint32
+	for i := 0; i < len(data) && i < 4; i++ {
+		value = value<<7 | uint32(data[i]&0x7F)
+		if data[i]&0x80 == 0 {
+			return value, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// TickToSeconds converts a tick position to seconds from the start of the file using the tempo map
+func (f *MidiFile) TickToSeconds(tick uint64) float64 {
+	if f.TicksPerSecond > 0 {
+		return float64(tick) / f.TicksPerSecond
+	}
+
+	seconds := 0.0
+	lastTick := uint64(0)
+	tempo := float64(defaultMicrosecondsPerQuarter)
+	for _, change := range f.TempoMap {
+		if change.Tick >= tick {
+			break
+		}
+		seconds += float64(change.Tick-lastTick) * tempo / 1e6 / float64(f.TicksPerQuarter)
+		lastTick = change.Tick
+		tempo = float64(change.MicrosecondsPerQuarter)
+	}
+	return seconds + float64(tick-lastTick)*tempo/1e6/float64(f.TicksPerQuarter)
+}
+
+// Events returns every track's channel messages merged in time order. Events at the same time keep
+// their file order, tracks in order.
+func (f *MidiFile) Events() []TimedMidiEvent {
+	var merged []MidiFileEvent
+	for _, track := range f.Tracks {
+		merged = append(merged, track.Events...)
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Tick < merged[j].Tick })
+
+	events := make([]TimedMidiEvent, len(merged))
+	for i, event := range merged {
+		events[i] = TimedMidiEvent{Time: f.TickToSeconds(event.Tick), Data: event.Data}
+	}
+	return events
+}
+
+// PlaybackEvents returns Events with the file's tempo changes added as set tempo meta events
+// (FF 51 03 tt tt tt), each ahead of the channel messages at its time. Files with metrical timing
+// start with their initial tempo, 120 BPM if they don't set one. Rendering the events sets the
+// player's tempo as they play, for tempo-synced effects and lobpm/hibpm.
+func (f *MidiFile) PlaybackEvents() []TimedMidiEvent {
+	var events []TimedMidiEvent
+	if f.TicksPerSecond == 0 {
+		if len(f.TempoMap) == 0 || f.TempoMap[0].Tick > 0 {
+			events = append(events, TempoChange{MicrosecondsPerQuarter: defaultMicrosecondsPerQuarter}.timedEvent(f))
+		}
+		for _, change := range f.TempoMap {
+			events = append(events, change.timedEvent(f))
+		}
+	}
+	events = append(events, f.Events()...)
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time < events[j].Time })
+	return events
+}
+
+// timedEvent returns a tempo change as a set tempo meta event at its time in a file
+func (tc TempoChange) timedEvent(f *MidiFile) TimedMidiEvent {
+	tempo := tc.MicrosecondsPerQuarter
+	return TimedMidiEvent{
+		Time: f.TickToSeconds(tc.Tick),
+		Data: []byte{0xFF, metaSetTempo, 0x03, byte(tempo >> 16), byte(tempo >> 8), byte(tempo)},
+	}
+}
+
+// Duration returns the time of the file's last channel message in seconds
+func (f *MidiFile) Duration() float64 {
+	var last uint64
+	for _, track := range f.Tracks {
+		if n := len(track.Events); n > 0 && track.Events[n-1].Tick > last {
+			last = track.Events[n-1].Tick
+		}
+	}
+	return f.TickToSeconds(last)
+}
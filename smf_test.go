@@ -0,0 +1,298 @@
+package gosfzplayer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// buildMidiFile assembles a Standard MIDI File from raw track data
+func buildMidiFile(format, division uint16, tracks ...[]byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("MThd")
+	binary.Write(&buf, binary.BigEndian, uint32(6))
+	binary.Write(&buf, binary.BigEndian, format)
+	binary.Write(&buf, binary.BigEndian, uint16(len(tracks)))
+	binary.Write(&buf, binary.BigEndian, division)
+	for _, track := range tracks {
+		buf.WriteString("MTrk")
+		binary.Write(&buf, binary.BigEndian, uint32(len(track)))
+		buf.Write(track)
+	}
+	return buf.Bytes()
+}
+
+// writeMidiFile writes a MIDI file to a temporary directory and returns its path
+func writeMidiFile(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.mid")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to write MIDI file: %v", err)
+	}
+	return path
+}
+
+var endOfTrack = []byte{0x00, 0xFF, 0x2F, 0x00}
+
+func TestReadMidiFileFormat0(t *testing.T) {
+	// 480 ticks per quarter at 120 BPM, switching to 60 BPM after one beat
+	track := []byte{
+		0x00, 0xFF, 0x03, 0x04, 'L', 'e', 'a', 'd', // Track name
+		0x00, 0x90, 60, 100, // Note on
+		0x83, 0x60, 0x80, 60, 0, // Note off after 480 ticks
+		0x00, 0xFF, 0x51, 0x03, 0x0F, 0x42, 0x40, // Tempo 1000000 us/quarter
+		0x00, 0x90, 64, 100, // Note on
+		0x83, 0x60, 64, 0, // Running status note on with velocity 0 after 480 ticks
+	}
+	track = append(track, endOfTrack...)
+
+	f, err := ReadMidiFile(bytes.NewReader(buildMidiFile(0, 480, track)))
+	if err != nil {
+		t.Fatalf("ReadMidiFile failed: %v", err)
+	}
+
+	if f.Format != 0 || f.TicksPerQuarter != 480 || len(f.Tracks) != 1 {
+		t.Fatalf("Unexpected header: format %d, %d ticks per quarter, %d tracks", f.Format, f.TicksPerQuarter, len(f.Tracks))
+	}
+	if f.Tracks[0].Name != "Lead" {
+		t.Errorf("Expected track name Lead, got %q", f.Tracks[0].Name)
+	}
+	if len(f.TempoMap) != 1 || f.TempoMap[0].Tick != 480 || f.TempoMap[0].BPM() != 60 {
+		t.Errorf("Unexpected tempo map: %+v", f.TempoMap)
+	}
+
+	events := f.Events()
+	if len(events) != 4 {
+		t.Fatalf("Expected 4 events, got %d", len(events))
+	}
+	if !bytes.Equal(events[3].Data, []byte{0x90, 64, 0}) {
+		t.Errorf("Expected running status to repeat the note on status, got % X", events[3].Data)
+	}
+
+	// One beat at 120 BPM, then one beat at 60 BPM
+	expected := []float64{0, 0.5, 0.5, 1.5}
+	for i, event := range events {
+		if math.Abs(event.Time-expected[i]) > 1e-9 {
+			t.Errorf("Event %d: expected time %.3f, got %.3f", i, expected[i], event.Time)
+		}
+	}
+	if d := f.Duration(); math.Abs(d-1.5) > 1e-9 {
+		t.Errorf("Expected duration 1.5s, got %.3f", d)
+	}
+}
+
+func TestReadMidiFileFormat1(t *testing.T) {
+	// Conductor track sets 100 BPM; the note track plays on beat 2 of channel 2
+	conductor := append([]byte{0x00, 0xFF, 0x51, 0x03, 0x09, 0x27, 0xC0}, endOfTrack...)
+	notes := []byte{
+		0x00, 0xF0, 0x03, 0x7E, 0x7F, 0xF7, // Sysex is skipped
+		0x60, 0x91, 62, 90,
+		0x60, 0x81, 62, 0,
+	}
+	notes = append(notes, endOfTrack...)
+
+	f, err := ReadMidiFile(bytes.NewReader(buildMidiFile(1, 96, conductor, notes)))
+	if err != nil {
+		t.Fatalf("ReadMidiFile failed: %v", err)
+	}
+
+	events := f.Events()
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+	if math.Abs(events[0].Time-0.6) > 1e-9 || math.Abs(events[1].Time-1.2) > 1e-9 {
+		t.Errorf("Expected events at 0.6s and 1.2s, got %.3f and %.3f", events[0].Time, events[1].Time)
+	}
+	if events[0].Data[0] != 0x91 {
+		t.Errorf("Expected channel 2 note on, got % X", events[0].Data)
+	}
+}
+
+func TestReadMidiFileSMPTE(t *testing.T) {
+	// 25 fps, 40 ticks per frame = 1000 ticks per second
+	track := append([]byte{0x87, 0x68, 0x90, 60, 100}, endOfTrack...)
+	f, err := ReadMidiFile(bytes.NewReader(buildMidiFile(0, 0xE728, track)))
+	if err != nil {
+		t.Fatalf("ReadMidiFile failed: %v", err)
+	}
+	if f.TicksPerSecond != 1000 {
+		t.Errorf("Expected 1000 ticks per second, got %.1f", f.TicksPerSecond)
+	}
+	if events := f.Events(); len(events) != 1 || math.Abs(events[0].Time-1.0) > 1e-9 {
+		t.Errorf("Expected one event at 1s, got %+v", events)
+	}
+}
+
+func TestReadMidiFileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not a MIDI file", []byte("RIFF\x00\x00\x00\x04WAVE")},
+		{"format 2", buildMidiFile(2, 96, endOfTrack)},
+		{"truncated track", buildMidiFile(0, 96, []byte{0x00, 0x90, 60})},
+		{"data without status", buildMidiFile(0, 96, []byte{0x00, 60, 100})},
+	}
+	for _, tt := range tests {
+		if _, err := ReadMidiFile(bytes.NewReader(tt.data)); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestReadMidiFileChunkLength(t *testing.T) {
+	// A track claiming about 4 GB in a file of a few bytes is rejected before reading it, from a
+	// reader or a file on disk
+	data := buildMidiFile(0, 96, endOfTrack)
+	binary.BigEndian.PutUint32(data[len(data)-len(endOfTrack)-4:], 0xFFFFFFF0)
+	if _, err := ReadMidiFile(bytes.NewReader(data)); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("Expected a chunk length error from a reader, got %v", err)
+	}
+	if _, err := ParseMidiFile(writeMidiFile(t, data)); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("Expected a chunk length error from a file, got %v", err)
+	}
+
+	// Readers of unknown size still report the chunk as truncated
+	if _, err := ReadMidiFile(struct{ io.Reader }{bytes.NewReader(data)}); err == nil {
+		t.Error("Expected an error for a truncated chunk")
+	}
+}
+
+func TestRenderMidiFile(t *testing.T) {
+	e := createTestEngine(t, `<region>
+sample=sample1.wav
+lokey=0
+hikey=127
+ampeg_release=0.2
+`)
+
+	// Note on at 0.25s, off at 0.5s (120 BPM, 96 ticks per quarter)
+	track := append([]byte{0x30, 0x90, 60, 100, 0x30, 0x80, 60, 0}, endOfTrack...)
+	midiPath := writeMidiFile(t, buildMidiFile(0, 96, track))
+	wavPath := filepath.Join(t.TempDir(), "out.wav")
+
+	if err := RenderMidiFile(e.player, midiPath, wavPath, &RenderOptions{BlockSize: 100}); err != nil {
+		t.Fatalf("RenderMidiFile failed: %v", err)
+	}
+
+	data, err := os.ReadFile(wavPath)
+	if err != nil {
+		t.Fatalf("Failed to read rendered WAV: %v", err)
+	}
+	if string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		t.Fatal("Rendered file is not a WAV file")
+	}
+	dataSize := binary.LittleEndian.Uint32(data[40:44])
+	if int(dataSize) != len(data)-44 {
+		t.Errorf("Header data size %d doesn't match file size %d", dataSize, len(data)-44)
+	}
+
+	samples := make([]int16, dataSize/2)
+	binary.Read(bytes.NewReader(data[44:]), binary.LittleEndian, samples)
+
+	// The note starts on its exact frame, independent of block boundaries
+	noteFrame := 11025
+	for i := 0; i < noteFrame; i++ {
+		if samples[i] != 0 {
+			t.Fatalf("Expected silence before the note, got %d at frame %d", samples[i], i)
+		}
+	}
+	if samples[noteFrame] == 0 && samples[noteFrame+1] == 0 {
+		t.Error("Expected the note to start at frame 11025")
+	}
+
+	// The release tail plays past the note off, then rendering stops well short of MaxTail
+	releaseEnd := 22050 + int(0.2*44100)
+	if len(samples) < releaseEnd {
+		t.Errorf("Expected the release tail to be rendered (%d frames), got %d frames", releaseEnd, len(samples))
+	}
+	if len(samples) > 44100*2 {
+		t.Errorf("Expected rendering to stop once the tail decays, got %d frames", len(samples))
+	}
+}
+
+func TestRenderMidiFileFollowsTempo(t *testing.T) {
+	// The region only plays at 150 BPM and up: the file starts at 160 BPM and drops to 100
+	e := createTestEngine(t, "<region>\nsample=sample1.wav\nlokey=0\nhikey=127\nlobpm=150\n")
+	track := []byte{
+		0x00, 0xFF, 0x51, 0x03, 0x05, 0xB8, 0xD8, // 375000 us/quarter (160 BPM)
+		0x00, 0x90, 60, 100, // Note on at 0s
+		0x60, 0x80, 60, 0, // Note off after a quarter (0.375s)
+		0x00, 0xFF, 0x51, 0x03, 0x09, 0x27, 0xC0, // 600000 us/quarter (100 BPM)
+		0x60, 0x90, 62, 100, // Note on after another quarter (0.975s)
+		0x60, 0x80, 62, 0,
+	}
+	track = append(track, endOfTrack...)
+	midiPath := writeMidiFile(t, buildMidiFile(0, 96, track))
+	wavPath := filepath.Join(t.TempDir(), "out.wav")
+
+	if err := RenderMidiFile(e.player, midiPath, wavPath, nil); err != nil {
+		t.Fatalf("RenderMidiFile failed: %v", err)
+	}
+	if bpm := e.player.GetTempo(); math.Abs(bpm-100) > 1e-9 {
+		t.Errorf("Expected the player to end at the file's last tempo of 100 BPM, got %f", bpm)
+	}
+
+	data, err := os.ReadFile(wavPath)
+	if err != nil {
+		t.Fatalf("Failed to read rendered WAV: %v", err)
+	}
+	samples := make([]int16, (len(data)-44)/2)
+	binary.Read(bytes.NewReader(data[44:]), binary.LittleEndian, samples)
+	loudest := func(from, to float64) int16 {
+		var most int16
+		for _, sample := range samples[int(from*44100):min(int(to*44100), len(samples))] {
+			most = max(most, sample, -sample)
+		}
+		return most
+	}
+	if loudest(0, 0.3) == 0 {
+		t.Error("Expected the note at 160 BPM to play the lobpm=150 region")
+	}
+	if level := loudest(0.975, 1.2); level != 0 {
+		t.Errorf("Expected the note after the change to 100 BPM to skip the lobpm=150 region, got level %d", level)
+	}
+}
+
+func TestRenderMidiFileMissing(t *testing.T) {
+	e := createTestEngine(t, "<region>\nsample=sample1.wav\n")
+	if err := RenderMidiFile(e.player, "testdata/missing.mid", filepath.Join(t.TempDir(), "out.wav"), nil); err == nil {
+		t.Error("Expected an error for a missing MIDI file")
+	}
+}
+
+func TestRenderMidiEventsStereo(t *testing.T) {
+	e := createTestEngine(t, "<region>\nsample=sample1.wav\nkey=60\npan=100\neffect1=0\n")
+	wavPath := filepath.Join(t.TempDir(), "out.wav")
+	events := []TimedMidiEvent{{Time: 0, Data: []byte{0x90, 60, 100}}, {Time: 0.1, Data: []byte{0x80, 60, 0}}}
+
+	frames, err := RenderMidiEvents(e.player, events, wavPath, &RenderOptions{Channels: 2})
+	if err != nil {
+		t.Fatalf("RenderMidiEvents failed: %v", err)
+	}
+
+	data, err := os.ReadFile(wavPath)
+	if err != nil {
+		t.Fatalf("Failed to read rendered WAV: %v", err)
+	}
+	if channels := binary.LittleEndian.Uint16(data[22:24]); channels != 2 {
+		t.Fatalf("Expected a stereo WAV file, got %d channels", channels)
+	}
+	samples := make([]int16, frames*2)
+	binary.Read(bytes.NewReader(data[44:]), binary.LittleEndian, samples)
+
+	// A hard-right region only sounds on the right channel
+	var left, right float64
+	for i := 0; i < len(samples); i += 2 {
+		left = math.Max(left, math.Abs(float64(samples[i])))
+		right = math.Max(right, math.Abs(float64(samples[i+1])))
+	}
+	if left != 0 || right == 0 {
+		t.Errorf("Expected a hard-right note only on the right channel, got peaks L=%.0f R=%.0f", left, right)
+	}
+}
@@ -0,0 +1,100 @@
+package gosfzplayer
+
+import (
+	"testing"
+)
+
+const timingTestSfz = `<region>
+sample=sample1.wav
+ampeg_attack=0
+ampeg_release=0
+loop_mode=loop_continuous
+`
+
+// firstNonZero returns the index of the first non-silent frame, or -1
+func firstNonZero(output []float32) int {
+	for i, value := range output {
+		if value != 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestEventsLandOnTheirFrame(t *testing.T) {
+	// Reference: the note starts at the beginning of a block
+	reference := createTestEngine(t, timingTestSfz)
+	reference.processMidiMessage([]byte{0x90, 60, 100})
+	expected := make([]float32, 400)
+	reference.render(expected)
+
+	e := createTestEngine(t, timingTestSfz)
+	output := make([]float32, 512)
+	e.renderEvents(output, []MidiEvent{{Frame: 112, Data: []byte{0x90, 60, 100}}})
+
+	if start := firstNonZero(output); start < 112 {
+		t.Fatalf("Expected silence before frame 112, got sound at frame %d", start)
+	}
+	for i, value := range expected {
+		if output[112+i] != value {
+			t.Fatalf("Frame %d: expected %f, got %f", 112+i, value, output[112+i])
+		}
+	}
+}
+
+func TestEventsSplitTheBlock(t *testing.T) {
+	events := []MidiEvent{
+		{Frame: 10, Data: []byte{0x90, 60, 100}},
+		{Frame: 200, Data: []byte{0xE0, 0, 127}},
+		{Frame: 300, Data: []byte{0x80, 60, 0}},
+		{Frame: 9000, Data: []byte{0x90, 64, 100}}, // Past the end: applied at the end of the block
+	}
+
+	// Rendering with stamped events matches rendering up to each event and then applying it
+	e := createTestEngine(t, timingTestSfz)
+	output := make([]float32, 512)
+	e.renderEvents(output, events)
+
+	split := createTestEngine(t, timingTestSfz)
+	expected := make([]float32, 512)
+	position := 0
+	for _, event := range events {
+		frame := min(int(event.Frame), len(expected))
+		split.render(expected[position:frame])
+		split.processMidiMessage(event.Data)
+		position = frame
+	}
+	split.render(expected[position:])
+
+	for i := range expected {
+		if output[i] != expected[i] {
+			t.Fatalf("Frame %d: expected %f, got %f", i, expected[i], output[i])
+		}
+	}
+	if firstNonZero(output[300:]) != -1 {
+		t.Error("Expected silence after the note-off at frame 300")
+	}
+	if last := e.activeVoices[len(e.activeVoices)-1]; last.midiNote != 64 || !last.isActive {
+		t.Error("Expected the event past the end of the block to start a note")
+	}
+}
+
+func TestRackEventsLandOnTheirFrame(t *testing.T) {
+	rack := createTestRack(t, 1, 2)
+	left := make([]float32, 512)
+	right := make([]float32, 512)
+	rack.RenderEvents(left, right, []MidiEvent{
+		{Frame: 50, Data: []byte{0x91, 60, 100}},
+		{Frame: 60, Data: []byte{0x95, 60, 100}}, // No part on channel 6
+	})
+
+	if start := firstNonZero(left); start < 50 {
+		t.Errorf("Expected silence before frame 50, got sound at frame %d", start)
+	}
+	if n := len(rack.parts[1].Load().engine.activeVoices); n != 1 {
+		t.Errorf("Expected the channel 2 note on part 2, got %d voices", n)
+	}
+	if n := len(rack.parts[0].Load().engine.activeVoices); n != 0 {
+		t.Errorf("Expected no voices on part 1, got %d", n)
+	}
+}
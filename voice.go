@@ -6,6 +6,9 @@ import (
 
 var voiceDebug = debuggo.Debug("sfzplayer:voice")
 
+// voiceDebugEnabled guards logging on the audio thread
+var voiceDebugEnabled = debuggo.IsEnabled("sfzplayer:voice")
+
 // EnvelopeState represents the current state of an ADSR envelope
 type EnvelopeState int
 
@@ -20,13 +23,15 @@ const (
 // Voice represents an active playing voice/note
 type Voice struct {
 	sample     *Sample
-	region     *SfzSection
+	region     *Region
+	channel    uint8 // MIDI channel (0-15)
 	midiNote   uint8
 	velocity   uint8
 	position   float64 // Current playback position in samples (float for pitch adjustment)
 	volume     float64
 	pan        float64
-	pitchRatio float64 // Pitch adjustment ratio (1.0 = no change, 2.0 = octave up)
+	sends      [numFxBuses]float64 // Send levels to fx1-fx4 (0.0 to 1.0); fx1 is -1 to follow the player's send level
+	pitchRatio float64             // Pitch adjustment ratio (1.0 = no change, 2.0 = octave up)
 	isActive   bool
 	noteOn     bool
 
@@ -48,36 +53,44 @@ type Voice struct {
 	groupID     int    // Group number for exclusion
 	offByGroup  int    // Group that can stop this voice
 	triggerMode string // Trigger mode: attack, release, first, legato
+
+	// Filter and Modulation
+	filter      *VoiceFilter     // Per-voice filter (nil if the region has no cutoff)
+	filterState VoiceFilter      // Storage for filter, so starting a voice doesn't allocate
+	modulation  voiceModulation  // CC, LFO and EG modulation routing
+	eq          voiceEQ          // Region EQ bands (eq1-eq3)
+	crossfade   ccCrossfadeState // CC crossfade gain (xfin_loccN/xfout_hiccN)
+	spare       *voiceBuffers    // Larger modulation and EQ buffers to switch to on the next note
+	bend        bendState        // Live pitch bend
+
+	// Pedals
+	keyDown          bool // Key is physically held (noteOn stays true while a pedal sustains the note)
+	sustainSw        bool // Region responds to the sustain pedal (sustain_sw)
+	sostenutoSw      bool // Region responds to the sostenuto pedal (sostenuto_sw)
+	sustainCC        int  // Controller used as the sustain pedal (sustain_cc)
+	sostenutoLatched bool // Held by the sostenuto pedal
+
+	// Voice stealing
+	stolen    bool    // Fading out to make room for another voice
+	stealGain float64 // Fade-out gain, 1.0 down to 0.0
+	stealStep float64 // Fade-out gain decrement per sample
+}
+
+// reset clears a pooled voice for its next note, keeping the modulation and EQ buffers it can reuse
+func (v *Voice) reset() {
+	modulation := v.modulation
+	eq := v.eq
+	spare := v.spare
+	*v = Voice{modulation: modulation, eq: eq, spare: spare}
 }
 
 // InitializeEnvelope sets up the ADSR envelope for a voice
 func (v *Voice) InitializeEnvelope(sampleRate uint32) {
-	// Default ADSR values (in seconds)
-	defaultAttack := 0.001 // 1ms
-	defaultDecay := 0.1    // 100ms
-	defaultSustain := 1.0  // 100%
-	defaultRelease := 0.1  // 100ms
-
-	// Parse envelope opcodes with inheritance (Region → Group → Global)
-	attack := v.region.GetInheritedFloatOpcode("ampeg_attack", defaultAttack)
-	if attack < 0 {
-		attack = defaultAttack
-	}
-
-	decay := v.region.GetInheritedFloatOpcode("ampeg_decay", defaultDecay)
-	if decay < 0 {
-		decay = defaultDecay
-	}
-
-	sustain := v.region.GetInheritedFloatOpcode("ampeg_sustain", defaultSustain*100) / 100.0 // Convert percentage to 0-1
-	if sustain < 0 || sustain > 1 {
-		sustain = defaultSustain
-	}
-
-	release := v.region.GetInheritedFloatOpcode("ampeg_release", defaultRelease)
-	if release < 0 {
-		release = defaultRelease
-	}
+	// Envelope times in seconds, sustain level 0.0-1.0 (validated when the region was compiled)
+	attack := v.region.AmpegAttack
+	decay := v.region.AmpegDecay
+	sustain := v.region.AmpegSustain
+	release := v.region.AmpegRelease
 
 	// Convert times to samples
 	v.attackSamples = attack * float64(sampleRate)
@@ -90,8 +103,10 @@ func (v *Voice) InitializeEnvelope(sampleRate uint32) {
 	v.envelopeLevel = 0.0
 	v.envelopeTime = 0.0
 
-	voiceDebug("Initialized envelope: attack=%.3fs (%d samples), decay=%.3fs (%d samples), sustain=%.1f%%, release=%.3fs (%d samples)",
-		attack, int(v.attackSamples), decay, int(v.decaySamples), sustain*100, release, int(v.releaseSamples))
+	if voiceDebugEnabled {
+		voiceDebug("Initialized envelope: attack=%.3fs (%d samples), decay=%.3fs (%d samples), sustain=%.1f%%, release=%.3fs (%d samples)",
+			attack, int(v.attackSamples), decay, int(v.decaySamples), sustain*100, release, int(v.releaseSamples))
+	}
 }
 
 // ProcessEnvelope updates the envelope state and returns the current envelope level
@@ -169,24 +184,25 @@ func (v *Voice) TriggerRelease() {
 		// For loop_sustain mode, stop looping when note is released
 		if v.loopMode == "loop_sustain" {
 			v.loopMode = "no_loop"
-			voiceDebug("Voice note off: switching from loop_sustain to no_loop for note %d", v.midiNote)
+			if voiceDebugEnabled {
+				voiceDebug("Voice note off: switching from loop_sustain to no_loop for note %d", v.midiNote)
+			}
 		}
 
-		voiceDebug("Voice release triggered for note %d", v.midiNote)
+		if voiceDebugEnabled {
+			voiceDebug("Voice release triggered for note %d", v.midiNote)
+		}
 	}
 }
 
 // InitializeLoop sets up loop parameters for a voice
 func (v *Voice) InitializeLoop() {
-	// Get loop mode with inheritance (default: no_loop)
-	v.loopMode = v.region.GetInheritedStringOpcode("loop_mode")
-	if v.loopMode == "" {
-		v.loopMode = "no_loop"
-	}
+	// Loop mode (default: no_loop)
+	v.loopMode = v.region.LoopMode
 
-	// Get loop points with inheritance (default: 0 to end of sample)
-	v.loopStart = float64(v.region.GetInheritedIntOpcode("loop_start", 0))
-	v.loopEnd = float64(v.region.GetInheritedIntOpcode("loop_end", -1))
+	// Loop points (default: 0 to end of sample)
+	v.loopStart = float64(v.region.LoopStart)
+	v.loopEnd = float64(v.region.LoopEnd)
 
 	// Validate and set defaults for loop end
 	sampleLength := float64(len(v.sample.Data))
@@ -203,11 +219,15 @@ func (v *Voice) InitializeLoop() {
 	if v.loopStart >= v.loopEnd {
 		v.loopStart = 0
 		v.loopEnd = sampleLength - 1
-		voiceDebug("Invalid loop points for note %d, using full sample", v.midiNote)
+		if voiceDebugEnabled {
+			voiceDebug("Invalid loop points for note %d, using full sample", v.midiNote)
+		}
 	}
 
-	voiceDebug("Initialized loop: mode=%s, start=%.0f, end=%.0f (sample length=%.0f)",
-		v.loopMode, v.loopStart, v.loopEnd, sampleLength)
+	if voiceDebugEnabled {
+		voiceDebug("Initialized loop: mode=%s, start=%.0f, end=%.0f (sample length=%.0f)",
+			v.loopMode, v.loopStart, v.loopEnd, sampleLength)
+	}
 }
 
 // ProcessLoop handles loop behavior and returns true if voice should continue playing
@@ -232,7 +252,9 @@ func (v *Voice) ProcessLoop() bool {
 		if v.position >= v.loopEnd {
 			// Jump back to loop start
 			v.position = v.loopStart + (v.position - v.loopEnd)
-			voiceDebug("Voice %d: looping from %.0f back to %.0f", v.midiNote, v.loopEnd, v.position)
+			if voiceDebugEnabled {
+				voiceDebug("Voice %d: looping from %.0f back to %.0f", v.midiNote, v.loopEnd, v.position)
+			}
 		}
 
 	case "loop_sustain":
@@ -240,7 +262,9 @@ func (v *Voice) ProcessLoop() bool {
 		if v.noteOn && v.position >= v.loopEnd {
 			// Jump back to loop start while note is held
 			v.position = v.loopStart + (v.position - v.loopEnd)
-			voiceDebug("Voice %d: sustain looping from %.0f back to %.0f", v.midiNote, v.loopEnd, v.position)
+			if voiceDebugEnabled {
+				voiceDebug("Voice %d: sustain looping from %.0f back to %.0f", v.midiNote, v.loopEnd, v.position)
+			}
 		} else if !v.noteOn && v.position >= sampleLength-1 {
 			// Stop when reaching end after note off
 			return false
@@ -0,0 +1,198 @@
+package gosfzplayer
+
+import "sync/atomic"
+
+// voicePool preallocates an engine's voices so note-ons never allocate on the audio thread
+type voicePool struct {
+	voices []Voice
+	free   []*Voice
+
+	// Buffers for each voice grown by another goroutine, handed to the voices by adoptReserve
+	incoming atomic.Pointer[[]voiceBuffers]
+}
+
+// voiceCapacity is how many modulators of each kind a voice needs to play any region of the
+// instruments it can play
+type voiceCapacity struct {
+	ccMods    int // CC routes to one target
+	lfos      int
+	egs       int
+	depthMods int // CC routes to an LFO's frequency or to one target of an LFO or envelope's depth
+	eqMods    int // CC routes to one parameter of an EQ band
+}
+
+// voiceBuffers are the modulation and EQ buffers of one voice
+type voiceBuffers struct {
+	modulation voiceModulation
+	eq         voiceEQ
+}
+
+// capacityFor returns the modulators a voice needs to play any of an instrument's regions
+func capacityFor(inst *instrument) voiceCapacity {
+	var c voiceCapacity
+	for _, region := range inst.regions {
+		if spec := region.modulation; spec != nil {
+			c.ccMods = max(c.ccMods, mostRoutes(spec.ccRoutes[:]))
+			c.lfos = max(c.lfos, len(spec.lfos))
+			c.egs = max(c.egs, len(spec.egs))
+			for _, lfo := range spec.lfos {
+				c.depthMods = max(c.depthMods, len(lfo.freqCC), mostRoutes(lfo.depthCC[:]))
+			}
+			for _, eg := range spec.egs {
+				c.depthMods = max(c.depthMods, mostRoutes(eg.depthCC[:]))
+			}
+		}
+		for i := range region.eqRouting {
+			c.eqMods = max(c.eqMods, mostRoutes(region.eqRouting[i].cc[:]))
+		}
+	}
+	return c
+}
+
+// mostRoutes returns the length of the longest of a set of route lists
+func mostRoutes(routes [][]ccRoute) int {
+	most := 0
+	for _, list := range routes {
+		most = max(most, len(list))
+	}
+	return most
+}
+
+// union returns the larger of two capacities for each kind of modulator
+func (c voiceCapacity) union(other voiceCapacity) voiceCapacity {
+	return voiceCapacity{
+		ccMods:    max(c.ccMods, other.ccMods),
+		lfos:      max(c.lfos, other.lfos),
+		egs:       max(c.egs, other.egs),
+		depthMods: max(c.depthMods, other.depthMods),
+		eqMods:    max(c.eqMods, other.eqMods),
+	}
+}
+
+// modulatorsPerVoice returns how many modulators a voice's buffers hold at a capacity
+func (c voiceCapacity) modulatorsPerVoice() int {
+	targets := int(numModTargets)
+	perLFO := c.depthMods * (1 + targets)
+	perEG := c.depthMods * targets
+	return c.ccMods*targets + c.lfos*perLFO + c.egs*perEG + c.eqMods*maxEQBands*numEQParams
+}
+
+// reserve allocates buffers at a capacity for every voice, off the audio thread; the voices
+// switch to them once adoptReserve has run and they next start
+func (p *voicePool) reserve(c voiceCapacity) {
+	buffers := make([]voiceBuffers, len(p.voices))
+	modulators := make([]ccModulator, len(p.voices)*c.modulatorsPerVoice())
+	take := func(n int) []ccModulator {
+		taken := modulators[0:0:n]
+		modulators = modulators[n:]
+		return taken
+	}
+
+	for i := range buffers {
+		modulation := &buffers[i].modulation
+		for target := range modulation.ccMods {
+			modulation.ccMods[target] = take(c.ccMods)
+		}
+		lfos := make([]lfoState, c.lfos)
+		for j := range lfos {
+			lfos[j].freqMods = take(c.depthMods)
+			for target := range lfos[j].depthMods {
+				lfos[j].depthMods[target] = take(c.depthMods)
+			}
+		}
+		modulation.lfos = lfos[:0]
+		egs := make([]flexEGState, c.egs)
+		for j := range egs {
+			for target := range egs[j].depthMods {
+				egs[j].depthMods[target] = take(c.depthMods)
+			}
+		}
+		modulation.egs = egs[:0]
+
+		bands := &buffers[i].eq.bands
+		for j := range bands {
+			for param := range bands[j].ccMods {
+				bands[j].ccMods[param] = take(c.eqMods)
+			}
+		}
+	}
+	p.incoming.Store(&buffers)
+}
+
+// adoptReserve hands the voices the buffers last reserved for them; only the audio thread calls it
+func (p *voicePool) adoptReserve() {
+	buffers := p.incoming.Swap(nil)
+	if buffers == nil {
+		return
+	}
+	for i := range p.voices {
+		p.voices[i].spare = &(*buffers)[i]
+	}
+}
+
+// newVoicePool creates a pool of size voices, all free
+func newVoicePool(size int) *voicePool {
+	p := &voicePool{
+		voices: make([]Voice, size),
+		free:   make([]*Voice, size),
+	}
+	for i := range p.voices {
+		p.free[i] = &p.voices[i]
+	}
+	return p
+}
+
+// get takes a free voice, reset but keeping its modulation buffers, or switching to larger ones
+// reserved for it; nil if none is free
+func (p *voicePool) get() *Voice {
+	if len(p.free) == 0 {
+		return nil
+	}
+	voice := p.free[len(p.free)-1]
+	p.free = p.free[:len(p.free)-1]
+	voice.reset()
+	if voice.spare != nil {
+		voice.modulation = voice.spare.modulation
+		voice.eq = voice.spare.eq
+		voice.spare = nil
+	}
+	return voice
+}
+
+// put returns a voice to the pool
+func (p *voicePool) put(voice *Voice) {
+	voice.isActive = false
+	p.free = append(p.free, voice)
+}
+
+// allocVoice takes a voice from the pool. Polyphony limits are enforced beforehand, so the pool only
+// runs out when too many stolen voices are still fading; the oldest of those is cut immediately.
+func (e *engine) allocVoice() *Voice {
+	if len(e.pool.free) == 0 && len(e.activeVoices) > 0 {
+		victim := 0
+		for i, voice := range e.activeVoices {
+			if voice.stolen {
+				victim = i
+				break
+			}
+		}
+		e.removeVoice(victim)
+	}
+	return e.pool.get()
+}
+
+// removeVoice removes the active voice at index i and returns it to the pool
+func (e *engine) removeVoice(i int) {
+	voice := e.activeVoices[i]
+	copy(e.activeVoices[i:], e.activeVoices[i+1:])
+	e.activeVoices[len(e.activeVoices)-1] = nil
+	e.activeVoices = e.activeVoices[:len(e.activeVoices)-1]
+	e.pool.put(voice)
+}
+
+// clearVoices silences all voices and returns them to the pool
+func (e *engine) clearVoices() {
+	for len(e.activeVoices) > 0 {
+		e.removeVoice(len(e.activeVoices) - 1)
+	}
+}
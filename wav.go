@@ -0,0 +1,162 @@
+package gosfzplayer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// WAV format tags
+const (
+	wavFormatPCM   = 1
+	wavFormatFloat = 3
+)
+
+// wavEncoder streams PCM or float audio to a WAV file, filling in the chunk sizes on close
+type wavEncoder struct {
+	file      *os.File
+	buffer    *bufio.Writer
+	options   AudioWriterOptions
+	quantizer *quantizer
+	scratch   []byte
+	dataSize  int64
+}
+
+// newWAVEncoder creates a WAV file and writes a header for an empty data chunk
+func newWAVEncoder(path string, opts AudioWriterOptions) (*wavEncoder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create WAV file: %w", err)
+	}
+
+	w := &wavEncoder{
+		file:      file,
+		buffer:    bufio.NewWriter(file),
+		options:   opts,
+		quantizer: newQuantizer(opts.Format.bits(), opts.Dither),
+	}
+	if err := w.writeHeader(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// writeHeader writes the RIFF header and the fmt, fact, LIST and data chunk headers for the data written so far
+func (w *wavEncoder) writeHeader() error {
+	channels := w.options.Channels
+	bytesPerSample := w.options.Format.bits() / 8
+	blockAlign := channels * bytesPerSample
+	frames := w.dataSize / int64(blockAlign)
+
+	var chunks bytes.Buffer
+	if w.options.Format == FormatFloat32 {
+		// Non-PCM formats carry a cbSize field and a fact chunk with the frame count
+		writeChunkHeader(&chunks, "fmt ", 18)
+		binary.Write(&chunks, binary.LittleEndian, uint16(wavFormatFloat))
+	} else {
+		writeChunkHeader(&chunks, "fmt ", 16)
+		binary.Write(&chunks, binary.LittleEndian, uint16(wavFormatPCM))
+	}
+	binary.Write(&chunks, binary.LittleEndian, uint16(channels))                        // Channels
+	binary.Write(&chunks, binary.LittleEndian, w.options.SampleRate)                    // Sample rate
+	binary.Write(&chunks, binary.LittleEndian, w.options.SampleRate*uint32(blockAlign)) // Byte rate
+	binary.Write(&chunks, binary.LittleEndian, uint16(blockAlign))                      // Block align
+	binary.Write(&chunks, binary.LittleEndian, uint16(w.options.Format.bits()))         // Bits per sample
+	if w.options.Format == FormatFloat32 {
+		binary.Write(&chunks, binary.LittleEndian, uint16(0)) // cbSize
+		writeChunkHeader(&chunks, "fact", 4)
+		binary.Write(&chunks, binary.LittleEndian, uint32(frames))
+	}
+
+	if tags := w.options.Metadata.tags(); len(tags) > 0 {
+		var info bytes.Buffer
+		info.WriteString("INFO")
+		for _, tag := range tags {
+			// Zero-terminated strings, padded to an even length
+			writeChunkHeader(&info, tag[0], uint32(len(tag[2])+1))
+			info.WriteString(tag[2])
+			info.WriteByte(0)
+			if (len(tag[2])+1)%2 != 0 {
+				info.WriteByte(0)
+			}
+		}
+		writeChunkHeader(&chunks, "LIST", uint32(info.Len()))
+		chunks.Write(info.Bytes())
+	}
+
+	writeChunkHeader(&chunks, "data", uint32(w.dataSize))
+
+	// The RIFF size counts everything after it, including the data chunk's pad byte
+	riffSize := 4 + int64(chunks.Len()) + w.dataSize + w.dataSize%2
+	if riffSize > math.MaxUint32 {
+		return fmt.Errorf("WAV file exceeds 4 GB")
+	}
+	if _, err := w.buffer.WriteString("RIFF"); err != nil {
+		return err
+	}
+	binary.Write(w.buffer, binary.LittleEndian, uint32(riffSize))
+	w.buffer.WriteString("WAVE")
+	_, err := w.buffer.Write(chunks.Bytes())
+	return err
+}
+
+// writeChunkHeader writes a RIFF chunk id and size
+func writeChunkHeader(buf *bytes.Buffer, id string, size uint32) {
+	buf.WriteString(id)
+	binary.Write(buf, binary.LittleEndian, size)
+}
+
+// write appends interleaved samples in the file's sample format
+func (w *wavEncoder) write(samples []float32) error {
+	bytesPerSample := w.options.Format.bits() / 8
+	w.scratch = w.scratch[:0]
+	for _, sample := range samples {
+		switch w.options.Format {
+		case FormatFloat32:
+			w.scratch = binary.LittleEndian.AppendUint32(w.scratch, math.Float32bits(sample))
+		case FormatPCM24:
+			value := w.quantizer.quantize(sample)
+			w.scratch = append(w.scratch, byte(value), byte(value>>8), byte(value>>16))
+		default:
+			w.scratch = binary.LittleEndian.AppendUint16(w.scratch, uint16(w.quantizer.quantize(sample)))
+		}
+	}
+	if _, err := w.buffer.Write(w.scratch); err != nil {
+		return fmt.Errorf("failed to write WAV data: %w", err)
+	}
+	w.dataSize += int64(len(samples) * bytesPerSample)
+	return nil
+}
+
+// close pads the data chunk, rewrites the header with the final sizes and closes the file
+func (w *wavEncoder) close() error {
+	var err error
+	if w.dataSize%2 != 0 {
+		err = w.buffer.WriteByte(0)
+	}
+	if err == nil {
+		err = w.buffer.Flush()
+	}
+	if err == nil {
+		_, err = w.file.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		w.buffer.Reset(w.file)
+		err = w.writeHeader()
+	}
+	if err == nil {
+		err = w.buffer.Flush()
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to finish WAV file: %w", err)
+	}
+	return nil
+}